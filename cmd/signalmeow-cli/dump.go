@@ -0,0 +1,88 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+)
+
+func cmdContacts(ctx context.Context, env *cliEnv, args []string) error {
+	contacts, err := env.Client.Store.RecipientStore.LoadAllContacts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load contacts: %w", err)
+	}
+	return printJSON(contacts)
+}
+
+func cmdGroups(ctx context.Context, env *cliEnv, args []string) error {
+	groupIDs, err := env.Client.Store.GroupStore.AllGroupIdentifiers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load group IDs: %w", err)
+	}
+	groups := make([]*signalmeow.Group, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group, err := env.Client.RetrieveGroupByID(ctx, groupID, 0)
+		if err != nil {
+			env.Log.Err(err).Stringer("group_id", groupID).Msg("Failed to fetch group")
+			continue
+		}
+		groups = append(groups, group)
+	}
+	return printJSON(groups)
+}
+
+type jsonStorageRecord struct {
+	StorageID string          `json:"storage_id"`
+	Type      string          `json:"type"`
+	Record    json.RawMessage `json:"record"`
+}
+
+func cmdStorage(ctx context.Context, env *cliEnv, args []string) error {
+	if env.Client.Store.MasterKey == nil {
+		return errors.New("storage master key not known, receive messages first to get it from the primary device")
+	}
+	update, err := env.Client.FetchStorage(ctx, env.Client.Store.MasterKey, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch storage: %w", err)
+	} else if update == nil {
+		return errors.New("storage service doesn't have a manifest")
+	}
+	records := make([]jsonStorageRecord, 0, len(update.NewRecords))
+	for _, record := range update.NewRecords {
+		data, err := protojson.Marshal(record.StorageRecord)
+		if err != nil {
+			return fmt.Errorf("failed to encode record %s: %w", record.StorageID, err)
+		}
+		records = append(records, jsonStorageRecord{
+			StorageID: record.StorageID,
+			Type:      record.ItemType.String(),
+			Record:    data,
+		})
+	}
+	return printJSON(map[string]any{
+		"version":         update.Version,
+		"records":         records,
+		"missing_records": update.MissingRecords,
+	})
+}
@@ -0,0 +1,98 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+)
+
+// qrRefreshInterval is how often a new QR code is generated. The server times out provisioning sockets after
+// 60 seconds, but the official desktop app opens a new one after 45 seconds.
+const qrRefreshInterval = 45 * time.Second
+const maxQRRefreshes = 6
+
+func cmdLink(ctx context.Context, env *cliEnv, args []string) error {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	deviceName := flags.String("name", "signalmeow-cli", "Name of the device shown in the Signal app")
+	_ = flags.Parse(args)
+
+	for i := 0; i < maxQRRefreshes; i++ {
+		data, err := waitForScan(ctx, env.Container, *deviceName)
+		if errors.Is(err, errQRTimeout) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Successfully linked as %s / %s (device %d)\n", data.Number, data.ACI, data.DeviceID)
+		return nil
+	}
+	return errors.New("too many QR code refreshes")
+}
+
+var errQRTimeout = errors.New("QR code wasn't scanned in time")
+
+func waitForScan(ctx context.Context, container *store.Container, deviceName string) (*store.DeviceData, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	provChan := signalmeow.PerformProvisioning(ctx, container, deviceName, false)
+
+	resp := <-provChan
+	if resp.Err != nil {
+		return nil, resp.Err
+	} else if resp.State != signalmeow.StateProvisioningURLReceived {
+		return nil, fmt.Errorf("unexpected state %v", resp.State)
+	}
+	qr, err := qrcode.New(resp.ProvisioningURL, qrcode.Low)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	fmt.Println(qr.ToSmallString(false))
+	fmt.Println("Scan the QR code above with the Signal app on your phone (Settings > Linked devices)")
+
+	var data *store.DeviceData
+	select {
+	case resp = <-provChan:
+		if resp.Err != nil {
+			return nil, resp.Err
+		} else if resp.State != signalmeow.StateProvisioningDataReceived {
+			return nil, fmt.Errorf("unexpected state %v", resp.State)
+		}
+		data = resp.ProvisioningData
+	case <-time.After(qrRefreshInterval):
+		return nil, errQRTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	fmt.Printf("Processing login as %s...\n", data.Number)
+
+	resp = <-provChan
+	if resp.Err != nil {
+		return nil, resp.Err
+	} else if resp.State != signalmeow.StateProvisioningPreKeysRegistered {
+		return nil, fmt.Errorf("unexpected state %v", resp.State)
+	}
+	return data, nil
+}
@@ -0,0 +1,183 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// signalmeow-cli is a small command-line client for debugging and scripting signalmeow without a Matrix homeserver.
+// It uses the same database schema as the bridge, so it can also be pointed at a copy of the bridge database.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/litestream"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+)
+
+var (
+	dbPath   = flag.String("db", "signalmeow.db", "Path to the SQLite database, or a postgres:// URI")
+	account  = flag.String("account", "", "Phone number or ACI of the account to use if the database contains several")
+	logLevel = flag.String("log-level", "warn", "Minimum level of logs to write to stderr")
+)
+
+type command struct {
+	args        string
+	description string
+	needsClient bool
+	fn          func(ctx context.Context, env *cliEnv, args []string) error
+}
+
+var commands = map[string]*command{
+	"link":      {"[-name <device name>]", "Link a new device by scanning a QR code with the Signal app", false, cmdLink},
+	"send":      {"<recipient> <text>", "Send a text message", true, cmdSend},
+	"send-file": {"<recipient> <path> [caption]", "Send a file as an attachment", true, cmdSendFile},
+	"react":     {"[-remove] <recipient> <author ACI> <timestamp> <emoji>", "React to a message", true, cmdReact},
+	"receive":   {"", "Receive messages and print events as JSON until interrupted", true, cmdReceive},
+	"contacts":  {"", "Print all contacts in the database as JSON", true, cmdContacts},
+	"groups":    {"", "Fetch and print all known groups as JSON", true, cmdGroups},
+	"storage":   {"", "Fetch and print all storage service records as JSON", true, cmdStorage},
+}
+
+var commandOrder = []string{"link", "send", "send-file", "react", "receive", "contacts", "groups", "storage"}
+
+type cliEnv struct {
+	Log       zerolog.Logger
+	Container *store.Container
+	Client    *signalmeow.Client
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [flags] <command> [args...]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	_, _ = fmt.Fprintln(out, "\nCommands:")
+	for _, name := range commandOrder {
+		cmd := commands[name]
+		_, _ = fmt.Fprintf(out, "  %s %s\n    \t%s\n", name, cmd.args, cmd.description)
+	}
+	_, _ = fmt.Fprintln(out, "\nRecipients are ACIs, PNI:<uuid>, phone numbers in E.164 format, or group:<group ID>.")
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	level, err := zerolog.ParseLevel(*logLevel)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid log level:", err)
+		os.Exit(2)
+	}
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(level).With().Timestamp().Logger()
+	signalmeow.SetLogger(log.With().Str("component", "signalmeow").Logger())
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	ctx = log.WithContext(ctx)
+	err = run(ctx, log, cmd, flag.Args()[1:])
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, log zerolog.Logger, cmd *command, args []string) error {
+	container, err := openStore(ctx, log)
+	if err != nil {
+		return err
+	}
+	env := &cliEnv{Log: log, Container: container}
+	if cmd.needsClient {
+		device, err := findDevice(ctx, container, *account)
+		if err != nil {
+			return err
+		}
+		env.Client = &signalmeow.Client{
+			Store: device,
+			Log:   log.With().Str("component", "signalmeow").Logger(),
+		}
+	}
+	return cmd.fn(ctx, env, args)
+}
+
+func openStore(ctx context.Context, log zerolog.Logger) (*store.Container, error) {
+	uri, dialect := *dbPath, "postgres"
+	if !strings.HasPrefix(uri, "postgres://") && !strings.HasPrefix(uri, "postgresql://") {
+		uri = fmt.Sprintf("file:%s?_txlock=immediate", uri)
+		dialect = "sqlite3-fk-wal"
+	}
+	db, err := dbutil.NewWithDialect(uri, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if dialect != "postgres" {
+		db.RawDB.SetMaxOpenConns(1)
+	}
+	container := store.NewStore(db, dbutil.ZeroLogger(log.With().Str("db_section", "signalmeow").Logger()))
+	err = container.Upgrade(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade database: %w", err)
+	}
+	return container, nil
+}
+
+func findDevice(ctx context.Context, container *store.Container, account string) (*store.Device, error) {
+	devices, err := container.GetAllDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+	var found *store.Device
+	for _, device := range devices {
+		if account != "" && device.Number != account && device.ACI.String() != account {
+			continue
+		} else if found != nil {
+			return nil, errors.New("database contains multiple accounts, use -account to choose one")
+		}
+		found = device
+	}
+	if found == nil {
+		if account != "" {
+			return nil, fmt.Errorf("account %s not found in database", account)
+		}
+		return nil, errors.New("no accounts in database, use the link command first")
+	}
+	return found, nil
+}
+
+func printJSON(data any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
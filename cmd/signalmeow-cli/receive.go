@@ -0,0 +1,102 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+)
+
+type jsonEvent struct {
+	Type  string `json:"type"`
+	Event any    `json:"event"`
+	// Content is the protobuf content of chat events, encoded with the canonical protobuf JSON mapping.
+	Content json.RawMessage `json:"content,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+func encodeEvent(evt events.SignalEvent) *jsonEvent {
+	out := &jsonEvent{
+		Type:  strings.TrimPrefix(fmt.Sprintf("%T", evt), "*events."),
+		Event: evt,
+	}
+	switch typedEvt := evt.(type) {
+	case *events.ChatEvent:
+		if msg, ok := typedEvt.Event.(proto.Message); ok {
+			content, err := protojson.Marshal(msg)
+			if err == nil {
+				out.Content = content
+				out.Event = typedEvt.Info
+			}
+		}
+	case *events.DecryptionError:
+		out.Error = typedEvt.Err.Error()
+	}
+	return out
+}
+
+func cmdReceive(ctx context.Context, env *cliEnv, args []string) error {
+	cli := env.Client
+	var outputLock sync.Mutex
+	enc := json.NewEncoder(os.Stdout)
+	unsubscribe := signalmeow.Subscribe(cli, func(evt events.SignalEvent) bool {
+		outputLock.Lock()
+		defer outputLock.Unlock()
+		err := enc.Encode(encodeEvent(evt))
+		if err != nil {
+			env.Log.Err(err).Type("event_type", evt).Msg("Failed to encode event")
+		}
+		// Failing to write the event isn't a reason to have the server send it again
+		return true
+	}, nil)
+	defer unsubscribe()
+
+	statusChan, err := cli.StartReceiveLoops(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start receive loops: %w", err)
+	}
+	defer func() {
+		err := cli.StopReceiveLoops()
+		if err != nil {
+			env.Log.Err(err).Msg("Failed to stop receive loops")
+		}
+	}()
+	for {
+		select {
+		case status, ok := <-statusChan:
+			if !ok {
+				return nil
+			}
+			env.Log.Info().Err(status.Err).Stringer("status", status.Event).Msg("Connection status changed")
+			if status.Event == signalmeow.SignalConnectionEventLoggedOut {
+				return fmt.Errorf("logged out")
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
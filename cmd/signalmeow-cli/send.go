@@ -0,0 +1,174 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/types"
+)
+
+// recipient is either a user or a group.
+type recipient struct {
+	ServiceID libsignalgo.ServiceID
+	GroupID   types.GroupIdentifier
+}
+
+func resolveRecipient(ctx context.Context, cli *signalmeow.Client, target string) (*recipient, error) {
+	if groupID, ok := strings.CutPrefix(target, "group:"); ok {
+		return &recipient{GroupID: types.GroupIdentifier(groupID)}, nil
+	} else if strings.HasPrefix(target, "+") {
+		return resolvePhone(ctx, cli, target)
+	} else if aci, err := uuid.Parse(target); err == nil {
+		return &recipient{ServiceID: libsignalgo.NewACIServiceID(aci)}, nil
+	}
+	serviceID, err := libsignalgo.ServiceIDFromString(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", target, err)
+	}
+	return &recipient{ServiceID: serviceID}, nil
+}
+
+func resolvePhone(ctx context.Context, cli *signalmeow.Client, phone string) (*recipient, error) {
+	contact, err := cli.ContactByE164(ctx, phone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contact: %w", err)
+	} else if contact != nil && contact.ACI != uuid.Nil {
+		return &recipient{ServiceID: libsignalgo.NewACIServiceID(contact.ACI)}, nil
+	}
+	e164, err := strconv.ParseUint(strings.TrimPrefix(phone, "+"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid phone number %q", phone)
+	}
+	resp, err := cli.LookupPhone(ctx, e164)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up phone number: %w", err)
+	}
+	entry, ok := resp[e164]
+	if !ok {
+		return nil, fmt.Errorf("%s is not on Signal", phone)
+	} else if entry.ACI != uuid.Nil {
+		return &recipient{ServiceID: libsignalgo.NewACIServiceID(entry.ACI)}, nil
+	}
+	return &recipient{ServiceID: libsignalgo.NewPNIServiceID(entry.PNI)}, nil
+}
+
+func sendDataMessage(ctx context.Context, cli *signalmeow.Client, target string, dm *signalpb.DataMessage) error {
+	rcpt, err := resolveRecipient(ctx, cli, target)
+	if err != nil {
+		return err
+	}
+	if dm.Timestamp == nil {
+		dm.Timestamp = proto.Uint64(uint64(time.Now().UnixMilli()))
+	}
+	content := &signalpb.Content{DataMessage: dm}
+	if rcpt.GroupID != "" {
+		res, err := cli.SendGroupMessage(ctx, rcpt.GroupID, content)
+		if err != nil {
+			return err
+		}
+		for _, failed := range res.FailedToSendTo {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to send to %s: %v\n", failed.Recipient, failed.Error)
+		}
+		fmt.Printf("Sent message %d to %d group members\n", dm.GetTimestamp(), len(res.SuccessfullySentTo))
+		return nil
+	}
+	res := cli.SendMessage(ctx, rcpt.ServiceID, content)
+	if !res.WasSuccessful {
+		return res.Error
+	}
+	fmt.Printf("Sent message %d to %s\n", dm.GetTimestamp(), rcpt.ServiceID)
+	return nil
+}
+
+func cmdSend(ctx context.Context, env *cliEnv, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: send <recipient> <text>")
+	}
+	return sendDataMessage(ctx, env.Client, args[0], &signalpb.DataMessage{
+		Body: proto.String(strings.Join(args[1:], " ")),
+	})
+}
+
+func cmdSendFile(ctx context.Context, env *cliEnv, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: send-file <recipient> <path> [caption]")
+	}
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	fileName := filepath.Base(args[1])
+	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	att, err := env.Client.UploadAttachment(ctx, data)
+	if err != nil {
+		return fmt.Errorf("failed to upload attachment: %w", err)
+	}
+	att.ContentType = proto.String(mimeType)
+	att.FileName = proto.String(fileName)
+	dm := &signalpb.DataMessage{
+		Attachments: []*signalpb.AttachmentPointer{att},
+	}
+	if len(args) > 2 {
+		dm.Body = proto.String(strings.Join(args[2:], " "))
+	}
+	return sendDataMessage(ctx, env.Client, args[0], dm)
+}
+
+func cmdReact(ctx context.Context, env *cliEnv, args []string) error {
+	flags := flag.NewFlagSet("react", flag.ExitOnError)
+	remove := flags.Bool("remove", false, "Remove the reaction instead of adding it")
+	_ = flags.Parse(args)
+	if flags.NArg() != 4 {
+		return errors.New("usage: react [-remove] <recipient> <author ACI> <timestamp> <emoji>")
+	}
+	targetAuthor, err := uuid.Parse(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid author ACI: %w", err)
+	}
+	targetTimestamp, err := strconv.ParseUint(flags.Arg(2), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	return sendDataMessage(ctx, env.Client, flags.Arg(0), &signalpb.DataMessage{
+		Reaction: &signalpb.DataMessage_Reaction{
+			Emoji:               proto.String(flags.Arg(3)),
+			Remove:              proto.Bool(*remove),
+			TargetAuthorAci:     proto.String(targetAuthor.String()),
+			TargetSentTimestamp: proto.Uint64(targetTimestamp),
+		},
+	})
+}
@@ -27,6 +27,9 @@ import (
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-signal/pkg/msgconv"
+	"go.mau.fi/mautrix-signal/pkg/signalid"
 )
 
 func supportedIfFFmpeg() event.CapabilitySupportLevel {
@@ -36,8 +39,17 @@ func supportedIfFFmpeg() event.CapabilitySupportLevel {
 	return event.CapLevelRejected
 }
 
+// maxImageDimension returns the image size limit to advertise.
+// If ffmpeg is available, larger images are downscaled automatically, so there's no limit.
+func maxImageDimension() int {
+	if ffmpeg.Supported() {
+		return 0
+	}
+	return msgconv.MaxImageDimension
+}
+
 func capID() string {
-	base := "fi.mau.signal.capabilities.2025_01_16"
+	base := "fi.mau.signal.capabilities.2025_06_21"
 	if ffmpeg.Supported() {
 		return base + "+ffmpeg"
 	}
@@ -45,7 +57,10 @@ func capID() string {
 }
 
 const MaxFileSize = 100 * 1024 * 1024
-const MaxTextLength = 2000
+
+// MaxTextLength is the maximum length of text messages and captions.
+// Anything over msgconv.MaxInlineBodyLength is sent as a long text attachment.
+const MaxTextLength = 64 * 1024
 
 var signalCaps = &event.RoomFeatures{
 	ID: capID(),
@@ -76,9 +91,11 @@ var signalCaps = &event.RoomFeatures{
 				"image/jpeg": event.CapLevelFullySupported,
 				"image/webp": event.CapLevelFullySupported,
 				"image/bmp":  event.CapLevelFullySupported,
+				"image/heic": supportedIfFFmpeg(),
+				"image/heif": supportedIfFFmpeg(),
 			},
-			MaxWidth:         4096,
-			MaxHeight:        4096,
+			MaxWidth:         maxImageDimension(),
+			MaxHeight:        maxImageDimension(),
 			MaxSize:          MaxFileSize,
 			Caption:          event.CapLevelFullySupported,
 			MaxCaptionLength: MaxTextLength,
@@ -88,6 +105,9 @@ var signalCaps = &event.RoomFeatures{
 				"video/mp4":  event.CapLevelFullySupported,
 				"video/ogg":  event.CapLevelFullySupported,
 				"video/webm": event.CapLevelFullySupported,
+
+				"video/quicktime":  supportedIfFFmpeg(),
+				"video/x-matroska": supportedIfFFmpeg(),
 			},
 			MaxSize:          MaxFileSize,
 			Caption:          event.CapLevelFullySupported,
@@ -97,6 +117,8 @@ var signalCaps = &event.RoomFeatures{
 			MimeTypes: map[string]event.CapabilitySupportLevel{
 				"audio/aac":  event.CapLevelFullySupported,
 				"audio/mpeg": event.CapLevelFullySupported,
+				"audio/ogg":  supportedIfFFmpeg(),
+				"audio/opus": supportedIfFFmpeg(),
 			},
 			MaxSize: MaxFileSize,
 		},
@@ -136,7 +158,7 @@ var signalCaps = &event.RoomFeatures{
 			MaxSize: MaxFileSize,
 		},
 	},
-	MaxTextLength:        MaxTextLength, // TODO support arbitrary sized text messages with files
+	MaxTextLength:        MaxTextLength,
 	LocationMessage:      event.CapLevelPartialSupport,
 	Poll:                 event.CapLevelRejected,
 	Thread:               event.CapLevelUnsupported,
@@ -157,6 +179,11 @@ var signalCaps = &event.RoomFeatures{
 
 var signalCapsNoteToSelf *event.RoomFeatures
 
+// signalCapsStories is used for the stories room, which is read-only.
+var signalCapsStories = &event.RoomFeatures{
+	ID: capID() + "+stories",
+}
+
 func init() {
 	signalCapsNoteToSelf = ptr.Clone(signalCaps)
 	signalCapsNoteToSelf.EditMaxAge = nil
@@ -166,6 +193,8 @@ func init() {
 func (s *SignalClient) GetCapabilities(ctx context.Context, portal *bridgev2.Portal) *event.RoomFeatures {
 	if portal.Receiver == s.UserLogin.ID && portal.ID == networkid.PortalID(s.UserLogin.ID) {
 		return signalCapsNoteToSelf
+	} else if portal.ID == signalid.StoriesPortalID {
+		return signalCapsStories
 	}
 	return signalCaps
 }
@@ -180,5 +209,5 @@ func (s *SignalConnector) GetCapabilities() *bridgev2.NetworkGeneralCapabilities
 }
 
 func (s *SignalConnector) GetBridgeInfoVersion() (info, capabilities int) {
-	return 1, 3
+	return 1, 5
 }
@@ -42,6 +42,8 @@ import (
 
 const PrivateChatTopic = "Signal private chat"
 const NoteToSelfName = "Signal Note to Self"
+const StoriesRoomName = "Signal Stories"
+const StoriesRoomTopic = "Stories posted by your Signal contacts"
 
 func (s *SignalClient) GetUserInfoWithRefreshAfter(ctx context.Context, ghost *bridgev2.Ghost, refreshAfter time.Duration) (*bridgev2.UserInfo, error) {
 	userID, err := signalid.ParseUserID(ghost.ID)
@@ -68,6 +70,9 @@ func (s *SignalClient) GetUserInfo(ctx context.Context, ghost *bridgev2.Ghost) (
 }
 
 func (s *SignalClient) GetChatInfo(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
+	if portal.ID == signalid.StoriesPortalID {
+		return s.makeStoriesChatInfo(), nil
+	}
 	userID, groupID, err := signalid.ParsePortalID(portal.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse portal id: %w", err)
@@ -17,8 +17,10 @@
 package connector
 
 import (
+	"cmp"
 	"context"
 	"encoding/base64"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,6 +30,7 @@ import (
 
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 	"go.mau.fi/mautrix-signal/pkg/signalid"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf/backuppb"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/types"
 )
@@ -64,6 +67,9 @@ func (s *SignalClient) syncChats(ctx context.Context) {
 		return
 	}
 	zerolog.Ctx(ctx).Info().Int("chat_count", len(chats)).Msg("Fetched chats to sync from database")
+	if len(chats) == 0 && s.Client.Store.EphemeralBackupKey == nil && !s.syncChatsFromStorage(ctx) {
+		return
+	}
 	for _, chat := range chats {
 		recipient, err := s.Client.Store.BackupStore.GetBackupRecipient(ctx, chat.RecipientId)
 		if err != nil {
@@ -155,3 +161,176 @@ func (s *SignalClient) syncChats(ctx context.Context) {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to save user login metadata after syncing chats")
 	}
 }
+
+type storageGroup struct {
+	record *signalpb.GroupV2Record
+	pinned bool
+}
+
+func (sg *storageGroup) priority() int {
+	if sg.pinned {
+		return 0
+	} else if sg.record.GetArchived() {
+		return 2
+	}
+	return 1
+}
+
+// syncChatsFromStorage creates portals for groups found in the storage service.
+// It's used for logins that didn't get a transfer archive, so there's no chat list to sync from.
+// Direct chats are synced separately by syncContactChats, as the storage service doesn't know which contacts
+// have been active recently.
+//
+// The return value is false if the storage service hasn't been synced yet and the sync should be retried later.
+func (s *SignalClient) syncChatsFromStorage(ctx context.Context) bool {
+	log := zerolog.Ctx(ctx)
+	limit := s.Main.Config.InitialChatSyncLimit
+	if limit <= 0 {
+		return true
+	} else if s.Client.Store.MasterKey == nil {
+		log.Debug().Msg("Not syncing chats from storage service as master key is not known yet")
+		return false
+	}
+	version, err := s.Client.Store.StorageStore.GetStorageVersion(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get storage version")
+		return false
+	} else if version == 0 {
+		log.Debug().Msg("Not syncing chats from storage service as storage hasn't been synced yet")
+		return false
+	}
+	records, err := s.Client.Store.StorageStore.GetStorageRecordsByType(ctx, signalpb.ManifestRecord_Identifier_GROUPV2)
+	if err != nil {
+		log.Err(err).Msg("Failed to get group records from storage store")
+		return false
+	}
+	pinnedGroups := make(map[string]struct{})
+	for _, pinned := range s.Client.Store.AccountRecord.GetPinnedConversations() {
+		if masterKey := pinned.GetGroupMasterKey(); masterKey != nil {
+			pinnedGroups[string(masterKey)] = struct{}{}
+		}
+	}
+	groups := make([]*storageGroup, 0, len(records))
+	for _, record := range records {
+		group := record.Record.GetGroupV2()
+		if group.GetBlocked() || len(group.GetMasterKey()) != libsignalgo.GroupMasterKeyLength {
+			continue
+		}
+		_, pinned := pinnedGroups[string(group.GetMasterKey())]
+		groups = append(groups, &storageGroup{record: group, pinned: pinned})
+	}
+	slices.SortStableFunc(groups, func(a, b *storageGroup) int {
+		return cmp.Compare(a.priority(), b.priority())
+	})
+	log.Info().
+		Int("group_count", len(groups)).
+		Int("limit", limit).
+		Msg("Syncing groups from storage service")
+	synced := 0
+	for _, group := range groups {
+		if synced >= limit {
+			break
+		}
+		groupID, err := s.Client.StoreMasterKey(ctx, types.SerializedGroupMasterKey(base64.StdEncoding.EncodeToString(group.record.MasterKey)))
+		if err != nil {
+			log.Err(err).Msg("Failed to store group master key")
+			continue
+		}
+		groupInfo, err := s.getGroupInfo(ctx, groupID, 0, nil)
+		if err != nil {
+			// This is expected for groups that the user has left
+			log.Warn().Err(err).Stringer("group_id", groupID).Msg("Failed to get group info for storage service group")
+			continue
+		}
+		s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
+			EventMeta: simplevent.EventMeta{
+				Type:         bridgev2.RemoteEventChatResync,
+				PortalKey:    s.makePortalKey(string(groupID)),
+				CreatePortal: true,
+			},
+			ChatInfo: groupInfo,
+		})
+		synced++
+	}
+	return true
+}
+
+// syncContactChats creates portals for the most recently active direct chats in the contact list sent by the primary
+// device. It only does anything once per login, and not at all for logins that got a transfer archive,
+// as direct chats are included in the archive.
+func (s *SignalClient) syncContactChats(ctx context.Context, inboxPositions map[uuid.UUID]uint32) {
+	meta := s.UserLogin.Metadata.(*signalid.UserLoginMetadata)
+	if !meta.ContactChatsPending {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	limit := s.Main.Config.InitialChatSyncLimit
+	if limit > 0 {
+		// Hidden and blocked contacts may still be in the primary device's chat list, but shouldn't get portals
+		skip := make(map[uuid.UUID]struct{})
+		contactRecords, err := s.Client.Store.StorageStore.GetStorageRecordsByType(ctx, signalpb.ManifestRecord_Identifier_CONTACT)
+		if err != nil {
+			log.Err(err).Msg("Failed to get contact records from storage store")
+			return
+		}
+		for _, record := range contactRecords {
+			contact := record.Record.GetContact()
+			if contact.GetHidden() || contact.GetBlocked() {
+				aci, _ := uuid.Parse(contact.GetAci())
+				skip[aci] = struct{}{}
+			}
+		}
+		pinned := make(map[uuid.UUID]struct{})
+		for _, pin := range s.Client.Store.AccountRecord.GetPinnedConversations() {
+			serviceID, err := libsignalgo.ServiceIDFromString(pin.GetContact().GetServiceId())
+			if err == nil && serviceID.Type == libsignalgo.ServiceIDTypeACI {
+				pinned[serviceID.UUID] = struct{}{}
+			}
+		}
+		acis := make([]uuid.UUID, 0, len(inboxPositions))
+		for aci := range inboxPositions {
+			if _, ok := skip[aci]; !ok {
+				acis = append(acis, aci)
+			}
+		}
+		slices.SortFunc(acis, func(a, b uuid.UUID) int {
+			_, aPinned := pinned[a]
+			_, bPinned := pinned[b]
+			if aPinned != bPinned {
+				if aPinned {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(inboxPositions[a], inboxPositions[b])
+		})
+		if len(acis) > limit {
+			acis = acis[:limit]
+		}
+		log.Info().
+			Int("chat_count", len(inboxPositions)).
+			Int("limit", limit).
+			Msg("Syncing direct chats from contact list")
+		for _, aci := range acis {
+			recipient, err := s.Client.Store.RecipientStore.LoadAndUpdateRecipient(ctx, aci, uuid.Nil, nil)
+			if err != nil {
+				log.Err(err).Stringer("aci", aci).Msg("Failed to get full recipient data")
+				continue
+			}
+			dmInfo := s.makeCreateDMResponse(ctx, recipient, nil)
+			s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
+				EventMeta: simplevent.EventMeta{
+					Type:         bridgev2.RemoteEventChatResync,
+					PortalKey:    dmInfo.PortalKey,
+					CreatePortal: true,
+				},
+				ChatInfo: dmInfo.PortalInfo,
+			})
+		}
+	}
+	meta.ContactChatsPending = false
+	err := s.UserLogin.Save(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to save user login metadata after syncing direct chats")
+	}
+}
@@ -289,6 +289,15 @@ func (s *SignalClient) Disconnect() {
 	}
 }
 
+// forceReconnect reconnects the websockets immediately instead of waiting for keepalives to time out.
+func (s *SignalClient) forceReconnect() {
+	if s.Client == nil {
+		return
+	}
+	s.UserLogin.Log.Debug().Msg("Forcing reconnect")
+	s.Client.ForceReconnect()
+}
+
 func (s *SignalClient) postLoginConnect() {
 	ctx := s.UserLogin.Log.WithContext(context.Background())
 	// TODO it would be more proper to only connect after syncing,
@@ -302,7 +311,10 @@ func (s *SignalClient) postLoginConnect() {
 			}
 		}()
 	} else if s.Client.Store.MasterKey != nil {
-		go s.Client.SyncStorage(ctx)
+		go func() {
+			s.Client.SyncStorage(ctx)
+			s.syncChats(ctx)
+		}()
 	}
 }
 
@@ -0,0 +1,332 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/util/ffmpeg"
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-signal/pkg/signalid"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+var HelpSectionDevices = commands.HelpSection{Name: "Linked devices", Order: 15}
+var HelpSectionStickers = commands.HelpSection{Name: "Stickers", Order: 16}
+var HelpSectionConnection = commands.HelpSection{Name: "Connection", Order: 17}
+
+var cmdListDevices = &commands.FullHandler{
+	Func:    wrapCommand(fnListDevices),
+	Name:    "list-devices",
+	Aliases: []string{"devices"},
+	Help: commands.HelpMeta{
+		Section:     HelpSectionDevices,
+		Description: "List the devices linked to your Signal account.",
+	},
+	RequiresLogin: true,
+}
+
+var cmdRemoveDevice = &commands.FullHandler{
+	Func:    wrapCommand(fnRemoveDevice),
+	Name:    "remove-device",
+	Aliases: []string{"unlink-device"},
+	Help: commands.HelpMeta{
+		Section:     HelpSectionDevices,
+		Description: "Unlink a device from your Signal account. Only available if the bridge is the primary device.",
+		Args:        "<_device ID_>",
+	},
+	RequiresLogin: true,
+}
+
+var cmdRenameDevice = &commands.FullHandler{
+	Func: wrapCommand(fnRenameDevice),
+	Name: "rename-device",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionDevices,
+		Description: "Rename a device linked to your Signal account. Renaming devices other than the bridge is only available if the bridge is the primary device.",
+		Args:        "<_device ID_> <_name_>",
+	},
+	RequiresLogin: true,
+}
+
+var cmdUploadStickerPack = &commands.FullHandler{
+	Func: wrapCommand(fnUploadStickerPack),
+	Name: "upload-sticker-pack",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionStickers,
+		Description: "Upload a Matrix image pack from a room or your account data as a Signal sticker pack.",
+		Args:        "<_room ID_ | `account`> [_state key_]",
+	},
+	RequiresLogin: true,
+}
+
+var cmdSetProxy = &commands.FullHandler{
+	Func: wrapCommand(fnSetProxy),
+	Name: "set-proxy",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionConnection,
+		Description: "Set the proxy used for connecting to Signal with your login, disable the proxy with `direct`, or reset it to the bridge default.",
+		Args:        "<_proxy URL_ | `direct` | `default`>",
+	},
+	RequiresLogin: true,
+}
+
+var cmdReconnect = &commands.FullHandler{
+	Func: wrapCommand(fnReconnect),
+	Name: "reconnect",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionConnection,
+		Description: "Reconnect to Signal immediately, e.g. after the network of the bridge has changed.",
+	},
+	RequiresLogin: true,
+}
+
+func wrapCommand(fn func(ce *commands.Event, sc *SignalClient)) func(ce *commands.Event) {
+	return func(ce *commands.Event) {
+		login := ce.User.GetDefaultLogin()
+		if login == nil {
+			ce.Reply("You're not logged in")
+			return
+		}
+		sc, ok := login.Client.(*SignalClient)
+		if !ok || sc.Client == nil {
+			ce.Reply("Your login is not connected")
+			return
+		}
+		fn(ce, sc)
+	}
+}
+
+func fnListDevices(ce *commands.Event, sc *SignalClient) {
+	devices, err := sc.Client.ListDevices(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to list devices")
+		ce.Reply("Failed to list devices: %v", err)
+		return
+	}
+	var out strings.Builder
+	for _, dev := range devices {
+		name := dev.Name
+		if dev.ID == signalmeow.PrimaryDeviceID && name == "" {
+			name = "Primary device"
+		} else if name == "" {
+			name = "Unnamed device"
+		}
+		_, _ = fmt.Fprintf(&out, "* `%d`: %s", dev.ID, name)
+		if dev.ID == sc.Client.Store.DeviceID {
+			out.WriteString(" (this bridge)")
+		}
+		_, _ = fmt.Fprintf(&out, " - linked %s, last seen %s\n", dev.Created.Format("2006-01-02"), dev.LastSeen.Format("2006-01-02"))
+	}
+	ce.Reply(out.String())
+}
+
+func fnRemoveDevice(ce *commands.Event, sc *SignalClient) {
+	if len(ce.Args) != 1 {
+		ce.Reply("Usage: `$cmdprefix remove-device <device ID>`")
+		return
+	}
+	deviceID, err := strconv.Atoi(ce.Args[0])
+	if err != nil {
+		ce.Reply("Invalid device ID")
+		return
+	} else if deviceID == sc.Client.Store.DeviceID {
+		ce.Reply("Use `$cmdprefix logout` to unlink the bridge itself")
+		return
+	}
+	err = sc.Client.RemoveDevice(ce.Ctx, deviceID)
+	if errors.Is(err, signalmeow.ErrNotPrimaryDevice) {
+		ce.Reply("Only the primary device can unlink other devices")
+	} else if err != nil {
+		ce.Log.Err(err).Int("device_id", deviceID).Msg("Failed to remove device")
+		ce.Reply("Failed to remove device: %v", err)
+	} else {
+		ce.Log.Info().Int("device_id", deviceID).Msg("Removed linked device")
+		ce.Reply("Device %d unlinked", deviceID)
+	}
+}
+
+func fnRenameDevice(ce *commands.Event, sc *SignalClient) {
+	if len(ce.Args) < 2 {
+		ce.Reply("Usage: `$cmdprefix rename-device <device ID> <name>`")
+		return
+	}
+	deviceID, err := strconv.Atoi(ce.Args[0])
+	if err != nil {
+		ce.Reply("Invalid device ID")
+		return
+	}
+	name := strings.Join(ce.Args[1:], " ")
+	err = sc.Client.RenameDevice(ce.Ctx, deviceID, name)
+	if errors.Is(err, signalmeow.ErrNotPrimaryDevice) {
+		ce.Reply("Only the primary device can rename other devices")
+	} else if err != nil {
+		ce.Log.Err(err).Int("device_id", deviceID).Msg("Failed to rename device")
+		ce.Reply("Failed to rename device: %v", err)
+	} else {
+		ce.Log.Info().Int("device_id", deviceID).Msg("Renamed device")
+		ce.Reply("Device %d renamed to %s", deviceID, name)
+	}
+}
+
+func fnUploadStickerPack(ce *commands.Event, sc *SignalClient) {
+	if len(ce.Args) < 1 || len(ce.Args) > 2 {
+		ce.Reply("Usage: `$cmdprefix upload-sticker-pack <room ID | account> [state key]`")
+		return
+	} else if !ffmpeg.Supported() {
+		ce.Reply("Converting stickers requires ffmpeg, which is not installed")
+		return
+	}
+	var roomID id.RoomID
+	var stateKey string
+	if ce.Args[0] != "account" {
+		roomID = id.RoomID(ce.Args[0])
+		if len(ce.Args) > 1 {
+			stateKey = ce.Args[1]
+		}
+	}
+	pack, rawPack, err := getImagePack(ce.Ctx, ce.Bridge, ce.User, roomID, stateKey)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get image pack")
+		ce.Reply("Failed to get image pack: %v", err)
+		return
+	}
+	shortcodes := make([]string, 0, len(pack.Images))
+	for shortcode, img := range pack.Images {
+		if img.isSticker(pack) {
+			shortcodes = append(shortcodes, shortcode)
+		}
+	}
+	slices.Sort(shortcodes)
+	if len(shortcodes) == 0 {
+		ce.Reply("That image pack doesn't contain any stickers")
+		return
+	} else if len(shortcodes) > signalmeow.MaxStickersPerPack {
+		ce.Reply("The pack has %d stickers, only the first %d will be uploaded", len(shortcodes), signalmeow.MaxStickersPerPack)
+		shortcodes = shortcodes[:signalmeow.MaxStickersPerPack]
+	}
+	ce.Reply("Converting %d stickers...", len(shortcodes))
+	uploads := make([]*signalmeow.StickerUpload, 0, len(shortcodes))
+	uploadedShortcodes := make([]string, 0, len(shortcodes))
+	for _, shortcode := range shortcodes {
+		img := pack.Images[shortcode]
+		data, err := ce.Bot.DownloadMedia(ce.Ctx, img.URL, nil)
+		if err != nil {
+			ce.Log.Err(err).Str("shortcode", shortcode).Msg("Failed to download sticker")
+			ce.Reply("Failed to download `%s`, skipping: %v", shortcode, err)
+			continue
+		}
+		mimeType, _ := img.Info["mimetype"].(string)
+		converted, err := convertToSignalSticker(ce.Ctx, data, mimeType)
+		if err != nil {
+			ce.Log.Err(err).Str("shortcode", shortcode).Msg("Failed to convert sticker")
+			ce.Reply("Failed to convert `%s`, skipping: %v", shortcode, err)
+			continue
+		}
+		uploads = append(uploads, &signalmeow.StickerUpload{
+			Data:        converted,
+			ContentType: "image/webp",
+			Emoji:       getStickerEmoji(img),
+		})
+		uploadedShortcodes = append(uploadedShortcodes, shortcode)
+	}
+	if len(uploads) == 0 {
+		ce.Reply("None of the stickers could be converted")
+		return
+	}
+	title := pack.Pack.DisplayName
+	if title == "" {
+		title = "Matrix stickers"
+	}
+	author := pack.Pack.Attribution
+	if author == "" {
+		author = ce.User.MXID.String()
+	}
+	stickerPack, err := sc.Client.UploadStickerPack(ce.Ctx, title, author, uploads)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to upload sticker pack")
+		ce.Reply("Failed to upload sticker pack: %v", err)
+		return
+	}
+	ce.Reply("Uploaded %d stickers: %s", len(uploads), signalmeow.StickerPackURL(stickerPack.ID, stickerPack.Key))
+
+	addStickerMetadataToImagePack(rawPack, uploadedShortcodes, stickerPack)
+	err = saveImagePack(ce.Ctx, ce.Bridge, ce.User, roomID, stateKey, rawPack)
+	if err != nil {
+		ce.Log.Warn().Err(err).Msg("Failed to add Signal sticker metadata to image pack")
+		ce.Reply("Failed to link the Matrix image pack to the new sticker pack (%v), "+
+			"use the copy of the pack in your management room to send the Signal stickers", err)
+	}
+	err = sc.bridgeStickerPack(ce.Ctx, &events.StickerPackUpdate{
+		PackID:    stickerPack.ID,
+		PackKey:   stickerPack.Key,
+		Installed: true,
+		Manifest:  stickerPack.Manifest,
+	})
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to bridge uploaded sticker pack")
+	}
+}
+
+func fnSetProxy(ce *commands.Event, sc *SignalClient) {
+	if len(ce.Args) != 1 {
+		ce.Reply("Usage: `$cmdprefix set-proxy <proxy URL | direct | default>`")
+		return
+	}
+	proxy := ce.Args[0]
+	if proxy == "default" {
+		proxy = ""
+	}
+	env, err := sc.Main.environmentForLogin(sc.Client.Store.Number, proxy)
+	if err != nil {
+		ce.Reply("Invalid proxy: %v", err)
+		return
+	}
+	meta := sc.UserLogin.Metadata.(*signalid.UserLoginMetadata)
+	meta.Proxy = proxy
+	err = sc.UserLogin.Save(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to save proxy to user login metadata")
+		ce.Reply("Failed to save proxy: %v", err)
+		return
+	}
+	// The websockets are recreated with the new environment when reconnecting
+	sc.Disconnect()
+	sc.Client.Environment = env
+	sc.Connect(sc.UserLogin.Log.WithContext(context.Background()))
+	if proxy == "" {
+		ce.Reply("Reset proxy to the bridge default and reconnected")
+	} else if proxy == web.ProxyDirect {
+		ce.Reply("Disabled proxy and reconnected")
+	} else {
+		ce.Reply("Proxy set and reconnected")
+	}
+}
+
+func fnReconnect(ce *commands.Event, sc *SignalClient) {
+	sc.forceReconnect()
+	ce.Reply("Reconnecting to Signal")
+}
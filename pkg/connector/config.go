@@ -18,6 +18,10 @@ package connector
 
 import (
 	_ "embed"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
 	"strings"
 	"text/template"
 
@@ -25,6 +29,7 @@ import (
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/types"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
 )
 
 //go:embed example-config.yaml
@@ -40,10 +45,97 @@ type SignalConfig struct {
 	NoteToSelfAvatar      id.ContentURIString `yaml:"note_to_self_avatar"`
 	LocationFormat        string              `yaml:"location_format"`
 	DisappearViewOnce     bool                `yaml:"disappear_view_once"`
+	InitialChatSyncLimit  int                 `yaml:"initial_chat_sync_limit"`
+	Stories               StoriesMode         `yaml:"stories"`
+	Server                ServerConfig        `yaml:"server"`
+	Proxy                 string              `yaml:"proxy"`
+	// CensorshipCircumvention is off, auto, or the name of a fronting config in web.FrontingConfigs.
+	CensorshipCircumvention string        `yaml:"censorship_circumvention"`
+	Metrics                 MetricsConfig `yaml:"metrics"`
 
 	displaynameTemplate *template.Template `yaml:"-"`
 }
 
+type StoriesMode string
+
+const (
+	StoriesDisabled StoriesMode = "disabled"
+	StoriesInDM     StoriesMode = "dm"
+	StoriesRoom     StoriesMode = "room"
+)
+
+type ServerConfig struct {
+	Environment string `yaml:"environment"`
+
+	ChatHost                  string            `yaml:"chat_host"`
+	StorageHost               string            `yaml:"storage_host"`
+	CDNHosts                  map[uint32]string `yaml:"cdn_hosts"`
+	ContactDiscoveryHost      string            `yaml:"cds_host"`
+	ContactDiscoveryMrenclave string            `yaml:"cds_mrenclave"`
+	SVR2Host                  string            `yaml:"svr2_host"`
+	SVR2Mrenclave             string            `yaml:"svr2_mrenclave"`
+	ServerPublicParams        string            `yaml:"server_public_params"`
+	TrustRoots                []string          `yaml:"trust_roots"`
+	TrustSystemRoots          bool              `yaml:"trust_system_roots"`
+}
+
+func overrideStr(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
+
+// Build creates a server environment from the selected preset and the overrides in the config.
+func (sc *ServerConfig) Build() (*web.ServerEnvironment, error) {
+	var preset *web.ServerEnvironment
+	switch sc.Environment {
+	case "", "production":
+		preset = web.ProductionEnvironment
+	case "staging":
+		preset = web.StagingEnvironment
+	default:
+		return nil, fmt.Errorf("unknown server environment %q", sc.Environment)
+	}
+	env := preset.Clone()
+	env.TrustSystemRoots = env.TrustSystemRoots || sc.TrustSystemRoots
+	overrideStr(&env.ChatHost, sc.ChatHost)
+	overrideStr(&env.StorageHost, sc.StorageHost)
+	overrideStr(&env.ContactDiscoveryHost, sc.ContactDiscoveryHost)
+	overrideStr(&env.ContactDiscoveryMrenclave, sc.ContactDiscoveryMrenclave)
+	overrideStr(&env.SVR2Host, sc.SVR2Host)
+	overrideStr(&env.SVR2Mrenclave, sc.SVR2Mrenclave)
+	if len(sc.CDNHosts) > 0 {
+		env.CDNHosts = sc.CDNHosts
+	}
+	if sc.ServerPublicParams != "" {
+		var err error
+		env.ServerPublicParams, err = base64.StdEncoding.DecodeString(sc.ServerPublicParams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode server public params: %w", err)
+		}
+	}
+	for _, path := range sc.TrustRoots {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trust root: %w", err)
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			} else if block.Type == "CERTIFICATE" {
+				env.TrustRoots = append(env.TrustRoots, block.Bytes)
+			}
+		}
+	}
+	err := env.Init()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s server environment: %w", env.Name, err)
+	}
+	return env, nil
+}
+
 type DisplaynameParams struct {
 	ProfileName string
 	ContactName string
@@ -83,6 +175,23 @@ func upgradeConfig(helper up.Helper) {
 	helper.Copy(up.Str, "note_to_self_avatar")
 	helper.Copy(up.Str, "location_format")
 	helper.Copy(up.Bool, "disappear_view_once")
+	helper.Copy(up.Int, "initial_chat_sync_limit")
+	helper.Copy(up.Str, "stories")
+	helper.Copy(up.Str, "server", "environment")
+	helper.Copy(up.Str|up.Null, "server", "chat_host")
+	helper.Copy(up.Str|up.Null, "server", "storage_host")
+	helper.Copy(up.Map, "server", "cdn_hosts")
+	helper.Copy(up.Str|up.Null, "server", "cds_host")
+	helper.Copy(up.Str|up.Null, "server", "cds_mrenclave")
+	helper.Copy(up.Str|up.Null, "server", "svr2_host")
+	helper.Copy(up.Str|up.Null, "server", "svr2_mrenclave")
+	helper.Copy(up.Str|up.Null, "server", "server_public_params")
+	helper.Copy(up.List, "server", "trust_roots")
+	helper.Copy(up.Bool, "server", "trust_system_roots")
+	helper.Copy(up.Str|up.Null, "proxy")
+	helper.Copy(up.Str, "censorship_circumvention")
+	helper.Copy(up.Bool, "metrics", "enabled")
+	helper.Copy(up.Str, "metrics", "listen")
 }
 
 func (s *SignalConnector) GetConfig() (string, any, up.Upgrader) {
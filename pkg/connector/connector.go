@@ -19,6 +19,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"text/template"
 	"time"
@@ -27,13 +28,17 @@ import (
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exsync"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/commands"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/mautrix-signal/pkg/msgconv"
+	"go.mau.fi/mautrix-signal/pkg/signalid"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/metrics"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
 )
 
 type SignalConnector struct {
@@ -41,11 +46,17 @@ type SignalConnector struct {
 	Store   *store.Container
 	Bridge  *bridgev2.Bridge
 	Config  SignalConfig
+
+	Environment *web.ServerEnvironment
+	Metrics     *metrics.Metrics
+
+	metricsServer *http.Server
 }
 
 var _ bridgev2.NetworkConnector = (*SignalConnector)(nil)
 var _ bridgev2.MaxFileSizeingNetwork = (*SignalConnector)(nil)
 var _ bridgev2.TransactionIDGeneratingNetwork = (*SignalConnector)(nil)
+var _ bridgev2.StoppableNetwork = (*SignalConnector)(nil)
 
 func (s *SignalConnector) GetName() bridgev2.BridgeName {
 	return bridgev2.BridgeName{
@@ -70,6 +81,7 @@ func (s *SignalConnector) Init(bridge *bridgev2.Bridge) {
 	s.MsgConv = msgconv.NewMessageConverter(bridge)
 	s.MsgConv.LocationFormat = s.Config.LocationFormat
 	s.MsgConv.DisappearViewOnce = s.Config.DisappearViewOnce
+	s.Bridge.Commands.(*commands.Processor).AddHandlers(cmdListDevices, cmdRemoveDevice, cmdRenameDevice, cmdUploadStickerPack, cmdSetProxy, cmdReconnect)
 }
 
 func (s *SignalConnector) SetMaxFileSize(maxSize int64) {
@@ -81,6 +93,29 @@ func (s *SignalConnector) Start(ctx context.Context) error {
 	if err != nil {
 		return bridgev2.DBUpgradeError{Err: err, Section: "signalmeow"}
 	}
+	s.Environment, err = s.Config.Server.Build()
+	if err != nil {
+		return err
+	}
+	s.Environment, err = s.Environment.WithProxy(s.Config.Proxy)
+	if err != nil {
+		return fmt.Errorf("failed to configure proxy: %w", err)
+	}
+	switch s.Config.CensorshipCircumvention {
+	case "", "off", "auto":
+	default:
+		fronting, ok := web.FrontingConfigs[s.Config.CensorshipCircumvention]
+		if !ok {
+			return fmt.Errorf("unknown censorship circumvention mode %q", s.Config.CensorshipCircumvention)
+		}
+		s.Environment, err = s.Environment.WithFronting(fronting)
+		if err != nil {
+			return fmt.Errorf("failed to configure domain fronting: %w", err)
+		}
+	}
+	// Free functions like attachment downloads use the default environment unless the context specifies one
+	web.DefaultEnvironment = s.Environment
+	s.startMetrics()
 	return nil
 }
 
@@ -100,10 +135,16 @@ func (s *SignalConnector) LoadUserLogin(ctx context.Context, login *bridgev2.Use
 		queueEmptyWaiter: exsync.NewEvent(),
 	}
 	if device != nil {
+		env, err := s.environmentForLogin(device.Number, login.Metadata.(*signalid.UserLoginMetadata).Proxy)
+		if err != nil {
+			return err
+		}
 		sc.Client = &signalmeow.Client{
 			Store:        device,
 			Log:          sc.UserLogin.Log.With().Str("component", "signalmeow").Logger(),
+			Environment:  env,
 			EventHandler: sc.handleSignalEvent,
+			Metrics:      s.Metrics,
 
 			SyncContactsOnConnect: s.Config.SyncContactsOnStartup,
 		}
@@ -112,6 +153,48 @@ func (s *SignalConnector) LoadUserLogin(ctx context.Context, login *bridgev2.Use
 	return nil
 }
 
+// HandleNetworkChange reconnects all logins that are currently loaded. It should be called when the bridge
+// detects that the network has changed, as the existing connections are likely dead.
+func (s *SignalConnector) HandleNetworkChange(ctx context.Context) error {
+	userIDs, err := s.Bridge.DB.UserLogin.GetAllUserIDsWithLogins(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get users with logins: %w", err)
+	}
+	for _, userID := range userIDs {
+		logins, err := s.Bridge.DB.UserLogin.GetAllForUser(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to get logins of %s: %w", userID, err)
+		}
+		for _, dbLogin := range logins {
+			login := s.Bridge.GetCachedUserLoginByID(dbLogin.ID)
+			if login == nil {
+				continue
+			}
+			if sc, ok := login.Client.(*SignalClient); ok {
+				sc.forceReconnect()
+			}
+		}
+	}
+	return nil
+}
+
+// environmentForLogin returns the server environment for a login with the given phone number and proxy override.
+func (s *SignalConnector) environmentForLogin(number, proxy string) (*web.ServerEnvironment, error) {
+	env := s.Environment
+	var err error
+	if s.Config.CensorshipCircumvention == "auto" {
+		env, err = env.WithFronting(web.FrontingForNumber(number))
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure domain fronting for login: %w", err)
+		}
+	}
+	env, err = env.WithProxy(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxy for login: %w", err)
+	}
+	return env, nil
+}
+
 func (s *SignalConnector) GenerateTransactionID(userID id.UserID, roomID id.RoomID, eventType event.Type) networkid.RawTransactionID {
 	return networkid.RawTransactionID(strconv.FormatInt(time.Now().UnixMilli(), 10))
 }
@@ -5,7 +5,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"os"
 
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/mediaproxy"
@@ -38,18 +41,40 @@ func (s *SignalConnector) Download(ctx context.Context, mediaID networkid.MediaI
 			Int("key_len", len(info.Key)).
 			Int("digest_len", len(info.Digest)).
 			Uint32("size", info.Size).
+			Int("incremental_mac_len", len(info.IncrementalMAC)).
+			Uint32("chunk_size", info.ChunkSize).
+			Stringer("user_id", info.UserID).
 			Msg("Direct downloading attachment")
-
+		ctx := s.directMediaEnvContext(ctx, info.UserID)
+
+		if len(info.IncrementalMAC) == 0 {
+			// Without an incremental MAC, nothing is verified until the whole attachment has been downloaded,
+			// so let the media proxy spool it into a temp file and only serve it after verification.
+			return &mediaproxy.GetMediaResponseFile{
+				Callback: func(f *os.File) error {
+					err := signalmeow.DownloadAttachmentStream(
+						ctx, info.CDNID, info.CDNKey, info.CDNNumber, info.Key, info.Digest, info.Size, f,
+					)
+					if err != nil {
+						log.Err(err).Msg("Direct download failed")
+					}
+					return err
+				},
+			}, nil
+		}
 		return &mediaproxy.GetMediaResponseCallback{
 			Callback: func(w io.Writer) (int64, error) {
-				data, err := signalmeow.DownloadAttachment(ctx, info.CDNID, info.CDNKey, info.CDNNumber, info.Key, info.Digest, info.Size)
+				// With an incremental MAC, each chunk is validated before it's written,
+				// so the data can be proxied to the client as it's downloaded.
+				err := signalmeow.DownloadAttachmentStreamWithIncrementalMAC(
+					ctx, info.CDNID, info.CDNKey, info.CDNNumber, info.Key, info.Digest,
+					info.IncrementalMAC, info.ChunkSize, info.Size, w,
+				)
 				if err != nil {
 					log.Err(err).Msg("Direct download failed")
 					return 0, err
 				}
-
-				_, err = w.Write(data)
-				return int64(info.Size), err
+				return int64(info.Size), nil
 			},
 		}, nil
 	case *signalid.DirectMediaGroupAvatar:
@@ -118,6 +143,26 @@ func (s *SignalConnector) Download(ctx context.Context, mediaID networkid.MediaI
 					return 0, err
 				}
 
+				_, err = w.Write(data)
+				return int64(len(data)), err
+			},
+		}, nil
+	case *signalid.DirectMediaSticker:
+		log.Info().
+			Hex("pack_id", info.PackID).
+			Uint32("sticker_id", info.StickerID).
+			Stringer("user_id", info.UserID).
+			Msg("Direct downloading sticker")
+		ctx := s.directMediaEnvContext(ctx, info.UserID)
+
+		return &mediaproxy.GetMediaResponseCallback{
+			Callback: func(w io.Writer) (int64, error) {
+				data, err := signalmeow.DownloadSticker(ctx, info.PackID, info.PackKey, info.StickerID)
+				if err != nil {
+					log.Err(err).Msg("Direct download failed")
+					return 0, err
+				}
+
 				_, err = w.Write(data)
 				return int64(len(data)), err
 			},
@@ -126,3 +171,24 @@ func (s *SignalConnector) Download(ctx context.Context, mediaID networkid.MediaI
 		return nil, fmt.Errorf("no downloader for direct media type: %T", info)
 	}
 }
+
+// directMediaEnvContext returns a context that makes downloads use the server environment and proxy
+// of the given login. Attachments and stickers don't need any data from the login, so if the login
+// isn't known (e.g. because the media ID predates user IDs in media IDs), the default environment is used.
+func (s *SignalConnector) directMediaEnvContext(ctx context.Context, userID uuid.UUID) context.Context {
+	if userID == uuid.Nil {
+		return ctx
+	}
+	userLogin, err := s.Bridge.GetExistingUserLoginByID(ctx, signalid.MakeUserLoginID(userID))
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get user login for direct download")
+		return ctx
+	} else if userLogin == nil {
+		return ctx
+	}
+	client, ok := userLogin.Client.(*SignalClient)
+	if !ok || client.Client == nil {
+		return ctx
+	}
+	return client.Client.EnvContext(ctx)
+}
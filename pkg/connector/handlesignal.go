@@ -31,6 +31,7 @@ import (
 	"maunium.net/go/mautrix/bridgev2/simplevent"
 	"maunium.net/go/mautrix/event"
 
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 	"go.mau.fi/mautrix-signal/pkg/signalid"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
 	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
@@ -55,6 +56,14 @@ func (s *SignalClient) handleSignalEvent(rawEvt events.SignalEvent) bool {
 		s.handleSignalACIFound(evt)
 	case *events.QueueEmpty:
 		s.queueEmptyWaiter.Set()
+	case *events.ContactRemoved:
+		s.handleSignalContactRemoved(evt)
+	case *events.GroupRemoved:
+		s.handleSignalGroupRemoved(evt)
+	case *events.StickerPackUpdate:
+		s.handleSignalStickerPackUpdate(evt)
+	case *events.Story:
+		return s.handleSignalStory(evt)
 	default:
 		s.UserLogin.Log.Warn().Type("event_type", evt).Msg("Unrecognized signalmeow event type")
 	}
@@ -164,6 +173,9 @@ func (evt *Bv2ChatEvent) GetType() bridgev2.RemoteEventType {
 			innerEvt.GetRequiredProtocolVersion() > uint32(signalpb.DataMessage_CURRENT),
 			innerEvt.GetFlags()&uint32(signalpb.DataMessage_EXPIRATION_TIMER_UPDATE) != 0:
 			return bridgev2.RemoteEventMessage
+		case innerEvt.Reaction != nil && innerEvt.StoryContext != nil:
+			// Story reactions are shown as replies rather than reactions
+			return bridgev2.RemoteEventMessage
 		case innerEvt.Reaction != nil:
 			if innerEvt.Reaction.GetRemove() {
 				return bridgev2.RemoteEventReactionRemove
@@ -521,6 +533,47 @@ func (s *SignalClient) handleSignalContactList(evt *events.ContactList) {
 			s.updateRemoteProfile(ctx, true)
 		}
 	}
+	s.syncContactChats(ctx, evt.InboxPositions)
+}
+
+func (s *SignalClient) handleSignalContactRemoved(evt *events.ContactRemoved) {
+	log := s.UserLogin.Log.With().
+		Str("action", "handle contact removed").
+		Stringer("aci", evt.Contact.ACI).
+		Stringer("pni", evt.Contact.PNI).
+		Logger()
+	ctx := log.WithContext(context.TODO())
+	var serviceID libsignalgo.ServiceID
+	if evt.Contact.ACI != uuid.Nil {
+		serviceID = libsignalgo.NewACIServiceID(evt.Contact.ACI)
+		ghost, err := s.Main.Bridge.GetGhostByID(ctx, signalid.MakeUserID(evt.Contact.ACI))
+		if err != nil {
+			log.Err(err).Msg("Failed to get ghost to update contact info")
+		} else if userInfo, err := s.contactToUserInfo(ctx, evt.Contact); err != nil {
+			log.Err(err).Msg("Failed to convert contact info")
+		} else {
+			ghost.UpdateInfo(ctx, userInfo)
+		}
+	} else {
+		serviceID = libsignalgo.NewPNIServiceID(evt.Contact.PNI)
+	}
+	s.UserLogin.QueueRemoteEvent(&simplevent.ChatDelete{
+		EventMeta: simplevent.EventMeta{
+			Type:      bridgev2.RemoteEventChatDelete,
+			PortalKey: s.makeDMPortalKey(serviceID),
+		},
+		OnlyForMe: true,
+	})
+}
+
+func (s *SignalClient) handleSignalGroupRemoved(evt *events.GroupRemoved) {
+	s.UserLogin.QueueRemoteEvent(&simplevent.ChatDelete{
+		EventMeta: simplevent.EventMeta{
+			Type:      bridgev2.RemoteEventChatDelete,
+			PortalKey: s.makePortalKey(string(evt.GroupID)),
+		},
+		OnlyForMe: true,
+	})
 }
 
 func (s *SignalClient) updateRemoteProfile(ctx context.Context, resendState bool) {
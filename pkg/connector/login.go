@@ -36,14 +36,22 @@ func (s *SignalConnector) GetLoginFlows() []bridgev2.LoginFlow {
 		Name:        "QR",
 		Description: "Scan a QR code to pair the bridge to your Signal app",
 		ID:          "qr",
+	}, {
+		Name:        "Register",
+		Description: "Register a phone number as a new primary Signal device. This will log out the Signal app on your phone, if any.",
+		ID:          "register",
 	}}
 }
 
 func (s *SignalConnector) CreateLogin(ctx context.Context, user *bridgev2.User, flowID string) (bridgev2.LoginProcess, error) {
-	if flowID != "qr" {
+	switch flowID {
+	case "qr":
+		return &QRLogin{User: user, Main: s}, nil
+	case "register":
+		return &RegisterLogin{User: user, Main: s}, nil
+	default:
 		return nil, fmt.Errorf("invalid login flow ID")
 	}
-	return &QRLogin{User: user, Main: s}, nil
 }
 
 type QRLogin struct {
@@ -54,6 +62,7 @@ type QRLogin struct {
 	newQRCount int
 
 	ProvData *store.DeviceData
+	Proxy    string
 }
 
 var _ bridgev2.LoginProcessDisplayAndWait = (*QRLogin)(nil)
@@ -77,7 +86,12 @@ func (qr *QRLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
 		Str("action", "login").
 		Stringer("user_id", qr.User.MXID).
 		Logger()
-	provCtx, cancel := context.WithCancel(log.WithContext(context.Background()))
+	qr.Proxy = qr.Main.proxyForNewLogin(qr.User, "")
+	env, err := qr.Main.environmentForLogin("", qr.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	provCtx, cancel := context.WithCancel(env.WithContext(log.WithContext(context.Background())))
 	qr.cancelChan = cancel
 	// Don't use the start context here: the channel will outlive the start request.
 	qr.ProvChan = signalmeow.PerformProvisioning(
@@ -160,7 +174,6 @@ func (qr *QRLogin) qrWait(ctx context.Context) (*bridgev2.LoginStep, error) {
 
 func (qr *QRLogin) processingWait(ctx context.Context) (*bridgev2.LoginStep, error) {
 	defer qr.cancelChan()
-	newLoginID := signalid.MakeUserLoginID(qr.ProvData.ACI)
 
 	select {
 	case resp := <-qr.ProvChan:
@@ -173,13 +186,37 @@ func (qr *QRLogin) processingWait(ctx context.Context) (*bridgev2.LoginStep, err
 		return nil, ctx.Err()
 	}
 
-	ul, err := qr.User.NewLogin(ctx, &database.UserLogin{
-		ID:         newLoginID,
-		RemoteName: qr.ProvData.Number,
+	return completeLogin(ctx, qr.User, qr.ProvData, qr.Proxy)
+}
+
+// proxyForNewLogin returns the proxy override that a new login should inherit from the user's existing logins,
+// so that logging in again or adding another account doesn't bypass a proxy the user has set up.
+// The login with the given phone number is preferred, followed by the user's default login.
+func (s *SignalConnector) proxyForNewLogin(user *bridgev2.User, number string) string {
+	if number != "" {
+		for _, login := range user.GetUserLogins() {
+			if login.RemoteProfile.Phone == number {
+				return login.Metadata.(*signalid.UserLoginMetadata).Proxy
+			}
+		}
+	}
+	if login := user.GetDefaultLogin(); login != nil {
+		return login.Metadata.(*signalid.UserLoginMetadata).Proxy
+	}
+	return ""
+}
+
+func completeLogin(ctx context.Context, user *bridgev2.User, data *store.DeviceData, proxy string) (*bridgev2.LoginStep, error) {
+	ul, err := user.NewLogin(ctx, &database.UserLogin{
+		ID:         signalid.MakeUserLoginID(data.ACI),
+		RemoteName: data.Number,
 		RemoteProfile: status.RemoteProfile{
-			Phone: qr.ProvData.Number,
+			Phone: data.Number,
+		},
+		Metadata: &signalid.UserLoginMetadata{
+			Proxy:               proxy,
+			ContactChatsPending: data.EphemeralBackupKey == nil && data.DeviceID != 1,
 		},
-		Metadata: &signalid.UserLoginMetadata{},
 	}, &bridgev2.NewLoginParams{
 		DeleteOnConflict: true,
 	})
@@ -190,7 +227,7 @@ func (qr *QRLogin) processingWait(ctx context.Context) (*bridgev2.LoginStep, err
 	return &bridgev2.LoginStep{
 		Type:         bridgev2.LoginStepTypeComplete,
 		StepID:       LoginStepComplete,
-		Instructions: fmt.Sprintf("Successfully logged in as %s / %s", qr.ProvData.Number, qr.ProvData.ACI),
+		Instructions: fmt.Sprintf("Successfully logged in as %s / %s", data.Number, data.ACI),
 		CompleteParams: &bridgev2.LoginCompleteParams{
 			UserLoginID: ul.ID,
 			UserLogin:   ul,
@@ -0,0 +1,64 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/metrics"
+)
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"`
+}
+
+func (s *SignalConnector) startMetrics() {
+	if !s.Config.Metrics.Enabled {
+		return
+	}
+	s.Metrics = metrics.New(prometheus.DefaultRegisterer)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s.metricsServer = &http.Server{
+		Addr:              s.Config.Metrics.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log := s.Bridge.Log.With().Str("component", "metrics").Logger()
+	go func() {
+		log.Info().Str("listen_address", s.Config.Metrics.Listen).Msg("Starting metrics listener")
+		err := s.metricsServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("Error in metrics listener")
+		}
+	}()
+}
+
+func (s *SignalConnector) Stop() {
+	if s.metricsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.metricsServer.Shutdown(ctx)
+	}
+}
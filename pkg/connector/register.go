@@ -0,0 +1,288 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/bridgev2"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+const (
+	LoginStepPhoneNumber = "fi.mau.signal.register.phone_number"
+	LoginStepCaptcha     = "fi.mau.signal.register.captcha"
+	LoginStepCode        = "fi.mau.signal.register.code"
+	LoginStepPIN         = "fi.mau.signal.register.pin"
+)
+
+const captchaURL = "https://signalcaptchas.org/registration/generate.html"
+
+// maxCaptchaAttempts is the number of captchas that can be submitted before the registration has to be started over.
+// Signal keeps asking for new captchas if it doesn't like the ones it gets, so there's no point in looping forever.
+const maxCaptchaAttempts = 5
+
+var ErrIncorrectVerificationCode = bridgev2.RespError{ErrCode: "FI.MAU.SIGNAL.INCORRECT_CODE", Err: "Incorrect verification code", StatusCode: 400}
+
+func makeIncorrectPINError(triesRemaining uint32) bridgev2.RespError {
+	return bridgev2.RespError{
+		ErrCode:    "FI.MAU.SIGNAL.INCORRECT_PIN",
+		Err:        fmt.Sprintf("Incorrect PIN, %d tries remaining", triesRemaining),
+		StatusCode: 400,
+	}
+}
+
+type RegisterLogin struct {
+	User *bridgev2.User
+	Main *SignalConnector
+
+	Number          string
+	Transport       signalmeow.VerificationTransport
+	ProfileName     string
+	Session         *signalmeow.VerificationSession
+	CaptchaAttempts int
+
+	// Set if registering failed due to registration lock and the master key must be restored with the PIN.
+	LockError *signalmeow.RegistrationLockError
+	// Set after registering successfully, when the PIN is used to restore or back up the master key.
+	Device *store.Device
+	Env    *web.ServerEnvironment
+	Proxy  string
+}
+
+var _ bridgev2.LoginProcessUserInput = (*RegisterLogin)(nil)
+
+func (rl *RegisterLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepPhoneNumber,
+		Instructions: "Enter the phone number to register and how to receive the verification code",
+		UserInputParams: &bridgev2.LoginUserInputParams{
+			Fields: []bridgev2.LoginInputDataField{{
+				Type:        bridgev2.LoginInputFieldTypePhoneNumber,
+				ID:          "phone_number",
+				Name:        "Phone number",
+				Description: "The phone number in international format",
+			}, {
+				Type:        bridgev2.LoginInputFieldTypeUsername,
+				ID:          "transport",
+				Name:        "Verification method",
+				Description: "Either sms or voice",
+				Pattern:     "^(sms|voice)$",
+			}, {
+				Type:        bridgev2.LoginInputFieldTypeUsername,
+				ID:          "profile_name",
+				Name:        "Profile name",
+				Description: "The name to show to other Signal users",
+				Pattern:     "^.+$",
+			}},
+		},
+	}, nil
+}
+
+func (rl *RegisterLogin) Cancel() {
+	// The device is saved before asking for the PIN, so delete it if the login is abandoned at that step
+	if rl.Device == nil {
+		return
+	}
+	err := rl.Main.Store.DeleteDevice(context.Background(), &rl.Device.DeviceData)
+	if err != nil {
+		rl.User.Log.Err(err).Stringer("aci", rl.Device.ACI).Msg("Failed to delete device of cancelled registration")
+	}
+	rl.Device = nil
+}
+
+func (rl *RegisterLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	if rl.Env != nil {
+		ctx = rl.Env.WithContext(ctx)
+	}
+	switch {
+	case rl.Session == nil:
+		rl.Number = input["phone_number"]
+		rl.Transport = signalmeow.VerificationTransport(input["transport"])
+		if rl.Transport != signalmeow.VerificationTransportSMS && rl.Transport != signalmeow.VerificationTransportVoice {
+			return nil, fmt.Errorf("invalid verification method %q", rl.Transport)
+		}
+		rl.ProfileName = strings.TrimSpace(input["profile_name"])
+		if rl.ProfileName == "" {
+			return nil, fmt.Errorf("profile name is required")
+		}
+		rl.Proxy = rl.Main.proxyForNewLogin(rl.User, rl.Number)
+		env, err := rl.Main.environmentForLogin(rl.Number, rl.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		rl.Env = env
+		ctx = env.WithContext(ctx)
+		session, err := signalmeow.CreateVerificationSession(ctx, rl.Number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create verification session: %w", err)
+		}
+		rl.Session = session
+		return rl.requestCode(ctx)
+	case input["captcha"] != "":
+		if rl.CaptchaAttempts >= maxCaptchaAttempts {
+			return nil, fmt.Errorf("too many captcha attempts, please start the registration over")
+		}
+		rl.CaptchaAttempts++
+		session, err := signalmeow.SubmitVerificationCaptcha(ctx, rl.Session.ID, input["captcha"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to submit captcha: %w", err)
+		}
+		rl.Session = session
+		return rl.requestCode(ctx)
+	case input["code"] != "":
+		return rl.submitCode(ctx, input["code"])
+	case input["pin"] != "":
+		return rl.submitPIN(ctx, input["pin"])
+	default:
+		return nil, fmt.Errorf("unexpected input")
+	}
+}
+
+func (rl *RegisterLogin) requestCode(ctx context.Context) (*bridgev2.LoginStep, error) {
+	if rl.Session.NeedsCaptcha() || !rl.Session.AllowedToRequestCode {
+		return &bridgev2.LoginStep{
+			Type:   bridgev2.LoginStepTypeUserInput,
+			StepID: LoginStepCaptcha,
+			Instructions: fmt.Sprintf(
+				"Signal requires a captcha. Solve it at %s, then copy the \"Open Signal\" link and paste it here.",
+				captchaURL,
+			),
+			UserInputParams: &bridgev2.LoginUserInputParams{
+				Fields: []bridgev2.LoginInputDataField{{
+					Type:        bridgev2.LoginInputFieldTypeToken,
+					ID:          "captcha",
+					Name:        "Captcha token",
+					Description: "The signalcaptcha:// link",
+				}},
+			},
+		}, nil
+	}
+	session, err := signalmeow.RequestVerificationCode(ctx, rl.Session.ID, rl.Transport, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to request verification code: %w", err)
+	}
+	rl.Session = session
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepCode,
+		Instructions: fmt.Sprintf("Enter the verification code sent to %s", rl.Number),
+		UserInputParams: &bridgev2.LoginUserInputParams{
+			Fields: []bridgev2.LoginInputDataField{{
+				Type: bridgev2.LoginInputFieldType2FACode,
+				ID:   "code",
+				Name: "Verification code",
+			}},
+		},
+	}, nil
+}
+
+func (rl *RegisterLogin) submitCode(ctx context.Context, code string) (*bridgev2.LoginStep, error) {
+	_, err := signalmeow.SubmitVerificationCode(ctx, rl.Session.ID, code)
+	if errors.Is(err, signalmeow.ErrVerificationCodeIncorrect) {
+		return nil, ErrIncorrectVerificationCode
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to submit verification code: %w", err)
+	}
+	device, err := signalmeow.RegisterPrimaryDevice(ctx, rl.Main.Store, rl.Number, rl.Session.ID, &signalmeow.RegistrationParams{
+		ProfileName: rl.ProfileName,
+	})
+	var lockErr *signalmeow.RegistrationLockError
+	if errors.As(err, &lockErr) {
+		if lockErr.SVR2Username == "" {
+			return nil, fmt.Errorf("account is registration locked and no SVR2 credentials were provided, try again in %s", lockErr.TimeRemaining)
+		}
+		rl.LockError = lockErr
+		return makePINStep("This account has registration lock enabled. Enter your Signal PIN to continue."), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to register: %w", err)
+	}
+	rl.Device = device
+	return makePINStep(
+		"Enter your Signal PIN. If the account already has a PIN, it will be used to restore your contacts and settings. " +
+			"Otherwise, it will be set as the new PIN.",
+	), nil
+}
+
+func makePINStep(instructions string) *bridgev2.LoginStep {
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepPIN,
+		Instructions: instructions,
+		UserInputParams: &bridgev2.LoginUserInputParams{
+			Fields: []bridgev2.LoginInputDataField{{
+				Type:    bridgev2.LoginInputFieldTypePassword,
+				ID:      "pin",
+				Name:    "Signal PIN",
+				Pattern: "^.{4,}$",
+			}},
+		},
+	}
+}
+
+func (rl *RegisterLogin) submitPIN(ctx context.Context, pin string) (*bridgev2.LoginStep, error) {
+	var mismatchErr *signalmeow.SVRPINMismatchError
+	if rl.Device == nil {
+		if rl.LockError == nil {
+			return nil, fmt.Errorf("unexpected input")
+		}
+		masterKey, err := signalmeow.RestoreMasterKeyFromSVR2(ctx, rl.LockError.SVR2Username, rl.LockError.SVR2Password, pin)
+		if errors.As(err, &mismatchErr) {
+			return nil, makeIncorrectPINError(mismatchErr.TriesRemaining)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to restore master key: %w", err)
+		}
+		device, err := signalmeow.RegisterPrimaryDevice(ctx, rl.Main.Store, rl.Number, rl.Session.ID, &signalmeow.RegistrationParams{
+			MasterKey:        masterKey,
+			RegistrationLock: signalmeow.RegistrationLockToken(masterKey),
+			ProfileName:      rl.ProfileName,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to register: %w", err)
+		}
+		return completeLogin(ctx, rl.User, &device.DeviceData, rl.Proxy)
+	}
+	cli := &signalmeow.Client{Store: rl.Device, Environment: rl.Env}
+	err := cli.RestoreMasterKeyWithPIN(ctx, pin)
+	if errors.Is(err, signalmeow.ErrSVRDataMissing) {
+		err = cli.BackupMasterKeyWithPIN(ctx, pin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up master key: %w", err)
+		}
+		err = cli.SetRegistrationLock(ctx, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to enable registration lock: %w", err)
+		}
+	} else if errors.As(err, &mismatchErr) {
+		return nil, makeIncorrectPINError(mismatchErr.TriesRemaining)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to restore master key: %w", err)
+	}
+	step, err := completeLogin(ctx, rl.User, &rl.Device.DeviceData, rl.Proxy)
+	if err == nil {
+		// The device belongs to the new login now, so it must not be deleted if the process is cancelled
+		rl.Device = nil
+	}
+	return step, err
+}
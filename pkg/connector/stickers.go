@@ -0,0 +1,342 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ffmpeg"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-signal/pkg/msgconv"
+	"go.mau.fi/mautrix-signal/pkg/signalid"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+)
+
+// StateImagePack is the MSC2545 room image pack state event type.
+var StateImagePack = event.Type{Type: "im.ponies.room_emotes", Class: event.StateEventType}
+
+// AccountDataImagePack is the MSC2545 personal image pack account data type.
+const AccountDataImagePack = "im.ponies.user_emotes"
+
+// Signal stickers are always 512x512, but they're displayed at half size.
+const stickerDisplaySize = 256
+
+type imagePackImage struct {
+	URL   id.ContentURIString `json:"url"`
+	Body  string              `json:"body,omitempty"`
+	Info  map[string]any      `json:"info,omitempty"`
+	Usage []string            `json:"usage,omitempty"`
+}
+
+type imagePackInfo struct {
+	DisplayName string              `json:"display_name,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	Usage       []string            `json:"usage,omitempty"`
+	Attribution string              `json:"attribution,omitempty"`
+}
+
+type imagePack struct {
+	Images map[string]*imagePackImage `json:"images"`
+	Pack   imagePackInfo              `json:"pack"`
+}
+
+func (s *SignalClient) handleSignalStickerPackUpdate(evt *events.StickerPackUpdate) {
+	log := s.UserLogin.Log.With().
+		Str("action", "bridge sticker pack").
+		Hex("pack_id", evt.PackID).
+		Bool("installed", evt.Installed).
+		Logger()
+	ctx := log.WithContext(context.Background())
+	go func() {
+		err := s.bridgeStickerPack(ctx, evt)
+		if err != nil {
+			log.Err(err).Msg("Failed to bridge sticker pack")
+		} else {
+			log.Debug().Msg("Bridged sticker pack update")
+		}
+	}()
+}
+
+// bridgeStickerPack publishes an installed Signal sticker pack as an image pack in the user's management room,
+// or clears the image pack if the sticker pack was removed.
+func (s *SignalClient) bridgeStickerPack(ctx context.Context, evt *events.StickerPackUpdate) error {
+	roomID, err := s.UserLogin.User.GetManagementRoom(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get management room: %w", err)
+	}
+	content := &event.Content{Raw: map[string]any{}}
+	if evt.Installed {
+		if evt.Manifest == nil {
+			return fmt.Errorf("sticker pack manifest not available")
+		}
+		pack, err := s.makeImagePack(ctx, roomID, evt.PackID, evt.PackKey, evt.Manifest)
+		if err != nil {
+			return err
+		}
+		content = &event.Content{Parsed: pack}
+	}
+	_, err = s.Main.Bridge.Bot.SendState(ctx, roomID, StateImagePack, hex.EncodeToString(evt.PackID), content, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to send image pack state event: %w", err)
+	}
+	return nil
+}
+
+func (s *SignalClient) makeImagePack(ctx context.Context, roomID id.RoomID, packID, packKey []byte, manifest *signalpb.Pack) (*imagePack, error) {
+	pack := &imagePack{
+		Images: make(map[string]*imagePackImage, len(manifest.GetStickers())),
+		Pack: imagePackInfo{
+			DisplayName: manifest.GetTitle(),
+			Usage:       []string{"sticker"},
+			Attribution: manifest.GetAuthor(),
+		},
+	}
+	for _, sticker := range manifest.GetStickers() {
+		url, err := s.getStickerURL(ctx, roomID, packID, packKey, sticker)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Uint32("sticker_id", sticker.GetId()).Msg("Failed to get sticker URL")
+			continue
+		}
+		shortcode := strconv.FormatUint(uint64(sticker.GetId()), 10)
+		pack.Images[shortcode] = &imagePackImage{
+			URL:  url,
+			Body: sticker.GetEmoji(),
+			Info: map[string]any{
+				"w":        stickerDisplaySize,
+				"h":        stickerDisplaySize,
+				"mimetype": getStickerMimeType(sticker),
+				msgconv.StickerMetadataKey: &msgconv.StickerMetadata{
+					ID:    sticker.GetId(),
+					Emoji: sticker.GetEmoji(),
+					Pack: msgconv.StickerPackMetadata{
+						ID:     packID,
+						Key:    packKey,
+						Title:  manifest.GetTitle(),
+						Author: manifest.GetAuthor(),
+					},
+				},
+			},
+		}
+		if sticker.GetId() == manifest.GetCover().GetId() {
+			pack.Pack.AvatarURL = url
+		}
+	}
+	if pack.Pack.AvatarURL == "" && manifest.GetCover() != nil {
+		url, err := s.getStickerURL(ctx, roomID, packID, packKey, manifest.GetCover())
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get sticker pack cover URL")
+		}
+		pack.Pack.AvatarURL = url
+	}
+	return pack, nil
+}
+
+func (s *SignalClient) getStickerURL(ctx context.Context, roomID id.RoomID, packID, packKey []byte, sticker *signalpb.Pack_Sticker) (id.ContentURIString, error) {
+	if s.Main.MsgConv.DirectMedia {
+		mediaID, err := signalid.DirectMediaSticker{
+			PackID:    packID,
+			PackKey:   packKey,
+			StickerID: sticker.GetId(),
+			UserID:    s.Client.Store.ACI,
+		}.AsMediaID()
+		if err != nil {
+			return "", err
+		}
+		return s.Main.Bridge.Matrix.GenerateContentURI(ctx, mediaID)
+	}
+	data, err := signalmeow.DownloadSticker(s.Client.EnvContext(ctx), packID, packKey, sticker.GetId())
+	if err != nil {
+		return "", err
+	}
+	url, _, err := s.Main.Bridge.Bot.UploadMedia(ctx, roomID, data, "", getStickerMimeType(sticker))
+	if err != nil {
+		return "", fmt.Errorf("failed to upload sticker: %w", err)
+	}
+	return url, nil
+}
+
+func getStickerMimeType(sticker *signalpb.Pack_Sticker) string {
+	if sticker.GetContentType() != "" {
+		return sticker.GetContentType()
+	}
+	return "image/webp"
+}
+
+// isSticker checks if the image can be used as a sticker according to the image and pack usage fields.
+func (img *imagePackImage) isSticker(pack *imagePack) bool {
+	usage := img.Usage
+	if len(usage) == 0 {
+		usage = pack.Pack.Usage
+	}
+	return len(usage) == 0 || slices.Contains(usage, "sticker")
+}
+
+// getStickerEmoji returns the body of an image pack image if it looks like an emoji rather than a description.
+func getStickerEmoji(img *imagePackImage) string {
+	if img.Body == "" || strings.IndexFunc(img.Body, func(r rune) bool {
+		return r < unicode.MaxASCII
+	}) >= 0 {
+		return ""
+	}
+	return img.Body
+}
+
+// convertToSignalSticker converts an image to a 512x512 WebP sticker with transparent padding.
+func convertToSignalSticker(ctx context.Context, data []byte, mimeType string) ([]byte, error) {
+	const size = signalmeow.StickerDimension
+	filter := fmt.Sprintf(
+		"scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=0x00000000",
+		size,
+	)
+	for _, quality := range []string{"80", "50"} {
+		converted, err := ffmpeg.ConvertBytes(ctx, data, ".sticker.webp", nil, []string{
+			"-vf", filter, "-c:v", "libwebp", "-quality", quality, "-loop", "0",
+		}, mimeType)
+		if err != nil {
+			return nil, err
+		} else if len(converted) <= signalmeow.MaxStickerSize {
+			return converted, nil
+		}
+	}
+	return nil, fmt.Errorf("sticker is too large even after compression")
+}
+
+// checkImagePackAccess makes sure the user is allowed to use the image pack in the given room.
+// Reading requires being joined to the room, writing also requires permission to send the state event.
+// The bridge bot is used to access room packs, so without this check users could read and overwrite
+// packs in any room the bot is in.
+func checkImagePackAccess(ctx context.Context, br *bridgev2.Bridge, user *bridgev2.User, roomID id.RoomID, write bool) error {
+	member, err := br.Matrix.GetMemberInfo(ctx, roomID, user.MXID)
+	if err != nil {
+		return fmt.Errorf("failed to get membership: %w", err)
+	} else if member == nil || member.Membership != event.MembershipJoin {
+		return fmt.Errorf("you're not in that room")
+	}
+	if write {
+		pls, err := br.Matrix.GetPowerLevels(ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("failed to get power levels: %w", err)
+		} else if pls.GetUserLevel(user.MXID) < pls.GetEventLevel(StateImagePack) {
+			return fmt.Errorf("you don't have permission to change image packs in that room")
+		}
+	}
+	return nil
+}
+
+// getImagePack fetches an MSC2545 image pack either from a room (using the bridge bot)
+// or from the user's account data (using their double puppet). The raw content is returned too,
+// so that the pack can be written back without losing unknown fields.
+func getImagePack(ctx context.Context, br *bridgev2.Bridge, user *bridgev2.User, roomID id.RoomID, stateKey string) (*imagePack, map[string]any, error) {
+	var raw json.RawMessage
+	if roomID == "" {
+		dp, ok := user.DoublePuppet(ctx).(*matrix.ASIntent)
+		if !ok || dp == nil {
+			return nil, nil, fmt.Errorf("reading personal image packs requires double puppeting")
+		}
+		err := dp.Matrix.GetAccountData(ctx, AccountDataImagePack, &raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get account data: %w", err)
+		}
+	} else {
+		asBot, ok := br.Bot.(*matrix.ASIntent)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported Matrix connector")
+		}
+		err := checkImagePackAccess(ctx, br, user, roomID, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = asBot.Matrix.StateEvent(ctx, roomID, StateImagePack, stateKey, &raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get image pack state event: %w", err)
+		}
+	}
+	var pack imagePack
+	var rawMap map[string]any
+	if err := json.Unmarshal(raw, &pack); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse image pack: %w", err)
+	} else if err = json.Unmarshal(raw, &rawMap); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse image pack: %w", err)
+	}
+	return &pack, rawMap, nil
+}
+
+// saveImagePack writes an image pack back to where getImagePack read it from.
+// Room packs are written with the user's double puppet if possible, and with the bridge bot otherwise.
+func saveImagePack(ctx context.Context, br *bridgev2.Bridge, user *bridgev2.User, roomID id.RoomID, stateKey string, content map[string]any) error {
+	dp, ok := user.DoublePuppet(ctx).(*matrix.ASIntent)
+	if roomID == "" {
+		if !ok || dp == nil {
+			return fmt.Errorf("double puppeting is not enabled")
+		}
+		return dp.Matrix.SetAccountData(ctx, AccountDataImagePack, content)
+	}
+	err := checkImagePackAccess(ctx, br, user, roomID, true)
+	if err != nil {
+		return err
+	}
+	intent := br.Bot
+	if ok && dp != nil {
+		intent = dp
+	}
+	_, err = intent.SendState(ctx, roomID, StateImagePack, stateKey, &event.Content{Raw: content}, time.Time{})
+	return err
+}
+
+// addStickerMetadataToImagePack adds the Signal sticker metadata to the info of each uploaded image,
+// so that Matrix clients which copy the image info into sticker events let the bridge send the real sticker.
+func addStickerMetadataToImagePack(content map[string]any, shortcodes []string, pack *store.StickerPack) {
+	images, _ := content["images"].(map[string]any)
+	for i, shortcode := range shortcodes {
+		img, _ := images[shortcode].(map[string]any)
+		if img == nil {
+			continue
+		}
+		info, _ := img["info"].(map[string]any)
+		if info == nil {
+			info = make(map[string]any)
+			img["info"] = info
+		}
+		sticker := pack.Manifest.GetStickers()[i]
+		info[msgconv.StickerMetadataKey] = &msgconv.StickerMetadata{
+			ID:    sticker.GetId(),
+			Emoji: sticker.GetEmoji(),
+			Pack: msgconv.StickerPackMetadata{
+				ID:     pack.ID,
+				Key:    pack.Key,
+				Title:  pack.Manifest.GetTitle(),
+				Author: pack.Manifest.GetAuthor(),
+			},
+		}
+	}
+}
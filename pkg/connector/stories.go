@@ -0,0 +1,102 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-signal/pkg/signalid"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+)
+
+func (s *SignalClient) makeStoriesPortalKey() networkid.PortalKey {
+	return networkid.PortalKey{
+		ID:       signalid.StoriesPortalID,
+		Receiver: s.UserLogin.ID,
+	}
+}
+
+func (s *SignalClient) makeStoriesChatInfo() *bridgev2.ChatInfo {
+	selfUser := s.makeEventSender(s.Client.Store.ACI)
+	return &bridgev2.ChatInfo{
+		Name:  ptr.Ptr(StoriesRoomName),
+		Topic: ptr.Ptr(StoriesRoomTopic),
+		Type:  ptr.Ptr(database.RoomTypeDefault),
+		Members: &bridgev2.ChatMemberList{
+			MemberMap: map[networkid.UserID]bridgev2.ChatMember{
+				selfUser.Sender: {
+					EventSender: selfUser,
+					Membership:  event.MembershipJoin,
+				},
+			},
+			// Only contacts posting stories can send messages
+			PowerLevels: &bridgev2.PowerLevelOverrides{
+				EventsDefault: &moderatorPL,
+				UsersDefault:  &moderatorPL,
+			},
+		},
+	}
+}
+
+func (s *SignalClient) handleSignalStory(evt *events.Story) bool {
+	var portalKey networkid.PortalKey
+	switch s.Main.Config.Stories {
+	case StoriesRoom:
+		portalKey = s.makeStoriesPortalKey()
+	case StoriesInDM:
+		if evt.Info.Sender == s.Client.Store.ACI && evt.Info.ChatID == s.Client.Store.ACI.String() {
+			// Own stories don't have a DM to go to
+			return true
+		}
+		portalKey = s.makePortalKey(evt.Info.ChatID)
+	default:
+		return true
+	}
+	return s.Main.Bridge.QueueRemoteEvent(s.UserLogin, &simplevent.Message[*events.Story]{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventMessage,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.
+					Stringer("sender_id", evt.Info.Sender).
+					Uint64("story_ts", evt.Timestamp)
+			},
+			PortalKey: portalKey,
+			// Stories shouldn't create DMs with everyone who posts one
+			CreatePortal: portalKey.ID == signalid.StoriesPortalID,
+			Sender:       s.makeEventSender(evt.Info.Sender),
+			Timestamp:    time.UnixMilli(int64(evt.Timestamp)),
+			StreamOrder:  int64(evt.Info.ServerTimestamp),
+		},
+		Data: evt,
+		ID:   signalid.MakeMessageID(evt.Info.Sender, evt.Timestamp),
+
+		ConvertMessageFunc: s.convertStory,
+	}).Success
+}
+
+func (s *SignalClient) convertStory(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, evt *events.Story) (*bridgev2.ConvertedMessage, error) {
+	return s.Main.MsgConv.StoryToMatrix(ctx, s.Client, portal, intent, evt), nil
+}
@@ -27,6 +27,15 @@ import (
 
 type AccountEntropyPool string
 
+func GenerateAccountEntropyPool() (AccountEntropyPool, error) {
+	var out *C.char
+	signalFfiError := C.signal_account_entropy_pool_generate(&out)
+	if signalFfiError != nil {
+		return "", wrapError(signalFfiError)
+	}
+	return AccountEntropyPool(CopyCStringToString(out)), nil
+}
+
 func (aep AccountEntropyPool) DeriveSVRKey() ([]byte, error) {
 	var out [C.SignalSVR_KEY_LEN]byte
 	signalFfiError := C.signal_account_entropy_pool_derive_svr_key(
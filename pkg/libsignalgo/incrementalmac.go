@@ -0,0 +1,161 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+/*
+#include "./libsignal-ffi.h"
+*/
+import "C"
+import (
+	"errors"
+	"runtime"
+)
+
+// ErrIncrementalMACMismatch is returned by ValidatingMac when a chunk doesn't match the expected digest.
+var ErrIncrementalMACMismatch = errors.New("incremental MAC mismatch")
+
+// CalculateIncrementalMACChunkSize returns the chunk size that clients use for data of the given size.
+func CalculateIncrementalMACChunkSize(dataSize uint32) (uint32, error) {
+	var out C.uint32_t
+	signalFfiError := C.signal_incremental_mac_calculate_chunk_size(&out, C.uint32_t(dataSize))
+	if signalFfiError != nil {
+		return 0, wrapError(signalFfiError)
+	}
+	return uint32(out), nil
+}
+
+// IncrementalMac calculates a HMAC-SHA256 for each chunk of a stream.
+type IncrementalMac struct {
+	nc  noCopy
+	ptr *C.SignalIncrementalMac
+}
+
+func wrapIncrementalMac(ptr *C.SignalIncrementalMac) *IncrementalMac {
+	mac := &IncrementalMac{ptr: ptr}
+	runtime.SetFinalizer(mac, (*IncrementalMac).Destroy)
+	return mac
+}
+
+func NewIncrementalMac(key []byte, chunkSize uint32) (*IncrementalMac, error) {
+	var mac C.SignalMutPointerIncrementalMac
+	signalFfiError := C.signal_incremental_mac_initialize(&mac, BytesToBuffer(key), C.uint32_t(chunkSize))
+	runtime.KeepAlive(key)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapIncrementalMac(mac.raw), nil
+}
+
+func (im *IncrementalMac) mutPtr() C.SignalMutPointerIncrementalMac {
+	return C.SignalMutPointerIncrementalMac{im.ptr}
+}
+
+func (im *IncrementalMac) Destroy() error {
+	runtime.SetFinalizer(im, nil)
+	return wrapError(C.signal_incremental_mac_destroy(im.mutPtr()))
+}
+
+// Update feeds data into the MAC and returns the digests of any chunks that were completed.
+func (im *IncrementalMac) Update(data []byte) ([]byte, error) {
+	var out C.SignalOwnedBuffer
+	signalFfiError := C.signal_incremental_mac_update(&out, im.mutPtr(), BytesToBuffer(data), 0, C.uint32_t(len(data)))
+	runtime.KeepAlive(im)
+	runtime.KeepAlive(data)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return CopySignalOwnedBufferToBytes(out), nil
+}
+
+// Finalize returns the digest of the last (possibly partial) chunk.
+// The MAC can't be used after finalizing.
+func (im *IncrementalMac) Finalize() ([]byte, error) {
+	var out C.SignalOwnedBuffer
+	signalFfiError := C.signal_incremental_mac_finalize(&out, im.mutPtr())
+	runtime.KeepAlive(im)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return CopySignalOwnedBufferToBytes(out), nil
+}
+
+// ValidatingMac validates a stream against a list of expected per-chunk digests.
+type ValidatingMac struct {
+	nc  noCopy
+	ptr *C.SignalValidatingMac
+}
+
+func wrapValidatingMac(ptr *C.SignalValidatingMac) *ValidatingMac {
+	mac := &ValidatingMac{ptr: ptr}
+	runtime.SetFinalizer(mac, (*ValidatingMac).Destroy)
+	return mac
+}
+
+// NewValidatingMac creates a validator for the given concatenated chunk digests.
+func NewValidatingMac(key []byte, chunkSize uint32, digests []byte) (*ValidatingMac, error) {
+	var mac C.SignalMutPointerValidatingMac
+	signalFfiError := C.signal_validating_mac_initialize(&mac, BytesToBuffer(key), C.uint32_t(chunkSize), BytesToBuffer(digests))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(digests)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	if mac.raw == nil {
+		return nil, errors.New("invalid incremental MAC parameters")
+	}
+	return wrapValidatingMac(mac.raw), nil
+}
+
+func (vm *ValidatingMac) mutPtr() C.SignalMutPointerValidatingMac {
+	return C.SignalMutPointerValidatingMac{vm.ptr}
+}
+
+func (vm *ValidatingMac) Destroy() error {
+	runtime.SetFinalizer(vm, nil)
+	return wrapError(C.signal_validating_mac_destroy(vm.mutPtr()))
+}
+
+// Update feeds data into the validator and returns the number of bytes that became validated by this call.
+//
+// Validated bytes are always whole chunks. Bytes that aren't validated yet are
+// buffered internally and will be counted by a later Update or Finalize call.
+func (vm *ValidatingMac) Update(data []byte) (int, error) {
+	var out C.int32_t
+	signalFfiError := C.signal_validating_mac_update(&out, vm.mutPtr(), BytesToBuffer(data), 0, C.uint32_t(len(data)))
+	runtime.KeepAlive(vm)
+	runtime.KeepAlive(data)
+	if signalFfiError != nil {
+		return 0, wrapError(signalFfiError)
+	} else if out < 0 {
+		return 0, ErrIncrementalMACMismatch
+	}
+	return int(out), nil
+}
+
+// Finalize validates the last chunk and returns the number of bytes that became validated.
+// The validator can't be used after finalizing.
+func (vm *ValidatingMac) Finalize() (int, error) {
+	var out C.int32_t
+	signalFfiError := C.signal_validating_mac_finalize(&out, vm.mutPtr())
+	runtime.KeepAlive(vm)
+	if signalFfiError != nil {
+		return 0, wrapError(signalFfiError)
+	} else if out < 0 {
+		return 0, ErrIncrementalMACMismatch
+	}
+	return int(out), nil
+}
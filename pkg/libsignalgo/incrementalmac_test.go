@@ -0,0 +1,82 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/random"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+)
+
+func calculateIncrementalMAC(t *testing.T, key, data []byte, chunkSize uint32) []byte {
+	mac, err := libsignalgo.NewIncrementalMac(key, chunkSize)
+	require.NoError(t, err)
+	defer mac.Destroy()
+	var digests []byte
+	for i := 0; i < len(data); i += 1000 {
+		digest, err := mac.Update(data[i:min(i+1000, len(data))])
+		require.NoError(t, err)
+		digests = append(digests, digest...)
+	}
+	digest, err := mac.Finalize()
+	require.NoError(t, err)
+	return append(digests, digest...)
+}
+
+func TestIncrementalMAC_Validate(t *testing.T) {
+	setupLogging()
+	key := random.Bytes(32)
+	data := random.Bytes(300_000)
+	chunkSize, err := libsignalgo.CalculateIncrementalMACChunkSize(uint32(len(data)))
+	require.NoError(t, err)
+	digests := calculateIncrementalMAC(t, key, data, chunkSize)
+
+	validator, err := libsignalgo.NewValidatingMac(key, chunkSize, digests)
+	require.NoError(t, err)
+	defer validator.Destroy()
+	var validated int
+	for i := 0; i < len(data); i += 1000 {
+		n, err := validator.Update(data[i:min(i+1000, len(data))])
+		require.NoError(t, err)
+		assert.Zero(t, n%int(chunkSize))
+		validated += n
+		assert.LessOrEqual(t, validated, i+1000)
+	}
+	n, err := validator.Finalize()
+	require.NoError(t, err)
+	assert.Equal(t, len(data), validated+n)
+}
+
+func TestIncrementalMAC_Mismatch(t *testing.T) {
+	setupLogging()
+	key := random.Bytes(32)
+	data := random.Bytes(300_000)
+	chunkSize, err := libsignalgo.CalculateIncrementalMACChunkSize(uint32(len(data)))
+	require.NoError(t, err)
+	digests := calculateIncrementalMAC(t, key, data, chunkSize)
+
+	data[5] ^= 0xff
+	validator, err := libsignalgo.NewValidatingMac(key, chunkSize, digests)
+	require.NoError(t, err)
+	defer validator.Destroy()
+	_, err = validator.Update(data)
+	assert.ErrorIs(t, err, libsignalgo.ErrIncrementalMACMismatch)
+}
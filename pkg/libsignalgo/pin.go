@@ -0,0 +1,114 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+/*
+#include "./libsignal-ffi.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"runtime"
+	"unsafe"
+)
+
+// PinHash contains the keys derived from a PIN for use with secure value recovery.
+//
+// The PIN must already be normalized before hashing.
+type PinHash struct {
+	nc  noCopy
+	ptr *C.SignalPinHash
+}
+
+func wrapPinHash(ptr *C.SignalPinHash) *PinHash {
+	pinHash := &PinHash{ptr: ptr}
+	runtime.SetFinalizer(pinHash, (*PinHash).Destroy)
+	return pinHash
+}
+
+// NewPinHashForSVR2 hashes the PIN with a salt derived from the SVR2 username and enclave measurement.
+func NewPinHashForSVR2(pin []byte, username string, mrenclave []byte) (*PinHash, error) {
+	var ph C.SignalMutPointerPinHash
+	cUsername := C.CString(username)
+	defer C.free(unsafe.Pointer(cUsername))
+	signalFfiError := C.signal_pin_hash_from_username_mrenclave(&ph, BytesToBuffer(pin), cUsername, BytesToBuffer(mrenclave))
+	runtime.KeepAlive(pin)
+	runtime.KeepAlive(mrenclave)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapPinHash(ph.raw), nil
+}
+
+func (ph *PinHash) mutPtr() C.SignalMutPointerPinHash {
+	return C.SignalMutPointerPinHash{ph.ptr}
+}
+
+func (ph *PinHash) constPtr() C.SignalConstPointerPinHash {
+	return C.SignalConstPointerPinHash{ph.ptr}
+}
+
+func (ph *PinHash) Destroy() error {
+	runtime.SetFinalizer(ph, nil)
+	return wrapError(C.signal_pin_hash_destroy(ph.mutPtr()))
+}
+
+// AccessKey returns the key that is sent to the SVR enclave in place of the PIN.
+func (ph *PinHash) AccessKey() ([]byte, error) {
+	var out [32]byte
+	signalFfiError := C.signal_pin_hash_access_key((*[32]C.uint8_t)(unsafe.Pointer(&out)), ph.constPtr())
+	runtime.KeepAlive(ph)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return out[:], nil
+}
+
+// EncryptionKey returns the key that is used to encrypt the data stored in the SVR enclave.
+func (ph *PinHash) EncryptionKey() ([]byte, error) {
+	var out [32]byte
+	signalFfiError := C.signal_pin_hash_encryption_key((*[32]C.uint8_t)(unsafe.Pointer(&out)), ph.constPtr())
+	runtime.KeepAlive(ph)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return out[:], nil
+}
+
+// LocalPinHash creates a salted hash of the PIN that can be stored locally to verify the PIN later.
+func LocalPinHash(pin []byte) (string, error) {
+	var out *C.char
+	signalFfiError := C.signal_pin_local_hash(&out, BytesToBuffer(pin))
+	runtime.KeepAlive(pin)
+	if signalFfiError != nil {
+		return "", wrapError(signalFfiError)
+	}
+	return CopyCStringToString(out), nil
+}
+
+// VerifyLocalPinHash checks if the PIN matches a hash created with LocalPinHash.
+func VerifyLocalPinHash(encodedHash string, pin []byte) (bool, error) {
+	var out C.bool
+	cEncodedHash := C.CString(encodedHash)
+	defer C.free(unsafe.Pointer(cEncodedHash))
+	signalFfiError := C.signal_pin_verify_local_hash(&out, cEncodedHash, BytesToBuffer(pin))
+	runtime.KeepAlive(pin)
+	if signalFfiError != nil {
+		return false, wrapError(signalFfiError)
+	}
+	return bool(out), nil
+}
@@ -0,0 +1,66 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+)
+
+func TestPinHashForSVR2(t *testing.T) {
+	setupLogging()
+	mrenclave := make([]byte, 32)
+	hash1, err := libsignalgo.NewPinHashForSVR2([]byte("1234"), "username", mrenclave)
+	require.NoError(t, err)
+	hash2, err := libsignalgo.NewPinHashForSVR2([]byte("1234"), "username", mrenclave)
+	require.NoError(t, err)
+	otherUser, err := libsignalgo.NewPinHashForSVR2([]byte("1234"), "other", mrenclave)
+	require.NoError(t, err)
+
+	accessKey1, err := hash1.AccessKey()
+	require.NoError(t, err)
+	accessKey2, err := hash2.AccessKey()
+	require.NoError(t, err)
+	otherAccessKey, err := otherUser.AccessKey()
+	require.NoError(t, err)
+	encryptionKey, err := hash1.EncryptionKey()
+	require.NoError(t, err)
+
+	assert.Len(t, accessKey1, 32)
+	assert.Len(t, encryptionKey, 32)
+	assert.Equal(t, accessKey1, accessKey2)
+	assert.NotEqual(t, accessKey1, otherAccessKey)
+	assert.NotEqual(t, accessKey1, encryptionKey)
+}
+
+func TestLocalPinHash(t *testing.T) {
+	setupLogging()
+	hash, err := libsignalgo.LocalPinHash([]byte("password"))
+	require.NoError(t, err)
+
+	ok, err := libsignalgo.VerifyLocalPinHash(hash, []byte("password"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = libsignalgo.VerifyLocalPinHash(hash, []byte("badpassword"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,185 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+/*
+#include "./libsignal-ffi.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/google/uuid"
+)
+
+// ServerSecretParams are the zkgroup secrets of a Signal server.
+// Clients never have these, they're only used for running a fake server in tests.
+type ServerSecretParams = C.SignalServerSecretParams
+
+func GenerateServerSecretParams() (*ServerSecretParams, error) {
+	randomness := GenerateRandomness()
+	var out C.SignalMutPointerServerSecretParams
+	signalFfiError := C.signal_server_secret_params_generate_deterministic(
+		&out,
+		(*[C.SignalRANDOMNESS_LEN]C.uint8_t)(unsafe.Pointer(&randomness[0])),
+	)
+	runtime.KeepAlive(randomness)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return out.raw, nil
+}
+
+func ServerSecretParamsGetPublicParams(ssp *ServerSecretParams) (*ServerPublicParams, error) {
+	var out C.SignalMutPointerServerPublicParams
+	signalFfiError := C.signal_server_secret_params_get_public_params(&out, C.SignalConstPointerServerSecretParams{ssp})
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return out.raw, nil
+}
+
+func SerializeServerPublicParams(spp *ServerPublicParams) ([]byte, error) {
+	var out C.SignalOwnedBuffer = C.SignalOwnedBuffer{}
+	signalFfiError := C.signal_server_public_params_serialize(&out, C.SignalConstPointerServerPublicParams{spp})
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return CopySignalOwnedBufferToBytes(out), nil
+}
+
+func ServerSecretParamsIssueAuthCredentialWithPni(
+	ssp *ServerSecretParams,
+	aci uuid.UUID,
+	pni uuid.UUID,
+	redemptionTime uint64,
+) (*AuthCredentialWithPniResponse, error) {
+	randomness := GenerateRandomness()
+	var out C.SignalOwnedBuffer = C.SignalOwnedBuffer{}
+	signalFfiError := C.signal_server_secret_params_issue_auth_credential_with_pni_zkc_deterministic(
+		&out,
+		C.SignalConstPointerServerSecretParams{ssp},
+		(*[C.SignalRANDOMNESS_LEN]C.uint8_t)(unsafe.Pointer(&randomness[0])),
+		NewACIServiceID(aci).CFixedBytes(),
+		NewPNIServiceID(pni).CFixedBytes(),
+		C.uint64_t(redemptionTime),
+	)
+	runtime.KeepAlive(randomness)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	resultBytes := CopySignalOwnedBufferToBytes(out)
+	if len(resultBytes) != C.SignalAUTH_CREDENTIAL_WITH_PNI_RESPONSE_LEN {
+		return nil, fmt.Errorf("invalid response length %d (expected %d)", len(resultBytes), C.SignalAUTH_CREDENTIAL_WITH_PNI_RESPONSE_LEN)
+	}
+	return (*AuthCredentialWithPniResponse)(resultBytes), nil
+}
+
+func ServerSecretParamsIssueExpiringProfileKeyCredential(
+	ssp *ServerSecretParams,
+	request *ProfileKeyCredentialRequest,
+	aci uuid.UUID,
+	commitment *ProfileKeyCommitment,
+	expiration time.Time,
+) (*ExpiringProfileKeyCredentialResponse, error) {
+	randomness := GenerateRandomness()
+	var out ExpiringProfileKeyCredentialResponse
+	signalFfiError := C.signal_server_secret_params_issue_expiring_profile_key_credential_deterministic(
+		(*[C.SignalEXPIRING_PROFILE_KEY_CREDENTIAL_RESPONSE_LEN]C.uchar)(unsafe.Pointer(&out[0])),
+		C.SignalConstPointerServerSecretParams{ssp},
+		(*[C.SignalRANDOMNESS_LEN]C.uint8_t)(unsafe.Pointer(&randomness[0])),
+		(*[C.SignalPROFILE_KEY_CREDENTIAL_REQUEST_LEN]C.uchar)(unsafe.Pointer(request)),
+		NewACIServiceID(aci).CFixedBytes(),
+		(*[C.SignalPROFILE_KEY_COMMITMENT_LEN]C.uchar)(unsafe.Pointer(commitment)),
+		C.uint64_t(expiration.Unix()),
+	)
+	runtime.KeepAlive(randomness)
+	runtime.KeepAlive(request)
+	runtime.KeepAlive(commitment)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return &out, nil
+}
+
+func ServerSecretParamsVerifyAuthCredentialPresentation(
+	ssp *ServerSecretParams,
+	groupPublicParams GroupPublicParams,
+	presentation AuthCredentialPresentation,
+	now time.Time,
+) error {
+	signalFfiError := C.signal_server_secret_params_verify_auth_credential_presentation(
+		C.SignalConstPointerServerSecretParams{ssp},
+		(*[C.SignalGROUP_PUBLIC_PARAMS_LEN]C.uchar)(unsafe.Pointer(&groupPublicParams[0])),
+		BytesToBuffer(presentation),
+		C.uint64_t(now.Unix()),
+	)
+	runtime.KeepAlive(groupPublicParams)
+	runtime.KeepAlive(presentation)
+	return wrapError(signalFfiError)
+}
+
+func ServerSecretParamsVerifyProfileKeyCredentialPresentation(
+	ssp *ServerSecretParams,
+	groupPublicParams GroupPublicParams,
+	presentation ProfileKeyCredentialPresentation,
+	now time.Time,
+) error {
+	signalFfiError := C.signal_server_secret_params_verify_profile_key_credential_presentation(
+		C.SignalConstPointerServerSecretParams{ssp},
+		(*[C.SignalGROUP_PUBLIC_PARAMS_LEN]C.uchar)(unsafe.Pointer(&groupPublicParams[0])),
+		BytesToBuffer(presentation),
+		C.uint64_t(now.Unix()),
+	)
+	runtime.KeepAlive(groupPublicParams)
+	runtime.KeepAlive(presentation)
+	return wrapError(signalFfiError)
+}
+
+// ServerSecretParamsSign creates the server signature that clients check with ServerPublicParamsVerifySignature.
+func ServerSecretParamsSign(ssp *ServerSecretParams, message []byte) (NotarySignature, error) {
+	randomness := GenerateRandomness()
+	var out NotarySignature
+	signalFfiError := C.signal_server_secret_params_sign_deterministic(
+		(*[C.SignalSIGNATURE_LEN]C.uint8_t)(unsafe.Pointer(&out[0])),
+		C.SignalConstPointerServerSecretParams{ssp},
+		(*[C.SignalRANDOMNESS_LEN]C.uint8_t)(unsafe.Pointer(&randomness[0])),
+		BytesToBuffer(message),
+	)
+	runtime.KeepAlive(randomness)
+	runtime.KeepAlive(message)
+	if signalFfiError != nil {
+		return out, wrapError(signalFfiError)
+	}
+	return out, nil
+}
+
+func (a AuthCredentialPresentation) UUIDCiphertext() (UUIDCiphertext, error) {
+	out := [C.SignalUUID_CIPHERTEXT_LEN]C.uchar{}
+	signalFfiError := C.signal_auth_credential_presentation_get_uuid_ciphertext(&out, BytesToBuffer(a))
+	runtime.KeepAlive(a)
+	if signalFfiError != nil {
+		return UUIDCiphertext{}, wrapError(signalFfiError)
+	}
+	var result UUIDCiphertext
+	copy(result[:], C.GoBytes(unsafe.Pointer(&out), C.int(C.SignalUUID_CIPHERTEXT_LEN)))
+	return result, nil
+}
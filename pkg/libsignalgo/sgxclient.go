@@ -52,6 +52,22 @@ func NewCDS2ClientState(mrenclave, attestationMessage []byte, currentTime time.T
 	return wrapSGXClientState(cds.raw), nil
 }
 
+func NewSVR2ClientState(mrenclave, attestationMessage []byte, currentTime time.Time) (*SGXClientState, error) {
+	var svr C.SignalMutPointerSgxClientState
+	signalFfiError := C.signal_svr2_client_new(
+		&svr,
+		BytesToBuffer(mrenclave),
+		BytesToBuffer(attestationMessage),
+		C.uint64_t(currentTime.UnixMilli()),
+	)
+	runtime.KeepAlive(mrenclave)
+	runtime.KeepAlive(attestationMessage)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapSGXClientState(svr.raw), nil
+}
+
 func (cds *SGXClientState) mutPtr() C.SignalMutPointerSgxClientState {
 	return C.SignalMutPointerSgxClientState{cds.ptr}
 }
@@ -0,0 +1,107 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestImage(width, height int, fn func(x, y int) color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fn(x, y))
+		}
+	}
+	return img
+}
+
+func solidColor(c color.Color) func(x, y int) color.Color {
+	return func(x, y int) color.Color {
+		return c
+	}
+}
+
+var (
+	red    = color.RGBA{R: 255, A: 255}
+	black  = color.RGBA{A: 255}
+	white  = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	blue   = color.RGBA{B: 255, A: 255}
+	yellow = color.RGBA{R: 255, G: 255, A: 255}
+)
+
+func TestEncodeBlurhash(t *testing.T) {
+	// The expected hashes were calculated with a port of the reference implementation that doesn't sample pixels.
+	tests := []struct {
+		name        string
+		img         image.Image
+		xComponents int
+		yComponents int
+		expected    string
+	}{
+		{"Solid", makeTestImage(8, 8, solidColor(red)), 4, 3, "LfTI:j|cfQ|c|csUfQsUfQfQfQfQ"},
+		{"SinglePixel", makeTestImage(1, 1, solidColor(color.RGBA{R: 12, G: 34, B: 56, A: 255})), 4, 3, "LC1WZqt:t:t:t:t:t:t:t:t:t:t:"},
+		{"SinglePixelSingleComponent", makeTestImage(1, 1, solidColor(color.RGBA{R: 12, G: 34, B: 56, A: 255})), 1, 1, "001WZq"},
+		{"Gradient", makeTestImage(32, 16, func(x, y int) color.Color {
+			return color.RGBA{R: uint8(x * 255 / 31), G: uint8(y * 255 / 15), B: 128, A: 255}
+		}), 4, 3, "L$HezM2swxX8qRWDjtaggJfjfQfj"},
+		{"Checkerboard", makeTestImage(16, 16, func(x, y int) color.Color {
+			if (x/4+y/4)%2 == 0 {
+				return white
+			}
+			return black
+		}), 3, 3, "KBLqe9_3fQ_3~qfQfQfQfQ"},
+		{"Tall", makeTestImage(10, 40, func(x, y int) color.Color {
+			if y < 20 {
+				return blue
+			}
+			return yellow
+		}), 3, 5, "c~Lqe9xufQ0IRpfQRpa#fQ-yobfQfQfQfQ"},
+		// Large images are sampled, which doesn't make a difference for solid colors
+		{"SampledSolid", makeTestImage(200, 100, solidColor(red)), 4, 3, "L4TI:j|cfQ|c|cjtfQjtfQfQfQfQ"},
+		{"SubImage", makeTestImage(20, 20, solidColor(red)).(*image.RGBA).SubImage(image.Rect(6, 6, 14, 14)), 4, 3, "LfTI:j|cfQ|c|csUfQsUfQfQfQfQ"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := encodeBlurhash(test.img, test.xComponents, test.yComponents)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, hash)
+		})
+	}
+}
+
+func TestEncodeBlurhash_Errors(t *testing.T) {
+	img := makeTestImage(8, 8, solidColor(red))
+	for _, size := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(0, 0, 0, 10),
+		image.Rect(0, 0, 10, 0),
+		image.Rect(5, 5, 5, 5),
+	} {
+		_, err := encodeBlurhash(image.NewRGBA(size), 4, 3)
+		assert.ErrorIs(t, err, errBlurhashEmptyImage, "size %v", size)
+	}
+	for _, components := range [][2]int{{0, 3}, {4, 0}, {10, 3}, {4, 10}, {-1, -1}} {
+		_, err := encodeBlurhash(img, components[0], components[1])
+		assert.ErrorIs(t, err, errBlurhashInvalidComponents, "components %v", components)
+	}
+}
@@ -18,9 +18,14 @@ package msgconv
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exmime"
@@ -61,13 +66,9 @@ func (mc *MessageConverter) ToSignal(
 	if replyTo != nil {
 		authorACI, messageID, err := signalid.ParseMessageID(replyTo.ID)
 		if err == nil {
-			dm.Quote = &signalpb.DataMessage_Quote{
-				Id:        proto.Uint64(messageID),
-				AuthorAci: proto.String(authorACI.String()),
-				Type:      signalpb.DataMessage_Quote_NORMAL.Enum(),
-			}
-			if replyTo.Metadata.(*signalid.MessageMetadata).ContainsAttachments {
-				dm.Quote.Attachments = make([]*signalpb.DataMessage_Quote_QuotedAttachment, 1)
+			dm.StoryContext = makeStoryReplyContext(replyTo, authorACI, messageID)
+			if dm.StoryContext == nil {
+				dm.Quote = mc.makeQuote(ctx, replyTo, authorACI, messageID)
 			}
 		}
 	}
@@ -123,6 +124,14 @@ func (mc *MessageConverter) ToSignal(
 			Data:  att,
 			Emoji: emoji,
 		}
+		if meta := getStickerMetadata(evt); meta != nil {
+			dm.Sticker.PackId = meta.Pack.ID
+			dm.Sticker.PackKey = meta.Pack.Key
+			dm.Sticker.StickerId = proto.Uint32(meta.ID)
+			if emoji == nil && meta.Emoji != "" {
+				dm.Sticker.Emoji = proto.String(meta.Emoji)
+			}
+		}
 	case event.MsgLocation:
 		lat, lon, err := parseGeoURI(content.GeoURI)
 		if err != nil {
@@ -134,9 +143,51 @@ func (mc *MessageConverter) ToSignal(
 	default:
 		return nil, fmt.Errorf("%w %s", bridgev2.ErrUnsupportedMessageType, content.MsgType)
 	}
+	if len(dm.GetBody()) > MaxInlineBodyLength {
+		err := mc.moveLongTextToAttachment(ctx, dm)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return dm, nil
 }
 
+// MaxInlineBodyLength is the maximum length of a message body in bytes.
+// Longer bodies are truncated and the full text is sent as a long text attachment.
+const MaxInlineBodyLength = 2000
+
+const longTextContentType = "text/x-signal-plain"
+
+// moveLongTextToAttachment uploads the full body of the message as a long text attachment like the official
+// clients do, and truncates the inline body. Body ranges are left as-is, as they apply to the full text.
+func (mc *MessageConverter) moveLongTextToAttachment(ctx context.Context, dm *signalpb.DataMessage) error {
+	att, err := getClient(ctx).UploadAttachment(ctx, []byte(dm.GetBody()))
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to upload long text attachment")
+		return fmt.Errorf("%w: failed to upload long text: %w", bridgev2.ErrMediaReuploadFailed, err)
+	}
+	attachLongText(dm, att)
+	return nil
+}
+
+// attachLongText adds the uploaded long text attachment to the message and truncates the inline body.
+func attachLongText(dm *signalpb.DataMessage, att *signalpb.AttachmentPointer) {
+	att.ContentType = proto.String(longTextContentType)
+	dm.Attachments = append(dm.Attachments, att)
+	dm.Body = proto.String(truncateUTF8(dm.GetBody(), MaxInlineBodyLength))
+}
+
+// truncateUTF8 cuts the string to at most maxBytes bytes without splitting a multibyte character.
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
+
 func maybeInt[T constraints.Integer](v T) *T {
 	if v == 0 {
 		return nil
@@ -145,48 +196,54 @@ func maybeInt[T constraints.Integer](v T) *T {
 }
 
 func (mc *MessageConverter) convertFileToSignal(ctx context.Context, evt *event.Event, content *event.MessageEventContent) (*signalpb.AttachmentPointer, error) {
-	log := zerolog.Ctx(ctx)
-	data, err := mc.Bridge.Bot.DownloadMedia(ctx, content.URL, content.File)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
-	}
 	fileName := content.Body
 	if content.FileName != "" {
 		fileName = content.FileName
 	}
 	mime := content.GetInfo().MimeType
-	if mime == "" {
-		mime = http.DetectContentType(data)
-	}
-	if content.MSC3245Voice != nil && mime != "audio/aac" && ffmpeg.Supported() {
-		data, err = ffmpeg.ConvertBytes(ctx, data, ".aac", []string{}, []string{"-c:a", "aac"}, mime)
-		if err != nil {
-			return nil, err
+	var att *signalpb.AttachmentPointer
+	var mediaInfo *outgoingMediaInfo
+	var converted bool
+	var callbackErr error
+	err := mc.Bridge.Bot.DownloadMediaToFile(ctx, content.URL, content.File, false, func(file *os.File) error {
+		if mime == "" {
+			mime, callbackErr = detectFileMime(file)
+			if callbackErr != nil {
+				return callbackErr
+			}
 		}
-		mime = "audio/aac"
-		fileName += ".aac"
-	} else if evt.Type == event.EventSticker {
-		switch mime {
-		case "image/webp", "image/png", "image/apng":
-			// allowed
-		case "image/gif":
-			if !ffmpeg.Supported() {
-				return nil, fmt.Errorf("converting gif stickers is not supported")
+		var convertedPath, newMime string
+		convertedPath, newMime, callbackErr = mc.convertFileForSignal(ctx, evt, content, file.Name(), mime)
+		if callbackErr != nil {
+			return callbackErr
+		} else if convertedPath != "" {
+			defer os.Remove(convertedPath)
+			file, callbackErr = os.Open(convertedPath)
+			if callbackErr != nil {
+				callbackErr = fmt.Errorf("%w: failed to open converted file: %w", bridgev2.ErrMediaConvertFailed, callbackErr)
+				return callbackErr
+			}
+			defer file.Close()
+			if ext := filepath.Ext(convertedPath); !strings.EqualFold(filepath.Ext(fileName), ext) {
+				fileName += ext
 			}
-			data, err = ffmpeg.ConvertBytes(ctx, data, ".apng", []string{}, []string{}, mime)
+			mime = newMime
+			converted = true
+		}
+		if converted || needsMediaInfo(content, mime) {
+			var err error
+			mediaInfo, err = generateMediaInfo(ctx, file.Name(), mime)
 			if err != nil {
-				return nil, fmt.Errorf("%w (gif to apng): %w", bridgev2.ErrMediaConvertFailed, err)
+				zerolog.Ctx(ctx).Warn().Err(err).Str("mime_type", mime).Msg("Failed to generate media info")
 			}
-			fileName += ".apng"
-			mime = "image/apng"
-		default:
-			return nil, fmt.Errorf("unsupported content type for sticker %s", mime)
 		}
-	}
-	att, err := getClient(ctx).UploadAttachment(ctx, data)
-	if err != nil {
-		log.Err(err).Msg("Failed to upload file")
-		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+		att, callbackErr = mc.uploadFileToSignal(ctx, file)
+		return callbackErr
+	})
+	if callbackErr != nil {
+		return nil, callbackErr
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
 	}
 	if content.MSC3245Voice != nil && mime == "audio/aac" {
 		att.Flags = proto.Uint32(uint32(signalpb.AttachmentPointer_VOICE_MESSAGE))
@@ -196,13 +253,104 @@ func (mc *MessageConverter) convertFileToSignal(ctx context.Context, evt *event.
 	}
 	att.ContentType = proto.String(mime)
 	att.FileName = &fileName
-	att.Height = maybeInt(uint32(content.Info.Height))
-	att.Width = maybeInt(uint32(content.Info.Width))
+	if !converted {
+		// Conversion may resize the media, in which case the dimensions are taken from the generated media info
+		att.Height = maybeInt(uint32(content.Info.Height))
+		att.Width = maybeInt(uint32(content.Info.Width))
+	}
 	if content.Info.Blurhash != "" {
 		att.BlurHash = proto.String(content.Info.Blurhash)
 	} else if content.Info.AnoaBlurhash != "" {
 		att.BlurHash = proto.String(content.Info.AnoaBlurhash)
 	}
+	if mediaInfo != nil {
+		if att.Width == nil || att.Height == nil {
+			att.Width = maybeInt(uint32(mediaInfo.Width))
+			att.Height = maybeInt(uint32(mediaInfo.Height))
+		}
+		if att.BlurHash == nil && mediaInfo.Blurhash != "" {
+			att.BlurHash = proto.String(mediaInfo.Blurhash)
+		}
+		att.Thumbnail = mediaInfo.Thumbnail
+	}
+	return att, nil
+}
+
+// needsMediaInfo returns true if the Matrix event is missing metadata that can be generated from the file.
+// Videos always need it, because Matrix thumbnails aren't reused for the poster frame.
+func needsMediaInfo(content *event.MessageEventContent, mime string) bool {
+	if strings.HasPrefix(mime, "video/") {
+		return true
+	} else if !strings.HasPrefix(mime, "image/") {
+		return false
+	}
+	return content.Info.Width == 0 || content.Info.Height == 0 ||
+		(content.Info.Blurhash == "" && content.Info.AnoaBlurhash == "")
+}
+
+func detectFileMime(file *os.File) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read file header: %w", err)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("failed to seek file: %w", err)
+	}
+	return http.DetectContentType(header[:n]), nil
+}
+
+// convertFileForSignal converts the downloaded file into a format supported by Signal if necessary.
+// If the file was converted, the path to the new file is returned and the caller must remove it.
+func (mc *MessageConverter) convertFileForSignal(
+	ctx context.Context, evt *event.Event, content *event.MessageEventContent, path, mime string,
+) (convertedPath, newMime string, err error) {
+	var outputExt string
+	var outputArgs []string
+	if content.MSC3245Voice != nil && mime != "audio/aac" && ffmpeg.Supported() {
+		outputExt, newMime, outputArgs = ".aac", "audio/aac", []string{"-c:a", "aac"}
+	} else if evt.Type == event.EventSticker {
+		switch mime {
+		case "image/webp", "image/png", "image/apng":
+			// allowed
+		case "image/gif":
+			if !ffmpeg.Supported() {
+				return "", "", fmt.Errorf("converting gif stickers is not supported")
+			}
+			outputExt, newMime = ".apng", "image/apng"
+		default:
+			return "", "", fmt.Errorf("unsupported content type for sticker %s", mime)
+		}
+	} else if !ffmpeg.Supported() {
+		// Other conversions are best-effort, send the original file if ffmpeg isn't available
+	} else if policy := getTranscodePolicy(content, path, mime); policy != nil {
+		outputExt, newMime, outputArgs = policy.Extension, policy.MimeType, policy.OutputArgs(ctx, path)
+	}
+	if outputExt == "" {
+		return "", mime, nil
+	}
+	zerolog.Ctx(ctx).Debug().
+		Str("from_mime", mime).
+		Str("to_mime", newMime).
+		Msg("Converting file for Signal")
+	convertedPath, err = ffmpeg.ConvertPath(ctx, path, outputExt, []string{}, outputArgs, false)
+	if err != nil {
+		return "", "", fmt.Errorf("%w (%s to %s): %w", bridgev2.ErrMediaConvertFailed, mime, newMime, err)
+	}
+	return convertedPath, newMime, nil
+}
+
+func (mc *MessageConverter) uploadFileToSignal(ctx context.Context, file *os.File) (*signalpb.AttachmentPointer, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to stat file: %w", bridgev2.ErrMediaReuploadFailed, err)
+	}
+	att, err := getClient(ctx).UploadAttachmentStream(ctx, file, stat.Size())
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to upload file")
+		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+	}
 	return att, nil
 }
 
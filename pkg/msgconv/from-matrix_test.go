@@ -0,0 +1,142 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		maxBytes int
+		expected string
+	}{
+		{"Short", "hello", 10, "hello"},
+		{"ExactLength", "hello", 5, "hello"},
+		{"ASCII", "hello world", 5, "hello"},
+		{"Empty", "", 5, ""},
+		{"Zero", "hello", 0, ""},
+		// ä is 2 bytes, so cutting at 2 would split it
+		{"TwoByteAtCut", "aäb", 2, "a"},
+		{"TwoByteBeforeCut", "aäb", 3, "aä"},
+		// € is 3 bytes
+		{"ThreeByteAtCut", "ab€", 3, "ab"},
+		{"ThreeByteMiddleOfCut", "ab€", 4, "ab"},
+		// 🐈 is 4 bytes
+		{"FourByteAtCut", "a🐈", 2, "a"},
+		{"FourByteEndOfCut", "a🐈", 4, "a"},
+		{"FourByteFits", "a🐈b", 5, "a🐈"},
+		{"OnlyMultibyte", "🐈", 3, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := truncateUTF8(test.input, test.maxBytes)
+			assert.Equal(t, test.expected, output)
+			assert.True(t, utf8.ValidString(output))
+		})
+	}
+
+	t.Run("Long", func(t *testing.T) {
+		input := strings.Repeat("a", MaxInlineBodyLength-1) + "🐈🐈"
+		output := truncateUTF8(input, MaxInlineBodyLength)
+		assert.Equal(t, MaxInlineBodyLength-1, len(output))
+		assert.True(t, utf8.ValidString(output))
+	})
+}
+
+func makeStyleRange(start, length uint32, style signalpb.BodyRange_Style) *signalpb.BodyRange {
+	return &signalpb.BodyRange{
+		Start:           proto.Uint32(start),
+		Length:          proto.Uint32(length),
+		AssociatedValue: &signalpb.BodyRange_Style_{Style: style},
+	}
+}
+
+func TestAttachLongText(t *testing.T) {
+	body := strings.Repeat("a", MaxInlineBodyLength) + " mention and formatting past the cutoff"
+	ranges := []*signalpb.BodyRange{
+		makeStyleRange(0, 5, signalpb.BodyRange_BOLD),
+		makeStyleRange(MaxInlineBodyLength-2, 10, signalpb.BodyRange_ITALIC),
+		{
+			Start:           proto.Uint32(MaxInlineBodyLength + 1),
+			Length:          proto.Uint32(7),
+			AssociatedValue: &signalpb.BodyRange_MentionAci{MentionAci: "4b5b2a5b-0000-4000-8000-000000000000"},
+		},
+	}
+	expectedRanges := make([]*signalpb.BodyRange, len(ranges))
+	for i, br := range ranges {
+		expectedRanges[i] = proto.Clone(br).(*signalpb.BodyRange)
+	}
+	dm := &signalpb.DataMessage{
+		Body:       proto.String(body),
+		BodyRanges: ranges,
+	}
+	att := &signalpb.AttachmentPointer{}
+	attachLongText(dm, att)
+	assert.Equal(t, strings.Repeat("a", MaxInlineBodyLength), dm.GetBody())
+	if assert.Len(t, dm.Attachments, 1) {
+		assert.Same(t, att, dm.Attachments[0])
+		assert.Equal(t, longTextContentType, att.GetContentType())
+	}
+	// Body ranges apply to the full text in the attachment, so they must not be clipped to the inline body
+	if assert.Len(t, dm.BodyRanges, len(expectedRanges)) {
+		for i := range expectedRanges {
+			assert.True(t, proto.Equal(expectedRanges[i], dm.BodyRanges[i]), "range %d was modified", i)
+		}
+	}
+}
+
+func TestClipBodyRanges(t *testing.T) {
+	ranges := []*signalpb.BodyRange{
+		makeStyleRange(0, 5, signalpb.BodyRange_BOLD),
+		makeStyleRange(3, 10, signalpb.BodyRange_ITALIC),
+		makeStyleRange(10, 5, signalpb.BodyRange_STRIKETHROUGH),
+		makeStyleRange(15, 5, signalpb.BodyRange_MONOSPACE),
+	}
+	clipped := clipBodyRanges(ranges, 10)
+	expected := []*signalpb.BodyRange{
+		makeStyleRange(0, 5, signalpb.BodyRange_BOLD),
+		makeStyleRange(3, 7, signalpb.BodyRange_ITALIC),
+	}
+	if assert.Len(t, clipped, len(expected)) {
+		for i := range expected {
+			assert.True(t, proto.Equal(expected[i], clipped[i]), "range %d: expected %v, got %v", i, expected[i], clipped[i])
+		}
+	}
+	// The input ranges must not be modified
+	assert.Equal(t, uint32(10), ranges[1].GetLength())
+
+	assert.Empty(t, clipBodyRanges(ranges, 0))
+	assert.Empty(t, clipBodyRanges(nil, 10))
+}
+
+func TestUTF16Length(t *testing.T) {
+	assert.Equal(t, 0, utf16Length(""))
+	assert.Equal(t, 5, utf16Length("hello"))
+	assert.Equal(t, 3, utf16Length("aäb"))
+	// Characters outside the BMP are surrogate pairs in UTF-16
+	assert.Equal(t, 3, utf16Length("a🐈"))
+}
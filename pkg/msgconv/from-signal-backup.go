@@ -81,6 +81,26 @@ func BackupToDataMessage(ci *backuppb.ChatItem, attMap AttachmentMap) (*signalpb
 			Emoji:     ti.StickerMessage.Sticker.Emoji,
 			Data:      backupToSignalAttachment(ti.StickerMessage.Sticker.Data, 0, uuid.New(), attMap),
 		}
+	case *backuppb.ChatItem_DirectStoryReplyMessage:
+		reactions = ti.DirectStoryReplyMessage.Reactions
+		// Backups don't include the story itself, so the reply is bridged with a text fallback
+		dm.StoryContext = &signalpb.DataMessage_StoryContext{}
+		switch reply := ti.DirectStoryReplyMessage.Reply.(type) {
+		case *backuppb.DirectStoryReplyMessage_TextReply_:
+			if text := reply.TextReply.GetText(); text != nil {
+				dm.Body = &text.Body
+				dm.BodyRanges = slices.DeleteFunc(exslices.CastFunc(text.BodyRanges, backupToSignalBodyRange), deleteNil)
+			}
+			if reply.TextReply.GetLongText() != nil {
+				dm.Attachments = []*signalpb.AttachmentPointer{
+					backupToSignalAttachment(reply.TextReply.GetLongText(), 0, uuid.New(), attMap),
+				}
+			}
+		case *backuppb.DirectStoryReplyMessage_Emoji:
+			dm.Reaction = &signalpb.DataMessage_Reaction{Emoji: &reply.Emoji}
+		default:
+			return nil, nil
+		}
 	case *backuppb.ChatItem_RemoteDeletedMessage:
 		// TODO handle some other way? (also disappeared view-once messages)
 		return nil, nil
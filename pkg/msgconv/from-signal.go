@@ -22,7 +22,9 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -64,6 +66,9 @@ func calculateLength(dm *signalpb.DataMessage) int {
 	if dm.GiftBadge != nil {
 		length++
 	}
+	if dm.Reaction != nil && dm.StoryContext != nil {
+		length++
+	}
 	if length == 0 && dm.GetRequiredProtocolVersion() > uint32(signalpb.DataMessage_CURRENT) {
 		length = 1
 	}
@@ -114,7 +119,7 @@ func (mc *MessageConverter) ToMatrix(
 		return cm
 	}
 	for i, att := range dm.GetAttachments() {
-		if att.GetContentType() != "text/x-signal-plain" {
+		if att.GetContentType() != longTextContentType {
 			cm.Parts = append(cm.Parts, mc.convertAttachmentToMatrix(ctx, i, att, attMap))
 		} else {
 			longBody, err := mc.downloadSignalLongText(ctx, att, attMap)
@@ -137,6 +142,9 @@ func (mc *MessageConverter) ToMatrix(
 	if dm.Body != nil {
 		cm.Parts = append(cm.Parts, mc.convertTextToMatrix(ctx, dm, attMap))
 	}
+	if dm.Reaction != nil && dm.StoryContext != nil {
+		cm.Parts = append(cm.Parts, mc.convertStoryReactionToMatrix(ctx, dm.Reaction))
+	}
 	if len(cm.Parts) == 0 && dm.GetRequiredProtocolVersion() > uint32(signalpb.DataMessage_CURRENT) {
 		cm.Parts = append(cm.Parts, &bridgev2.ConvertedMessagePart{
 			Type: event.EventMessage,
@@ -173,6 +181,8 @@ func (mc *MessageConverter) ToMatrix(
 				MessageID: signalid.MakeMessageID(authorACI, dm.Quote.GetId()),
 			}
 		}
+	} else if dm.StoryContext != nil {
+		mc.addStoryReplyContext(ctx, cm, dm.StoryContext)
 	}
 	return cm
 }
@@ -442,15 +452,21 @@ func (mc *MessageConverter) convertStickerToMatrix(ctx context.Context, sticker
 	if converted.Extra == nil {
 		converted.Extra = map[string]any{}
 	}
-	// TODO fetch full pack metadata like the old bridge did?
-	converted.Extra["fi.mau.signal.sticker"] = map[string]any{
-		"id":    sticker.GetStickerId(),
-		"emoji": sticker.GetEmoji(),
-		"pack": map[string]any{
-			"id":  sticker.GetPackId(),
-			"key": sticker.GetPackKey(),
+	meta := &StickerMetadata{
+		ID:    sticker.GetStickerId(),
+		Emoji: sticker.GetEmoji(),
+		Pack: StickerPackMetadata{
+			ID:  sticker.GetPackId(),
+			Key: sticker.GetPackKey(),
 		},
 	}
+	if len(meta.Pack.ID) == 16 && len(meta.Pack.Key) == 32 {
+		// Backfilling from a transfer archive can contain lots of stickers, so only use installed packs there
+		manifest := getStickerPackManifest(ctx, meta.Pack.ID, meta.Pack.Key, attMap == nil)
+		meta.Pack.Title = manifest.GetTitle()
+		meta.Pack.Author = manifest.GetAuthor()
+	}
+	converted.Extra[StickerMetadataKey] = meta
 	return converted
 }
 
@@ -464,21 +480,43 @@ func (mc *MessageConverter) downloadSignalLongText(ctx context.Context, att *sig
 }
 
 func (mc *MessageConverter) downloadAttachment(ctx context.Context, att *signalpb.AttachmentPointer, attMap AttachmentMap) ([]byte, error) {
+	var buf bytes.Buffer
+	err := mc.downloadAttachmentStream(ctx, att, attMap, &buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (mc *MessageConverter) downloadAttachmentStream(ctx context.Context, att *signalpb.AttachmentPointer, attMap AttachmentMap, output io.Writer) error {
 	if att.AttachmentIdentifier == nil {
 		if len(att.GetClientUuid()) != 16 {
-			return nil, fmt.Errorf("no attachment identifier found")
+			return fmt.Errorf("no attachment identifier found")
 		}
 		target, ok := attMap[uuid.UUID(att.GetClientUuid())]
 		if !ok {
-			return nil, fmt.Errorf("no attachment identifier and attachment not found in map")
+			return fmt.Errorf("no attachment identifier and attachment not found in map")
 		} else if target == nil {
-			return nil, ErrAttachmentNotInBackup
+			return ErrAttachmentNotInBackup
 		} else {
 			// TODO add support for downloading attachments from backup
-			return nil, ErrBackupNotSupported
+			return ErrBackupNotSupported
 		}
 	}
-	return signalmeow.DownloadAttachmentWithPointer(ctx, att)
+	return signalmeow.DownloadAttachmentPointerStream(getClient(ctx).EnvContext(ctx), att, output)
+}
+
+// headerCapturingWriter stores the first 512 bytes written through it for mime type detection.
+type headerCapturingWriter struct {
+	io.Writer
+	header []byte
+}
+
+func (hcw *headerCapturingWriter) Write(p []byte) (int, error) {
+	if remaining := 512 - len(hcw.header); remaining > 0 {
+		hcw.header = append(hcw.header, p[:min(remaining, len(p))]...)
+	}
+	return hcw.Writer.Write(p)
 }
 
 func (mc *MessageConverter) reuploadAttachment(ctx context.Context, att *signalpb.AttachmentPointer, attMap AttachmentMap) (*bridgev2.ConvertedMessagePart, error) {
@@ -499,31 +537,43 @@ func (mc *MessageConverter) reuploadAttachment(ctx context.Context, att *signalp
 			Key:       att.Key,
 			Digest:    att.Digest,
 			Size:      att.GetSize(),
+
+			IncrementalMAC: att.IncrementalMac,
+			ChunkSize:      att.GetChunkSize(),
+			UserID:         getClient(ctx).Store.ACI,
 		}.AsMediaID()
 		if err != nil {
 			return nil, err
 		}
 		content.URL, err = mc.Bridge.Matrix.GenerateContentURI(ctx, mediaID)
 	} else {
-		data, err := mc.downloadAttachment(ctx, att, attMap)
-		if err != nil {
-			return nil, err
-		}
-		if mimeType == "" {
-			mimeType = http.DetectContentType(data)
-		}
-		if att.GetFlags()&uint32(signalpb.AttachmentPointer_VOICE_MESSAGE) != 0 && ffmpeg.Supported() {
-			data, err = ffmpeg.ConvertBytes(ctx, data, ".ogg", []string{}, []string{"-c:a", "libopus"}, mimeType)
+		convertVoice := att.GetFlags()&uint32(signalpb.AttachmentPointer_VOICE_MESSAGE) != 0 && ffmpeg.Supported()
+		var err error
+		content.URL, content.File, err = getIntent(ctx).UploadMediaStream(ctx, getPortal(ctx).MXID, int64(att.GetSize()), convertVoice, func(file io.Writer) (*bridgev2.FileStreamResult, error) {
+			hcw := &headerCapturingWriter{Writer: file}
+			err := mc.downloadAttachmentStream(ctx, att, attMap, hcw)
 			if err != nil {
-				return nil, fmt.Errorf("failed to convert audio to ogg/opus: %w", err)
+				return nil, err
 			}
-			fileName += ".ogg"
-			mimeType = "audio/ogg"
-			content.MSC3245Voice = &event.MSC3245Voice{}
-			// TODO include duration here (and in info) if there's some easy way to extract it with ffmpeg
-			//content.MSC1767Audio = &event.MSC1767Audio{}
-		}
-		content.URL, content.File, err = getIntent(ctx).UploadMedia(ctx, getPortal(ctx).MXID, data, fileName, mimeType)
+			if mimeType == "" {
+				mimeType = http.DetectContentType(hcw.header)
+			}
+			result := &bridgev2.FileStreamResult{}
+			if convertVoice {
+				result.ReplacementFile, err = ffmpeg.ConvertPath(ctx, file.(*os.File).Name(), ".ogg", []string{}, []string{"-c:a", "libopus"}, false)
+				if err != nil {
+					return nil, fmt.Errorf("failed to convert audio to ogg/opus: %w", err)
+				}
+				fileName += ".ogg"
+				mimeType = "audio/ogg"
+				content.MSC3245Voice = &event.MSC3245Voice{}
+				// TODO include duration here (and in info) if there's some easy way to extract it with ffmpeg
+				//content.MSC1767Audio = &event.MSC1767Audio{}
+			}
+			result.FileName = fileName
+			result.MimeType = mimeType
+			return result, nil
+		})
 		if err != nil {
 			return nil, err
 		}
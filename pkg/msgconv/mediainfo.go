@@ -0,0 +1,167 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+
+	"go.mau.fi/util/ffmpeg"
+)
+
+const (
+	// maxDecodePixels is the largest image that will be decoded for generating a blurhash or thumbnail.
+	maxDecodePixels = 50_000_000
+	// thumbnailMaxSize is the maximum width and height of video poster thumbnails.
+	thumbnailMaxSize = 320
+)
+
+// outgoingMediaInfo contains metadata generated locally for media sent to Signal.
+type outgoingMediaInfo struct {
+	Width     int
+	Height    int
+	Blurhash  string
+	Thumbnail []byte
+}
+
+// generateMediaInfo calculates the dimensions and blurhash of an image or video file.
+// For videos, the first frame is also encoded as a small JPEG thumbnail.
+//
+// Videos and images that Go can't decode natively require ffmpeg. If it's not available, nil is returned.
+func generateMediaInfo(ctx context.Context, path, mime string) (*outgoingMediaInfo, error) {
+	var img image.Image
+	var info outgoingMediaInfo
+	var err error
+	isVideo := strings.HasPrefix(mime, "video/")
+	if strings.HasPrefix(mime, "image/") {
+		img, info.Width, info.Height, err = decodeImageFile(path)
+		if errors.Is(err, image.ErrFormat) && ffmpeg.Supported() {
+			img, err = extractFirstFrame(ctx, path)
+		} else if errors.Is(err, image.ErrFormat) {
+			return nil, nil
+		}
+	} else if isVideo && ffmpeg.Supported() {
+		img, err = extractFirstFrame(ctx, path)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	} else if img == nil {
+		// Image was too large to decode, but the dimensions are still useful
+		return &info, nil
+	}
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	info.Blurhash, err = encodeBlurhash(img, 4, 3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate blurhash: %w", err)
+	}
+	if isVideo {
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, downscaleImage(img, thumbnailMaxSize), &jpeg.Options{Quality: 75})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		info.Thumbnail = buf.Bytes()
+	}
+	return &info, nil
+}
+
+// decodeImageFile decodes an image file using the standard library decoders.
+// If the image is larger than maxDecodePixels, only the dimensions are returned.
+func decodeImageFile(path string) (img image.Image, width, height int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, 0, 0, err
+	} else if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, cfg.Width, cfg.Height, nil
+	}
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to seek file: %w", err)
+	}
+	img, _, err = image.Decode(file)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, cfg.Width, cfg.Height, nil
+}
+
+// extractFirstFrame uses ffmpeg to extract the first frame of a video (or an image in a format Go can't decode).
+func extractFirstFrame(ctx context.Context, path string) (image.Image, error) {
+	framePath, err := ffmpeg.ConvertPath(ctx, path, ".frame.png", []string{"-y"}, []string{"-frames:v", "1"}, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract frame: %w", err)
+	}
+	defer os.Remove(framePath)
+	file, err := os.Open(framePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open extracted frame: %w", err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode extracted frame: %w", err)
+	}
+	return img, nil
+}
+
+// downscaleImage shrinks the image so that neither dimension exceeds maxSize by averaging source pixels.
+func downscaleImage(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= maxSize && srcH <= maxSize {
+		return img
+	}
+	dstW, dstH := maxSize, maxSize
+	if srcW > srcH {
+		dstH = max(1, srcH*maxSize/srcW)
+	} else {
+		dstW = max(1, srcW*maxSize/srcH)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,207 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-signal/pkg/msgconv/matrixfmt"
+	"go.mau.fi/mautrix-signal/pkg/signalid"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+const (
+	// maxQuoteThumbnailSourceSize is the largest image that will be downloaded to generate a quote thumbnail.
+	maxQuoteThumbnailSourceSize = 2 * 1024 * 1024
+	// quoteThumbnailTimeout limits how long generating a quote thumbnail can delay sending a reply.
+	quoteThumbnailTimeout = 5 * time.Second
+)
+
+// makeQuote creates the quote for a reply from Matrix. The text and attachment info are taken from
+// the replied-to Matrix event, so that nothing about the original message has to be stored in the database.
+// If the event can't be fetched, the quote only contains the ID and author, which makes Signal clients
+// look up the message locally.
+func (mc *MessageConverter) makeQuote(ctx context.Context, replyTo *database.Message, authorACI uuid.UUID, messageID uint64) *signalpb.DataMessage_Quote {
+	quote := &signalpb.DataMessage_Quote{
+		Id:        proto.Uint64(messageID),
+		AuthorAci: proto.String(authorACI.String()),
+		Type:      signalpb.DataMessage_Quote_NORMAL.Enum(),
+	}
+	evt, err := mc.fetchMatrixEvent(ctx, replyTo.MXID)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Stringer("event_id", replyTo.MXID).Msg("Failed to fetch replied-to event for quote")
+		if replyTo.Metadata.(*signalid.MessageMetadata).ContainsAttachments {
+			quote.Attachments = make([]*signalpb.DataMessage_Quote_QuotedAttachment, 1)
+		}
+		return quote
+	}
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok {
+		return quote
+	}
+	isFile := evt.Type == event.EventSticker || content.MsgType.IsMedia()
+	if !isFile || (content.FileName != "" && (content.FileName != content.Body || content.Format == event.FormatHTML)) {
+		body, bodyRanges := matrixfmt.Parse(ctx, mc.MatrixFmtParams, content)
+		text := truncateUTF8(body, MaxInlineBodyLength)
+		if text != "" {
+			quote.Text = proto.String(text)
+			quote.BodyRanges = clipBodyRanges(bodyRanges, utf16Length(text))
+		}
+	}
+	if isFile {
+		qa := &signalpb.DataMessage_Quote_QuotedAttachment{
+			ContentType: maybeString(content.GetInfo().MimeType),
+		}
+		if content.FileName != "" {
+			qa.FileName = proto.String(content.FileName)
+		} else if evt.Type != event.EventSticker {
+			qa.FileName = proto.String(content.Body)
+		}
+		qa.Thumbnail, err = mc.makeQuoteThumbnail(ctx, evt.Type, content)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to create quote thumbnail")
+		}
+		quote.Attachments = []*signalpb.DataMessage_Quote_QuotedAttachment{qa}
+	}
+	return quote
+}
+
+func maybeString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// fetchMatrixEvent fetches and decrypts an event in the current portal using the bridge bot.
+func (mc *MessageConverter) fetchMatrixEvent(ctx context.Context, eventID id.EventID) (*event.Event, error) {
+	asBot, ok := mc.Bridge.Bot.(*matrix.ASIntent)
+	if !ok {
+		return nil, fmt.Errorf("unsupported Matrix connector")
+	} else if eventID == "" {
+		return nil, fmt.Errorf("message doesn't have a Matrix event ID")
+	}
+	evt, err := asBot.Matrix.GetEvent(ctx, getPortal(ctx).MXID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	err = evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	if evt.Type == event.EventEncrypted {
+		if asBot.Connector.Crypto == nil {
+			return nil, fmt.Errorf("event is encrypted, but encryption is not enabled")
+		}
+		evt, err = asBot.Connector.Crypto.Decrypt(ctx, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt event: %w", err)
+		}
+		err = evt.Content.ParseRaw(evt.Type)
+		if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return nil, fmt.Errorf("failed to parse decrypted event: %w", err)
+		}
+	}
+	return evt, nil
+}
+
+// makeQuoteThumbnail creates and uploads a thumbnail for a quoted media event.
+// Thumbnails are best-effort: only small source files are used and the whole process is time-limited.
+// A nil pointer is returned if the media type doesn't have thumbnails.
+func (mc *MessageConverter) makeQuoteThumbnail(ctx context.Context, evtType event.Type, content *event.MessageEventContent) (*signalpb.AttachmentPointer, error) {
+	info := content.GetInfo()
+	var sourceURL id.ContentURIString
+	var sourceFile *event.EncryptedFileInfo
+	if (info.ThumbnailURL != "" || info.ThumbnailFile != nil) && info.ThumbnailInfo != nil &&
+		info.ThumbnailInfo.Size > 0 && info.ThumbnailInfo.Size <= maxQuoteThumbnailSourceSize {
+		sourceURL, sourceFile = info.ThumbnailURL, info.ThumbnailFile
+	} else if (evtType == event.EventSticker || content.MsgType == event.MsgImage) &&
+		info.Size > 0 && info.Size <= maxQuoteThumbnailSourceSize {
+		sourceURL, sourceFile = content.URL, content.File
+	} else {
+		return nil, nil
+	}
+	if sourceFile != nil {
+		sourceURL = sourceFile.URL
+	}
+	ctx, cancel := context.WithTimeout(ctx, quoteThumbnailTimeout)
+	defer cancel()
+	data, err := mc.Bridge.Bot.DownloadMedia(ctx, sourceURL, sourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download source: %w", err)
+	} else if len(data) > maxQuoteThumbnailSourceSize {
+		return nil, fmt.Errorf("source is too large (%d bytes)", len(data))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode source: %w", err)
+	}
+	img = downscaleImage(img, thumbnailMaxSize)
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, img, &jpeg.Options{Quality: 75})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	att, err := getClient(ctx).UploadAttachment(ctx, out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload thumbnail: %w", err)
+	}
+	att.ContentType = proto.String("image/jpeg")
+	att.Width = proto.Uint32(uint32(img.Bounds().Dx()))
+	att.Height = proto.Uint32(uint32(img.Bounds().Dy()))
+	return att, nil
+}
+
+// clipBodyRanges drops body ranges that start after the given UTF-16 length and shortens ones that extend past it.
+func clipBodyRanges(ranges []*signalpb.BodyRange, maxLength int) []*signalpb.BodyRange {
+	clipped := make([]*signalpb.BodyRange, 0, len(ranges))
+	for _, br := range ranges {
+		start := int(br.GetStart())
+		if start >= maxLength {
+			continue
+		}
+		br = proto.Clone(br).(*signalpb.BodyRange)
+		br.Length = proto.Uint32(uint32(min(int(br.GetLength()), maxLength-start)))
+		clipped = append(clipped, br)
+	}
+	return clipped
+}
+
+func utf16Length(s string) (length int) {
+	for _, r := range s {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return
+}
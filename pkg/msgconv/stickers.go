@@ -0,0 +1,99 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+// stickerManifestTimeout limits how long fetching a sticker pack manifest can delay bridging a sticker.
+const stickerManifestTimeout = 5 * time.Second
+
+// StickerMetadataKey is the key used for Signal sticker metadata in Matrix sticker events
+// and in the info of images in bridged sticker packs.
+const StickerMetadataKey = "fi.mau.signal.sticker"
+
+type StickerMetadata struct {
+	ID    uint32              `json:"id"`
+	Emoji string              `json:"emoji,omitempty"`
+	Pack  StickerPackMetadata `json:"pack"`
+}
+
+type StickerPackMetadata struct {
+	ID     []byte `json:"id"`
+	Key    []byte `json:"key"`
+	Title  string `json:"title,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+// getStickerMetadata finds Signal sticker pack info from a Matrix sticker event.
+//
+// The metadata is either at the top level (stickers bridged from Signal) or
+// inside the info object (stickers sent from a bridged image pack).
+func getStickerMetadata(evt *event.Event) *StickerMetadata {
+	raw := evt.Content.Raw[StickerMetadataKey]
+	if raw == nil {
+		info, _ := evt.Content.Raw["info"].(map[string]any)
+		raw = info[StickerMetadataKey]
+	}
+	if raw == nil {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var meta StickerMetadata
+	err = json.Unmarshal(data, &meta)
+	if err != nil || len(meta.Pack.ID) != 16 || len(meta.Pack.Key) != 32 {
+		return nil
+	}
+	return &meta
+}
+
+// getStickerPackManifest returns the manifest of a sticker pack for adding the title and author to sticker metadata.
+// Installed packs are read from the store. Other packs are only downloaded if allowFetch is true.
+// Errors are only logged, as the metadata is optional.
+func getStickerPackManifest(ctx context.Context, packID, packKey []byte, allowFetch bool) *signalpb.Pack {
+	log := zerolog.Ctx(ctx)
+	client := getClient(ctx)
+	pack, err := client.Store.StickerStore.GetStickerPack(ctx, packID)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to get sticker pack from store")
+	} else if pack != nil && pack.Manifest != nil {
+		return pack.Manifest
+	}
+	if !allowFetch {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, stickerManifestTimeout)
+	defer cancel()
+	manifest, err := signalmeow.DownloadStickerPackManifest(client.EnvContext(ctx), packID, packKey)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to fetch sticker pack manifest")
+		return nil
+	}
+	return manifest
+}
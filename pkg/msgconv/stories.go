@@ -0,0 +1,238 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-signal/pkg/msgconv/signalfmt"
+	"go.mau.fi/mautrix-signal/pkg/signalid"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+// StoryMetadataKey is the key used for Signal story metadata in Matrix events.
+const StoryMetadataKey = "fi.mau.signal.story"
+
+// StoryLifetime is how long stories are visible on Signal.
+const StoryLifetime = 24 * time.Hour
+
+type StoryMetadata struct {
+	AllowsReplies bool               `json:"allows_replies"`
+	Text          *TextStoryMetadata `json:"text,omitempty"`
+}
+
+// TextStoryMetadata describes how a text story is rendered. Colors are CSS hex colors,
+// the background is a CSS color or linear-gradient.
+type TextStoryMetadata struct {
+	Style               string `json:"style,omitempty"`
+	TextColor           string `json:"text_color,omitempty"`
+	TextBackgroundColor string `json:"text_background_color,omitempty"`
+	Background          string `json:"background,omitempty"`
+}
+
+// StoryToMatrix converts a Signal story into a Matrix message that disappears when the story expires.
+func (mc *MessageConverter) StoryToMatrix(
+	ctx context.Context,
+	client *signalmeow.Client,
+	portal *bridgev2.Portal,
+	intent bridgev2.MatrixAPI,
+	story *events.Story,
+) *bridgev2.ConvertedMessage {
+	ctx = context.WithValue(ctx, contextKeyClient, client)
+	ctx = context.WithValue(ctx, contextKeyPortal, portal)
+	ctx = context.WithValue(ctx, contextKeyIntent, intent)
+	meta := &StoryMetadata{AllowsReplies: story.AllowsReplies}
+	var part *bridgev2.ConvertedMessagePart
+	if story.File != nil {
+		part = mc.convertAttachmentToMatrix(ctx, 0, story.File, nil)
+		addStoryCaption(ctx, part.Content, story.File.GetCaption(), story.BodyRanges, mc.SignalFmtParams)
+	} else {
+		part, meta.Text = mc.convertTextStoryToMatrix(ctx, story.Text, story.BodyRanges)
+	}
+	if part.Extra == nil {
+		part.Extra = make(map[string]any)
+	}
+	part.Extra[StoryMetadataKey] = meta
+	part.ID = signalid.MakeMessagePartID(0)
+	part.DBMetadata = &signalid.MessageMetadata{
+		ContainsAttachments: story.File != nil,
+		IsStory:             true,
+	}
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{part},
+		Disappear: database.DisappearingSetting{
+			Type:        database.DisappearingTypeAfterSend,
+			Timer:       StoryLifetime,
+			DisappearAt: time.UnixMilli(int64(story.Timestamp)).Add(StoryLifetime),
+		},
+	}
+}
+
+func addStoryCaption(ctx context.Context, content *event.MessageEventContent, caption string, ranges []*signalpb.BodyRange, params *signalfmt.FormatParams) {
+	if content.FileName == "" {
+		content.FileName = content.Body
+	}
+	if caption == "" {
+		content.Body = "Story"
+		content.Format = event.FormatHTML
+		content.FormattedBody = "<b>Story</b>"
+		return
+	}
+	parsed := signalfmt.Parse(ctx, caption, ranges, params)
+	content.Body = "Story: " + parsed.Body
+	content.Format = event.FormatHTML
+	content.FormattedBody = "<b>Story:</b> " + formattedBodyOf(parsed)
+	content.Mentions = parsed.Mentions
+}
+
+func (mc *MessageConverter) convertTextStoryToMatrix(ctx context.Context, story *events.TextStory, ranges []*signalpb.BodyRange) (*bridgev2.ConvertedMessagePart, *TextStoryMetadata) {
+	meta := &TextStoryMetadata{
+		TextColor:           argbToCSS(story.ForegroundColor),
+		TextBackgroundColor: argbToCSS(story.BackgroundColor),
+	}
+	if story.Style != signalpb.TextAttachment_DEFAULT {
+		meta.Style = strings.ToLower(story.Style.String())
+	}
+	if story.BackgroundSolid != nil {
+		meta.Background = argbToCSS(story.BackgroundSolid)
+	} else if story.BackgroundGradient != nil {
+		meta.Background = gradientToCSS(story.BackgroundGradient)
+	}
+
+	content := signalfmt.Parse(ctx, story.Text, ranges, mc.SignalFmtParams)
+	content.MsgType = event.MsgNotice
+	formatted := formattedBodyOf(content)
+	// Matrix doesn't support gradients, so use the first gradient color as the text background
+	bgColor := meta.TextBackgroundColor
+	if bgColor == "" && story.BackgroundSolid != nil {
+		bgColor = meta.Background
+	} else if bgColor == "" && story.BackgroundGradient != nil {
+		bgColor = argbToCSS(&story.BackgroundGradient.Colors[0])
+	}
+	if meta.TextColor != "" || bgColor != "" {
+		var attrs strings.Builder
+		if meta.TextColor != "" {
+			_, _ = fmt.Fprintf(&attrs, ` data-mx-color="%s"`, meta.TextColor)
+		}
+		if bgColor != "" {
+			_, _ = fmt.Fprintf(&attrs, ` data-mx-bg-color="%s"`, bgColor)
+		}
+		formatted = fmt.Sprintf("<span%s>%s</span>", attrs.String(), formatted)
+	}
+	content.Body = "Story: " + content.Body
+	content.Format = event.FormatHTML
+	content.FormattedBody = "<b>Story:</b> " + formatted
+	if story.Preview != nil {
+		content.BeeperLinkPreviews = mc.convertURLPreviewsToBeeper(ctx, []*signalpb.Preview{story.Preview}, nil)
+	}
+	return &bridgev2.ConvertedMessagePart{
+		Type:    event.EventMessage,
+		Content: content,
+	}, meta
+}
+
+func formattedBodyOf(content *event.MessageEventContent) string {
+	if content.Format == event.FormatHTML {
+		return content.FormattedBody
+	}
+	return strings.ReplaceAll(html.EscapeString(content.Body), "\n", "<br>")
+}
+
+// argbToCSS converts a Signal ARGB color integer to a CSS hex color. The alpha channel is dropped,
+// as Matrix color attributes don't support it.
+func argbToCSS(color *uint32) string {
+	if color == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%06x", *color&0xffffff)
+}
+
+func gradientToCSS(gradient *events.StoryGradient) string {
+	stops := make([]string, len(gradient.Colors))
+	for i, color := range gradient.Colors {
+		stops[i] = fmt.Sprintf("%s %g%%", argbToCSS(&color), gradient.Positions[i]*100)
+	}
+	return fmt.Sprintf("linear-gradient(%ddeg, %s)", gradient.Angle, strings.Join(stops, ", "))
+}
+
+// addStoryReplyContext makes a message that replies or reacts to a story into a Matrix reply to the bridged story.
+// If the story isn't bridged in the same room, a text fallback is added instead, as Matrix replies can't point
+// to events in other rooms.
+func (mc *MessageConverter) addStoryReplyContext(ctx context.Context, cm *bridgev2.ConvertedMessage, storyCtx *signalpb.DataMessage_StoryContext) {
+	var target *networkid.MessageOptionalPartID
+	if authorACI, err := uuid.Parse(storyCtx.GetAuthorAci()); err == nil && storyCtx.GetSentTimestamp() != 0 {
+		target = &networkid.MessageOptionalPartID{
+			MessageID: signalid.MakeMessageID(authorACI, storyCtx.GetSentTimestamp()),
+		}
+	}
+	if target != nil {
+		portal := getPortal(ctx)
+		existing, err := mc.Bridge.DB.Message.GetFirstPartByID(ctx, portal.Receiver, target.MessageID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get story reply target from database")
+		} else if existing != nil && existing.Room == portal.PortalKey {
+			cm.ReplyTo = target
+			return
+		}
+	}
+	if len(cm.Parts) == 0 {
+		return
+	}
+	content := cm.Parts[0].Content
+	formatted := formattedBodyOf(content)
+	content.Body = "> Reply to a story\n\n" + content.Body
+	content.Format = event.FormatHTML
+	content.FormattedBody = "<blockquote>Reply to a story</blockquote>" + formatted
+}
+
+// makeStoryReplyContext returns the story context for a Matrix reply to a bridged story,
+// or nil if the replied-to message isn't a story. Signal clients show story replies
+// with the story instead of as a quote, so stories must not be quoted.
+func makeStoryReplyContext(replyTo *database.Message, authorACI uuid.UUID, sentTimestamp uint64) *signalpb.DataMessage_StoryContext {
+	meta, ok := replyTo.Metadata.(*signalid.MessageMetadata)
+	if !ok || !meta.IsStory {
+		return nil
+	}
+	return &signalpb.DataMessage_StoryContext{
+		AuthorAci:     proto.String(authorACI.String()),
+		SentTimestamp: proto.Uint64(sentTimestamp),
+	}
+}
+
+func (mc *MessageConverter) convertStoryReactionToMatrix(_ context.Context, reaction *signalpb.DataMessage_Reaction) *bridgev2.ConvertedMessagePart {
+	return &bridgev2.ConvertedMessagePart{
+		Type: event.EventMessage,
+		Content: &event.MessageEventContent{
+			MsgType: event.MsgText,
+			Body:    reaction.GetEmoji(),
+		},
+	}
+}
@@ -0,0 +1,150 @@
+// mautrix-signal - A Matrix-Signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/litestream"
+	"google.golang.org/protobuf/proto"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-signal/pkg/signalid"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+func newTestBridge(t *testing.T) *bridgev2.Bridge {
+	rawDB, err := dbutil.NewWithDialect("file::memory:?_txlock=immediate", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	rawDB.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = rawDB.Close() })
+	db := database.New("signal", database.MetaTypes{
+		Portal:  func() any { return &signalid.PortalMetadata{} },
+		Message: func() any { return &signalid.MessageMetadata{} },
+	}, rawDB)
+	require.NoError(t, db.Upgrade(context.Background()))
+	return &bridgev2.Bridge{ID: "signal", DB: db}
+}
+
+func insertTestPortal(t *testing.T, br *bridgev2.Bridge, key networkid.PortalKey) *bridgev2.Portal {
+	dbPortal := &database.Portal{
+		BridgeID:  br.ID,
+		PortalKey: key,
+		MXID:      id.RoomID("!" + string(key.ID) + ":example.com"),
+		Metadata:  &signalid.PortalMetadata{},
+	}
+	require.NoError(t, br.DB.Portal.Insert(context.Background(), dbPortal))
+	return &bridgev2.Portal{Portal: dbPortal}
+}
+
+func makeStoryReply(body string) *bridgev2.ConvertedMessage {
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{{
+			Type: event.EventMessage,
+			Content: &event.MessageEventContent{
+				MsgType: event.MsgText,
+				Body:    body,
+			},
+		}},
+	}
+}
+
+func TestAddStoryReplyContext(t *testing.T) {
+	br := newTestBridge(t)
+	mc := &MessageConverter{Bridge: br}
+	storyAuthor := uuid.New()
+	storyPortal := insertTestPortal(t, br, networkid.PortalKey{ID: "stories"})
+	otherPortal := insertTestPortal(t, br, networkid.PortalKey{ID: "other"})
+
+	const storyTS = 1700000000000
+	storyMsgID := signalid.MakeMessageID(storyAuthor, storyTS)
+	require.NoError(t, br.DB.Message.Insert(context.Background(), &database.Message{
+		ID:        storyMsgID,
+		MXID:      "$story",
+		Room:      storyPortal.PortalKey,
+		SenderID:  signalid.MakeUserID(storyAuthor),
+		Timestamp: time.UnixMilli(storyTS),
+		Metadata:  &signalid.MessageMetadata{},
+	}))
+	storyCtx := &signalpb.DataMessage_StoryContext{
+		AuthorAci:     proto.String(storyAuthor.String()),
+		SentTimestamp: proto.Uint64(storyTS),
+	}
+
+	t.Run("SameRoom", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), contextKeyPortal, storyPortal)
+		cm := makeStoryReply("nice")
+		mc.addStoryReplyContext(ctx, cm, storyCtx)
+		require.NotNil(t, cm.ReplyTo)
+		assert.Equal(t, storyMsgID, cm.ReplyTo.MessageID)
+		assert.Equal(t, "nice", cm.Parts[0].Content.Body)
+	})
+	t.Run("OtherRoom", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), contextKeyPortal, otherPortal)
+		cm := makeStoryReply("nice")
+		mc.addStoryReplyContext(ctx, cm, storyCtx)
+		assert.Nil(t, cm.ReplyTo)
+		assert.Equal(t, "> Reply to a story\n\nnice", cm.Parts[0].Content.Body)
+		assert.Equal(t, "<blockquote>Reply to a story</blockquote>nice", cm.Parts[0].Content.FormattedBody)
+	})
+	t.Run("NotBridged", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), contextKeyPortal, storyPortal)
+		cm := makeStoryReply("nice")
+		mc.addStoryReplyContext(ctx, cm, &signalpb.DataMessage_StoryContext{
+			AuthorAci:     proto.String(storyAuthor.String()),
+			SentTimestamp: proto.Uint64(storyTS + 1),
+		})
+		assert.Nil(t, cm.ReplyTo)
+		assert.Equal(t, "> Reply to a story\n\nnice", cm.Parts[0].Content.Body)
+	})
+}
+
+func TestMakeStoryReplyContext(t *testing.T) {
+	author := uuid.New()
+	const storyTS = 1700000000000
+
+	t.Run("Story", func(t *testing.T) {
+		replyTo := &database.Message{
+			ID:       signalid.MakeMessageID(author, storyTS),
+			Metadata: &signalid.MessageMetadata{IsStory: true, ContainsAttachments: true},
+		}
+		storyCtx := makeStoryReplyContext(replyTo, author, storyTS)
+		require.NotNil(t, storyCtx)
+		assert.Equal(t, author.String(), storyCtx.GetAuthorAci())
+		assert.Equal(t, uint64(storyTS), storyCtx.GetSentTimestamp())
+	})
+	t.Run("NormalMessage", func(t *testing.T) {
+		replyTo := &database.Message{
+			ID:       signalid.MakeMessageID(author, storyTS),
+			Metadata: &signalid.MessageMetadata{ContainsAttachments: true},
+		}
+		assert.Nil(t, makeStoryReplyContext(replyTo, author, storyTS))
+	})
+	t.Run("NoMetadata", func(t *testing.T) {
+		assert.Nil(t, makeStoryReplyContext(&database.Message{}, author, storyTS))
+	})
+}
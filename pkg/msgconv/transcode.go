@@ -0,0 +1,169 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"image"
+	"os"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ffmpeg"
+	"maunium.net/go/mautrix/event"
+)
+
+// MaxImageDimension is the largest width or height of images that Signal clients accept without resizing.
+const MaxImageDimension = 4096
+
+const scaleToMaxImageDimension = "scale=w='min(4096,iw)':h='min(4096,ih)':force_original_aspect_ratio=decrease"
+
+// transcodePolicy describes how media that Signal clients can't handle is converted with ffmpeg.
+type transcodePolicy struct {
+	Extension  string
+	MimeType   string
+	OutputArgs func(ctx context.Context, path string) []string
+}
+
+func staticArgs(args ...string) func(context.Context, string) []string {
+	return func(context.Context, string) []string {
+		return args
+	}
+}
+
+var (
+	transcodeToJPEG = &transcodePolicy{
+		Extension:  ".jpg",
+		MimeType:   "image/jpeg",
+		OutputArgs: staticArgs("-frames:v", "1", "-vf", scaleToMaxImageDimension, "-q:v", "2"),
+	}
+	transcodeToAAC = &transcodePolicy{
+		Extension:  ".aac",
+		MimeType:   "audio/aac",
+		OutputArgs: staticArgs("-vn", "-c:a", "aac"),
+	}
+	transcodeToMP4 = &transcodePolicy{
+		Extension:  ".mp4",
+		MimeType:   "video/mp4",
+		OutputArgs: mp4OutputArgs,
+	}
+)
+
+// transcodePolicies maps mime types sent from Matrix to the conversion that makes them playable on Signal.
+var transcodePolicies = map[string]*transcodePolicy{
+	"image/heic": transcodeToJPEG,
+	"image/heif": transcodeToJPEG,
+
+	"audio/ogg":  transcodeToAAC,
+	"audio/opus": transcodeToAAC,
+
+	"video/x-matroska": transcodeToMP4,
+	"video/quicktime":  transcodeToMP4,
+}
+
+// downscalePolicies contains the conversions used for images that are larger than MaxImageDimension.
+// The output extensions differ from the input ones so that ffmpeg doesn't try to overwrite the input file.
+var downscalePolicies = map[string]*transcodePolicy{
+	"image/jpeg": downscaleToJPEG,
+	"image/bmp":  downscaleToJPEG,
+	"image/png": {
+		Extension:  ".scaled.png",
+		MimeType:   "image/png",
+		OutputArgs: staticArgs("-frames:v", "1", "-vf", scaleToMaxImageDimension),
+	},
+	"image/webp": {
+		Extension:  ".scaled.webp",
+		MimeType:   "image/webp",
+		OutputArgs: staticArgs("-frames:v", "1", "-vf", scaleToMaxImageDimension, "-quality", "90"),
+	},
+	// GIFs keep all frames, and a new palette is generated, as the original one won't match the scaled image.
+	"image/gif": {
+		Extension:  ".scaled.gif",
+		MimeType:   "image/gif",
+		OutputArgs: staticArgs("-vf", scaleToMaxImageDimension+",split[a][b];[a]palettegen[p];[b][p]paletteuse"),
+	},
+}
+
+var downscaleToJPEG = &transcodePolicy{
+	Extension:  ".scaled.jpg",
+	MimeType:   "image/jpeg",
+	OutputArgs: transcodeToJPEG.OutputArgs,
+}
+
+// getTranscodePolicy finds the conversion needed for a file sent from Matrix, or nil if the file can be sent as-is.
+//
+// Files sent as m.file are never transcoded, as the user presumably wants the original file.
+func getTranscodePolicy(content *event.MessageEventContent, path, mime string) *transcodePolicy {
+	switch content.MsgType {
+	case event.MsgImage, event.MsgVideo, event.MsgAudio:
+	default:
+		return nil
+	}
+	if policy, ok := transcodePolicies[mime]; ok {
+		return policy
+	} else if policy, ok = downscalePolicies[mime]; ok && isOversizedImage(content, path) {
+		return policy
+	}
+	return nil
+}
+
+func isOversizedImage(content *event.MessageEventContent, path string) bool {
+	width, height := content.GetInfo().Width, content.GetInfo().Height
+	if width == 0 || height == 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return false
+		}
+		defer file.Close()
+		cfg, _, err := image.DecodeConfig(file)
+		if err != nil {
+			return false
+		}
+		width, height = cfg.Width, cfg.Height
+	}
+	return width > MaxImageDimension || height > MaxImageDimension
+}
+
+// mp4OutputArgs remuxes the video if it's already H.264 with AAC audio (or no audio), and re-encodes it otherwise.
+func mp4OutputArgs(ctx context.Context, path string) []string {
+	reencodeArgs := []string{
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:a", "aac",
+		"-movflags", "+faststart",
+	}
+	if !ffmpeg.ProbeSupported() {
+		return reencodeArgs
+	}
+	probe, err := ffmpeg.Probe(ctx, path)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to probe video, re-encoding")
+		return reencodeArgs
+	}
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if stream.CodecName != "h264" {
+				return reencodeArgs
+			}
+		case "audio":
+			if stream.CodecName != "aac" {
+				return reencodeArgs
+			}
+		}
+	}
+	return []string{"-map", "0:v:0", "-map", "0:a?", "-c", "copy", "-movflags", "+faststart"}
+}
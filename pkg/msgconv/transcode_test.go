@@ -0,0 +1,102 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/event"
+)
+
+func writeTestPNG(t *testing.T, width, height int) string {
+	path := filepath.Join(t.TempDir(), "image.png")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))))
+	return path
+}
+
+func TestGetTranscodePolicy(t *testing.T) {
+	smallPNG := writeTestPNG(t, 100, 50)
+	tallPNG := writeTestPNG(t, 10, MaxImageDimension+1)
+
+	tests := []struct {
+		name     string
+		msgType  event.MessageType
+		mime     string
+		width    int
+		height   int
+		path     string
+		expected *transcodePolicy
+	}{
+		{"JPEG", event.MsgImage, "image/jpeg", 1000, 1000, "", nil},
+		{"HEIC", event.MsgImage, "image/heic", 1000, 1000, "", transcodeToJPEG},
+		{"HEIF", event.MsgImage, "image/heif", 0, 0, "", transcodeToJPEG},
+		{"HEICAsFile", event.MsgFile, "image/heic", 1000, 1000, "", nil},
+		{"OggAudio", event.MsgAudio, "audio/ogg", 0, 0, "", transcodeToAAC},
+		{"OpusAudio", event.MsgAudio, "audio/opus", 0, 0, "", transcodeToAAC},
+		{"MP3Audio", event.MsgAudio, "audio/mpeg", 0, 0, "", nil},
+		{"OggAsFile", event.MsgFile, "audio/ogg", 0, 0, "", nil},
+		{"Matroska", event.MsgVideo, "video/x-matroska", 1920, 1080, "", transcodeToMP4},
+		{"QuickTime", event.MsgVideo, "video/quicktime", 1920, 1080, "", transcodeToMP4},
+		{"MP4", event.MsgVideo, "video/mp4", 1920, 1080, "", nil},
+		{"Text", event.MsgText, "image/heic", 0, 0, "", nil},
+
+		{"MaxSizeJPEG", event.MsgImage, "image/jpeg", MaxImageDimension, MaxImageDimension, "", nil},
+		{"WideJPEG", event.MsgImage, "image/jpeg", MaxImageDimension + 1, 100, "", downscaleToJPEG},
+		{"TallBMP", event.MsgImage, "image/bmp", 100, MaxImageDimension + 1, "", downscaleToJPEG},
+		{"LargePNG", event.MsgImage, "image/png", 8000, 8000, "", downscalePolicies["image/png"]},
+		{"LargeWebP", event.MsgImage, "image/webp", 8000, 8000, "", downscalePolicies["image/webp"]},
+		{"LargeGIF", event.MsgImage, "image/gif", 8000, 8000, "", downscalePolicies["image/gif"]},
+		{"SmallGIF", event.MsgImage, "image/gif", 500, 500, "", nil},
+		{"LargePNGAsFile", event.MsgFile, "image/png", 8000, 8000, "", nil},
+		{"LargeUnknownImage", event.MsgImage, "image/x-unknown", 8000, 8000, "", nil},
+
+		// Dimensions are read from the file if they're not in the event
+		{"SmallPNGFromFile", event.MsgImage, "image/png", 0, 0, smallPNG, nil},
+		{"TallPNGFromFile", event.MsgImage, "image/png", 0, 0, tallPNG, downscalePolicies["image/png"]},
+		{"MissingFile", event.MsgImage, "image/png", 0, 0, filepath.Join(t.TempDir(), "missing.png"), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := &event.MessageEventContent{MsgType: test.msgType}
+			if test.width != 0 || test.height != 0 {
+				content.Info = &event.FileInfo{Width: test.width, Height: test.height}
+			}
+			assert.Same(t, test.expected, getTranscodePolicy(content, test.path, test.mime))
+		})
+	}
+}
+
+func TestTranscodePolicyOutputs(t *testing.T) {
+	for mime, policy := range transcodePolicies {
+		assert.NotEqual(t, mime, policy.MimeType, "transcoding %s shouldn't produce the same type", mime)
+	}
+	for mime, policy := range downscalePolicies {
+		assert.Contains(t, policy.Extension, ".scaled.", "downscaling %s must not overwrite the input file", mime)
+		if mime != "image/bmp" {
+			assert.Equal(t, mime, policy.MimeType, "downscaling %s shouldn't change the format", mime)
+		}
+	}
+}
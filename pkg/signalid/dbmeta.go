@@ -27,10 +27,17 @@ type PortalMetadata struct {
 
 type MessageMetadata struct {
 	ContainsAttachments bool `json:"contains_attachments,omitempty"`
+	IsStory             bool `json:"is_story,omitempty"`
 }
 
 type UserLoginMetadata struct {
 	ChatsSynced bool `json:"chats_synced,omitempty"`
+	// ContactChatsPending is set for new linked logins without a transfer archive, and cleared after direct chats
+	// have been created from the contact list sent by the primary device. Logins with a transfer archive get
+	// direct chats from the archive, and logins from before this existed never need the initial sync.
+	ContactChatsPending bool `json:"contact_chats_pending,omitempty"`
+	// Proxy overrides the proxy in the bridge config for this login.
+	Proxy string `json:"proxy,omitempty"`
 }
 
 type GhostMetadata struct {
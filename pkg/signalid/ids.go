@@ -17,6 +17,7 @@
 package signalid
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -63,8 +64,15 @@ func ParseUserIDAsServiceID(userID networkid.UserID) (libsignalgo.ServiceID, err
 	return libsignalgo.ServiceIDFromString(string(userID))
 }
 
+// StoriesPortalID is the ID of the per-user portal that stories are bridged into when they're not sent to DMs.
+const StoriesPortalID networkid.PortalID = "stories"
+
+var ErrStoriesPortal = errors.New("the stories room is not a Signal chat")
+
 func ParsePortalID(portalID networkid.PortalID) (userID libsignalgo.ServiceID, groupID types.GroupIdentifier, err error) {
-	if len(portalID) == 44 {
+	if portalID == StoriesPortalID {
+		err = ErrStoriesPortal
+	} else if len(portalID) == 44 {
 		groupID = types.GroupIdentifier(portalID)
 	} else {
 		userID, err = libsignalgo.ServiceIDFromString(string(portalID))
@@ -34,6 +34,7 @@ const (
 	directMediaTypeAttachment directMediaType = iota
 	directMediaTypeGroupAvatar
 	directMediaTypeProfileAvatar
+	directMediaTypeSticker
 )
 
 type DirectMediaInfo interface {
@@ -44,6 +45,7 @@ var (
 	_ DirectMediaInfo = (*DirectMediaAttachment)(nil)
 	_ DirectMediaInfo = (*DirectMediaGroupAvatar)(nil)
 	_ DirectMediaInfo = (*DirectMediaProfileAvatar)(nil)
+	_ DirectMediaInfo = (*DirectMediaSticker)(nil)
 )
 
 type DirectMediaAttachment struct {
@@ -53,6 +55,12 @@ type DirectMediaAttachment struct {
 	Key       []byte
 	Digest    []byte
 	Size      uint32
+
+	// Optional fields, media IDs created before these were added simply end after Size.
+	IncrementalMAC []byte
+	ChunkSize      uint32
+	// UserID is the login whose proxy settings are used for the download.
+	UserID uuid.UUID
 }
 
 func (m DirectMediaAttachment) AsMediaID() (mediaID networkid.MediaID, err error) {
@@ -73,6 +81,18 @@ func (m DirectMediaAttachment) AsMediaID() (mediaID networkid.MediaID, err error
 	} else if err = writeUvarint(buf, uint64(m.Size)); err != nil {
 		return
 	}
+	if len(m.IncrementalMAC) > 0 || m.UserID != uuid.Nil {
+		if err = writeByteSlice(buf, m.IncrementalMAC); err != nil {
+			return
+		} else if err = writeUvarint(buf, uint64(m.ChunkSize)); err != nil {
+			return
+		}
+	}
+	if m.UserID != uuid.Nil {
+		if err = binary.Write(buf, binary.BigEndian, m.UserID); err != nil {
+			return
+		}
+	}
 
 	return networkid.MediaID(buf.Bytes()), nil
 }
@@ -121,6 +141,36 @@ func (m DirectMediaProfileAvatar) AsMediaID() (mediaID networkid.MediaID, err er
 	return networkid.MediaID(buf.Bytes()), nil
 }
 
+type DirectMediaSticker struct {
+	PackID    []byte
+	PackKey   []byte
+	StickerID uint32
+
+	// Optional fields, media IDs created before these were added simply end after StickerID.
+	UserID uuid.UUID
+}
+
+func (m DirectMediaSticker) AsMediaID() (mediaID networkid.MediaID, err error) {
+	buf := &bytes.Buffer{}
+
+	if err = binary.Write(buf, binary.BigEndian, directMediaTypeSticker); err != nil {
+		return
+	} else if err = writeByteSlice(buf, m.PackID); err != nil {
+		return
+	} else if err = writeByteSlice(buf, m.PackKey); err != nil {
+		return
+	} else if err = writeUvarint(buf, uint64(m.StickerID)); err != nil {
+		return
+	}
+	if m.UserID != uuid.Nil {
+		if err = binary.Write(buf, binary.BigEndian, m.UserID); err != nil {
+			return
+		}
+	}
+
+	return networkid.MediaID(buf.Bytes()), nil
+}
+
 func ParseDirectMediaInfo(mediaID networkid.MediaID) (_ DirectMediaInfo, err error) {
 	mediaIDLen := len(mediaID)
 	if mediaIDLen == 0 {
@@ -158,10 +208,25 @@ func ParseDirectMediaInfo(mediaID networkid.MediaID) (_ DirectMediaInfo, err err
 			return info, fmt.Errorf("failed to read digest: %w", err)
 		}
 		if size, err := binary.ReadUvarint(buf); err != nil {
-			return info, fmt.Errorf("failed to read cdn id: %w", err)
+			return info, fmt.Errorf("failed to read size: %w", err)
 		} else {
 			info.Size = uint32(size)
 		}
+		if _, err = buf.Peek(1); err == nil {
+			if info.IncrementalMAC, err = readByteSlice(buf, mediaIDLen); err != nil {
+				return info, fmt.Errorf("failed to read incremental mac: %w", err)
+			}
+			if chunkSize, err := binary.ReadUvarint(buf); err != nil {
+				return info, fmt.Errorf("failed to read chunk size: %w", err)
+			} else {
+				info.ChunkSize = uint32(chunkSize)
+			}
+		}
+		if _, err = buf.Peek(1); err == nil {
+			if err = binary.Read(buf, binary.BigEndian, &info.UserID); err != nil {
+				return info, fmt.Errorf("failed to read user id: %w", err)
+			}
+		}
 
 		return &info, nil
 	case directMediaTypeGroupAvatar:
@@ -193,6 +258,25 @@ func ParseDirectMediaInfo(mediaID networkid.MediaID) (_ DirectMediaInfo, err err
 			info.ProfileAvatarPath = string(profileAvatarPath)
 		}
 		return &info, nil
+	case directMediaTypeSticker:
+		var info DirectMediaSticker
+
+		if info.PackID, err = readByteSlice(buf, mediaIDLen); err != nil {
+			return info, fmt.Errorf("failed to read pack id: %w", err)
+		} else if info.PackKey, err = readByteSlice(buf, mediaIDLen); err != nil {
+			return info, fmt.Errorf("failed to read pack key: %w", err)
+		}
+		if stickerID, err := binary.ReadUvarint(buf); err != nil {
+			return info, fmt.Errorf("failed to read sticker id: %w", err)
+		} else {
+			info.StickerID = uint32(stickerID)
+		}
+		if _, err = buf.Peek(1); err == nil {
+			if err = binary.Read(buf, binary.BigEndian, &info.UserID); err != nil {
+				return info, fmt.Errorf("failed to read user id: %w", err)
+			}
+		}
+		return &info, nil
 	}
 
 	return nil, fmt.Errorf("invalid direct media type %d", mediaType)
@@ -31,6 +31,9 @@ import (
 	"math"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/random"
@@ -64,56 +67,258 @@ func DownloadAttachmentWithPointer(ctx context.Context, a *signalpb.AttachmentPo
 }
 
 func DownloadAttachment(ctx context.Context, cdnID uint64, cdnKey string, cdnNumber uint32, key, digest []byte, size uint32) ([]byte, error) {
-	path := getAttachmentPath(cdnID, cdnKey)
-	resp, err := web.GetAttachment(ctx, path, cdnNumber, nil)
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	err := DownloadAttachmentStream(ctx, cdnID, cdnKey, cdnNumber, key, digest, size, buf)
 	if err != nil {
 		return nil, err
 	}
-	bodyReader := resp.Body
-	defer bodyReader.Close()
+	return buf.Bytes(), nil
+}
 
-	body, err := io.ReadAll(bodyReader)
+// DownloadAttachmentToFile downloads and decrypts an attachment into a new temporary file.
+//
+// The returned file is seeked to the start. The caller is responsible for closing and removing the file.
+func DownloadAttachmentToFile(ctx context.Context, a *signalpb.AttachmentPointer) (*os.File, error) {
+	file, err := os.CreateTemp("", "signalmeow-download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	err = DownloadAttachmentPointerStream(ctx, a, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
 	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
 		return nil, err
 	}
-	if resp.StatusCode > 400 {
-		if json.Valid(body) && len(body) < 4096 {
-			zerolog.Ctx(ctx).Debug().RawJSON("response_data", body).Msg("Failed download response json")
-		} else if len(body) < 1024 {
-			zerolog.Ctx(ctx).Debug().Bytes("response_data", body).Msg("Failed download response data")
+	return file, nil
+}
+
+// MaxAttachmentDownloadRetries is the number of times an interrupted attachment download is resumed.
+const MaxAttachmentDownloadRetries = 5
+
+// DownloadAttachmentStream downloads an attachment and writes the decrypted data into the given writer.
+//
+// The MAC and digest are only verified at the end, which means unverified data is written to the output.
+// If an error is returned, anything written to the output must be discarded, so the output should be
+// something like a temp file rather than a direct connection to a client.
+// Interrupted downloads are resumed using HTTP range requests.
+func DownloadAttachmentStream(ctx context.Context, cdnID uint64, cdnKey string, cdnNumber uint32, key, digest []byte, size uint32, output io.Writer) error {
+	return DownloadAttachmentStreamWithIncrementalMAC(ctx, cdnID, cdnKey, cdnNumber, key, digest, nil, 0, size, output)
+}
+
+// DownloadAttachmentPointerStream calls DownloadAttachmentStreamWithIncrementalMAC with the info in the given pointer.
+func DownloadAttachmentPointerStream(ctx context.Context, a *signalpb.AttachmentPointer, output io.Writer) error {
+	return DownloadAttachmentStreamWithIncrementalMAC(
+		ctx, a.GetCdnId(), a.GetCdnKey(), a.GetCdnNumber(), a.Key, a.Digest, a.IncrementalMac, a.GetChunkSize(), a.GetSize(), output,
+	)
+}
+
+// DownloadAttachmentStreamWithIncrementalMAC is like DownloadAttachmentStream, but if incrementalMAC is set,
+// the ciphertext is also validated chunk by chunk and data is only written to the output after the chunk
+// it belongs to has been validated. If chunkSize is zero, it's calculated from the attachment size.
+//
+// The full MAC and digest are still only verified at the end, so errors must be handled the same way.
+func DownloadAttachmentStreamWithIncrementalMAC(
+	ctx context.Context,
+	cdnID uint64,
+	cdnKey string,
+	cdnNumber uint32,
+	key, digest, incrementalMAC []byte,
+	chunkSize, size uint32,
+	output io.Writer,
+) error {
+	if len(key) != 64 {
+		return fmt.Errorf("invalid attachment key length %d", len(key))
+	}
+	reader := &resumingAttachmentReader{
+		ctx:       ctx,
+		path:      getAttachmentPath(cdnID, cdnKey),
+		cdnNumber: cdnNumber,
+	}
+	err := reader.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if reader.totalSize < IVLength+aes.BlockSize+MACLength || (reader.totalSize-IVLength-MACLength)%aes.BlockSize != 0 {
+		return fmt.Errorf("invalid encrypted attachment length %d", reader.totalSize)
+	}
+
+	var ciphertext io.Reader = reader
+	if len(incrementalMAC) > 0 {
+		if chunkSize == 0 {
+			chunkSize, err = libsignalgo.CalculateIncrementalMACChunkSize(uint32(reader.totalSize))
+			if err != nil {
+				return fmt.Errorf("failed to calculate incremental MAC chunk size: %w", err)
+			}
 		}
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, ErrAttachmentNotFound
+		macReader, err := newIncrementalMACReader(reader, key[32:], chunkSize, incrementalMAC)
+		if err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		defer macReader.Close()
+		ciphertext = macReader
 	}
 
-	return decryptAttachment(body, key, digest, size)
+	digestHasher := sha256.New()
+	decrypter := aesDecryptStream([32]byte(key[:32]), [32]byte(key[32:]), io.TeeReader(ciphertext, digestHasher), reader.totalSize)
+	// The whole stream must be read for the MAC to be checked, so the padding is discarded on the writer side
+	limitedOutput := &limitedWriter{W: output, N: int64(size)}
+	_, err = io.Copy(limitedOutput, decrypter)
+	if err != nil {
+		return err
+	} else if limitedOutput.N > 0 {
+		return fmt.Errorf("decrypted attachment length %d < expected %d", int64(size)-limitedOutput.N, size)
+	} else if !hmac.Equal(digestHasher.Sum(nil), digest) {
+		return ErrInvalidDigestForAttachment
+	}
+	return nil
 }
 
-const MACLength = 32
-const IVLength = 16
+// limitedWriter writes up to N bytes to W and silently discards the rest.
+type limitedWriter struct {
+	W io.Writer
+	N int64
+}
 
-func decryptAttachment(body, key, digest []byte, size uint32) ([]byte, error) {
-	hash := sha256.Sum256(body)
-	if !hmac.Equal(hash[:], digest) {
-		return nil, ErrInvalidDigestForAttachment
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	origLen := len(p)
+	if int64(len(p)) > lw.N {
+		p = p[:lw.N]
 	}
-	l := len(body) - MACLength
-	if !verifyMAC(key[MACLength:], body[:l], body[l:]) {
-		return nil, ErrInvalidMACForAttachment
+	if len(p) > 0 {
+		n, err := lw.W.Write(p)
+		lw.N -= int64(n)
+		if err != nil {
+			return n, err
+		}
 	}
+	return origLen, nil
+}
+
+type resumingAttachmentReader struct {
+	ctx       context.Context
+	path      string
+	cdnNumber uint32
 
-	decrypted, err := aesDecrypt(key[:MACLength], body[:l])
+	body       io.ReadCloser
+	offset     int64
+	totalSize  int64
+	retries    int
+	pendingErr error
+}
+
+func (r *resumingAttachmentReader) open() error {
+	var opt *web.HTTPReqOpt
+	if r.offset > 0 {
+		opt = &web.HTTPReqOpt{Headers: map[string]string{
+			"Range": fmt.Sprintf("bytes=%d-", r.offset),
+		}}
+	}
+	resp, err := web.GetAttachment(r.ctx, r.path, r.cdnNumber, opt)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Valid(body) {
+			zerolog.Ctx(r.ctx).Debug().RawJSON("response_data", body).Msg("Failed download response json")
+		} else if len(body) < 1024 {
+			zerolog.Ctx(r.ctx).Debug().Bytes("response_data", body).Msg("Failed download response data")
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrAttachmentNotFound
+		}
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if r.offset == 0 {
+		if resp.ContentLength < 0 {
+			_ = resp.Body.Close()
+			return fmt.Errorf("attachment response is missing content length")
+		}
+		r.totalSize = resp.ContentLength
+	} else if resp.StatusCode == http.StatusPartialContent {
+		err = checkContentRangeStart(resp.Header.Get("Content-Range"), r.offset)
+		if err != nil {
+			_ = resp.Body.Close()
+			return err
+		}
+	} else if resp.StatusCode == http.StatusOK {
+		// The server ignored the range header, so skip the part we already have
+		_, err = io.CopyN(io.Discard, resp.Body, r.offset)
+		if err != nil {
+			_ = resp.Body.Close()
+			return fmt.Errorf("failed to skip already downloaded data: %w", err)
+		}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// checkContentRangeStart makes sure a partial response starts at the requested offset.
+// Without this check, a misbehaving server or proxy could make resumed downloads skip or repeat data.
+func checkContentRangeStart(contentRange string, offset int64) error {
+	rangeSpec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return fmt.Errorf("invalid content range %q in partial response", contentRange)
+	}
+	startStr, _, ok := strings.Cut(rangeSpec, "-")
+	if !ok {
+		return fmt.Errorf("invalid content range %q in partial response", contentRange)
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid content range %q in partial response", contentRange)
+	} else if start != offset {
+		return fmt.Errorf("partial response starts at %d, expected %d", start, offset)
 	}
-	if len(decrypted) < int(size) {
-		return nil, fmt.Errorf("decrypted attachment length %v < expected %v", len(decrypted), size)
+	return nil
+}
+
+func (r *resumingAttachmentReader) Read(p []byte) (int, error) {
+	for {
+		var n int
+		err := r.pendingErr
+		r.pendingErr = nil
+		if err == nil {
+			n, err = r.body.Read(p)
+			r.offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) && r.offset < r.totalSize {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			return n, err
+		} else if n > 0 {
+			r.pendingErr = err
+			return n, nil
+		} else if r.retries >= MaxAttachmentDownloadRetries || r.ctx.Err() != nil {
+			return 0, err
+		}
+		r.retries++
+		zerolog.Ctx(r.ctx).Warn().Err(err).
+			Int64("offset", r.offset).
+			Int64("total_size", r.totalSize).
+			Int("retry", r.retries).
+			Msg("Attachment download interrupted, resuming")
+		_ = r.body.Close()
+		err = r.open()
+		if err != nil {
+			return 0, fmt.Errorf("failed to resume download: %w", err)
+		}
 	}
-	return decrypted[:size], nil
 }
 
+func (r *resumingAttachmentReader) Close() error {
+	return r.body.Close()
+}
+
+const MACLength = 32
+const IVLength = 16
+
 type attachmentV4UploadAttributes struct {
 	Cdn                  uint32            `json:"cdn"`
 	Key                  string            `json:"key"`
@@ -121,47 +326,127 @@ type attachmentV4UploadAttributes struct {
 	SignedUploadLocation string            `json:"signedUploadLocation"`
 }
 
-func extend(data []byte, paddedLen int) []byte {
-	origLen := len(data)
-	if cap(data) >= paddedLen {
-		data = data[:paddedLen]
-		for i := origLen; i < paddedLen; i++ {
-			data[i] = 0
+// paddedAttachmentLength returns the length that an attachment is padded to before encryption.
+// Padded length uses exponential bracketing.
+func paddedAttachmentLength(size int64) int64 {
+	return int64(math.Max(541, math.Floor(math.Pow(1.05, math.Ceil(math.Log(float64(size))/math.Log(1.05))))))
+}
+
+// encryptedAttachmentLength returns the length of an encrypted attachment including the IV and MAC.
+func encryptedAttachmentLength(paddedLen int64) int64 {
+	// PKCS#7 padding always adds at least one byte
+	return IVLength + (paddedLen/aes.BlockSize+1)*aes.BlockSize + MACLength
+}
+
+// encryptAttachmentStream pads and encrypts the plaintext and writes the IV, ciphertext and MAC into the writer.
+// It returns the SHA-256 digest of the written data.
+func encryptAttachmentStream(keys []byte, plaintext io.Reader, plaintextLen, paddedLen int64, output io.Writer) ([]byte, error) {
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	iv := random.Bytes(IVLength)
+	cbc := cipher.NewCBCEncrypter(block, iv)
+	mac := hmac.New(sha256.New, keys[32:])
+	digest := sha256.New()
+	// The MAC covers the IV and ciphertext, while the digest covers everything including the MAC
+	macWriter := io.MultiWriter(output, mac, digest)
+	_, err = macWriter.Write(iv)
+	if err != nil {
+		return nil, err
+	}
+	paddedInput := io.MultiReader(
+		io.LimitReader(plaintext, plaintextLen),
+		io.LimitReader(zeroReader{}, paddedLen-plaintextLen),
+	)
+	buf := make([]byte, 32*1024)
+	var totalRead int64
+	for {
+		n, err := io.ReadFull(paddedInput, buf)
+		totalRead += int64(n)
+		chunk := buf[:n]
+		isLast := errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+		if err != nil && !isLast {
+			return nil, fmt.Errorf("failed to read plaintext: %w", err)
 		}
-		return data
-	} else {
-		newData := make([]byte, paddedLen)
-		copy(newData, data)
-		return newData
+		if isLast {
+			if totalRead != paddedLen {
+				return nil, fmt.Errorf("plaintext ended after %d bytes, expected %d", totalRead-(paddedLen-plaintextLen), plaintextLen)
+			}
+			pad := aes.BlockSize - len(chunk)%aes.BlockSize
+			chunk = append(chunk, bytes.Repeat([]byte{byte(pad)}, pad)...)
+		}
+		cbc.CryptBlocks(chunk, chunk)
+		_, err = macWriter.Write(chunk)
+		if err != nil {
+			return nil, err
+		}
+		if isLast {
+			break
+		}
+	}
+	_, err = io.MultiWriter(output, digest).Write(mac.Sum(nil))
+	if err != nil {
+		return nil, err
 	}
+	return digest.Sum(nil), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
 }
 
 func (cli *Client) UploadAttachment(ctx context.Context, body []byte) (*signalpb.AttachmentPointer, error) {
+	return cli.UploadAttachmentStream(ctx, bytes.NewReader(body), int64(len(body)))
+}
+
+// UploadAttachmentStream pads, encrypts and uploads an attachment without buffering the whole file in memory.
+//
+// If the size is negative, the reader is first copied into a temporary file to find the length,
+// as the upload length must be known before the upload starts.
+func (cli *Client) UploadAttachmentStream(ctx context.Context, body io.Reader, size int64) (*signalpb.AttachmentPointer, error) {
 	log := zerolog.Ctx(ctx).With().Str("func", "upload attachment").Logger()
+	if size < 0 {
+		tempFile, err := os.CreateTemp("", "signalmeow-upload-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		defer func() {
+			_ = tempFile.Close()
+			_ = os.Remove(tempFile.Name())
+		}()
+		size, err = io.Copy(tempFile, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write temp file: %w", err)
+		}
+		_, err = tempFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seek temp file: %w", err)
+		}
+		body = tempFile
+	}
+	if size > math.MaxUint32 {
+		return nil, fmt.Errorf("attachment too large (%d bytes)", size)
+	}
 	keys := random.Bytes(64) // combined AES and MAC keys
-	plaintextLength := uint32(len(body))
-
-	// Padded length uses exponential bracketing
-	paddedLen := int(math.Max(541, math.Floor(math.Pow(1.05, math.Ceil(math.Log(float64(len(body)))/math.Log(1.05))))))
-	if paddedLen < len(body) {
+	plaintextLength := uint32(size)
+	paddedLen := paddedAttachmentLength(size)
+	if paddedLen < size {
 		log.Panic().
-			Int("padded_len", paddedLen).
-			Int("len", len(body)).
+			Int64("padded_len", paddedLen).
+			Int64("len", size).
 			Msg("Math error: padded length is less than body length")
 	}
-	body = extend(body, paddedLen)
-
-	encrypted, err := aesEncrypt(keys[:32], body)
-	if err != nil {
-		return nil, err
-	}
-	encryptedWithMAC := appendMAC(keys[32:], encrypted)
+	encryptedLen := encryptedAttachmentLength(paddedLen)
 
 	// Get upload attributes from Signal server
 	attributesPath := "/v4/attachments/form/upload"
 	username, password := cli.Store.BasicAuthCreds()
 	opts := &web.HTTPReqOpt{Username: &username, Password: &password}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, attributesPath, opts)
+	resp, err := cli.sendRequest(ctx, http.MethodGet, attributesPath, opts)
 	if err != nil {
 		log.Err(err).Msg("Failed to request upload attributes")
 		return nil, fmt.Errorf("failed to request upload attributes: %w", err)
@@ -172,26 +457,48 @@ func (cli *Client) UploadAttachment(ctx context.Context, body []byte) (*signalpb
 		log.Err(err).Msg("Failed to decode upload attributes")
 		return nil, fmt.Errorf("failed to decode upload attributes: %w", err)
 	}
+
+	// The encryption runs in a goroutine writing into a pipe, which is read by the HTTP client as the request body.
+	// The HTTP client always closes the body, which makes the goroutine exit even if the request fails early.
+	pipeReader, pipeWriter := io.Pipe()
+	var digest []byte
+	var encryptErr error
+	encryptDone := make(chan struct{})
+	encryptStarted := false
+	startEncrypting := func() io.ReadCloser {
+		encryptStarted = true
+		go func() {
+			defer close(encryptDone)
+			digest, encryptErr = encryptAttachmentStream(keys, body, size, paddedLen, pipeWriter)
+			_ = pipeWriter.CloseWithError(encryptErr)
+		}()
+		return pipeReader
+	}
 	if uploadAttributes.Cdn == 3 {
 		log.Trace().Msg("Using TUS upload")
-		err = cli.uploadAttachmentTUS(ctx, uploadAttributes, encryptedWithMAC)
+		err = cli.uploadAttachmentTUS(ctx, uploadAttributes, startEncrypting, encryptedLen)
 	} else {
 		log.Trace().Msg("Using legacy upload")
-		err = cli.uploadAttachmentLegacy(ctx, uploadAttributes, encryptedWithMAC, username, password)
+		err = cli.uploadAttachmentLegacy(ctx, uploadAttributes, startEncrypting, encryptedLen, username, password)
+	}
+	_ = pipeReader.Close()
+	if encryptStarted {
+		<-encryptDone
+	}
+	if err == nil && encryptErr != nil {
+		err = fmt.Errorf("failed to encrypt attachment: %w", encryptErr)
 	}
 	if err != nil {
 		log.Err(err).Msg("Failed to upload attachment")
 		return nil, err
 	}
 
-	digest := sha256.Sum256(encryptedWithMAC)
-
 	attachmentPointer := &signalpb.AttachmentPointer{
 		AttachmentIdentifier: &signalpb.AttachmentPointer_CdnKey{
 			CdnKey: uploadAttributes.Key,
 		},
 		Key:       keys,
-		Digest:    digest[:],
+		Digest:    digest,
 		Size:      &plaintextLength,
 		CdnNumber: &uploadAttributes.Cdn,
 	}
@@ -202,12 +509,13 @@ func (cli *Client) UploadAttachment(ctx context.Context, body []byte) (*signalpb
 func (cli *Client) uploadAttachmentLegacy(
 	ctx context.Context,
 	uploadAttributes attachmentV4UploadAttributes,
-	encryptedWithMAC []byte,
+	getBody func() io.ReadCloser,
+	bodyLength int64,
 	username string,
 	password string,
 ) error {
 	// Allocate attachment on CDN
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
 		OverrideURL: uploadAttributes.SignedUploadLocation,
 		ContentType: web.ContentTypeOctetStream,
 		Headers:     uploadAttributes.Headers,
@@ -221,12 +529,13 @@ func (cli *Client) uploadAttachmentLegacy(
 	}
 
 	// Upload attachment to CDN
-	resp, err = web.SendHTTPRequest(ctx, http.MethodPut, "", &web.HTTPReqOpt{
-		OverrideURL: resp.Header.Get("Location"),
-		Body:        encryptedWithMAC,
-		ContentType: web.ContentTypeOctetStream,
-		Username:    &username,
-		Password:    &password,
+	resp, err = cli.env().SendHTTPRequest(ctx, http.MethodPut, "", &web.HTTPReqOpt{
+		OverrideURL:   resp.Header.Get("Location"),
+		BodyStream:    getBody(),
+		ContentLength: bodyLength,
+		ContentType:   web.ContentTypeOctetStream,
+		Username:      &username,
+		Password:      &password,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send upload request: %w", err)
@@ -239,17 +548,19 @@ func (cli *Client) uploadAttachmentLegacy(
 func (cli *Client) uploadAttachmentTUS(
 	ctx context.Context,
 	uploadAttributes attachmentV4UploadAttributes,
-	encryptedWithMAC []byte,
+	getBody func() io.ReadCloser,
+	bodyLength int64,
 ) error {
 	uploadAttributes.Headers["Tus-Resumable"] = "1.0.0"
-	uploadAttributes.Headers["Upload-Length"] = fmt.Sprintf("%d", len(encryptedWithMAC))
+	uploadAttributes.Headers["Upload-Length"] = strconv.FormatInt(bodyLength, 10)
 	uploadAttributes.Headers["Upload-Metadata"] = "filename " + base64.StdEncoding.EncodeToString([]byte(uploadAttributes.Key))
 
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
-		OverrideURL: uploadAttributes.SignedUploadLocation,
-		Body:        encryptedWithMAC,
-		ContentType: web.ContentTypeOffsetOctetStream,
-		Headers:     uploadAttributes.Headers,
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
+		OverrideURL:   uploadAttributes.SignedUploadLocation,
+		BodyStream:    getBody(),
+		ContentLength: bodyLength,
+		ContentType:   web.ContentTypeOffsetOctetStream,
+		Headers:       uploadAttributes.Headers,
 	})
 	// TODO actually support resuming on error
 	if err != nil {
@@ -291,8 +602,8 @@ func (cli *Client) UploadGroupAvatar(ctx context.Context, avatarBytes []byte, gi
 
 	// Get upload form from Signal server
 	formPath := "/v1/groups/avatar/form"
-	opts := &web.HTTPReqOpt{Username: &groupAuth.Username, Password: &groupAuth.Password, ContentType: web.ContentTypeProtobuf, Host: web.StorageHostname}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, formPath, opts)
+	opts := &web.HTTPReqOpt{Username: &groupAuth.Username, Password: &groupAuth.Password, ContentType: web.ContentTypeProtobuf, Host: cli.env().StorageHost}
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodGet, formPath, opts)
 	if err != nil {
 		log.Err(err).Msg("Error sending request fetching avatar upload form")
 		return nil, err
@@ -323,10 +634,10 @@ func (cli *Client) UploadGroupAvatar(ctx context.Context, avatarBytes []byte, gi
 	w.Close()
 
 	// Upload avatar to CDN
-	resp, err = web.SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
+	resp, err = cli.env().SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
 		Body:        requestBody.Bytes(),
 		ContentType: web.ContentType(w.FormDataContentType()),
-		Host:        web.CDN1Hostname,
+		Host:        cli.env().CDNHost(0),
 	})
 	if err != nil {
 		log.Err(err).Msg("Error sending request uploading attachment")
@@ -339,53 +650,3 @@ func (cli *Client) UploadGroupAvatar(ctx context.Context, avatarBytes []byte, gi
 
 	return &uploadForm.Key, nil
 }
-
-func verifyMAC(key, body, mac []byte) bool {
-	m := hmac.New(sha256.New, key)
-	m.Write(body)
-	return hmac.Equal(m.Sum(nil), mac)
-}
-
-func aesDecrypt(key, ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(ciphertext)%aes.BlockSize != 0 {
-		return nil, fmt.Errorf("ciphertext not multiple of AES blocksize (%d extra bytes)", len(ciphertext)%aes.BlockSize)
-	}
-
-	iv := ciphertext[:aes.BlockSize]
-	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(ciphertext, ciphertext)
-	pad := ciphertext[len(ciphertext)-1]
-	if pad > aes.BlockSize {
-		return nil, fmt.Errorf("pad value (%d) larger than AES blocksize (%d)", pad, aes.BlockSize)
-	}
-	return ciphertext[aes.BlockSize : len(ciphertext)-int(pad)], nil
-}
-
-func appendMAC(key, body []byte) []byte {
-	m := hmac.New(sha256.New, key)
-	m.Write(body)
-	return m.Sum(body)
-}
-
-func aesEncrypt(key, plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
-	plaintext = append(plaintext, bytes.Repeat([]byte{byte(pad)}, pad)...)
-
-	ciphertext := make([]byte, len(plaintext))
-	iv := random.Bytes(16)
-
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(ciphertext, plaintext)
-
-	return append(iv, ciphertext...), nil
-}
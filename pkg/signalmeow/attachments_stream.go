@@ -30,6 +30,8 @@ import (
 	"os"
 
 	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 )
 
 func verifyMACStream(hmacKey [32]byte, input io.Reader, totalSize int64) (bool, error) {
@@ -110,7 +112,7 @@ func (dr *decryptingReader) Read(p []byte) (int, error) {
 		}
 		actualMAC := dr.hasher.Sum(nil)
 		if !hmac.Equal(expectedMAC, actualMAC) {
-			return 0, fmt.Errorf("hmac mismatch")
+			return 0, ErrInvalidMACForAttachment
 		}
 	}
 	return len(p), nil
@@ -132,6 +134,71 @@ func aesDecryptStream(aesKey, macKey [32]byte, input io.Reader, totalSize int64)
 	}
 }
 
+// incrementalMACReader only returns data from the input after the chunk it belongs to has been validated
+// against the incremental MAC of the attachment.
+type incrementalMACReader struct {
+	input     io.Reader
+	validator *libsignalgo.ValidatingMac
+	readBuf   []byte
+	buf       []byte
+	validated int
+	err       error
+}
+
+func newIncrementalMACReader(input io.Reader, macKey []byte, chunkSize uint32, digests []byte) (*incrementalMACReader, error) {
+	validator, err := libsignalgo.NewValidatingMac(macKey, chunkSize, digests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create incremental MAC validator: %w", err)
+	}
+	return &incrementalMACReader{
+		input:     input,
+		validator: validator,
+		readBuf:   make([]byte, 32*1024),
+	}, nil
+}
+
+func (ir *incrementalMACReader) Read(p []byte) (int, error) {
+	for ir.validated == 0 {
+		if ir.err != nil {
+			return 0, ir.err
+		}
+		n, err := ir.input.Read(ir.readBuf)
+		if n > 0 {
+			ir.buf = append(ir.buf, ir.readBuf[:n]...)
+			validated, macErr := ir.validator.Update(ir.readBuf[:n])
+			if macErr != nil {
+				ir.err = fmt.Errorf("%w: %w", ErrInvalidMACForAttachment, macErr)
+				continue
+			}
+			ir.validated += validated
+		}
+		if errors.Is(err, io.EOF) {
+			validated, macErr := ir.validator.Finalize()
+			if macErr != nil {
+				ir.err = fmt.Errorf("%w: %w", ErrInvalidMACForAttachment, macErr)
+				continue
+			}
+			ir.validated += validated
+			if ir.validated != len(ir.buf) {
+				ir.err = fmt.Errorf("%w: %d bytes left unvalidated", ErrInvalidMACForAttachment, len(ir.buf)-ir.validated)
+				ir.validated = 0
+				continue
+			}
+			ir.err = io.EOF
+		} else if err != nil {
+			ir.err = err
+		}
+	}
+	n := copy(p, ir.buf[:ir.validated])
+	ir.buf = ir.buf[:copy(ir.buf, ir.buf[n:])]
+	ir.validated -= n
+	return n, nil
+}
+
+func (ir *incrementalMACReader) Close() error {
+	return ir.validator.Destroy()
+}
+
 func splitChunksStream(input io.Reader, callback func([]byte) error) error {
 	byteReader, ok := input.(io.ByteReader)
 	if !ok {
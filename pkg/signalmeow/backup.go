@@ -71,7 +71,7 @@ func (cli *Client) FetchAndProcessTransfer(ctx context.Context, meta *TransferAr
 		_ = file.Close()
 		_ = os.Remove(file.Name())
 	}()
-	err = downloadTransferArchive(ctx, meta, file)
+	err = cli.downloadTransferArchive(ctx, meta, file)
 	if err != nil {
 		return err
 	}
@@ -206,7 +206,7 @@ func (acp *archiveChunkProcessor) processFrame(frame *backuppb.Frame) error {
 		return acp.cli.Store.BackupStore.AddBackupChat(acp.ctx, item.Chat)
 	case *backuppb.Frame_ChatItem:
 		switch item.ChatItem.Item.(type) {
-		case *backuppb.ChatItem_DirectStoryReplyMessage, *backuppb.ChatItem_UpdateMessage, nil:
+		case *backuppb.ChatItem_UpdateMessage, nil:
 			zerolog.Ctx(acp.ctx).Debug().
 				Uint64("chat_id", item.ChatItem.ChatId).
 				Uint64("message_id", item.ChatItem.DateSent).
@@ -238,8 +238,8 @@ func (cli *Client) deriveTransferKeys() (aesKey, hmacKey [32]byte, err error) {
 	return
 }
 
-func downloadTransferArchive(ctx context.Context, meta *TransferArchiveMetadata, writeTo io.Writer) error {
-	resp, err := web.GetAttachment(ctx, getAttachmentPath(0, meta.Key), meta.CDN, nil)
+func (cli *Client) downloadTransferArchive(ctx context.Context, meta *TransferArchiveMetadata, writeTo io.Writer) error {
+	resp, err := cli.env().GetAttachment(ctx, getAttachmentPath(0, meta.Key), meta.CDN, nil)
 	if err != nil {
 		return fmt.Errorf("failed to download transfer archive: %w", err)
 	}
@@ -293,7 +293,7 @@ func (cli *Client) tryRequestTransferArchive(ctx context.Context, timeout time.D
 	path := "/v1/devices/transfer_archive?timeout=" + strconv.Itoa(int(timeout.Seconds()))
 	username, password := cli.Store.BasicAuthCreds()
 	opts := &web.HTTPReqOpt{Username: &username, Password: &password}
-	resp, err := web.SendHTTPRequest(reqCtx, http.MethodGet, path, opts)
+	resp, err := cli.env().SendHTTPRequest(reqCtx, http.MethodGet, path, opts)
 	defer func() {
 		if resp != nil && resp.Body != nil {
 			_ = resp.Body.Close()
@@ -27,6 +27,7 @@ import (
 
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/metrics"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
 )
@@ -34,6 +35,8 @@ import (
 type Client struct {
 	Store *store.Device
 	Log   zerolog.Logger
+	// Environment is the Signal server deployment to connect to. If nil, web.DefaultEnvironment is used.
+	Environment *web.ServerEnvironment
 
 	SenderCertificateWithE164 *libsignalgo.SenderCertificate
 	SenderCertificateNoE164   *libsignalgo.SenderCertificate
@@ -43,8 +46,13 @@ type Client struct {
 	GroupCallCache            *map[string]bool
 	LastContactRequestTime    time.Time
 	SyncContactsOnConnect     bool
+	// Metrics is optional and can be shared by all clients in the process.
+	Metrics *metrics.Metrics
 
-	encryptionLock sync.Mutex
+	sessionLocks          sessionLocks
+	senderCertificateLock sync.Mutex
+	groupCacheLock        sync.Mutex
+	groupCredentialsLock  sync.Mutex
 
 	AuthedWS             *web.SignalWebsocket
 	UnauthedWS           *web.SignalWebsocket
@@ -53,10 +61,14 @@ type Client struct {
 	loopCancel context.CancelFunc
 	loopWg     sync.WaitGroup
 
-	EventHandler func(events.SignalEvent) bool
+	// EventHandler is called for every event. Returning false prevents the envelope that caused the event from
+	// being acknowledged, so that the server sends it again later. Additional handlers can be added with Subscribe.
+	EventHandler  func(events.SignalEvent) bool
+	subscriptions subscriptionRegistry
 
 	storageAuthLock sync.Mutex
 	storageAuth     *basicExpiringCredentials
+	storageSyncLock sync.Mutex
 	cdAuthLock      sync.Mutex
 	cdAuth          *basicExpiringCredentials
 	cdToken         []byte
@@ -64,8 +76,25 @@ type Client struct {
 	writeCallbackCounter chan time.Time
 }
 
+func (cli *Client) env() *web.ServerEnvironment {
+	if cli.Environment == nil {
+		return web.DefaultEnvironment
+	}
+	return cli.Environment
+}
+
+// EnvContext returns a context that makes functions which don't take a client,
+// such as attachment and sticker downloads, use the client's server environment and proxy.
+func (cli *Client) EnvContext(ctx context.Context) context.Context {
+	return cli.env().WithContext(ctx)
+}
+
 func (cli *Client) handleEvent(evt events.SignalEvent) bool {
-	return cli.EventHandler(evt)
+	ok := true
+	if cli.EventHandler != nil {
+		ok = cli.EventHandler(evt)
+	}
+	return cli.subscriptions.dispatch(cli, evt) && ok
 }
 
 func (cli *Client) IsConnected() bool {
@@ -86,7 +115,8 @@ func (cli *Client) connectAuthedWS(ctx context.Context, requestHandler web.Reque
 		Str("username", username).
 		Logger()
 	ctx = log.WithContext(ctx)
-	authedWS := web.NewSignalWebsocket(url.UserPassword(username, password))
+	authedWS := web.NewSignalWebsocket(cli.env(), url.UserPassword(username, password))
+	authedWS.Metrics = cli.Metrics
 	statusChan := authedWS.Connect(ctx, requestHandler)
 	cli.AuthedWS = authedWS
 	return statusChan, nil
@@ -101,7 +131,8 @@ func (cli *Client) connectUnauthedWS(ctx context.Context) (chan web.SignalWebsoc
 		Str("websocket_type", "unauthed").
 		Logger()
 	ctx = log.WithContext(ctx)
-	unauthedWS := web.NewSignalWebsocket(nil)
+	unauthedWS := web.NewSignalWebsocket(cli.env(), nil)
+	unauthedWS.Metrics = cli.Metrics
 	statusChan := unauthedWS.Connect(ctx, nil)
 	cli.UnauthedWS = unauthedWS
 	return statusChan, nil
@@ -19,7 +19,6 @@ package signalmeow
 import (
 	"context"
 	"encoding/binary"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
@@ -31,7 +30,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/tidwall/gjson"
-	"go.mau.fi/util/exerrors"
 	"google.golang.org/protobuf/proto"
 
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
@@ -39,14 +37,10 @@ import (
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
 )
 
-const ProdContactDiscoveryServer = "cdsi.signal.org"
-const ProdContactDiscoveryMrenclave = "c6ff0682219217f7045624be472a077c0d4b06193fe71632eb0adb50051d5da1"
 const ContactDiscoveryAuthTTL = 23 * time.Hour
 
 const rateLimitCloseCode = websocket.StatusCode(4008)
 
-var prodContactDiscoveryMrenclaveBytes = exerrors.Must(hex.DecodeString(ProdContactDiscoveryMrenclave))
-
 type ContactDiscoveryRateLimitError struct {
 	RetryAfter time.Duration
 }
@@ -58,6 +52,7 @@ func (cdrle ContactDiscoveryRateLimitError) Error() string {
 type ContactDiscoveryClient struct {
 	CDS *libsignalgo.SGXClientState
 	WS  *websocket.Conn
+	Env *web.ServerEnvironment
 
 	Token     []byte
 	Response  ContactDiscoveryResponse
@@ -106,18 +101,19 @@ func (cli *Client) doContactDiscovery(ctx context.Context, req *signalpb.CDSClie
 	ctx = log.WithContext(ctx)
 	addr := (&url.URL{
 		Scheme: "wss",
-		Host:   ProdContactDiscoveryServer,
+		Host:   cli.env().ContactDiscoveryHost,
 		User:   url.UserPassword(creds.Username, creds.Password),
-		Path:   path.Join("v1", ProdContactDiscoveryMrenclave, "discovery"),
+		Path:   path.Join("v1", cli.env().ContactDiscoveryMrenclave, "discovery"),
 	}).String()
 	log.Trace().Msg("Connecting to contact discovery websocket")
-	ws, _, err := web.OpenWebsocket(ctx, addr)
+	ws, _, err := cli.env().OpenWebsocket(ctx, addr)
 	if err != nil {
 		var closeErr websocket.CloseError
 		if errors.As(err, &closeErr) && closeErr.Code == rateLimitCloseCode {
 			retryAfter := gjson.Get(closeErr.Reason, "retry_after")
 			if retryAfter.Type == gjson.Number {
 				retryAfterDuration := time.Duration(retryAfter.Int()) * time.Second
+				cli.Metrics.CDSIRateLimited(retryAfterDuration)
 				return nil, nil, ContactDiscoveryRateLimitError{RetryAfter: retryAfterDuration}
 			}
 		}
@@ -127,7 +123,8 @@ func (cli *Client) doContactDiscovery(ctx context.Context, req *signalpb.CDSClie
 		_ = ws.CloseNow()
 	}()
 	cdc := &ContactDiscoveryClient{
-		WS: ws,
+		WS:  ws,
+		Env: cli.env(),
 	}
 	log.Trace().Msg("Doing contact discovery websocket handshake")
 	err = cdc.Handshake(ctx)
@@ -159,7 +156,7 @@ func (cdc *ContactDiscoveryClient) Handshake(ctx context.Context) error {
 	} else if msgType != websocket.MessageBinary {
 		return fmt.Errorf("expected binary message, got %s", msgType.String())
 	}
-	cdsClient, err := libsignalgo.NewCDS2ClientState(prodContactDiscoveryMrenclaveBytes, attestationMsg, time.Now())
+	cdsClient, err := libsignalgo.NewCDS2ClientState(cdc.Env.ContactDiscoveryMrenclaveBytes(), attestationMsg, time.Now())
 	if err != nil {
 		return fmt.Errorf("failed to initialize CDS2 client state: %w", err)
 	}
@@ -49,22 +49,26 @@ func (cli *Client) UpdateDeviceName(ctx context.Context, name string) error {
 	if err != nil {
 		return fmt.Errorf("failed to encrypt device name: %w", err)
 	}
-	err = cli.updateDeviceName(ctx, encryptedName)
+	err = cli.updateDeviceName(ctx, encryptedName, cli.Store.DeviceID)
 	if err != nil {
 		return fmt.Errorf("failed to update device name: %w", err)
 	}
 	return nil
 }
 
-func (cli *Client) updateDeviceName(ctx context.Context, encryptedName []byte) error {
+func (cli *Client) updateDeviceName(ctx context.Context, encryptedName []byte, deviceID int) error {
 	reqData, err := json.Marshal(map[string]any{
 		"deviceName": encryptedName,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to marshal device name update request: %w", err)
 	}
+	path := "/v1/accounts/name"
+	if deviceID != cli.Store.DeviceID {
+		path += fmt.Sprintf("?deviceId=%d", deviceID)
+	}
 	username, password := cli.Store.BasicAuthCreds()
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPut, "/v1/accounts/name", &web.HTTPReqOpt{
+	resp, err := cli.sendRequest(ctx, http.MethodPut, path, &web.HTTPReqOpt{
 		Body:     reqData,
 		Username: &username,
 		Password: &password,
@@ -134,7 +138,7 @@ func DecryptDeviceName(wrappedData []byte, identityKey *libsignalgo.PrivateKey)
 
 	key1 := hmacSHA256(masterSecret, []byte("auth"))
 	syntheticIV := hmacSHA256(key1, decryptedName)[:16]
-	if !hmac.Equal(key1, hmacSHA256(name.SyntheticIv, syntheticIV)) {
+	if !hmac.Equal(name.SyntheticIv, syntheticIV) {
 		return "", fmt.Errorf("mismatching synthetic IV")
 	}
 	return string(decryptedName), nil
@@ -0,0 +1,137 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// PrimaryDeviceID is the device ID of the primary device of an account (usually the phone).
+const PrimaryDeviceID = 1
+
+var ErrNotPrimaryDevice = errors.New("only the primary device can manage other devices")
+
+// DeviceInfo contains information about a device on the account.
+type DeviceInfo struct {
+	ID   int
+	Name string
+	// The server only stores these with day precision.
+	Created  time.Time
+	LastSeen time.Time
+}
+
+type respListDevices struct {
+	Devices []struct {
+		ID       int    `json:"id"`
+		Name     []byte `json:"name"`
+		LastSeen int64  `json:"lastSeen"`
+		Created  int64  `json:"created"`
+	} `json:"devices"`
+}
+
+// ListDevices returns all devices on the account, including the primary device and the current device.
+//
+// Device names are decrypted using the account's ACI identity key.
+// If a name can't be decrypted, it's left empty.
+func (cli *Client) ListDevices(ctx context.Context) ([]*DeviceInfo, error) {
+	username, password := cli.Store.BasicAuthCreds()
+	resp, err := cli.sendRequest(ctx, http.MethodGet, "/v1/devices", &web.HTTPReqOpt{
+		Username: &username,
+		Password: &password,
+	})
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	var respData respListDevices
+	err = web.DecodeHTTPResponseBody(ctx, &respData, resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	devices := make([]*DeviceInfo, len(respData.Devices))
+	for i, dev := range respData.Devices {
+		devices[i] = &DeviceInfo{
+			ID:       dev.ID,
+			Created:  time.UnixMilli(dev.Created),
+			LastSeen: time.UnixMilli(dev.LastSeen),
+		}
+		if len(dev.Name) > 0 {
+			devices[i].Name, err = DecryptDeviceName(dev.Name, cli.Store.ACIIdentityKeyPair.GetPrivateKey())
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Int("device_id", dev.ID).Msg("Failed to decrypt device name")
+			}
+		}
+	}
+	return devices, nil
+}
+
+// RemoveDevice unlinks another device from the account. This is only allowed on the primary device.
+//
+// To unlink the current device, use [Client.Unlink].
+func (cli *Client) RemoveDevice(ctx context.Context, deviceID int) error {
+	if cli.Store.DeviceID != PrimaryDeviceID {
+		return ErrNotPrimaryDevice
+	} else if deviceID == PrimaryDeviceID {
+		return fmt.Errorf("can't remove the primary device")
+	}
+	return cli.deleteDevice(ctx, deviceID)
+}
+
+// RenameDevice changes the name of a device on the account. Renaming other devices is only allowed on the primary device.
+//
+// To rename the current device, this is equivalent to [Client.UpdateDeviceName].
+func (cli *Client) RenameDevice(ctx context.Context, deviceID int, name string) error {
+	if deviceID != cli.Store.DeviceID && cli.Store.DeviceID != PrimaryDeviceID {
+		return ErrNotPrimaryDevice
+	}
+	// Device names are encrypted with the account identity key, so the primary can encrypt them for other devices
+	encryptedName, err := EncryptDeviceName(name, cli.Store.ACIIdentityKeyPair.GetPublicKey())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt device name: %w", err)
+	}
+	err = cli.updateDeviceName(ctx, encryptedName, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to update device name: %w", err)
+	}
+	return nil
+}
+
+func (cli *Client) deleteDevice(ctx context.Context, deviceID int) error {
+	username, password := cli.Store.BasicAuthCreds()
+	resp, err := cli.sendRequest(ctx, http.MethodDelete, fmt.Sprintf("/v1/devices/%d", deviceID), &web.HTTPReqOpt{
+		Username: &username,
+		Password: &password,
+	})
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
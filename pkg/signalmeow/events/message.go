@@ -28,14 +28,18 @@ type SignalEvent interface {
 	isSignalEvent()
 }
 
-func (*ChatEvent) isSignalEvent()       {}
-func (*DecryptionError) isSignalEvent() {}
-func (*Receipt) isSignalEvent()         {}
-func (*ReadSelf) isSignalEvent()        {}
-func (*Call) isSignalEvent()            {}
-func (*ContactList) isSignalEvent()     {}
-func (*ACIFound) isSignalEvent()        {}
-func (*QueueEmpty) isSignalEvent()      {}
+func (*ChatEvent) isSignalEvent()         {}
+func (*DecryptionError) isSignalEvent()   {}
+func (*Receipt) isSignalEvent()           {}
+func (*ReadSelf) isSignalEvent()          {}
+func (*Call) isSignalEvent()              {}
+func (*ContactList) isSignalEvent()       {}
+func (*ACIFound) isSignalEvent()          {}
+func (*QueueEmpty) isSignalEvent()        {}
+func (*ContactRemoved) isSignalEvent()    {}
+func (*GroupRemoved) isSignalEvent()      {}
+func (*StickerPackUpdate) isSignalEvent() {}
+func (*Story) isSignalEvent()             {}
 
 type MessageInfo struct {
 	Sender uuid.UUID
@@ -73,6 +77,9 @@ type Call struct {
 
 type ContactList struct {
 	Contacts []*types.Recipient
+	// The positions of the contacts' chats in the chat list of the primary device, with 0 being the most recent chat.
+	// Contacts who don't have a chat on the primary device aren't included.
+	InboxPositions map[uuid.UUID]uint32
 }
 
 type ACIFound struct {
@@ -81,3 +88,57 @@ type ACIFound struct {
 }
 
 type QueueEmpty struct{}
+
+// ContactRemoved is emitted when a contact is deleted or hidden in the storage service.
+type ContactRemoved struct {
+	Contact *types.Recipient
+}
+
+// GroupRemoved is emitted when a group is deleted from the storage service.
+type GroupRemoved struct {
+	GroupID types.GroupIdentifier
+}
+
+// StickerPackUpdate is emitted when a sticker pack is installed or removed on another device.
+//
+// The manifest is nil if the pack was removed or if downloading the manifest failed.
+type StickerPackUpdate struct {
+	PackID    []byte
+	PackKey   []byte
+	Installed bool
+	Manifest  *signalpb.Pack
+}
+
+// Story is emitted when a contact posts a story, or when another own device sends one.
+//
+// For group stories, the chat ID is the group, otherwise it's the sender.
+// Exactly one of File and Text is set.
+type Story struct {
+	Info          MessageInfo
+	Timestamp     uint64
+	AllowsReplies bool
+
+	File       *signalpb.AttachmentPointer
+	Text       *TextStory
+	BodyRanges []*signalpb.BodyRange
+}
+
+// TextStory is a decoded text story. Colors are ARGB integers.
+type TextStory struct {
+	Text            string
+	Style           signalpb.TextAttachment_Style
+	ForegroundColor *uint32
+	BackgroundColor *uint32
+	Preview         *signalpb.Preview
+
+	// Only one of these is set. If neither is, clients use a default background.
+	BackgroundSolid    *uint32
+	BackgroundGradient *StoryGradient
+}
+
+type StoryGradient struct {
+	// Angle in degrees
+	Angle     uint32
+	Colors    []uint32
+	Positions []float32
+}
@@ -21,7 +21,6 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -313,19 +312,20 @@ func (cli *Client) fetchNewGroupCreds(ctx context.Context, today time.Time) (*Gr
 		Logger()
 	sevenDaysOut := today.Add(7 * 24 * time.Hour)
 	path := fmt.Sprintf("/v1/certificate/auth/group?redemptionStartSeconds=%d&redemptionEndSeconds=%d&pniAsServiceId=true", today.Unix(), sevenDaysOut.Unix())
-	authRequest := web.CreateWSRequest(http.MethodGet, path, nil, nil, nil)
-	resp, err := cli.AuthedWS.SendRequest(ctx, authRequest)
+	username, password := cli.Store.BasicAuthCreds()
+	resp, err := cli.sendRequest(ctx, http.MethodGet, path, &web.HTTPReqOpt{Username: &username, Password: &password})
 	if err != nil {
 		return nil, fmt.Errorf("SendRequest error: %w", err)
 	}
-	if *resp.Status != 200 {
-		return nil, fmt.Errorf("bad status code fetching group creds: %d", *resp.Status)
+	if resp.StatusCode != 200 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("bad status code fetching group creds: %d", resp.StatusCode)
 	}
 
 	var creds GroupCredentials
-	err = json.Unmarshal(resp.Body, &creds)
+	err = web.DecodeHTTPResponseBody(ctx, &creds, resp)
 	if err != nil {
-		log.Err(err).Msg("json.Unmarshal error")
+		log.Err(err).Msg("Failed to decode group credentials")
 		return nil, err
 	}
 	// make sure pni matches device pni
@@ -359,15 +359,18 @@ func (cli *Client) GetAuthorizationForToday(ctx context.Context, masterKey libsi
 	// Timestamps for the start of today, and 7 days later
 	today := time.Now().Truncate(24 * time.Hour)
 
+	cli.groupCredentialsLock.Lock()
 	todayCred := cli.getCachedAuthorizationForToday(today)
 	if todayCred == nil {
 		creds, err := cli.fetchNewGroupCreds(ctx, today)
 		if err != nil {
+			cli.groupCredentialsLock.Unlock()
 			return nil, fmt.Errorf("fetchNewGroupCreds error: %w", err)
 		}
 		cli.GroupCredentials = creds
 		todayCred = cli.getCachedAuthorizationForToday(today)
 	}
+	cli.groupCredentialsLock.Unlock()
 	if todayCred == nil {
 		return nil, fmt.Errorf("couldn't get credential for today")
 	}
@@ -383,7 +386,7 @@ func (cli *Client) GetAuthorizationForToday(ctx context.Context, masterKey libsi
 
 	// Receive the auth credential
 	authCredential, err := libsignalgo.ReceiveAuthCredentialWithPni(
-		prodServerPublicParams,
+		cli.env().PublicParams(),
 		cli.Store.ACI,
 		cli.Store.PNI,
 		redemptionTime,
@@ -401,7 +404,7 @@ func (cli *Client) GetAuthorizationForToday(ctx context.Context, masterKey libsi
 		return nil, err
 	}
 	authCredentialPresentation, err := libsignalgo.CreateAuthCredentialWithPniPresentation(
-		prodServerPublicParams,
+		cli.env().PublicParams(),
 		libsignalgo.GenerateRandomness(),
 		groupSecretParams,
 		*authCredential,
@@ -649,9 +652,9 @@ func (cli *Client) fetchGroupWithMasterKey(ctx context.Context, groupMasterKey t
 		Username:    &groupAuth.Username,
 		Password:    &groupAuth.Password,
 		ContentType: web.ContentTypeProtobuf,
-		Host:        web.StorageHostname,
+		Host:        cli.env().StorageHost,
 	}
-	response, err := web.SendHTTPRequest(ctx, http.MethodGet, "/v1/groups", opts)
+	response, err := cli.env().SendHTTPRequest(ctx, http.MethodGet, "/v1/groups", opts)
 	if err != nil {
 		return nil, err
 	}
@@ -692,11 +695,11 @@ func (cli *Client) fetchGroupWithMasterKey(ctx context.Context, groupMasterKey t
 func (cli *Client) DownloadGroupAvatar(ctx context.Context, avatarPath string, groupMasterKey types.SerializedGroupMasterKey) ([]byte, error) {
 	username, password := cli.Store.BasicAuthCreds()
 	opts := &web.HTTPReqOpt{
-		Host:     web.CDN1Hostname,
+		Host:     cli.env().CDNHost(0),
 		Username: &username,
 		Password: &password,
 	}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, avatarPath, opts)
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodGet, avatarPath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -716,24 +719,38 @@ func (cli *Client) DownloadGroupAvatar(ctx context.Context, avatarPath string, g
 }
 
 func (cli *Client) RetrieveGroupByID(ctx context.Context, gid types.GroupIdentifier, revision uint32) (*Group, error) {
+	cli.groupCacheLock.Lock()
 	cli.initGroupCache()
-
 	lastFetched, ok := cli.GroupCache.lastFetched[gid]
 	if ok && time.Since(lastFetched) < 1*time.Hour {
 		group, ok := cli.GroupCache.groups[gid]
 		if ok && group.Revision >= revision {
+			cli.groupCacheLock.Unlock()
 			return group, nil
 		}
 	}
+	cli.groupCacheLock.Unlock()
+
 	group, err := cli.fetchGroupByID(ctx, gid)
 	if err != nil {
 		return nil, err
 	}
+	cli.groupCacheLock.Lock()
 	cli.GroupCache.groups[gid] = group
 	cli.GroupCache.lastFetched[gid] = time.Now()
+	cli.groupCacheLock.Unlock()
 	return group, nil
 }
 
+func (cli *Client) invalidateGroupCache(gid types.GroupIdentifier) {
+	cli.groupCacheLock.Lock()
+	defer cli.groupCacheLock.Unlock()
+	cli.initGroupCache()
+	delete(cli.GroupCache.groups, gid)
+	delete(cli.GroupCache.lastFetched, gid)
+	delete(cli.GroupCache.activeCalls, gid)
+}
+
 // We should store the group master key in the group store as soon as we see it,
 // then use the group identifier to refer to groups. As a convenience, we return
 // the group identifier, which is derived from the group master key.
@@ -753,6 +770,8 @@ func (cli *Client) StoreMasterKey(ctx context.Context, groupMasterKey types.Seri
 // Of course for group calls Signal doesn't tell us *anything* so we're mostly just inferring
 // So we just jam a new call ID in, and return true if we *think* this is a new incoming call
 func (cli *Client) UpdateActiveCalls(gid types.GroupIdentifier, callID string) (isActive bool) {
+	cli.groupCacheLock.Lock()
+	defer cli.groupCacheLock.Unlock()
 	cli.initGroupCache()
 	// Check to see if we currently have an active call for this group
 	currentCallID, ok := cli.GroupCache.activeCalls[gid]
@@ -803,7 +822,7 @@ func (cli *Client) decryptGroupChange(ctx context.Context, encryptedGroupChange
 
 	var err error
 	if verifySignature {
-		err = libsignalgo.ServerPublicParamsVerifySignature(prodServerPublicParams, encryptedActionsBytes, libsignalgo.NotarySignature(serverSignature))
+		err = libsignalgo.ServerPublicParamsVerifySignature(cli.env().PublicParams(), encryptedActionsBytes, libsignalgo.NotarySignature(serverSignature))
 		if err != nil {
 			return nil, fmt.Errorf("Failed to verify Server Signature: %w", err)
 		}
@@ -1292,7 +1311,7 @@ func (cli *Client) EncryptAndSignGroupChange(ctx context.Context, decryptedGroup
 			return nil, err
 		}
 		presentation, err := groupSecretParams.CreateExpiringProfileKeyCredentialPresentation(
-			prodServerPublicParams,
+			cli.env().PublicParams(),
 			*expiringProfileKeyCredential,
 		)
 		if err != nil {
@@ -1310,7 +1329,7 @@ func (cli *Client) EncryptAndSignGroupChange(ctx context.Context, decryptedGroup
 			return nil, err
 		}
 		presentation, err := groupSecretParams.CreateExpiringProfileKeyCredentialPresentation(
-			prodServerPublicParams,
+			cli.env().PublicParams(),
 			*expiringProfileKeyCredential,
 		)
 		if err != nil {
@@ -1424,7 +1443,7 @@ func (cli *Client) encryptMember(ctx context.Context, member *GroupMember, group
 		return nil, encryptedPendingMember, err
 	}
 	presentation, err := groupSecretParams.CreateExpiringProfileKeyCredentialPresentation(
-		prodServerPublicParams,
+		cli.env().PublicParams(),
 		*expiringProfileKeyCredential,
 	)
 	if err != nil {
@@ -1503,9 +1522,9 @@ func (cli *Client) patchGroup(ctx context.Context, groupChange *signalpb.GroupCh
 		Password:    &groupAuth.Password,
 		ContentType: web.ContentTypeProtobuf,
 		Body:        requestBody,
-		Host:        web.StorageHostname,
+		Host:        cli.env().StorageHost,
 	}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPatch, path, opts)
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodPatch, path, opts)
 	if err != nil {
 		return nil, fmt.Errorf("SendRequest error: %w", err)
 	}
@@ -1577,9 +1596,7 @@ func (cli *Client) UpdateGroup(ctx context.Context, groupChange *GroupChange, gi
 				return 0, fmt.Errorf("Group Change Failed: %w", err)
 			}
 		} else if errors.Is(err, ConflictError) {
-			delete(cli.GroupCache.groups, gid)
-			delete(cli.GroupCache.lastFetched, gid)
-			delete(cli.GroupCache.activeCalls, gid)
+			cli.invalidateGroupCache(gid)
 			group, err = cli.RetrieveGroupByID(ctx, gid, 0)
 			groupChange.resolveConflict(group)
 			if groupChange.isEmptpy() {
@@ -1590,9 +1607,7 @@ func (cli *Client) UpdateGroup(ctx context.Context, groupChange *GroupChange, gi
 			break
 		}
 	}
-	delete(cli.GroupCache.groups, gid)
-	delete(cli.GroupCache.lastFetched, gid)
-	delete(cli.GroupCache.activeCalls, gid)
+	cli.invalidateGroupCache(gid)
 	if err != nil {
 		log.Err(err).Msg("couldn't patch group on server")
 		return 0, err
@@ -1724,9 +1739,9 @@ func (cli *Client) createGroupOnServer(ctx context.Context, decryptedGroup *Grou
 		Password:    &groupAuth.Password,
 		ContentType: web.ContentTypeProtobuf,
 		Body:        requestBody,
-		Host:        web.StorageHostname,
+		Host:        cli.env().StorageHost,
 	}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPut, path, opts)
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodPut, path, opts)
 	if err != nil {
 		return nil, fmt.Errorf("SendRequest error: %w", err)
 	}
@@ -1796,11 +1811,11 @@ func (cli *Client) GetGroupHistoryPage(ctx context.Context, gid types.GroupIdent
 		Username:    &groupAuth.Username,
 		Password:    &groupAuth.Password,
 		ContentType: web.ContentTypeProtobuf,
-		Host:        web.StorageHostname,
+		Host:        cli.env().StorageHost,
 	}
 	// highest known epoch seems to always be 5, but that may change in the future. includeLastState is always false
 	path := fmt.Sprintf("/v1/groups/logs/%d?maxSupportedChangeEpoch=%d&includeFirstState=%t&includeLastState=false", fromRevision, 5, includeFirstState)
-	response, err := web.SendHTTPRequest(ctx, http.MethodGet, path, opts)
+	response, err := cli.env().SendHTTPRequest(ctx, http.MethodGet, path, opts)
 	if err != nil {
 		return nil, err
 	}
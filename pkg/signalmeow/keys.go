@@ -99,7 +99,7 @@ func (cli *Client) RegisterAllPreKeys(ctx context.Context, pks store.PreKeyStore
 	preKeyUsername := fmt.Sprintf("%s.%d", cli.Store.ACI, cli.Store.DeviceID)
 	log := zerolog.Ctx(ctx).With().Str("action", "register prekeys").Logger()
 	log.Debug().Int("num_prekeys", len(preKeys)).Int("num_kyber_prekeys", len(kyberPreKeys)).Msg("Registering prekeys")
-	err = RegisterPreKeys(ctx, &generatedPreKeys, pni, preKeyUsername, cli.Store.Password)
+	err = RegisterPreKeys(cli.env().WithContext(ctx), &generatedPreKeys, pni, preKeyUsername, cli.Store.Password)
 	if err != nil {
 		return fmt.Errorf("failed to register prekeys: %w", err)
 	}
@@ -434,7 +434,7 @@ func (cli *Client) FetchAndProcessPreKey(ctx context.Context, theirServiceID lib
 	}
 	path := "/v2/keys/" + theirServiceID.String() + deviceIDPath + "?pq=true"
 	username, password := cli.Store.BasicAuthCreds()
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, path, &web.HTTPReqOpt{Username: &username, Password: &password})
+	resp, err := cli.sendRequest(ctx, http.MethodGet, path, &web.HTTPReqOpt{Username: &username, Password: &password})
 	if err != nil {
 		return fmt.Errorf("error sending request: %w", err)
 	}
@@ -555,7 +555,7 @@ func keysPath(pni bool) string {
 func (cli *Client) GetMyKeyCounts(ctx context.Context, pni bool) (int, int, error) {
 	log := zerolog.Ctx(ctx).With().Str("action", "get my key counts").Logger()
 	username, password := cli.Store.BasicAuthCreds()
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, keysPath(pni), &web.HTTPReqOpt{Username: &username, Password: &password})
+	resp, err := cli.sendRequest(ctx, http.MethodGet, keysPath(pni), &web.HTTPReqOpt{Username: &username, Password: &password})
 	if err != nil {
 		log.Err(err).Msg("Error sending request")
 		return 0, 0, err
@@ -566,6 +566,12 @@ func (cli *Client) GetMyKeyCounts(ctx context.Context, pni bool) (int, int, erro
 		log.Err(err).Msg("Fetching prekey counts, error with response body")
 		return 0, 0, err
 	}
+	identity := "aci"
+	if pni {
+		identity = "pni"
+	}
+	cli.Metrics.PreKeyCount(identity, "ec", preKeyCountResponse.Count)
+	cli.Metrics.PreKeyCount(identity, "kyber", preKeyCountResponse.PQCount)
 	return preKeyCountResponse.Count, preKeyCountResponse.PQCount, err
 }
 
@@ -0,0 +1,222 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package metrics contains the Prometheus metrics that signalmeow collects about connections and messaging.
+//
+// A single Metrics instance is meant to be shared by all clients in a process, so none of the metrics are
+// labeled by account. All methods are safe to call on a nil *Metrics, which makes collecting metrics optional.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "signalmeow"
+
+type Metrics struct {
+	websocketsConnected  *prometheus.GaugeVec
+	websocketsBackingOff *prometheus.GaugeVec
+	websocketReconnects  *prometheus.CounterVec
+	websocketBackoff     *prometheus.HistogramVec
+
+	envelopeQueueDepth  prometheus.Gauge
+	queueDrainTime      prometheus.Histogram
+	decryptionFailures  *prometheus.CounterVec
+	sendLatency         *prometheus.HistogramVec
+	sendFailures        *prometheus.CounterVec
+	preKeyCount         *prometheus.HistogramVec
+	cdsiRateLimits      prometheus.Counter
+	cdsiRateLimitLength prometheus.Histogram
+}
+
+// New creates a new set of metrics and registers them with the given registerer.
+//
+// Use prometheus.DefaultRegisterer to expose the metrics through promhttp.Handler, or a custom registry
+// to keep them separate from other metrics in the process.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		websocketsConnected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "websockets_connected",
+			Help:      "Number of currently connected chat websockets",
+		}, []string{"websocket"}),
+		websocketsBackingOff: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "websockets_backing_off",
+			Help:      "Number of chat websockets currently waiting before retrying a failed connection",
+		}, []string{"websocket"}),
+		websocketReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "websocket_reconnects_total",
+			Help:      "Number of times a chat websocket was disconnected and reconnected",
+		}, []string{"websocket"}),
+		websocketBackoff: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "websocket_backoff_seconds",
+			Help:      "Time waited before retrying a failed chat websocket connection",
+			Buckets:   []float64{1, 5, 10, 15, 30, 45, 60, 120},
+		}, []string{"websocket"}),
+
+		envelopeQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "envelope_queue_depth",
+			Help:      "Number of received envelopes that haven't been decrypted and handled yet",
+		}),
+		queueDrainTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_drain_seconds",
+			Help:      "Time from receiving the first queued envelope to the server reporting the queue as empty",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2.5, 10),
+		}),
+		decryptionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decryption_failures_total",
+			Help:      "Number of envelopes that couldn't be decrypted",
+		}, []string{"envelope_type"}),
+		sendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "send_latency_seconds",
+			Help:      "Time taken by the server to respond to message send requests",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"sealed_sender"}),
+		sendFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "send_failures_total",
+			Help:      "Number of message send requests that the server responded to with a non-200 status",
+		}, []string{"status"}),
+		preKeyCount: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "prekey_count",
+			Help:      "Number of one-time prekeys remaining on the server when checked",
+			Buckets:   []float64{0, 5, 10, 25, 50, 75, 100},
+		}, []string{"identity", "kind"}),
+		cdsiRateLimits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cdsi_rate_limits_total",
+			Help:      "Number of contact discovery requests rejected due to rate limiting",
+		}),
+		cdsiRateLimitLength: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cdsi_rate_limit_retry_after_seconds",
+			Help:      "Retry-after duration of contact discovery rate limits",
+			Buckets:   []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600},
+		}),
+	}
+	reg.MustRegister(
+		m.websocketsConnected,
+		m.websocketsBackingOff,
+		m.websocketReconnects,
+		m.websocketBackoff,
+		m.envelopeQueueDepth,
+		m.queueDrainTime,
+		m.decryptionFailures,
+		m.sendLatency,
+		m.sendFailures,
+		m.preKeyCount,
+		m.cdsiRateLimits,
+		m.cdsiRateLimitLength,
+	)
+	return m
+}
+
+// WebsocketConnected is called when a chat websocket connects. The websocket label is either authed or unauthed.
+// reconnect is true if the websocket had been connected before.
+func (m *Metrics) WebsocketConnected(websocket string, reconnect bool) {
+	if m == nil {
+		return
+	}
+	m.websocketsConnected.WithLabelValues(websocket).Inc()
+	if reconnect {
+		m.websocketReconnects.WithLabelValues(websocket).Inc()
+	}
+}
+
+// WebsocketDisconnected is called when a chat websocket that was previously connected disconnects.
+func (m *Metrics) WebsocketDisconnected(websocket string) {
+	if m == nil {
+		return
+	}
+	m.websocketsConnected.WithLabelValues(websocket).Dec()
+}
+
+// WebsocketBackoff is called when a chat websocket starts waiting before retrying a connection.
+// The returned function must be called once the wait is over.
+func (m *Metrics) WebsocketBackoff(websocket string, backoff time.Duration) (done func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.websocketBackoff.WithLabelValues(websocket).Observe(backoff.Seconds())
+	gauge := m.websocketsBackingOff.WithLabelValues(websocket)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// AddQueuedEnvelopes is called with a positive delta when envelopes are received from the server,
+// and with a negative delta when they've been decrypted and handled or dropped.
+func (m *Metrics) AddQueuedEnvelopes(delta int) {
+	if m == nil {
+		return
+	}
+	m.envelopeQueueDepth.Add(float64(delta))
+}
+
+// QueueDrained is called when the server reports that the message queue is empty.
+func (m *Metrics) QueueDrained(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queueDrainTime.Observe(duration.Seconds())
+}
+
+// DecryptionFailed is called when decrypting an envelope fails. The envelope type is the name of the Envelope.Type enum value.
+func (m *Metrics) DecryptionFailed(envelopeType string) {
+	if m == nil {
+		return
+	}
+	m.decryptionFailures.WithLabelValues(envelopeType).Inc()
+}
+
+// MessageSent is called when the server responds to a message send request.
+func (m *Metrics) MessageSent(sealedSender bool, status int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.sendLatency.WithLabelValues(strconv.FormatBool(sealedSender)).Observe(latency.Seconds())
+	if status != 200 {
+		m.sendFailures.WithLabelValues(strconv.Itoa(status)).Inc()
+	}
+}
+
+// PreKeyCount is called with the number of prekeys on the server. The identity is either aci or pni,
+// and the kind is either ec or kyber.
+func (m *Metrics) PreKeyCount(identity, kind string, count int) {
+	if m == nil {
+		return
+	}
+	m.preKeyCount.WithLabelValues(identity, kind).Observe(float64(count))
+}
+
+// CDSIRateLimited is called when the contact discovery service rejects a request due to rate limiting.
+func (m *Metrics) CDSIRateLimited(retryAfter time.Duration) {
+	if m == nil {
+		return
+	}
+	m.cdsiRateLimits.Inc()
+	m.cdsiRateLimitLength.Observe(retryAfter.Seconds())
+}
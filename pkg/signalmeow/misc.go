@@ -17,10 +17,7 @@
 package signalmeow
 
 import (
-	_ "embed"
-
 	"github.com/rs/zerolog"
-	"go.mau.fi/util/exerrors"
 
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 )
@@ -68,11 +65,3 @@ func (FFILogger) Flush() {}
 
 // Ensure FFILogger implements the Logger interface
 var _ libsignalgo.Logger = FFILogger{}
-
-//go:embed prod-server-public-params.dat
-var prodServerPublicParamsSlice []byte
-var prodServerPublicParams *libsignalgo.ServerPublicParams
-
-func init() {
-	prodServerPublicParams = exerrors.Must(libsignalgo.DeserializeServerPublicParams(prodServerPublicParamsSlice))
-}
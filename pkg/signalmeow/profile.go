@@ -88,7 +88,7 @@ func (cli *Client) ProfileKeyCredentialRequest(ctx context.Context, signalACI uu
 		return nil, fmt.Errorf("error getting profile key for ACI: %w", err)
 	}
 	requestContext, err := libsignalgo.CreateProfileKeyCredentialRequestContext(
-		prodServerPublicParams,
+		cli.env().PublicParams(),
 		signalACI,
 		*profileKey,
 	)
@@ -218,38 +218,45 @@ func (cli *Client) fetchProfileWithRequestAndKey(ctx context.Context, signalID u
 		path += "/" + string(credentialRequest)
 		path += "?credentialType=expiringProfileKey"
 	}
-	profileRequest := web.CreateWSRequest(http.MethodGet, path, nil, nil, nil)
+	opts := &web.HTTPReqOpt{}
 	if useUnidentified {
-		profileRequest.Headers = append(profileRequest.Headers, "unidentified-access-key:"+base64AccessKey)
-		profileRequest.Headers = append(profileRequest.Headers, "accept-language:en-CA")
+		opts.Headers = map[string]string{
+			"unidentified-access-key": base64AccessKey,
+			"accept-language":         "en-CA",
+		}
 	}
-	resp, err := cli.UnauthedWS.SendRequest(ctx, profileRequest)
+	resp, err := cli.sendRequest(ctx, http.MethodGet, path, opts)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request: %w", err)
 	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
 	var profile types.Profile
 	profile.FetchedAt = time.Now()
-	logEvt := log.Trace().Uint32("status_code", resp.GetStatus())
+	logEvt := log.Trace().Int("status_code", resp.StatusCode)
 	if logEvt.Enabled() {
-		if json.Valid(resp.Body) {
-			logEvt.RawJSON("response_data", resp.Body)
+		if json.Valid(body) {
+			logEvt.RawJSON("response_data", body)
 		} else {
-			logEvt.Str("invalid_response_data", base64.StdEncoding.EncodeToString(resp.Body))
+			logEvt.Str("invalid_response_data", base64.StdEncoding.EncodeToString(body))
 		}
 	}
 	logEvt.Msg("Got profile response")
-	if *resp.Status < 200 || *resp.Status >= 300 {
-		if *resp.Status == 401 {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if resp.StatusCode == 401 {
 			return nil, ErrProfileUnauthorized
-		} else if *resp.Status == 404 {
+		} else if resp.StatusCode == 404 {
 			return nil, ErrProfileNotFound
-		} else if *resp.Status >= 500 {
-			return nil, fmt.Errorf("%w %d", ErrProfileInternalError, *resp.Status)
+		} else if resp.StatusCode >= 500 {
+			return nil, fmt.Errorf("%w %d", ErrProfileInternalError, resp.StatusCode)
 		}
-		return nil, fmt.Errorf("unexpected status code %d", *resp.Status)
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 	}
 	var profileResponse ProfileResponse
-	err = json.Unmarshal(resp.Body, &profileResponse)
+	err = json.Unmarshal(body, &profileResponse)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshalling profile response: %w", err)
 	}
@@ -288,11 +295,11 @@ func (cli *Client) fetchProfileWithRequestAndKey(ctx context.Context, signalID u
 func (cli *Client) DownloadUserAvatar(ctx context.Context, avatarPath string, profileKey libsignalgo.ProfileKey) ([]byte, error) {
 	username, password := cli.Store.BasicAuthCreds()
 	opts := &web.HTTPReqOpt{
-		Host:     web.CDN1Hostname,
+		Host:     cli.env().CDNHost(0),
 		Username: &username,
 		Password: &password,
 	}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, avatarPath, opts)
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodGet, avatarPath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -311,6 +318,77 @@ func (cli *Client) DownloadUserAvatar(ctx context.Context, avatarPath string, pr
 	return avatar, nil
 }
 
+// Profile names are padded to one of these lengths before encryption, so that the length of the name isn't revealed.
+var profileNamePaddedLengths = []int{53, 257}
+
+// SetProfileName uploads a new version of the user's own profile with the given name.
+// The given and family names are stored separately, but Signal clients display them joined with a space.
+//
+// The avatar of the existing profile is kept. Other profile fields like the about text are cleared.
+func (cli *Client) SetProfileName(ctx context.Context, givenName, familyName string) error {
+	profileKey, err := cli.Store.RecipientStore.MyProfileKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get own profile key: %w", err)
+	} else if profileKey == nil {
+		return errProfileKeyNotFound
+	}
+	version, err := profileKey.GetProfileKeyVersion(cli.Store.ACI)
+	if err != nil {
+		return fmt.Errorf("failed to get profile key version: %w", err)
+	}
+	commitment, err := profileKey.GetCommitment(cli.Store.ACI)
+	if err != nil {
+		return fmt.Errorf("failed to get profile key commitment: %w", err)
+	}
+	name := givenName
+	if familyName != "" {
+		name += "\x00" + familyName
+	}
+	var encryptedName []byte
+	for _, length := range profileNamePaddedLengths {
+		if len(name) <= length {
+			encryptedName, err = encryptString(*profileKey, name, length)
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encrypt profile name: %w", err)
+	} else if encryptedName == nil {
+		return fmt.Errorf("profile name is too long")
+	}
+	reqData, err := json.Marshal(map[string]any{
+		"version":    version.String(),
+		"name":       encryptedName,
+		"commitment": commitment[:],
+		"avatar":     false,
+		"sameAvatar": true,
+		"badgeIds":   []string{},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal profile update request: %w", err)
+	}
+	username, password := cli.Store.BasicAuthCreds()
+	resp, err := cli.sendRequest(ctx, http.MethodPut, "/v1/profile", &web.HTTPReqOpt{
+		Body:        reqData,
+		ContentType: web.ContentTypeJSON,
+		Username:    &username,
+		Password:    &password,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send profile update request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("profile update request returned status %d", resp.StatusCode)
+	}
+	if cli.ProfileCache != nil {
+		cli.ProfileCache.lock.Lock()
+		delete(cli.ProfileCache.lastFetched, cli.Store.ACI.String())
+		cli.ProfileCache.lock.Unlock()
+	}
+	return nil
+}
+
 func decryptBytes(key []byte, encryptedText []byte) ([]byte, error) {
 	if len(encryptedText) < NONCE_LENGTH+16+1 {
 		return nil, errors.New("invalid encryptedBytes length")
@@ -386,7 +464,7 @@ func (cli *Client) FetchExpiringProfileKeyCredentialById(ctx context.Context, si
 		return nil, errProfileKeyNotFound
 	}
 	requestContext, err := libsignalgo.CreateProfileKeyCredentialRequestContext(
-		prodServerPublicParams,
+		cli.env().PublicParams(),
 		signalACI,
 		*profileKey,
 	)
@@ -411,7 +489,7 @@ func (cli *Client) FetchExpiringProfileKeyCredentialById(ctx context.Context, si
 	if err != nil {
 		return nil, fmt.Errorf("failed to get expiring profile key credential response: %w", err)
 	}
-	epkc, err := libsignalgo.ReceiveExpiringProfileKeyCredential(prodServerPublicParams, requestContext, response, uint64(time.Now().Unix()))
+	epkc, err := libsignalgo.ReceiveExpiringProfileKeyCredential(cli.env().PublicParams(), requestContext, response, uint64(time.Now().Unix()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to receive expiring profile key credential: %w", err)
 	}
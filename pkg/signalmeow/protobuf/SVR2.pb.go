@@ -0,0 +1,940 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v3.21.12
+// source: SVR2.proto
+
+// Copyright 2023 Signal Messenger, LLC
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package signalpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SVR2BackupResponse_Status int32
+
+const (
+	SVR2BackupResponse_UNSET           SVR2BackupResponse_Status = 0
+	SVR2BackupResponse_OK              SVR2BackupResponse_Status = 1
+	SVR2BackupResponse_REQUEST_INVALID SVR2BackupResponse_Status = 2
+)
+
+// Enum value maps for SVR2BackupResponse_Status.
+var (
+	SVR2BackupResponse_Status_name = map[int32]string{
+		0: "UNSET",
+		1: "OK",
+		2: "REQUEST_INVALID",
+	}
+	SVR2BackupResponse_Status_value = map[string]int32{
+		"UNSET":           0,
+		"OK":              1,
+		"REQUEST_INVALID": 2,
+	}
+)
+
+func (x SVR2BackupResponse_Status) Enum() *SVR2BackupResponse_Status {
+	p := new(SVR2BackupResponse_Status)
+	*p = x
+	return p
+}
+
+func (x SVR2BackupResponse_Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SVR2BackupResponse_Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_SVR2_proto_enumTypes[0].Descriptor()
+}
+
+func (SVR2BackupResponse_Status) Type() protoreflect.EnumType {
+	return &file_SVR2_proto_enumTypes[0]
+}
+
+func (x SVR2BackupResponse_Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *SVR2BackupResponse_Status) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
+	if err != nil {
+		return err
+	}
+	*x = SVR2BackupResponse_Status(num)
+	return nil
+}
+
+// Deprecated: Use SVR2BackupResponse_Status.Descriptor instead.
+func (SVR2BackupResponse_Status) EnumDescriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{3, 0}
+}
+
+type SVR2RestoreResponse_Status int32
+
+const (
+	SVR2RestoreResponse_UNSET           SVR2RestoreResponse_Status = 0
+	SVR2RestoreResponse_OK              SVR2RestoreResponse_Status = 1
+	SVR2RestoreResponse_MISSING         SVR2RestoreResponse_Status = 2
+	SVR2RestoreResponse_PIN_MISMATCH    SVR2RestoreResponse_Status = 3
+	SVR2RestoreResponse_REQUEST_INVALID SVR2RestoreResponse_Status = 4
+)
+
+// Enum value maps for SVR2RestoreResponse_Status.
+var (
+	SVR2RestoreResponse_Status_name = map[int32]string{
+		0: "UNSET",
+		1: "OK",
+		2: "MISSING",
+		3: "PIN_MISMATCH",
+		4: "REQUEST_INVALID",
+	}
+	SVR2RestoreResponse_Status_value = map[string]int32{
+		"UNSET":           0,
+		"OK":              1,
+		"MISSING":         2,
+		"PIN_MISMATCH":    3,
+		"REQUEST_INVALID": 4,
+	}
+)
+
+func (x SVR2RestoreResponse_Status) Enum() *SVR2RestoreResponse_Status {
+	p := new(SVR2RestoreResponse_Status)
+	*p = x
+	return p
+}
+
+func (x SVR2RestoreResponse_Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SVR2RestoreResponse_Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_SVR2_proto_enumTypes[1].Descriptor()
+}
+
+func (SVR2RestoreResponse_Status) Type() protoreflect.EnumType {
+	return &file_SVR2_proto_enumTypes[1]
+}
+
+func (x SVR2RestoreResponse_Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *SVR2RestoreResponse_Status) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
+	if err != nil {
+		return err
+	}
+	*x = SVR2RestoreResponse_Status(num)
+	return nil
+}
+
+// Deprecated: Use SVR2RestoreResponse_Status.Descriptor instead.
+func (SVR2RestoreResponse_Status) EnumDescriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{5, 0}
+}
+
+type SVR2ExposeResponse_Status int32
+
+const (
+	SVR2ExposeResponse_UNSET SVR2ExposeResponse_Status = 0
+	SVR2ExposeResponse_OK    SVR2ExposeResponse_Status = 1
+	SVR2ExposeResponse_ERROR SVR2ExposeResponse_Status = 2
+)
+
+// Enum value maps for SVR2ExposeResponse_Status.
+var (
+	SVR2ExposeResponse_Status_name = map[int32]string{
+		0: "UNSET",
+		1: "OK",
+		2: "ERROR",
+	}
+	SVR2ExposeResponse_Status_value = map[string]int32{
+		"UNSET": 0,
+		"OK":    1,
+		"ERROR": 2,
+	}
+)
+
+func (x SVR2ExposeResponse_Status) Enum() *SVR2ExposeResponse_Status {
+	p := new(SVR2ExposeResponse_Status)
+	*p = x
+	return p
+}
+
+func (x SVR2ExposeResponse_Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SVR2ExposeResponse_Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_SVR2_proto_enumTypes[2].Descriptor()
+}
+
+func (SVR2ExposeResponse_Status) Type() protoreflect.EnumType {
+	return &file_SVR2_proto_enumTypes[2]
+}
+
+func (x SVR2ExposeResponse_Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *SVR2ExposeResponse_Status) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
+	if err != nil {
+		return err
+	}
+	*x = SVR2ExposeResponse_Status(num)
+	return nil
+}
+
+// Deprecated: Use SVR2ExposeResponse_Status.Descriptor instead.
+func (SVR2ExposeResponse_Status) EnumDescriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{9, 0}
+}
+
+type SVR2Request struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Inner:
+	//
+	//	*SVR2Request_Backup
+	//	*SVR2Request_Expose
+	//	*SVR2Request_Restore
+	//	*SVR2Request_Delete
+	Inner         isSVR2Request_Inner `protobuf_oneof:"inner"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2Request) Reset() {
+	*x = SVR2Request{}
+	mi := &file_SVR2_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2Request) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2Request) ProtoMessage() {}
+
+func (x *SVR2Request) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2Request.ProtoReflect.Descriptor instead.
+func (*SVR2Request) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SVR2Request) GetInner() isSVR2Request_Inner {
+	if x != nil {
+		return x.Inner
+	}
+	return nil
+}
+
+func (x *SVR2Request) GetBackup() *SVR2BackupRequest {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Request_Backup); ok {
+			return x.Backup
+		}
+	}
+	return nil
+}
+
+func (x *SVR2Request) GetExpose() *SVR2ExposeRequest {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Request_Expose); ok {
+			return x.Expose
+		}
+	}
+	return nil
+}
+
+func (x *SVR2Request) GetRestore() *SVR2RestoreRequest {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Request_Restore); ok {
+			return x.Restore
+		}
+	}
+	return nil
+}
+
+func (x *SVR2Request) GetDelete() *SVR2DeleteRequest {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Request_Delete); ok {
+			return x.Delete
+		}
+	}
+	return nil
+}
+
+type isSVR2Request_Inner interface {
+	isSVR2Request_Inner()
+}
+
+type SVR2Request_Backup struct {
+	Backup *SVR2BackupRequest `protobuf:"bytes,2,opt,name=backup,oneof"`
+}
+
+type SVR2Request_Expose struct {
+	Expose *SVR2ExposeRequest `protobuf:"bytes,5,opt,name=expose,oneof"`
+}
+
+type SVR2Request_Restore struct {
+	Restore *SVR2RestoreRequest `protobuf:"bytes,3,opt,name=restore,oneof"`
+}
+
+type SVR2Request_Delete struct {
+	Delete *SVR2DeleteRequest `protobuf:"bytes,4,opt,name=delete,oneof"`
+}
+
+func (*SVR2Request_Backup) isSVR2Request_Inner() {}
+
+func (*SVR2Request_Expose) isSVR2Request_Inner() {}
+
+func (*SVR2Request_Restore) isSVR2Request_Inner() {}
+
+func (*SVR2Request_Delete) isSVR2Request_Inner() {}
+
+type SVR2Response struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Inner:
+	//
+	//	*SVR2Response_Backup
+	//	*SVR2Response_Restore
+	//	*SVR2Response_Delete
+	//	*SVR2Response_Expose
+	Inner         isSVR2Response_Inner `protobuf_oneof:"inner"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2Response) Reset() {
+	*x = SVR2Response{}
+	mi := &file_SVR2_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2Response) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2Response) ProtoMessage() {}
+
+func (x *SVR2Response) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2Response.ProtoReflect.Descriptor instead.
+func (*SVR2Response) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SVR2Response) GetInner() isSVR2Response_Inner {
+	if x != nil {
+		return x.Inner
+	}
+	return nil
+}
+
+func (x *SVR2Response) GetBackup() *SVR2BackupResponse {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Response_Backup); ok {
+			return x.Backup
+		}
+	}
+	return nil
+}
+
+func (x *SVR2Response) GetRestore() *SVR2RestoreResponse {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Response_Restore); ok {
+			return x.Restore
+		}
+	}
+	return nil
+}
+
+func (x *SVR2Response) GetDelete() *SVR2DeleteResponse {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Response_Delete); ok {
+			return x.Delete
+		}
+	}
+	return nil
+}
+
+func (x *SVR2Response) GetExpose() *SVR2ExposeResponse {
+	if x != nil {
+		if x, ok := x.Inner.(*SVR2Response_Expose); ok {
+			return x.Expose
+		}
+	}
+	return nil
+}
+
+type isSVR2Response_Inner interface {
+	isSVR2Response_Inner()
+}
+
+type SVR2Response_Backup struct {
+	Backup *SVR2BackupResponse `protobuf:"bytes,1,opt,name=backup,oneof"`
+}
+
+type SVR2Response_Restore struct {
+	Restore *SVR2RestoreResponse `protobuf:"bytes,2,opt,name=restore,oneof"`
+}
+
+type SVR2Response_Delete struct {
+	Delete *SVR2DeleteResponse `protobuf:"bytes,3,opt,name=delete,oneof"`
+}
+
+type SVR2Response_Expose struct {
+	Expose *SVR2ExposeResponse `protobuf:"bytes,4,opt,name=expose,oneof"`
+}
+
+func (*SVR2Response_Backup) isSVR2Response_Inner() {}
+
+func (*SVR2Response_Restore) isSVR2Response_Inner() {}
+
+func (*SVR2Response_Delete) isSVR2Response_Inner() {}
+
+func (*SVR2Response_Expose) isSVR2Response_Inner() {}
+
+type SVR2BackupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data" json:"data,omitempty"`
+	Pin           []byte                 `protobuf:"bytes,2,opt,name=pin" json:"pin,omitempty"`
+	MaxTries      *uint32                `protobuf:"varint,3,opt,name=max_tries,json=maxTries" json:"max_tries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2BackupRequest) Reset() {
+	*x = SVR2BackupRequest{}
+	mi := &file_SVR2_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2BackupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2BackupRequest) ProtoMessage() {}
+
+func (x *SVR2BackupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2BackupRequest.ProtoReflect.Descriptor instead.
+func (*SVR2BackupRequest) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SVR2BackupRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *SVR2BackupRequest) GetPin() []byte {
+	if x != nil {
+		return x.Pin
+	}
+	return nil
+}
+
+func (x *SVR2BackupRequest) GetMaxTries() uint32 {
+	if x != nil && x.MaxTries != nil {
+		return *x.MaxTries
+	}
+	return 0
+}
+
+type SVR2BackupResponse struct {
+	state         protoimpl.MessageState     `protogen:"open.v1"`
+	Status        *SVR2BackupResponse_Status `protobuf:"varint,1,opt,name=status,enum=signalservice.SVR2BackupResponse_Status" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2BackupResponse) Reset() {
+	*x = SVR2BackupResponse{}
+	mi := &file_SVR2_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2BackupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2BackupResponse) ProtoMessage() {}
+
+func (x *SVR2BackupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2BackupResponse.ProtoReflect.Descriptor instead.
+func (*SVR2BackupResponse) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SVR2BackupResponse) GetStatus() SVR2BackupResponse_Status {
+	if x != nil && x.Status != nil {
+		return *x.Status
+	}
+	return SVR2BackupResponse_UNSET
+}
+
+type SVR2RestoreRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           []byte                 `protobuf:"bytes,1,opt,name=pin" json:"pin,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2RestoreRequest) Reset() {
+	*x = SVR2RestoreRequest{}
+	mi := &file_SVR2_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2RestoreRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2RestoreRequest) ProtoMessage() {}
+
+func (x *SVR2RestoreRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2RestoreRequest.ProtoReflect.Descriptor instead.
+func (*SVR2RestoreRequest) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SVR2RestoreRequest) GetPin() []byte {
+	if x != nil {
+		return x.Pin
+	}
+	return nil
+}
+
+type SVR2RestoreResponse struct {
+	state         protoimpl.MessageState      `protogen:"open.v1"`
+	Status        *SVR2RestoreResponse_Status `protobuf:"varint,1,opt,name=status,enum=signalservice.SVR2RestoreResponse_Status" json:"status,omitempty"`
+	Data          []byte                      `protobuf:"bytes,2,opt,name=data" json:"data,omitempty"`
+	Tries         *uint32                     `protobuf:"varint,3,opt,name=tries" json:"tries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2RestoreResponse) Reset() {
+	*x = SVR2RestoreResponse{}
+	mi := &file_SVR2_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2RestoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2RestoreResponse) ProtoMessage() {}
+
+func (x *SVR2RestoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2RestoreResponse.ProtoReflect.Descriptor instead.
+func (*SVR2RestoreResponse) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SVR2RestoreResponse) GetStatus() SVR2RestoreResponse_Status {
+	if x != nil && x.Status != nil {
+		return *x.Status
+	}
+	return SVR2RestoreResponse_UNSET
+}
+
+func (x *SVR2RestoreResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *SVR2RestoreResponse) GetTries() uint32 {
+	if x != nil && x.Tries != nil {
+		return *x.Tries
+	}
+	return 0
+}
+
+type SVR2DeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2DeleteRequest) Reset() {
+	*x = SVR2DeleteRequest{}
+	mi := &file_SVR2_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2DeleteRequest) ProtoMessage() {}
+
+func (x *SVR2DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2DeleteRequest.ProtoReflect.Descriptor instead.
+func (*SVR2DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{6}
+}
+
+type SVR2DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2DeleteResponse) Reset() {
+	*x = SVR2DeleteResponse{}
+	mi := &file_SVR2_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2DeleteResponse) ProtoMessage() {}
+
+func (x *SVR2DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2DeleteResponse.ProtoReflect.Descriptor instead.
+func (*SVR2DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{7}
+}
+
+type SVR2ExposeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2ExposeRequest) Reset() {
+	*x = SVR2ExposeRequest{}
+	mi := &file_SVR2_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2ExposeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2ExposeRequest) ProtoMessage() {}
+
+func (x *SVR2ExposeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2ExposeRequest.ProtoReflect.Descriptor instead.
+func (*SVR2ExposeRequest) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SVR2ExposeRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type SVR2ExposeResponse struct {
+	state         protoimpl.MessageState     `protogen:"open.v1"`
+	Status        *SVR2ExposeResponse_Status `protobuf:"varint,1,opt,name=status,enum=signalservice.SVR2ExposeResponse_Status" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SVR2ExposeResponse) Reset() {
+	*x = SVR2ExposeResponse{}
+	mi := &file_SVR2_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SVR2ExposeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SVR2ExposeResponse) ProtoMessage() {}
+
+func (x *SVR2ExposeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_SVR2_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SVR2ExposeResponse.ProtoReflect.Descriptor instead.
+func (*SVR2ExposeResponse) Descriptor() ([]byte, []int) {
+	return file_SVR2_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SVR2ExposeResponse) GetStatus() SVR2ExposeResponse_Status {
+	if x != nil && x.Status != nil {
+		return *x.Status
+	}
+	return SVR2ExposeResponse_UNSET
+}
+
+var File_SVR2_proto protoreflect.FileDescriptor
+
+const file_SVR2_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"SVR2.proto\x12\rsignalservice\"\x89\x02\n" +
+	"\vSVR2Request\x12:\n" +
+	"\x06backup\x18\x02 \x01(\v2 .signalservice.SVR2BackupRequestH\x00R\x06backup\x12:\n" +
+	"\x06expose\x18\x05 \x01(\v2 .signalservice.SVR2ExposeRequestH\x00R\x06expose\x12=\n" +
+	"\arestore\x18\x03 \x01(\v2!.signalservice.SVR2RestoreRequestH\x00R\arestore\x12:\n" +
+	"\x06delete\x18\x04 \x01(\v2 .signalservice.SVR2DeleteRequestH\x00R\x06deleteB\a\n" +
+	"\x05inner\"\x8e\x02\n" +
+	"\fSVR2Response\x12;\n" +
+	"\x06backup\x18\x01 \x01(\v2!.signalservice.SVR2BackupResponseH\x00R\x06backup\x12>\n" +
+	"\arestore\x18\x02 \x01(\v2\".signalservice.SVR2RestoreResponseH\x00R\arestore\x12;\n" +
+	"\x06delete\x18\x03 \x01(\v2!.signalservice.SVR2DeleteResponseH\x00R\x06delete\x12;\n" +
+	"\x06expose\x18\x04 \x01(\v2!.signalservice.SVR2ExposeResponseH\x00R\x06exposeB\a\n" +
+	"\x05inner\"V\n" +
+	"\x11SVR2BackupRequest\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x10\n" +
+	"\x03pin\x18\x02 \x01(\fR\x03pin\x12\x1b\n" +
+	"\tmax_tries\x18\x03 \x01(\rR\bmaxTries\"\x88\x01\n" +
+	"\x12SVR2BackupResponse\x12@\n" +
+	"\x06status\x18\x01 \x01(\x0e2(.signalservice.SVR2BackupResponse.StatusR\x06status\"0\n" +
+	"\x06Status\x12\t\n" +
+	"\x05UNSET\x10\x00\x12\x06\n" +
+	"\x02OK\x10\x01\x12\x13\n" +
+	"\x0fREQUEST_INVALID\x10\x02\"&\n" +
+	"\x12SVR2RestoreRequest\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\fR\x03pin\"\xd3\x01\n" +
+	"\x13SVR2RestoreResponse\x12A\n" +
+	"\x06status\x18\x01 \x01(\x0e2).signalservice.SVR2RestoreResponse.StatusR\x06status\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x14\n" +
+	"\x05tries\x18\x03 \x01(\rR\x05tries\"O\n" +
+	"\x06Status\x12\t\n" +
+	"\x05UNSET\x10\x00\x12\x06\n" +
+	"\x02OK\x10\x01\x12\v\n" +
+	"\aMISSING\x10\x02\x12\x10\n" +
+	"\fPIN_MISMATCH\x10\x03\x12\x13\n" +
+	"\x0fREQUEST_INVALID\x10\x04\"\x13\n" +
+	"\x11SVR2DeleteRequest\"\x14\n" +
+	"\x12SVR2DeleteResponse\"'\n" +
+	"\x11SVR2ExposeRequest\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"~\n" +
+	"\x12SVR2ExposeResponse\x12@\n" +
+	"\x06status\x18\x01 \x01(\x0e2(.signalservice.SVR2ExposeResponse.StatusR\x06status\"&\n" +
+	"\x06Status\x12\t\n" +
+	"\x05UNSET\x10\x00\x12\x06\n" +
+	"\x02OK\x10\x01\x12\t\n" +
+	"\x05ERROR\x10\x02"
+
+var (
+	file_SVR2_proto_rawDescOnce sync.Once
+	file_SVR2_proto_rawDescData []byte
+)
+
+func file_SVR2_proto_rawDescGZIP() []byte {
+	file_SVR2_proto_rawDescOnce.Do(func() {
+		file_SVR2_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_SVR2_proto_rawDesc), len(file_SVR2_proto_rawDesc)))
+	})
+	return file_SVR2_proto_rawDescData
+}
+
+var file_SVR2_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_SVR2_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_SVR2_proto_goTypes = []any{
+	(SVR2BackupResponse_Status)(0),  // 0: signalservice.SVR2BackupResponse.Status
+	(SVR2RestoreResponse_Status)(0), // 1: signalservice.SVR2RestoreResponse.Status
+	(SVR2ExposeResponse_Status)(0),  // 2: signalservice.SVR2ExposeResponse.Status
+	(*SVR2Request)(nil),             // 3: signalservice.SVR2Request
+	(*SVR2Response)(nil),            // 4: signalservice.SVR2Response
+	(*SVR2BackupRequest)(nil),       // 5: signalservice.SVR2BackupRequest
+	(*SVR2BackupResponse)(nil),      // 6: signalservice.SVR2BackupResponse
+	(*SVR2RestoreRequest)(nil),      // 7: signalservice.SVR2RestoreRequest
+	(*SVR2RestoreResponse)(nil),     // 8: signalservice.SVR2RestoreResponse
+	(*SVR2DeleteRequest)(nil),       // 9: signalservice.SVR2DeleteRequest
+	(*SVR2DeleteResponse)(nil),      // 10: signalservice.SVR2DeleteResponse
+	(*SVR2ExposeRequest)(nil),       // 11: signalservice.SVR2ExposeRequest
+	(*SVR2ExposeResponse)(nil),      // 12: signalservice.SVR2ExposeResponse
+}
+var file_SVR2_proto_depIdxs = []int32{
+	5,  // 0: signalservice.SVR2Request.backup:type_name -> signalservice.SVR2BackupRequest
+	11, // 1: signalservice.SVR2Request.expose:type_name -> signalservice.SVR2ExposeRequest
+	7,  // 2: signalservice.SVR2Request.restore:type_name -> signalservice.SVR2RestoreRequest
+	9,  // 3: signalservice.SVR2Request.delete:type_name -> signalservice.SVR2DeleteRequest
+	6,  // 4: signalservice.SVR2Response.backup:type_name -> signalservice.SVR2BackupResponse
+	8,  // 5: signalservice.SVR2Response.restore:type_name -> signalservice.SVR2RestoreResponse
+	10, // 6: signalservice.SVR2Response.delete:type_name -> signalservice.SVR2DeleteResponse
+	12, // 7: signalservice.SVR2Response.expose:type_name -> signalservice.SVR2ExposeResponse
+	0,  // 8: signalservice.SVR2BackupResponse.status:type_name -> signalservice.SVR2BackupResponse.Status
+	1,  // 9: signalservice.SVR2RestoreResponse.status:type_name -> signalservice.SVR2RestoreResponse.Status
+	2,  // 10: signalservice.SVR2ExposeResponse.status:type_name -> signalservice.SVR2ExposeResponse.Status
+	11, // [11:11] is the sub-list for method output_type
+	11, // [11:11] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_SVR2_proto_init() }
+func file_SVR2_proto_init() {
+	if File_SVR2_proto != nil {
+		return
+	}
+	file_SVR2_proto_msgTypes[0].OneofWrappers = []any{
+		(*SVR2Request_Backup)(nil),
+		(*SVR2Request_Expose)(nil),
+		(*SVR2Request_Restore)(nil),
+		(*SVR2Request_Delete)(nil),
+	}
+	file_SVR2_proto_msgTypes[1].OneofWrappers = []any{
+		(*SVR2Response_Backup)(nil),
+		(*SVR2Response_Restore)(nil),
+		(*SVR2Response_Delete)(nil),
+		(*SVR2Response_Expose)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_SVR2_proto_rawDesc), len(file_SVR2_proto_rawDesc)),
+			NumEnums:      3,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_SVR2_proto_goTypes,
+		DependencyIndexes: file_SVR2_proto_depIdxs,
+		EnumInfos:         file_SVR2_proto_enumTypes,
+		MessageInfos:      file_SVR2_proto_msgTypes,
+	}.Build()
+	File_SVR2_proto = out.File
+	file_SVR2_proto_goTypes = nil
+	file_SVR2_proto_depIdxs = nil
+}
@@ -3,6 +3,8 @@ set -euo pipefail
 
 ANDROID_GIT_REVISION=${1:-23669c3c372284d42db486a218d9f29bef247abf}
 DESKTOP_GIT_REVISION=${1:-010c38ae9bce84c676a9c464a04f7c26e7a2c9e0}
+# Should match the libsignal version in ../../libsignalgo/version.go
+LIBSIGNAL_GIT_REVISION=v0.74.1
 
 update_proto() {
   case "$1" in
@@ -21,6 +23,11 @@ update_proto() {
       prefix="protos/"
       GIT_REVISION=$DESKTOP_GIT_REVISION
       ;;
+    libsignal)
+      REPO="libsignal"
+      prefix="rust/attest/src/proto/"
+      GIT_REVISION=$LIBSIGNAL_GIT_REVISION
+      ;;
   esac
   echo https://raw.githubusercontent.com/signalapp/${REPO}/${GIT_REVISION}/${prefix}${2}
   curl -LOf https://raw.githubusercontent.com/signalapp/${REPO}/${GIT_REVISION}/${prefix}${2}
@@ -41,3 +48,9 @@ update_proto Signal-Desktop DeviceName.proto
 update_proto Signal-Desktop UnidentifiedDelivery.proto
 # Android has CDSI.proto too, but the types have more generic names (since android uses a different package name)
 update_proto Signal-Desktop ContactDiscovery.proto
+
+# SVR2.proto is adapted from libsignal's svr2.proto (messages are renamed and moved to the signalservice package),
+# so the upstream file is downloaded next to it and changes have to be merged manually.
+update_proto libsignal svr2.proto
+mv svr2.proto SVR2.upstream.proto
+echo "Merge changes from SVR2.upstream.proto into SVR2.proto and delete it"
@@ -90,7 +90,7 @@ func PerformProvisioning(ctx context.Context, deviceStore store.DeviceStore, dev
 		defer cancel()
 		ws, resp, err := web.OpenWebsocket(timeoutCtx, (&url.URL{
 			Scheme: "wss",
-			Host:   web.APIHostname,
+			Host:   web.Env(ctx).ChatHost,
 			Path:   web.WebsocketProvisioningPath,
 		}).String())
 		if err != nil {
@@ -189,71 +189,24 @@ func PerformProvisioning(ctx context.Context, deviceStore store.DeviceStore, dev
 			}
 		}
 
-		// Store the provisioning data
-		err = deviceStore.PutDevice(ctx, data)
+		device, err := storeNewDevice(ctx, deviceStore, data, &newDeviceKeys{
+			ACISignedPreKey:       aciSignedPreKey,
+			PNISignedPreKey:       pniSignedPreKey,
+			ACIPQLastResortPreKey: aciPQLastResortPreKey,
+			PNIPQLastResortPreKey: pniPQLastResortPreKey,
+		}, profileKey)
 		if err != nil {
-			log.Err(err).Msg("error storing new device")
+			log.Err(err).Msg("Failed to store new device")
 			c <- ProvisioningResponse{State: StateProvisioningError, Err: err}
 			return
 		}
 
-		device, err := deviceStore.DeviceByACI(ctx, data.ACI)
-		if err != nil {
-			log.Err(err).Msg("error retrieving new device")
-			c <- ProvisioningResponse{State: StateProvisioningError, Err: err}
-			return
-		}
-
-		// In case this is an existing device, we gotta clear out keys
-		device.ClearDeviceKeys(ctx)
-
-		// Store identity keys?
-		_, err = device.IdentityKeyStore.SaveIdentityKey(ctx, device.ACIServiceID(), device.ACIIdentityKeyPair.GetIdentityKey())
-		if err != nil {
-			c <- ProvisioningResponse{
-				State: StateProvisioningError,
-				Err:   fmt.Errorf("error saving identity key: %w", err),
-			}
-			return
-		}
-		_, err = device.IdentityKeyStore.SaveIdentityKey(ctx, device.PNIServiceID(), device.PNIIdentityKeyPair.GetIdentityKey())
-		if err != nil {
-			c <- ProvisioningResponse{
-				State: StateProvisioningError,
-				Err:   fmt.Errorf("error saving identity key: %w", err),
-			}
-			return
-		}
-
-		// Store signed prekeys (now that we have a device)
-		device.ACIPreKeyStore.StoreSignedPreKey(ctx, 1, aciSignedPreKey)
-		device.PNIPreKeyStore.StoreSignedPreKey(ctx, 1, pniSignedPreKey)
-		device.ACIPreKeyStore.StoreLastResortKyberPreKey(ctx, 1, aciPQLastResortPreKey)
-		device.PNIPreKeyStore.StoreLastResortKyberPreKey(ctx, 1, pniPQLastResortPreKey)
-
-		// Store our profile key
-		err = device.RecipientStore.StoreRecipient(ctx, &types.Recipient{
-			ACI:  data.ACI,
-			PNI:  data.PNI,
-			E164: data.Number,
-			Profile: types.Profile{
-				Key: profileKey,
-			},
-		})
-		if err != nil {
-			c <- ProvisioningResponse{
-				State: StateProvisioningError,
-				Err:   fmt.Errorf("error storing profile key: %w", err),
-			}
-			return
-		}
-
 		// Return the provisioning data
 		c <- ProvisioningResponse{State: StateProvisioningDataReceived, ProvisioningData: data}
 
 		// Generate, store, and register prekeys
 		// TODO hacky client construction
-		cli := &Client{Store: device}
+		cli := &Client{Store: device, Environment: web.Env(ctx)}
 		err = cli.GenerateAndRegisterPreKeys(ctx, device.ACIPreKeyStore)
 		if err != nil {
 			c <- ProvisioningResponse{
@@ -276,6 +229,65 @@ func PerformProvisioning(ctx context.Context, deviceStore store.DeviceStore, dev
 	return c
 }
 
+type newDeviceKeys struct {
+	ACISignedPreKey       *libsignalgo.SignedPreKeyRecord
+	PNISignedPreKey       *libsignalgo.SignedPreKeyRecord
+	ACIPQLastResortPreKey *libsignalgo.KyberPreKeyRecord
+	PNIPQLastResortPreKey *libsignalgo.KyberPreKeyRecord
+}
+
+// storeNewDevice saves a freshly linked or registered device along with its initial keys and own profile key.
+func storeNewDevice(
+	ctx context.Context,
+	deviceStore store.DeviceStore,
+	data *store.DeviceData,
+	keys *newDeviceKeys,
+	profileKey libsignalgo.ProfileKey,
+) (*store.Device, error) {
+	err := deviceStore.PutDevice(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("error storing new device: %w", err)
+	}
+
+	device, err := deviceStore.DeviceByACI(ctx, data.ACI)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving new device: %w", err)
+	}
+
+	// In case this is an existing device, we gotta clear out keys
+	device.ClearDeviceKeys(ctx)
+
+	// Store identity keys?
+	_, err = device.IdentityKeyStore.SaveIdentityKey(ctx, device.ACIServiceID(), device.ACIIdentityKeyPair.GetIdentityKey())
+	if err != nil {
+		return nil, fmt.Errorf("error saving identity key: %w", err)
+	}
+	_, err = device.IdentityKeyStore.SaveIdentityKey(ctx, device.PNIServiceID(), device.PNIIdentityKeyPair.GetIdentityKey())
+	if err != nil {
+		return nil, fmt.Errorf("error saving identity key: %w", err)
+	}
+
+	// Store signed prekeys (now that we have a device)
+	device.ACIPreKeyStore.StoreSignedPreKey(ctx, 1, keys.ACISignedPreKey)
+	device.PNIPreKeyStore.StoreSignedPreKey(ctx, 1, keys.PNISignedPreKey)
+	device.ACIPreKeyStore.StoreLastResortKyberPreKey(ctx, 1, keys.ACIPQLastResortPreKey)
+	device.PNIPreKeyStore.StoreLastResortKyberPreKey(ctx, 1, keys.PNIPQLastResortPreKey)
+
+	// Store our profile key
+	err = device.RecipientStore.StoreRecipient(ctx, &types.Recipient{
+		ACI:  data.ACI,
+		PNI:  data.PNI,
+		E164: data.Number,
+		Profile: types.Profile{
+			Key: profileKey,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error storing profile key: %w", err)
+	}
+	return device, nil
+}
+
 // Returns the provisioningUrl and an error
 func startProvisioning(ctx context.Context, ws *websocket.Conn, provisioningCipher *ProvisioningCipher, allowBackup bool) (string, error) {
 	log := zerolog.Ctx(ctx).With().Str("action", "start provisioning").Logger()
@@ -368,7 +380,7 @@ var signalCapabilitiesBody = exerrors.Must(json.Marshal(signalCapabilities))
 
 func (cli *Client) RegisterCapabilities(ctx context.Context) error {
 	username, password := cli.Store.BasicAuthCreds()
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPut, "/v1/devices/capabilities", &web.HTTPReqOpt{
+	resp, err := cli.sendRequest(ctx, http.MethodPut, "/v1/devices/capabilities", &web.HTTPReqOpt{
 		Body:        signalCapabilitiesBody,
 		Username:    &username,
 		Password:    &password,
@@ -386,17 +398,7 @@ func (cli *Client) RegisterCapabilities(ctx context.Context) error {
 }
 
 func (cli *Client) Unlink(ctx context.Context) error {
-	username, password := cli.Store.BasicAuthCreds()
-	resp, err := web.SendHTTPRequest(ctx, http.MethodDelete, fmt.Sprintf("/v1/devices/%d", cli.Store.DeviceID), &web.HTTPReqOpt{
-		Username: &username,
-		Password: &password,
-	})
-	if err != nil {
-		return err
-	} else if resp.StatusCode >= 400 {
-		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
-	}
-	return nil
+	return cli.deleteDevice(ctx, cli.Store.DeviceID)
 }
 
 func confirmDevice(
@@ -422,7 +424,7 @@ func confirmDevice(
 
 	ws, resp, err := web.OpenWebsocket(ctx, (&url.URL{
 		Scheme: "wss",
-		Host:   web.APIHostname,
+		Host:   web.Env(ctx).ChatHost,
 		Path:   web.WebsocketPath,
 	}).String())
 	if err != nil {
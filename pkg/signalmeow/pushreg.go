@@ -53,7 +53,7 @@ func (cli *Client) registerPush(ctx context.Context, pushType string, data any)
 	} else {
 		method = http.MethodDelete
 	}
-	resp, err := web.SendHTTPRequest(ctx, method, "/v1/accounts/"+pushType, req)
+	resp, err := cli.sendRequest(ctx, method, "/v1/accounts/"+pushType, req)
 	if err != nil {
 		return err
 	} else if resp.StatusCode >= 300 || resp.StatusCode < 200 {
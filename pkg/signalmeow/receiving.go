@@ -23,13 +23,11 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
-	"google.golang.org/protobuf/proto"
 
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
@@ -88,7 +86,7 @@ func (cli *Client) startWebsocketsInternal(
 		return
 	}
 	zerolog.Ctx(ctx).Info().Msg("Unauthed websocket connecting")
-	authChan, err = cli.connectAuthedWS(loopCtx, cli.incomingRequestHandler)
+	authChan, err = cli.connectAuthedWS(loopCtx, newReceivePipeline(loopCtx, cli).handleRequest)
 	if err != nil {
 		loopCancel()
 		return
@@ -286,6 +284,14 @@ func (cli *Client) StopReceiveLoops() error {
 	return nil
 }
 
+// ForceReconnect closes both websockets and reconnects them immediately, skipping any reconnection backoff.
+// It should be called when the network has changed (e.g. a different interface or IP address), as the old
+// connections are likely dead, but it could take until the next keepalive to notice that.
+func (cli *Client) ForceReconnect() {
+	cli.AuthedWS.ForceReconnect()
+	cli.UnauthedWS.ForceReconnect()
+}
+
 func (cli *Client) LastConnectionStatus() SignalConnectionStatus {
 	return cli.lastConnectionStatus
 }
@@ -308,64 +314,6 @@ func (cli *Client) ClearKeysAndDisconnect(ctx context.Context) error {
 	return stopLoopErr
 }
 
-func (cli *Client) incomingRequestHandler(ctx context.Context, req *signalpb.WebSocketRequestMessage) (*web.SimpleResponse, error) {
-	log := zerolog.Ctx(ctx).With().
-		Str("handler", "incoming request handler").
-		Str("verb", *req.Verb).
-		Str("path", *req.Path).
-		Uint64("incoming_request_id", *req.Id).
-		Logger()
-	ctx = log.WithContext(ctx)
-	if *req.Verb == http.MethodPut && *req.Path == "/api/v1/message" {
-		return cli.incomingAPIMessageHandler(ctx, req)
-	} else if *req.Verb == http.MethodPut && *req.Path == "/api/v1/queue/empty" {
-		log.Debug().Msg("Received queue empty notice")
-		cli.handleEvent(&events.QueueEmpty{})
-	} else {
-		log.Warn().Any("req", req).Msg("Unknown websocket request message")
-	}
-	return &web.SimpleResponse{
-		Status: 200,
-	}, nil
-}
-
-func (cli *Client) incomingAPIMessageHandler(ctx context.Context, req *signalpb.WebSocketRequestMessage) (*web.SimpleResponse, error) {
-	log := *zerolog.Ctx(ctx)
-	envelope := &signalpb.Envelope{}
-	err := proto.Unmarshal(req.Body, envelope)
-	if err != nil {
-		log.Err(err).Msg("Unmarshal error")
-		return nil, err
-	}
-	log = log.With().
-		Uint64("envelope_timestamp", envelope.GetTimestamp()).
-		Uint64("server_timestamp", envelope.GetServerTimestamp()).
-		Logger()
-	ctx = log.WithContext(ctx)
-	destinationServiceID, err := libsignalgo.ServiceIDFromString(envelope.GetDestinationServiceId())
-	log.Debug().
-		Str("destination_service_id", envelope.GetDestinationServiceId()).
-		Str("source_service_id", envelope.GetSourceServiceId()).
-		Uint32("source_device_id", envelope.GetSourceDevice()).
-		Object("parsed_destination_service_id", destinationServiceID).
-		Int32("envelope_type_id", int32(envelope.GetType())).
-		Str("envelope_type", signalpb.Envelope_Type_name[int32(envelope.GetType())]).
-		Msg("Received envelope")
-
-	result := cli.decryptEnvelope(ctx, envelope)
-
-	err = cli.handleDecryptedResult(ctx, result, envelope, destinationServiceID)
-	if err != nil {
-		log.Err(err).Msg("Error handling decrypted result")
-		return nil, err
-	}
-
-	return &web.SimpleResponse{
-		Status:        200,
-		WriteCallback: cli.writeCallback,
-	}, nil
-}
-
 func (cli *Client) writeCallback(preWriteTime time.Time) {
 	ch := cli.writeCallbackCounter
 	if ch != nil {
@@ -405,6 +353,9 @@ func (cli *Client) handleDecryptedResult(
 	// result.Err is set if there was an error during decryption and we
 	// should notifiy the user that the message could not be decrypted
 	if result.Err != nil {
+		if !errors.Is(result.Err, EventAlreadyProcessed) {
+			cli.Metrics.DecryptionFailed(envelope.GetType().String())
+		}
 		logEvt := log.Err(result.Err).
 			Bool("urgent", envelope.GetUrgent()).
 			Stringer("content_hint", result.ContentHint).
@@ -557,6 +508,9 @@ func (cli *Client) handleDecryptedResult(
 			go cli.SyncStorage(ctx)
 		}
 		syncSent := content.SyncMessage.GetSent()
+		if syncSent.GetStoryMessage() != nil {
+			handlerSuccess = cli.incomingStoryMessage(ctx, syncSent.GetStoryMessage(), cli.Store.ACI, syncSent.GetTimestamp(), envelope.GetServerTimestamp()) && handlerSuccess
+		}
 		if syncSent.GetMessage() != nil || syncSent.GetEditMessage() != nil {
 			destination := syncSent.DestinationServiceId
 			var syncDestinationServiceID libsignalgo.ServiceID
@@ -598,6 +552,7 @@ func (cli *Client) handleDecryptedResult(
 				}
 				log.Debug().Int("contact_count", len(contacts)).Msg("Contacts Sync received contacts")
 				convertedContacts := make([]*types.Recipient, 0, len(contacts))
+				inboxPositions := make(map[uuid.UUID]uint32)
 				err = cli.Store.DoContactTxn(ctx, func(ctx context.Context) error {
 					for i, signalContact := range contacts {
 						if signalContact.Aci == nil || *signalContact.Aci == "" {
@@ -612,6 +567,9 @@ func (cli *Client) handleDecryptedResult(
 							return err
 						}
 						convertedContacts = append(convertedContacts, contact)
+						if signalContact.InboxPosition != nil {
+							inboxPositions[contact.ACI] = signalContact.GetInboxPosition()
+						}
 					}
 					return nil
 				})
@@ -619,11 +577,15 @@ func (cli *Client) handleDecryptedResult(
 					log.Err(err).Msg("Error storing contacts")
 				} else {
 					handlerSuccess = cli.handleEvent(&events.ContactList{
-						Contacts: convertedContacts,
+						Contacts:       convertedContacts,
+						InboxPositions: inboxPositions,
 					})
 				}
 			}
 		}
+		if len(content.SyncMessage.StickerPackOperation) > 0 {
+			handlerSuccess = cli.handleStickerPackOperations(ctx, content.SyncMessage.StickerPackOperation) && handlerSuccess
+		}
 		if content.SyncMessage.Read != nil {
 			handlerSuccess = cli.handleEvent(&events.ReadSelf{
 				Messages: content.SyncMessage.GetRead(),
@@ -647,6 +609,10 @@ func (cli *Client) handleDecryptedResult(
 		}
 	}
 
+	if content.StoryMessage != nil {
+		handlerSuccess = cli.incomingStoryMessage(ctx, content.StoryMessage, theirServiceID.UUID, envelope.GetTimestamp(), envelope.GetServerTimestamp()) && handlerSuccess
+	}
+
 	if content.TypingMessage != nil {
 		var groupID types.GroupIdentifier
 		if content.TypingMessage.GetGroupId() != nil {
@@ -44,6 +44,7 @@ type DecryptionResult struct {
 func (cli *Client) decryptEnvelope(
 	ctx context.Context,
 	envelope *signalpb.Envelope,
+	unsealed *unsealedMessage,
 ) DecryptionResult {
 	log := zerolog.Ctx(ctx)
 
@@ -55,7 +56,13 @@ func (cli *Client) decryptEnvelope(
 
 	switch *envelope.Type {
 	case signalpb.Envelope_UNIDENTIFIED_SENDER:
-		result, err := cli.decryptUnidentifiedSenderEnvelope(ctx, destinationServiceID, envelope)
+		if unsealed == nil {
+			unsealed, err = cli.unsealEnvelope(ctx, destinationServiceID, envelope)
+			if err != nil {
+				return DecryptionResult{Err: fmt.Errorf("failed to decrypt unidentified sender envelope: %w", err)}
+			}
+		}
+		result, err := cli.decryptUnsealedMessage(ctx, destinationServiceID, envelope, unsealed)
 		if err != nil {
 			result.Err = fmt.Errorf("failed to decrypt unidentified sender envelope: %w", err)
 		}
@@ -284,13 +291,26 @@ func (cli *Client) decryptSenderKeyMessage(
 	}, nil
 }
 
-func (cli *Client) decryptUnidentifiedSenderEnvelope(ctx context.Context, destinationServiceID libsignalgo.ServiceID, envelope *signalpb.Envelope) (result DecryptionResult, err error) {
+// unsealedMessage is the result of the first, stateless layer of sealed sender decryption.
+// Unsealing only needs our own identity key, so it can safely be done for many envelopes in parallel,
+// after which the inner message can be routed to the queue of the sender it came from.
+type unsealedMessage struct {
+	SenderAddress *libsignalgo.Address
+	SenderUUID    uuid.UUID
+	SenderDevice  uint32
+	SenderE164    string
+	MessageType   libsignalgo.CiphertextMessageType
+	ContentHint   signalpb.UnidentifiedSenderMessage_Message_ContentHint
+	Contents      []byte
+}
+
+func (cli *Client) unsealEnvelope(ctx context.Context, destinationServiceID libsignalgo.ServiceID, envelope *signalpb.Envelope) (*unsealedMessage, error) {
 	log := zerolog.Ctx(ctx)
 
 	if destinationServiceID != cli.Store.ACIServiceID() {
 		log.Warn().Stringer("destination_service_id", destinationServiceID).
 			Msg("Received UNIDENTIFIED_SENDER envelope for non-ACI destination")
-		return result, fmt.Errorf("received unidentified sender envelope for non-ACI destination")
+		return nil, fmt.Errorf("received unidentified sender envelope for non-ACI destination")
 	}
 	usmc, err := libsignalgo.SealedSenderDecryptToUSMC(
 		ctx,
@@ -298,76 +318,81 @@ func (cli *Client) decryptUnidentifiedSenderEnvelope(ctx context.Context, destin
 		cli.Store.ACIIdentityStore,
 	)
 	if err != nil {
-		return result, fmt.Errorf("failed to decrypt to USMC: %w", err)
+		return nil, fmt.Errorf("failed to decrypt to USMC: %w", err)
 	} else if usmc == nil {
-		return result, fmt.Errorf("decrypting to USMC returned nil")
+		return nil, fmt.Errorf("decrypting to USMC returned nil")
 	}
 
-	messageType, err := usmc.GetMessageType()
+	var msg unsealedMessage
+	msg.MessageType, err = usmc.GetMessageType()
 	if err != nil {
-		return result, fmt.Errorf("failed to get message type: %w", err)
+		return nil, fmt.Errorf("failed to get message type: %w", err)
 	}
 	senderCertificate, err := usmc.GetSenderCertificate()
 	if err != nil {
-		return result, fmt.Errorf("failed to get sender certificate: %w", err)
+		return nil, fmt.Errorf("failed to get sender certificate: %w", err)
 	}
 	contentHint, err := usmc.GetContentHint()
 	if err != nil {
-		return result, fmt.Errorf("failed to get content hint: %w", err)
+		return nil, fmt.Errorf("failed to get content hint: %w", err)
 	}
-	result.ContentHint = signalpb.UnidentifiedSenderMessage_Message_ContentHint(contentHint)
-	senderUUID, err := senderCertificate.GetSenderUUID()
+	msg.ContentHint = signalpb.UnidentifiedSenderMessage_Message_ContentHint(contentHint)
+	msg.SenderUUID, err = senderCertificate.GetSenderUUID()
 	if err != nil {
-		return result, fmt.Errorf("failed to get sender UUID: %w", err)
+		return nil, fmt.Errorf("failed to get sender UUID: %w", err)
 	}
-	senderDeviceID, err := senderCertificate.GetDeviceID()
+	msg.SenderDevice, err = senderCertificate.GetDeviceID()
 	if err != nil {
-		return result, fmt.Errorf("failed to get sender device ID: %w", err)
+		return nil, fmt.Errorf("failed to get sender device ID: %w", err)
 	}
-	senderAddress, err := libsignalgo.NewACIServiceID(senderUUID).Address(uint(senderDeviceID))
+	msg.SenderAddress, err = libsignalgo.NewACIServiceID(msg.SenderUUID).Address(uint(msg.SenderDevice))
 	if err != nil {
-		return result, fmt.Errorf("failed to create sender address: %w", err)
+		return nil, fmt.Errorf("failed to create sender address: %w", err)
 	}
-	result.SenderAddress = senderAddress
-	senderE164, err := senderCertificate.GetSenderE164()
+	msg.SenderE164, err = senderCertificate.GetSenderE164()
 	if err != nil {
-		return result, fmt.Errorf("failed to get sender E164: %w", err)
+		return nil, fmt.Errorf("failed to get sender E164: %w", err)
 	}
-	usmcContents, err := usmc.GetContents()
+	msg.Contents, err = usmc.GetContents()
 	if err != nil {
-		return result, fmt.Errorf("failed to get USMC contents: %w", err)
+		return nil, fmt.Errorf("failed to get USMC contents: %w", err)
 	}
-	newLog := log.With().
-		Stringer("sender_uuid", senderUUID).
-		Uint32("sender_device_id", senderDeviceID).
-		Str("sender_e164", senderE164).
-		Uint8("sealed_sender_type", uint8(messageType)).
+	return &msg, nil
+}
+
+func (cli *Client) decryptUnsealedMessage(ctx context.Context, destinationServiceID libsignalgo.ServiceID, envelope *signalpb.Envelope, msg *unsealedMessage) (result DecryptionResult, err error) {
+	result.ContentHint = msg.ContentHint
+	result.SenderAddress = msg.SenderAddress
+	log := zerolog.Ctx(ctx).With().
+		Stringer("sender_uuid", msg.SenderUUID).
+		Uint32("sender_device_id", msg.SenderDevice).
+		Str("sender_e164", msg.SenderE164).
+		Uint8("sealed_sender_type", uint8(msg.MessageType)).
 		Logger()
-	log = &newLog
 	ctx = log.WithContext(ctx)
 	log.Trace().Msg("Received SealedSender message")
 
-	if senderE164 != "" {
-		_, err = cli.Store.RecipientStore.UpdateRecipientE164(ctx, senderUUID, uuid.Nil, senderE164)
+	if msg.SenderE164 != "" {
+		_, err = cli.Store.RecipientStore.UpdateRecipientE164(ctx, msg.SenderUUID, uuid.Nil, msg.SenderE164)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to update sender E164 in recipient store")
 		}
 	}
 
 	var resultPtr *DecryptionResult
-	switch messageType {
+	switch msg.MessageType {
 	case libsignalgo.CiphertextMessageTypeSenderKey:
-		resultPtr, err = cli.decryptSenderKeyMessage(ctx, senderAddress, usmcContents, envelope.GetServerTimestamp())
+		resultPtr, err = cli.decryptSenderKeyMessage(ctx, msg.SenderAddress, msg.Contents, envelope.GetServerTimestamp())
 	case libsignalgo.CiphertextMessageTypePreKey:
-		resultPtr, err = cli.prekeyDecrypt(ctx, destinationServiceID, senderAddress, usmcContents, envelope.GetServerTimestamp())
+		resultPtr, err = cli.prekeyDecrypt(ctx, destinationServiceID, msg.SenderAddress, msg.Contents, envelope.GetServerTimestamp())
 	case libsignalgo.CiphertextMessageTypeWhisper:
-		resultPtr, err = cli.decryptCiphertextEnvelope(ctx, destinationServiceID, senderAddress, usmcContents, envelope.GetServerTimestamp())
+		resultPtr, err = cli.decryptCiphertextEnvelope(ctx, destinationServiceID, msg.SenderAddress, msg.Contents, envelope.GetServerTimestamp())
 	case libsignalgo.CiphertextMessageTypePlaintext:
 		// TODO: handle plaintext (usually DecryptionErrorMessage) and retries
 		//       when implementing SenderKey groups
 		return result, fmt.Errorf("unsupported plaintext sealed sender message")
 	default:
-		return result, fmt.Errorf("unsupported sealed sender message type %d", messageType)
+		return result, fmt.Errorf("unsupported sealed sender message type %d", msg.MessageType)
 	}
 	if err != nil {
 		return result, err
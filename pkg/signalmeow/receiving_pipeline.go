@@ -0,0 +1,317 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// unsealParallelism is the maximum number of sealed sender envelopes that are unsealed at the same time.
+const unsealParallelism = 8
+
+// sessionLocks is a set of mutexes keyed by service ID. They're held while encrypting to or decrypting from
+// a user to make sure the ratchets of their sessions aren't advanced by two goroutines at once.
+type sessionLocks struct {
+	lock  sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the sessions of the given service ID and returns a function that unlocks them.
+func (sl *sessionLocks) Lock(key string) (unlock func()) {
+	sl.lock.Lock()
+	if sl.locks == nil {
+		sl.locks = make(map[string]*sessionLock)
+	}
+	lock, ok := sl.locks[key]
+	if !ok {
+		lock = &sessionLock{}
+		sl.locks[key] = lock
+	}
+	lock.refs++
+	sl.lock.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		sl.lock.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(sl.locks, key)
+		}
+		sl.lock.Unlock()
+	}
+}
+
+// receivePipeline decrypts and handles incoming envelopes.
+//
+// Envelopes from the same sender are handled in the order they were received, while envelopes from different
+// senders are handled in parallel. The sender of sealed sender envelopes is only known after unsealing, so that
+// step is done in parallel for all envelopes before they're routed to the queue of the sender.
+type receivePipeline struct {
+	cli       *Client
+	ctx       context.Context
+	queue     chan *pipelineItem
+	unsealSem chan struct{}
+	inflight  sync.WaitGroup
+
+	lanesLock sync.Mutex
+	lanes     map[string][]*pipelineItem
+
+	depthLock  sync.Mutex
+	depth      int
+	closed     bool
+	drainStart time.Time
+}
+
+type pipelineItem struct {
+	ctx                  context.Context
+	envelope             *signalpb.Envelope
+	destinationServiceID libsignalgo.ServiceID
+	sender               string
+	unsealed             *unsealedMessage
+	unsealErr            error
+	received             time.Time
+	ready                chan struct{}
+	done                 chan error
+
+	// barrier is called once all items before it have been handled.
+	barrier func()
+}
+
+func newReceivePipeline(ctx context.Context, cli *Client) *receivePipeline {
+	p := &receivePipeline{
+		cli:       cli,
+		ctx:       ctx,
+		queue:     make(chan *pipelineItem, 256),
+		unsealSem: make(chan struct{}, unsealParallelism),
+		lanes:     make(map[string][]*pipelineItem),
+	}
+	go p.dispatchLoop()
+	return p
+}
+
+func (p *receivePipeline) handleRequest(ctx context.Context, req *signalpb.WebSocketRequestMessage) (*web.SimpleResponse, error) {
+	log := zerolog.Ctx(ctx).With().
+		Str("handler", "incoming request handler").
+		Str("verb", *req.Verb).
+		Str("path", *req.Path).
+		Uint64("incoming_request_id", *req.Id).
+		Logger()
+	ctx = log.WithContext(ctx)
+	if *req.Verb == http.MethodPut && *req.Path == "/api/v1/message" {
+		return p.handleAPIMessage(ctx, req)
+	} else if *req.Verb == http.MethodPut && *req.Path == "/api/v1/queue/empty" {
+		log.Debug().Msg("Received queue empty notice")
+		return &web.SimpleResponse{
+			Status: 200,
+			Done: p.enqueue(ctx, &pipelineItem{
+				barrier: func() {
+					p.cli.handleEvent(&events.QueueEmpty{})
+				},
+			}),
+		}, nil
+	} else {
+		log.Warn().Any("req", req).Msg("Unknown websocket request message")
+	}
+	return &web.SimpleResponse{
+		Status: 200,
+	}, nil
+}
+
+func (p *receivePipeline) handleAPIMessage(ctx context.Context, req *signalpb.WebSocketRequestMessage) (*web.SimpleResponse, error) {
+	log := *zerolog.Ctx(ctx)
+	envelope := &signalpb.Envelope{}
+	err := proto.Unmarshal(req.Body, envelope)
+	if err != nil {
+		log.Err(err).Msg("Unmarshal error")
+		return nil, err
+	}
+	log = log.With().
+		Uint64("envelope_timestamp", envelope.GetTimestamp()).
+		Uint64("server_timestamp", envelope.GetServerTimestamp()).
+		Logger()
+	ctx = log.WithContext(ctx)
+	destinationServiceID, err := libsignalgo.ServiceIDFromString(envelope.GetDestinationServiceId())
+	log.Debug().
+		Str("destination_service_id", envelope.GetDestinationServiceId()).
+		Str("source_service_id", envelope.GetSourceServiceId()).
+		Uint32("source_device_id", envelope.GetSourceDevice()).
+		Object("parsed_destination_service_id", destinationServiceID).
+		Int32("envelope_type_id", int32(envelope.GetType())).
+		Str("envelope_type", signalpb.Envelope_Type_name[int32(envelope.GetType())]).
+		Msg("Received envelope")
+
+	item := &pipelineItem{
+		ctx:                  ctx,
+		envelope:             envelope,
+		destinationServiceID: destinationServiceID,
+		sender:               envelope.GetSourceServiceId(),
+		received:             time.Now(),
+		ready:                make(chan struct{}),
+	}
+	p.addDepth(1)
+	if err == nil && envelope.GetType() == signalpb.Envelope_UNIDENTIFIED_SENDER {
+		go p.unseal(item)
+	} else {
+		close(item.ready)
+	}
+	return &web.SimpleResponse{
+		Status:        200,
+		WriteCallback: p.cli.writeCallback,
+		Done:          p.enqueue(ctx, item),
+	}, nil
+}
+
+func (p *receivePipeline) enqueue(ctx context.Context, item *pipelineItem) <-chan error {
+	item.done = make(chan error, 1)
+	select {
+	case p.queue <- item:
+	case <-ctx.Done():
+		if item.envelope != nil {
+			p.addDepth(-1)
+		}
+		item.done <- ctx.Err()
+	}
+	return item.done
+}
+
+// addDepth updates the queue depth metric. Once the pipeline is stopped, any envelopes that weren't handled
+// are subtracted from the metric and later updates are ignored, so that stopped clients don't leave the depth inflated.
+func (p *receivePipeline) addDepth(delta int) {
+	p.depthLock.Lock()
+	defer p.depthLock.Unlock()
+	if p.closed {
+		return
+	}
+	p.depth += delta
+	p.cli.Metrics.AddQueuedEnvelopes(delta)
+}
+
+func (p *receivePipeline) close() {
+	p.depthLock.Lock()
+	defer p.depthLock.Unlock()
+	p.closed = true
+	p.cli.Metrics.AddQueuedEnvelopes(-p.depth)
+	p.depth = 0
+}
+
+func (p *receivePipeline) unseal(item *pipelineItem) {
+	defer close(item.ready)
+	select {
+	case p.unsealSem <- struct{}{}:
+	case <-p.ctx.Done():
+		item.unsealErr = p.ctx.Err()
+		return
+	}
+	defer func() {
+		<-p.unsealSem
+	}()
+	item.unsealed, item.unsealErr = p.cli.unsealEnvelope(item.ctx, item.destinationServiceID, item.envelope)
+	if item.unsealErr == nil {
+		item.sender = libsignalgo.NewACIServiceID(item.unsealed.SenderUUID).String()
+	}
+}
+
+// dispatchLoop routes items to the queue of their sender in the order they were received.
+func (p *receivePipeline) dispatchLoop() {
+	defer p.close()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case item := <-p.queue:
+			if item.barrier != nil {
+				p.inflight.Wait()
+				if !p.drainStart.IsZero() {
+					p.cli.Metrics.QueueDrained(time.Since(p.drainStart))
+					p.drainStart = time.Time{}
+				}
+				item.barrier()
+				item.done <- nil
+				continue
+			} else if p.drainStart.IsZero() {
+				p.drainStart = item.received
+			}
+			select {
+			case <-item.ready:
+			case <-p.ctx.Done():
+				return
+			}
+			p.inflight.Add(1)
+			p.lanesLock.Lock()
+			queue, running := p.lanes[item.sender]
+			p.lanes[item.sender] = append(queue, item)
+			p.lanesLock.Unlock()
+			if !running {
+				go p.runLane(item.sender)
+			}
+		}
+	}
+}
+
+func (p *receivePipeline) runLane(sender string) {
+	for {
+		p.lanesLock.Lock()
+		queue := p.lanes[sender]
+		if len(queue) == 0 {
+			delete(p.lanes, sender)
+			p.lanesLock.Unlock()
+			return
+		}
+		item := queue[0]
+		queue[0] = nil
+		p.lanes[sender] = queue[1:]
+		p.lanesLock.Unlock()
+
+		item.done <- p.handleEnvelope(item)
+		p.addDepth(-1)
+		p.inflight.Done()
+	}
+}
+
+func (p *receivePipeline) handleEnvelope(item *pipelineItem) error {
+	var result DecryptionResult
+	if item.unsealErr != nil {
+		result.Err = fmt.Errorf("failed to decrypt unidentified sender envelope: %w", item.unsealErr)
+	} else {
+		unlock := p.cli.sessionLocks.Lock(item.sender)
+		result = p.cli.decryptEnvelope(item.ctx, item.envelope, item.unsealed)
+		unlock()
+	}
+	err := p.cli.handleDecryptedResult(item.ctx, result, item.envelope, item.destinationServiceID)
+	if err != nil {
+		zerolog.Ctx(item.ctx).Err(err).Msg("Error handling decrypted result")
+	}
+	return err
+}
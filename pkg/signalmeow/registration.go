@@ -0,0 +1,411 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// VerificationSession is the state of a phone number verification session,
+// which must be verified before the number can be registered as a primary device.
+type VerificationSession struct {
+	ID                      string   `json:"id"`
+	NextSMS                 *int     `json:"nextSms"`
+	NextCall                *int     `json:"nextCall"`
+	NextVerificationAttempt *int     `json:"nextVerificationAttempt"`
+	AllowedToRequestCode    bool     `json:"allowedToRequestCode"`
+	RequestedInformation    []string `json:"requestedInformation"`
+	Verified                bool     `json:"verified"`
+}
+
+const (
+	RequestedInformationCaptcha       = "captcha"
+	RequestedInformationPushChallenge = "pushChallenge"
+)
+
+// NeedsCaptcha returns true if the server requires a captcha before a verification code can be requested.
+func (vs *VerificationSession) NeedsCaptcha() bool {
+	return slices.Contains(vs.RequestedInformation, RequestedInformationCaptcha)
+}
+
+type VerificationTransport string
+
+const (
+	VerificationTransportSMS   VerificationTransport = "sms"
+	VerificationTransportVoice VerificationTransport = "voice"
+)
+
+// RegistrationError is returned when the registration API responds with a non-successful status code.
+type RegistrationError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	// The current state of the verification session, if the server included it in the response.
+	Session *VerificationSession
+}
+
+func (re *RegistrationError) Error() string {
+	switch re.StatusCode {
+	case http.StatusTooManyRequests:
+		return fmt.Sprintf("rate limited by server, retry after %s", re.RetryAfter)
+	case http.StatusNotFound:
+		return "verification session not found"
+	case http.StatusConflict:
+		return "verification session is not in a valid state for the request"
+	case http.StatusTeapot:
+		return "verification code provider rejected the request"
+	case 440:
+		return "verification code transport is not supported for this number"
+	default:
+		return fmt.Sprintf("unexpected status code %d", re.StatusCode)
+	}
+}
+
+// RegistrationLockError is returned by RegisterPrimaryDevice if the account has registration lock enabled.
+type RegistrationLockError struct {
+	TimeRemaining time.Duration
+	// Credentials for restoring the master key from SVR2, which is needed to get the registration lock token.
+	SVR2Username string
+	SVR2Password string
+}
+
+func (rle *RegistrationLockError) Error() string {
+	return fmt.Sprintf("account is registration locked for %s", rle.TimeRemaining)
+}
+
+var ErrVerificationCodeIncorrect = errors.New("incorrect verification code")
+
+func sendRegistrationRequest(ctx context.Context, method, path string, body any, opt *web.HTTPReqOpt, out any) error {
+	if opt == nil {
+		opt = &web.HTTPReqOpt{}
+	}
+	if body != nil {
+		var err error
+		opt.Body, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+	}
+	opt.ContentType = web.ContentTypeJSON
+	resp, err := web.SendHTTPRequest(ctx, method, path, opt)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if resp.StatusCode == http.StatusLocked {
+			var lockResp struct {
+				TimeRemaining   int64                     `json:"timeRemaining"`
+				SVR2Credentials *basicExpiringCredentials `json:"svr2Credentials"`
+			}
+			_ = json.Unmarshal(respBody, &lockResp)
+			lockErr := &RegistrationLockError{
+				TimeRemaining: time.Duration(lockResp.TimeRemaining) * time.Millisecond,
+			}
+			if lockResp.SVR2Credentials != nil {
+				lockErr.SVR2Username = lockResp.SVR2Credentials.Username
+				lockErr.SVR2Password = lockResp.SVR2Credentials.Password
+			}
+			return lockErr
+		}
+		regErr := &RegistrationError{StatusCode: resp.StatusCode}
+		if retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retryAfter > 0 {
+			regErr.RetryAfter = time.Duration(retryAfter) * time.Second
+		}
+		var session VerificationSession
+		if json.Unmarshal(respBody, &session) == nil && session.ID != "" {
+			regErr.Session = &session
+		}
+		zerolog.Ctx(ctx).Debug().
+			Int("status_code", resp.StatusCode).
+			Str("body", string(respBody)).
+			Msg("Registration request failed")
+		return regErr
+	}
+	if out != nil {
+		err = json.Unmarshal(respBody, out)
+		if err != nil {
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreateVerificationSession starts verifying the given phone number (in E.164 format).
+func CreateVerificationSession(ctx context.Context, number string) (*VerificationSession, error) {
+	var session VerificationSession
+	err := sendRegistrationRequest(ctx, http.MethodPost, "/v1/verification/session", map[string]any{
+		"number": number,
+	}, nil, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func GetVerificationSession(ctx context.Context, sessionID string) (*VerificationSession, error) {
+	var session VerificationSession
+	err := sendRegistrationRequest(ctx, http.MethodGet, "/v1/verification/session/"+sessionID, nil, nil, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SubmitVerificationCaptcha submits a captcha token from https://signalcaptchas.org/registration/generate.html.
+// The token may include the signalcaptcha:// prefix, which is removed before submitting.
+func SubmitVerificationCaptcha(ctx context.Context, sessionID, captcha string) (*VerificationSession, error) {
+	var session VerificationSession
+	err := sendRegistrationRequest(ctx, http.MethodPatch, "/v1/verification/session/"+sessionID, map[string]any{
+		"captcha": strings.TrimPrefix(strings.TrimSpace(captcha), "signalcaptcha://"),
+	}, nil, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RequestVerificationCode asks the server to send a verification code to the phone number via SMS or a voice call.
+func RequestVerificationCode(ctx context.Context, sessionID string, transport VerificationTransport, locale string) (*VerificationSession, error) {
+	var opt web.HTTPReqOpt
+	if locale != "" {
+		opt.Headers = map[string]string{"Accept-Language": locale}
+	}
+	var session VerificationSession
+	err := sendRegistrationRequest(ctx, http.MethodPost, "/v1/verification/session/"+sessionID+"/code", map[string]any{
+		"transport": transport,
+		"client":    "android",
+	}, &opt, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SubmitVerificationCode submits the code received via SMS or voice call.
+// If the code is wrong, [ErrVerificationCodeIncorrect] is returned.
+func SubmitVerificationCode(ctx context.Context, sessionID, code string) (*VerificationSession, error) {
+	var session VerificationSession
+	err := sendRegistrationRequest(ctx, http.MethodPut, "/v1/verification/session/"+sessionID+"/code", map[string]any{
+		"code": code,
+	}, nil, &session)
+	if err != nil {
+		return nil, err
+	} else if !session.Verified {
+		return &session, ErrVerificationCodeIncorrect
+	}
+	return &session, nil
+}
+
+type registerAccountResponse struct {
+	ACI            uuid.UUID `json:"uuid"`
+	PNI            uuid.UUID `json:"pni"`
+	Number         string    `json:"number"`
+	StorageCapable bool      `json:"storageCapable"`
+	Reregistration bool      `json:"reregistration"`
+}
+
+// RegistrationParams contains optional parameters for RegisterPrimaryDevice.
+type RegistrationParams struct {
+	// The account entropy pool to use. If unset, a new one is generated.
+	AccountEntropyPool libsignalgo.AccountEntropyPool
+	// The master key to use, e.g. one restored with [RestoreMasterKeyFromSVR2].
+	// If set, it takes precedence over the account entropy pool and no new pool is generated.
+	MasterKey []byte
+	// The registration lock token derived from the master key, required if the account has registration lock enabled.
+	// See [RegistrationLockToken].
+	RegistrationLock string
+	// The name to set in the profile of the new account. Signal clients require a profile name,
+	// so accounts without one are shown as unknown to other users.
+	ProfileName string
+}
+
+// RegisterPrimaryDevice registers the given phone number as a new primary device using a verified session.
+//
+// This will replace any existing primary device of the account (e.g. the official app on a phone),
+// and unlink all linked devices. Prekeys are generated and uploaded before returning, and the device is deleted
+// from the store if that fails. The profile name in the params is also uploaded, but failing to do so is only logged.
+func RegisterPrimaryDevice(ctx context.Context, deviceStore store.DeviceStore, number, sessionID string, params *RegistrationParams) (*store.Device, error) {
+	log := zerolog.Ctx(ctx).With().Str("action", "register primary device").Logger()
+	ctx = log.WithContext(ctx)
+	if params == nil {
+		params = &RegistrationParams{}
+	}
+
+	aciIdentityKeyPair, err := libsignalgo.GenerateIdentityKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACI identity key pair: %w", err)
+	}
+	pniIdentityKeyPair, err := libsignalgo.GenerateIdentityKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate PNI identity key pair: %w", err)
+	}
+	aep := params.AccountEntropyPool
+	masterKey := params.MasterKey
+	if masterKey == nil {
+		if aep == "" {
+			aep, err = libsignalgo.GenerateAccountEntropyPool()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate account entropy pool: %w", err)
+			}
+		}
+		masterKey, err = aep.DeriveSVRKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive master key: %w", err)
+		}
+	} else if aep != "" {
+		return nil, fmt.Errorf("both account entropy pool and master key were provided")
+	}
+	profileKey := libsignalgo.ProfileKey(random.Bytes(libsignalgo.ProfileKeyLength))
+	accessKey, err := profileKey.DeriveAccessKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive access key: %w", err)
+	}
+
+	password := random.String(22)
+	aciRegistrationID := mrand.Intn(16383) + 1
+	pniRegistrationID := mrand.Intn(16383) + 1
+	keys := &newDeviceKeys{
+		ACISignedPreKey:       GenerateSignedPreKey(1, aciIdentityKeyPair),
+		PNISignedPreKey:       GenerateSignedPreKey(1, pniIdentityKeyPair),
+		ACIPQLastResortPreKey: GenerateKyberPreKeys(1, 1, aciIdentityKeyPair)[0],
+		PNIPQLastResortPreKey: GenerateKyberPreKeys(1, 1, pniIdentityKeyPair)[0],
+	}
+	aciSignedPreKeyJSON, err := SignedPreKeyToJSON(keys.ACISignedPreKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert signed ACI prekey to JSON: %w", err)
+	}
+	pniSignedPreKeyJSON, err := SignedPreKeyToJSON(keys.PNISignedPreKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert signed PNI prekey to JSON: %w", err)
+	}
+	aciPQLastResortPreKeyJSON, err := KyberPreKeyToJSON(keys.ACIPQLastResortPreKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert ACI kyber last resort prekey to JSON: %w", err)
+	}
+	pniPQLastResortPreKeyJSON, err := KyberPreKeyToJSON(keys.PNIPQLastResortPreKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert PNI kyber last resort prekey to JSON: %w", err)
+	}
+	aciIdentityKey, err := aciIdentityKeyPair.GetIdentityKey().Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize ACI identity key: %w", err)
+	}
+	pniIdentityKey, err := pniIdentityKeyPair.GetIdentityKey().Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize PNI identity key: %w", err)
+	}
+
+	accountAttributes := map[string]any{
+		"fetchesMessages":                true,
+		"registrationId":                 aciRegistrationID,
+		"pniRegistrationId":              pniRegistrationID,
+		"capabilities":                   signalCapabilities,
+		"unidentifiedAccessKey":          base64.StdEncoding.EncodeToString(accessKey[:]),
+		"unrestrictedUnidentifiedAccess": false,
+		"discoverableByPhoneNumber":      true,
+	}
+	if params.RegistrationLock != "" {
+		accountAttributes["registrationLock"] = params.RegistrationLock
+	}
+	var resp registerAccountResponse
+	err = sendRegistrationRequest(ctx, http.MethodPost, "/v1/registration", map[string]any{
+		"sessionId":             sessionID,
+		"accountAttributes":     accountAttributes,
+		"skipDeviceTransfer":    true,
+		"aciIdentityKey":        base64.StdEncoding.EncodeToString(aciIdentityKey),
+		"pniIdentityKey":        base64.StdEncoding.EncodeToString(pniIdentityKey),
+		"aciSignedPreKey":       aciSignedPreKeyJSON,
+		"pniSignedPreKey":       pniSignedPreKeyJSON,
+		"aciPqLastResortPreKey": aciPQLastResortPreKeyJSON,
+		"pniPqLastResortPreKey": pniPQLastResortPreKeyJSON,
+	}, &web.HTTPReqOpt{Username: &number, Password: &password}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register account: %w", err)
+	}
+	log.Info().
+		Stringer("aci", resp.ACI).
+		Stringer("pni", resp.PNI).
+		Bool("reregistration", resp.Reregistration).
+		Msg("Registered account")
+
+	data := &store.DeviceData{
+		ACIIdentityKeyPair: aciIdentityKeyPair,
+		PNIIdentityKeyPair: pniIdentityKeyPair,
+		ACIRegistrationID:  aciRegistrationID,
+		PNIRegistrationID:  pniRegistrationID,
+		ACI:                resp.ACI,
+		PNI:                resp.PNI,
+		DeviceID:           1,
+		Number:             resp.Number,
+		Password:           password,
+		MasterKey:          masterKey,
+		AccountEntropyPool: aep,
+	}
+	if data.Number == "" {
+		data.Number = number
+	}
+	device, err := storeNewDevice(ctx, deviceStore, data, keys, profileKey)
+	if err != nil {
+		return nil, err
+	}
+	cli := &Client{Store: device, Environment: web.Env(ctx)}
+	err = cli.GenerateAndRegisterPreKeys(ctx, device.ACIPreKeyStore)
+	if err != nil {
+		err = fmt.Errorf("error generating and registering ACI prekeys: %w", err)
+	} else if err = cli.GenerateAndRegisterPreKeys(ctx, device.PNIPreKeyStore); err != nil {
+		err = fmt.Errorf("error generating and registering PNI prekeys: %w", err)
+	}
+	if err != nil {
+		// The device can't receive messages without prekeys, so don't leave it in the store.
+		// Registering again with a new session will replace the half-registered account.
+		if deleteErr := deviceStore.DeleteDevice(ctx, &device.DeviceData); deleteErr != nil {
+			log.Err(deleteErr).Msg("Failed to delete device after prekey upload failed")
+		}
+		return nil, err
+	}
+	if params.ProfileName != "" {
+		err = cli.SetProfileName(ctx, params.ProfileName, "")
+		if err != nil {
+			// The profile can be set later, so this isn't worth failing the registration for
+			log.Warn().Err(err).Msg("Failed to upload profile name after registration")
+		}
+	}
+	return device, nil
+}
@@ -0,0 +1,78 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// sendRequest sends a REST request to the chat server.
+//
+// Like the official clients, requests are sent over the already open websockets when possible instead of
+// making a new HTTPS connection: requests authenticated with this device's credentials use the authed websocket
+// and requests without credentials use the unauthed one. If the websocket isn't connected or the request couldn't
+// be queued on it, the request falls back to HTTP. Other websocket errors are returned as-is, because the server
+// may have already processed the request, and resending requests like message sends isn't safe.
+// Requests to other hosts or with streamed bodies always use HTTP.
+func (cli *Client) sendRequest(ctx context.Context, method, path string, opt *web.HTTPReqOpt) (*http.Response, error) {
+	ws := cli.websocketForRequest(opt)
+	if ws != nil {
+		resp, err := ws.SendHTTPRequest(ctx, method, path, opt)
+		if !errors.Is(err, web.ErrRequestNotSent) || ctx.Err() != nil {
+			return resp, err
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Str("method", method).
+			Str("path", path).
+			Msg("Failed to send request over websocket, falling back to HTTP")
+	}
+	return cli.env().SendHTTPRequest(ctx, method, path, opt)
+}
+
+func (cli *Client) websocketForRequest(opt *web.HTTPReqOpt) *web.SignalWebsocket {
+	if opt == nil {
+		opt = &web.HTTPReqOpt{}
+	}
+	if opt.Host != "" || opt.OverrideURL != "" || opt.BodyStream != nil {
+		return nil
+	}
+	for key := range opt.Headers {
+		if strings.EqualFold(key, "Authorization") {
+			return nil
+		}
+	}
+	var ws *web.SignalWebsocket
+	if opt.Username == nil && opt.Password == nil {
+		ws = cli.UnauthedWS
+	} else if opt.Username != nil && opt.Password != nil && cli.Store != nil {
+		username, password := cli.Store.BasicAuthCreds()
+		if *opt.Username == username && *opt.Password == password {
+			ws = cli.AuthedWS
+		}
+	}
+	if ws == nil || !ws.IsConnected() {
+		return nil
+	}
+	return ws
+}
@@ -42,6 +42,8 @@ import (
 // Sending
 
 func (cli *Client) senderCertificate(ctx context.Context, e164 bool) (*libsignalgo.SenderCertificate, error) {
+	cli.senderCertificateLock.Lock()
+	defer cli.senderCertificateLock.Unlock()
 	cached := cli.SenderCertificateNoE164
 	if e164 {
 		cached = cli.SenderCertificateWithE164
@@ -76,7 +78,7 @@ func (cli *Client) senderCertificate(ctx context.Context, e164 bool) (*libsignal
 	if !e164 {
 		query = "?includeE164=false"
 	}
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, "/v1/certificate/delivery"+query, opts)
+	resp, err := cli.sendRequest(ctx, http.MethodGet, "/v1/certificate/delivery"+query, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -180,9 +182,9 @@ func (cli *Client) howManyOtherDevicesDoWeHave(ctx context.Context) int {
 }
 
 func (cli *Client) buildMessagesToSend(ctx context.Context, recipient libsignalgo.ServiceID, content *signalpb.Content, unauthenticated, isGroup bool) ([]MyMessage, error) {
-	// We need to prevent multiple encryption operations from happening at once, or else ratchets can race
-	cli.encryptionLock.Lock()
-	defer cli.encryptionLock.Unlock()
+	// We need to prevent multiple encryption operations with the same recipient from happening at once, or else ratchets can race
+	unlock := cli.sessionLocks.Lock(recipient.String())
+	defer unlock()
 
 	addresses, sessionRecords, err := cli.Store.ACISessionStore.AllSessionsForServiceID(ctx, recipient)
 	if err == nil && (len(addresses) == 0 || len(sessionRecords) == 0) {
@@ -910,6 +912,7 @@ func (cli *Client) sendContent(
 	request := web.CreateWSRequest(http.MethodPut, path, jsonBytes, nil, nil)
 
 	var response *signalpb.WebSocketResponseMessage
+	sendStart := time.Now()
 	if useUnidentifiedSender {
 		log.Trace().Msg("Sending message over unidentified WS")
 		base64AccessKey := base64.StdEncoding.EncodeToString(accessKey[:])
@@ -923,6 +926,7 @@ func (cli *Client) sendContent(
 	if err != nil {
 		return sentUnidentified, err
 	}
+	cli.Metrics.MessageSent(useUnidentifiedSender, int(response.GetStatus()), time.Since(sendStart))
 	log = log.With().
 		Uint64("response_id", *response.Id).
 		Uint32("response_status", *response.Status).
@@ -1097,7 +1101,7 @@ func (cli *Client) handle428(ctx context.Context, recipient libsignalgo.ServiceI
 	//			zlog.Info().Msg("Got pushChallenge, sending response")
 	//			token := body["token"].(string)
 	//			username, password := device.Data.BasicAuthCreds()
-	//			response, err := web.SendHTTPRequest(
+	//			response, err := cli.env().SendHTTPRequest(
 	//				http.MethodPut,
 	//				"/v1/challenge",
 	//				&web.HTTPReqOpt{
@@ -0,0 +1,535 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/util/random"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+type identityType int
+
+const (
+	identityACI identityType = iota
+	identityPNI
+)
+
+type account struct {
+	aci    uuid.UUID
+	pni    uuid.UUID
+	number string
+
+	identities            [2]accountIdentity
+	unidentifiedAccessKey []byte
+	devices               map[int]*device
+	nextDeviceID          int
+	profiles              map[string]*profileVersion
+}
+
+type accountIdentity struct {
+	identityKey []byte
+}
+
+type device struct {
+	account *account
+	id      int
+	name    []byte
+	created time.Time
+
+	password string
+	keys     [2]deviceKeys
+
+	queue  []*signalpb.Envelope
+	notify chan struct{}
+}
+
+type deviceKeys struct {
+	registrationID        int
+	signedPreKey          *preKey
+	lastResortKyberPreKey *preKey
+	preKeys               []*preKey
+	kyberPreKeys          []*preKey
+}
+
+type preKey struct {
+	KeyID     int    `json:"keyId"`
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type accountAttributes struct {
+	RegistrationID        int    `json:"registrationId"`
+	PNIRegistrationID     int    `json:"pniRegistrationId"`
+	UnidentifiedAccessKey []byte `json:"unidentifiedAccessKey"`
+	Name                  []byte `json:"name,omitempty"`
+}
+
+type verificationSession struct {
+	ID                   string   `json:"id"`
+	AllowedToRequestCode bool     `json:"allowedToRequestCode"`
+	RequestedInformation []string `json:"requestedInformation"`
+	Verified             bool     `json:"verified"`
+
+	number        string
+	codeRequested bool
+}
+
+func (acc *account) serviceID(identity identityType) libsignalgo.ServiceID {
+	if identity == identityPNI {
+		return libsignalgo.NewPNIServiceID(acc.pni)
+	}
+	return libsignalgo.NewACIServiceID(acc.aci)
+}
+
+func (acc *account) addDevice(password string, attrs *accountAttributes, aciSignedPreKey, pniSignedPreKey, aciLastResort, pniLastResort *preKey) *device {
+	dev := &device{
+		account:  acc,
+		id:       acc.nextDeviceID,
+		name:     attrs.Name,
+		created:  time.Now(),
+		password: password,
+		keys: [2]deviceKeys{{
+			registrationID:        attrs.RegistrationID,
+			signedPreKey:          aciSignedPreKey,
+			lastResortKyberPreKey: aciLastResort,
+		}, {
+			registrationID:        attrs.PNIRegistrationID,
+			signedPreKey:          pniSignedPreKey,
+			lastResortKyberPreKey: pniLastResort,
+		}},
+		notify: make(chan struct{}, 1),
+	}
+	acc.nextDeviceID++
+	acc.devices[dev.id] = dev
+	return dev
+}
+
+// sortedDeviceIDs returns the IDs of the account's devices in ascending order.
+func (acc *account) sortedDeviceIDs() []int {
+	ids := make([]int, 0, len(acc.devices))
+	for id := range acc.devices {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// accountByServiceID finds an account by ACI or PNI. The server lock must be held.
+func (s *Server) accountByServiceID(serviceID libsignalgo.ServiceID) (*account, identityType) {
+	if serviceID.Type == libsignalgo.ServiceIDTypePNI {
+		return s.accountsByPNI[serviceID.UUID], identityPNI
+	}
+	return s.accounts[serviceID.UUID], identityACI
+}
+
+func (s *Server) handleCreateVerificationSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Number string `json:"number"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	} else if req.Number == "" {
+		writeError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	session := &verificationSession{
+		ID:                   base64.RawURLEncoding.EncodeToString(random.Bytes(16)),
+		AllowedToRequestCode: true,
+		RequestedInformation: []string{},
+		number:               req.Number,
+	}
+	s.lock.Lock()
+	s.sessions[session.ID] = session
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleGetVerificationSession(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	session, ok := s.sessions[r.PathValue("id")]
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleRequestVerificationCode(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	session.codeRequested = true
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleSubmitVerificationCode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	} else if !session.codeRequested {
+		writeJSON(w, http.StatusConflict, session)
+		return
+	}
+	session.Verified = req.Code == VerificationCode
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleRegistration(w http.ResponseWriter, r *http.Request) {
+	number, password, ok := r.BasicAuth()
+	if !ok {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		SessionID             string            `json:"sessionId"`
+		AccountAttributes     accountAttributes `json:"accountAttributes"`
+		ACIIdentityKey        []byte            `json:"aciIdentityKey"`
+		PNIIdentityKey        []byte            `json:"pniIdentityKey"`
+		ACISignedPreKey       *preKey           `json:"aciSignedPreKey"`
+		PNISignedPreKey       *preKey           `json:"pniSignedPreKey"`
+		ACIPQLastResortPreKey *preKey           `json:"aciPqLastResortPreKey"`
+		PNIPQLastResortPreKey *preKey           `json:"pniPqLastResortPreKey"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	} else if req.ACISignedPreKey == nil || req.PNISignedPreKey == nil || req.ACIPQLastResortPreKey == nil || req.PNIPQLastResortPreKey == nil {
+		writeError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[req.SessionID]
+	if !ok || !session.Verified || session.number != number {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	delete(s.sessions, req.SessionID)
+
+	acc, reregistration := s.accountsByNum[number]
+	if reregistration {
+		// Re-registering unlinks all devices, but keeps the account identifiers
+		for _, dev := range acc.devices {
+			close(dev.notify)
+		}
+	} else {
+		acc = &account{
+			aci:      uuid.New(),
+			pni:      uuid.New(),
+			number:   number,
+			profiles: make(map[string]*profileVersion),
+		}
+		s.accounts[acc.aci] = acc
+		s.accountsByPNI[acc.pni] = acc
+		s.accountsByNum[number] = acc
+	}
+	acc.identities[identityACI].identityKey = req.ACIIdentityKey
+	acc.identities[identityPNI].identityKey = req.PNIIdentityKey
+	acc.unidentifiedAccessKey = req.AccountAttributes.UnidentifiedAccessKey
+	acc.devices = make(map[int]*device)
+	acc.nextDeviceID = 1
+	acc.addDevice(password, &req.AccountAttributes, req.ACISignedPreKey, req.PNISignedPreKey, req.ACIPQLastResortPreKey, req.PNIPQLastResortPreKey)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"uuid":           acc.aci,
+		"pni":            acc.pni,
+		"number":         acc.number,
+		"storageCapable": true,
+		"reregistration": reregistration,
+	})
+}
+
+func (s *Server) handleGetLinkCode(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	} else if dev.id != 1 {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	code := base64.RawURLEncoding.EncodeToString(random.Bytes(16))
+	s.lock.Lock()
+	s.linkCodes[code] = dev.account
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"verificationCode": code,
+	})
+}
+
+func (s *Server) handleLinkDevice(w http.ResponseWriter, r *http.Request) {
+	number, password, ok := r.BasicAuth()
+	if !ok {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		VerificationCode      string            `json:"verificationCode"`
+		AccountAttributes     accountAttributes `json:"accountAttributes"`
+		ACISignedPreKey       *preKey           `json:"aciSignedPreKey"`
+		PNISignedPreKey       *preKey           `json:"pniSignedPreKey"`
+		ACIPQLastResortPreKey *preKey           `json:"aciPqLastResortPreKey"`
+		PNIPQLastResortPreKey *preKey           `json:"pniPqLastResortPreKey"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	} else if req.ACISignedPreKey == nil || req.PNISignedPreKey == nil || req.ACIPQLastResortPreKey == nil || req.PNIPQLastResortPreKey == nil {
+		writeError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	acc, ok := s.linkCodes[req.VerificationCode]
+	if !ok || acc.number != number {
+		writeError(w, http.StatusForbidden)
+		return
+	}
+	delete(s.linkCodes, req.VerificationCode)
+	dev := acc.addDevice(password, &req.AccountAttributes, req.ACISignedPreKey, req.PNISignedPreKey, req.ACIPQLastResortPreKey, req.PNIPQLastResortPreKey)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"uuid":     acc.aci,
+		"pni":      acc.pni,
+		"deviceId": dev.id,
+	})
+}
+
+func (s *Server) handleSetCapabilities(w http.ResponseWriter, r *http.Request) {
+	if s.requireAuth(w, r) != nil {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleDeleteDevice(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	deviceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || deviceID == 1 {
+		writeError(w, http.StatusForbidden)
+		return
+	} else if dev.id != 1 && dev.id != deviceID {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if target, ok := dev.account.devices[deviceID]; ok {
+		close(target.notify)
+		delete(dev.account.devices, deviceID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	devices := make([]map[string]any, 0, len(dev.account.devices))
+	for _, id := range dev.account.sortedDeviceIDs() {
+		target := dev.account.devices[id]
+		devices = append(devices, map[string]any{
+			"id":       target.id,
+			"name":     target.name,
+			"created":  target.created.UnixMilli(),
+			"lastSeen": target.created.UnixMilli(),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"devices": devices})
+}
+
+// handleSetDeviceName renames the authenticated device, or another device if the deviceId query parameter is set.
+// Only the primary device can rename other devices.
+func (s *Server) handleSetDeviceName(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	deviceID := dev.id
+	if rawDeviceID := r.URL.Query().Get("deviceId"); rawDeviceID != "" {
+		var err error
+		deviceID, err = strconv.Atoi(rawDeviceID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		} else if dev.id != 1 && deviceID != dev.id {
+			writeError(w, http.StatusForbidden)
+			return
+		}
+	}
+	var req struct {
+		DeviceName []byte `json:"deviceName"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	} else if len(req.DeviceName) == 0 {
+		writeError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	target, ok := dev.account.devices[deviceID]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	target.name = req.DeviceName
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseIdentityType(r *http.Request) identityType {
+	if r.URL.Query().Get("identity") == "pni" {
+		return identityPNI
+	}
+	return identityACI
+}
+
+func (s *Server) handleUploadKeys(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	var req struct {
+		PreKeys            []*preKey `json:"preKeys"`
+		PQPreKeys          []*preKey `json:"pqPreKeys"`
+		SignedPreKey       *preKey   `json:"signedPreKey"`
+		PQLastResortPreKey *preKey   `json:"pqLastResortPreKey"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	identity := parseIdentityType(r)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := &dev.keys[identity]
+	// Like the real server, uploading one-time prekeys replaces all previously uploaded ones
+	if len(req.PreKeys) > 0 {
+		keys.preKeys = req.PreKeys
+	}
+	if len(req.PQPreKeys) > 0 {
+		keys.kyberPreKeys = req.PQPreKeys
+	}
+	if req.SignedPreKey != nil {
+		keys.signedPreKey = req.SignedPreKey
+	}
+	if req.PQLastResortPreKey != nil {
+		keys.lastResortKyberPreKey = req.PQLastResortPreKey
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetKeyCounts(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	identity := parseIdentityType(r)
+	s.lock.Lock()
+	keys := &dev.keys[identity]
+	count, pqCount := len(keys.preKeys), len(keys.kyberPreKeys)
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"count":   count,
+		"pqCount": pqCount,
+	})
+}
+
+type preKeyDevice struct {
+	DeviceID       int     `json:"deviceId"`
+	RegistrationID int     `json:"registrationId"`
+	SignedPreKey   *preKey `json:"signedPreKey"`
+	PreKey         *preKey `json:"preKey,omitempty"`
+	PQPreKey       *preKey `json:"pqPreKey,omitempty"`
+}
+
+func (s *Server) handleGetPreKeys(w http.ResponseWriter, r *http.Request) {
+	if s.requireAuth(w, r) == nil {
+		return
+	}
+	serviceID, err := libsignalgo.ServiceIDFromString(r.PathValue("serviceID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	acc, identity := s.accountByServiceID(serviceID)
+	if acc == nil {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	var deviceIDs []int
+	if rawDeviceID := r.PathValue("deviceID"); rawDeviceID == "*" {
+		deviceIDs = acc.sortedDeviceIDs()
+	} else if deviceID, err := strconv.Atoi(rawDeviceID); err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	} else if _, ok := acc.devices[deviceID]; !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	} else {
+		deviceIDs = []int{deviceID}
+	}
+	devices := make([]preKeyDevice, 0, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		keys := &acc.devices[deviceID].keys[identity]
+		bundle := preKeyDevice{
+			DeviceID:       deviceID,
+			RegistrationID: keys.registrationID,
+			SignedPreKey:   keys.signedPreKey,
+			PQPreKey:       keys.lastResortKyberPreKey,
+		}
+		// One-time prekeys are consumed when fetched
+		if len(keys.preKeys) > 0 {
+			bundle.PreKey = keys.preKeys[0]
+			keys.preKeys = keys.preKeys[1:]
+		}
+		if len(keys.kyberPreKeys) > 0 {
+			bundle.PQPreKey = keys.kyberPreKeys[0]
+			keys.kyberPreKeys = keys.kyberPreKeys[1:]
+		}
+		devices = append(devices, bundle)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"identityKey": acc.identities[identity].identityKey,
+		"devices":     devices,
+	})
+}
@@ -0,0 +1,173 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/random"
+)
+
+// maxUploadSize is the largest file that the fake CDN accepts.
+const maxUploadSize = 100 << 20
+
+func (s *Server) handleGetAttachmentUploadForm(w http.ResponseWriter, r *http.Request) {
+	if s.requireAuth(w, r) == nil {
+		return
+	}
+	key := base64.RawURLEncoding.EncodeToString(random.Bytes(18))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cdn":                  3,
+		"key":                  key,
+		"headers":              map[string]string{},
+		"signedUploadLocation": "https://" + s.env.CDNHost(3) + "/cdn3/upload/" + key,
+	})
+}
+
+// handleTUSUpload implements the creation-with-upload extension of the TUS protocol.
+// Uploads must be completed in a single request, resuming is not supported.
+func (s *Server) handleTUSUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if r.Header.Get("Tus-Resumable") != "1.0.0" || err != nil || length < 0 || length > maxUploadSize {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, length+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	} else if int64(len(data)) != length {
+		writeError(w, http.StatusConflict)
+		return
+	}
+	s.lock.Lock()
+	s.cdn["/attachments/"+r.PathValue("key")] = data
+	s.lock.Unlock()
+	w.Header().Set("Tus-Resumable", "1.0.0")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleAvatarUpload implements the multipart form upload used for group avatars on CDN 0.
+func (s *Server) handleAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(maxUploadSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	key := r.FormValue("key")
+	file, _, err := r.FormFile("file")
+	if key == "" || err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	s.cdn["/"+key] = data
+	s.lock.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InterruptDownloads makes the CDN drop the connection after sending afterBytes bytes
+// of the response body in the next count download requests.
+func (s *Server) InterruptDownloads(count int, afterBytes int64) {
+	s.lock.Lock()
+	s.interruptDownloads = count
+	s.interruptAfter = afterBytes
+	s.lock.Unlock()
+}
+
+// CorruptRangeRequests makes the CDN flip the first byte of all responses to range requests.
+func (s *Server) CorruptRangeRequests() {
+	s.lock.Lock()
+	s.corruptRanges = true
+	s.lock.Unlock()
+}
+
+// DownloadRanges returns the Range headers of all download requests made so far.
+// Requests without a Range header are included as empty strings.
+func (s *Server) DownloadRanges() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.downloadRanges)
+}
+
+// handleDownload serves stored files. Range requests are handled by [http.ServeContent].
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	rangeHeader := r.Header.Get("Range")
+	s.lock.Lock()
+	data, ok := s.cdn[r.URL.Path]
+	s.downloadRanges = append(s.downloadRanges, rangeHeader)
+	interrupt := s.interruptDownloads > 0
+	if interrupt {
+		s.interruptDownloads--
+	}
+	interruptAfter := s.interruptAfter
+	corrupt := s.corruptRanges && rangeHeader != ""
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	if corrupt {
+		data = bytes.Clone(data)
+		if start, err := parseRangeStart(rangeHeader); err == nil && start < int64(len(data)) {
+			data[start] ^= 0xff
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if interrupt {
+		w = &interruptingWriter{ResponseWriter: w, remaining: interruptAfter}
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func parseRangeStart(rangeHeader string) (int64, error) {
+	spec, _ := strings.CutPrefix(rangeHeader, "bytes=")
+	start, _, _ := strings.Cut(spec, "-")
+	return strconv.ParseInt(start, 10, 64)
+}
+
+// interruptingWriter aborts the response after the given number of body bytes have been sent.
+type interruptingWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+func (iw *interruptingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) < iw.remaining {
+		n, err := iw.ResponseWriter.Write(p)
+		iw.remaining -= int64(n)
+		return n, err
+	}
+	_, _ = iw.ResponseWriter.Write(p[:iw.remaining])
+	http.NewResponseController(iw.ResponseWriter).Flush()
+	// Abort the handler without logging to make the client see a truncated body
+	panic(http.ErrAbortHandler)
+}
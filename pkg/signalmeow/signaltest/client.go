@@ -0,0 +1,198 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/litestream"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+)
+
+// DefaultTimeout is how long the helpers in this package wait for something to happen before failing the test.
+const DefaultTimeout = 30 * time.Second
+
+// Client is a connected signalmeow client whose events are collected into a channel.
+type Client struct {
+	*signalmeow.Client
+	Events chan events.SignalEvent
+}
+
+// NewDeviceStore creates a new SQLite-backed device store in a temporary directory.
+//
+// Each client needs its own store, because devices of the same account share an ACI.
+func NewDeviceStore(t testing.TB) *store.Container {
+	t.Helper()
+	db, err := dbutil.NewFromConfig("signaltest", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         "sqlite3-fk-wal",
+			URI:          "file:" + filepath.Join(t.TempDir(), "signalmeow.db") + "?_txlock=immediate",
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	container := store.NewStore(db, dbutil.NoopLogger)
+	err = container.Upgrade(context.Background())
+	if err != nil {
+		t.Fatalf("failed to upgrade database: %v", err)
+	}
+	return container
+}
+
+func (s *Server) testContext(t testing.TB) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	t.Cleanup(cancel)
+	return s.env.WithContext(s.Log.WithContext(ctx))
+}
+
+// RegisterClient registers a new primary device for the given phone number and connects it.
+func (s *Server) RegisterClient(t testing.TB, number string) *Client {
+	t.Helper()
+	ctx := s.testContext(t)
+	session, err := signalmeow.CreateVerificationSession(ctx, number)
+	if err != nil {
+		t.Fatalf("failed to create verification session: %v", err)
+	}
+	_, err = signalmeow.RequestVerificationCode(ctx, session.ID, signalmeow.VerificationTransportSMS, "")
+	if err != nil {
+		t.Fatalf("failed to request verification code: %v", err)
+	}
+	_, err = signalmeow.SubmitVerificationCode(ctx, session.ID, VerificationCode)
+	if err != nil {
+		t.Fatalf("failed to submit verification code: %v", err)
+	}
+	device, err := signalmeow.RegisterPrimaryDevice(ctx, NewDeviceStore(t), number, session.ID, nil)
+	if err != nil {
+		t.Fatalf("failed to register primary device: %v", err)
+	}
+	return s.connectClient(t, device)
+}
+
+// LinkClient links a new secondary device to the account of the given primary client and connects it.
+func (s *Server) LinkClient(t testing.TB, primary *Client, deviceName string) *Client {
+	t.Helper()
+	ctx := s.testContext(t)
+	deviceStore := NewDeviceStore(t)
+	provChan := signalmeow.PerformProvisioning(ctx, deviceStore, deviceName, false)
+	for resp := range provChan {
+		switch resp.State {
+		case signalmeow.StateProvisioningError:
+			t.Fatalf("provisioning failed: %v", resp.Err)
+		case signalmeow.StateProvisioningURLReceived:
+			err := s.LinkDevice(ctx, primary.Client, resp.ProvisioningURL)
+			if err != nil {
+				t.Fatalf("failed to link device: %v", err)
+			}
+		case signalmeow.StateProvisioningDataReceived:
+			t.Logf("Linked device %d to %s", resp.ProvisioningData.DeviceID, resp.ProvisioningData.ACI)
+		case signalmeow.StateProvisioningPreKeysRegistered:
+			device, err := deviceStore.DeviceByACI(ctx, primary.Store.ACI)
+			if err != nil || device == nil {
+				t.Fatalf("failed to get linked device from store: %v", err)
+			}
+			return s.connectClient(t, device)
+		}
+	}
+	t.Fatalf("provisioning channel closed unexpectedly")
+	return nil
+}
+
+func (s *Server) connectClient(t testing.TB, device *store.Device) *Client {
+	t.Helper()
+	cli := &Client{
+		Client: &signalmeow.Client{
+			Store: device,
+			Log: s.Log.With().
+				Stringer("aci", device.ACI).
+				Int("device_id", device.DeviceID).
+				Logger(),
+			Environment: s.env,
+		},
+		Events: make(chan events.SignalEvent, 256),
+	}
+	stopped := make(chan struct{})
+	cli.EventHandler = func(evt events.SignalEvent) bool {
+		select {
+		case cli.Events <- evt:
+			return true
+		case <-stopped:
+			return false
+		}
+	}
+	statusChan, err := cli.StartReceiveLoops(cli.Log.WithContext(context.Background()))
+	if err != nil {
+		t.Fatalf("failed to start receive loops: %v", err)
+	}
+	t.Cleanup(func() {
+		close(stopped)
+		_ = cli.StopReceiveLoops()
+	})
+	timeout := time.After(DefaultTimeout)
+	for {
+		select {
+		case status, ok := <-statusChan:
+			if !ok {
+				t.Fatalf("connection status channel closed before connecting")
+			}
+			switch status.Event {
+			case signalmeow.SignalConnectionEventConnected:
+				go func() {
+					for range statusChan {
+					}
+				}()
+				return cli
+			case signalmeow.SignalConnectionEventLoggedOut:
+				t.Fatalf("client was logged out while connecting: %v", status.Err)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for client to connect")
+		}
+	}
+}
+
+// WaitForEvent waits until the client receives an event of the given type for which match returns true.
+// Other events received in the meantime are discarded. If match is nil, the first event of the type is returned.
+func WaitForEvent[T events.SignalEvent](t testing.TB, cli *Client, match func(T) bool) T {
+	t.Helper()
+	timeout := time.After(DefaultTimeout)
+	for {
+		select {
+		case evt := <-cli.Events:
+			typedEvt, ok := evt.(T)
+			if ok && (match == nil || match(typedEvt)) {
+				return typedEvt
+			}
+		case <-timeout:
+			var zero T
+			t.Fatalf("timed out waiting for %T event", zero)
+			return zero
+		}
+	}
+}
@@ -0,0 +1,463 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mau.fi/util/random"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+type group struct {
+	state   *signalpb.Group
+	changes []*signalpb.GroupChanges_GroupChangeState
+}
+
+var errUnsupportedGroupAction = errors.New("group change action not supported by fake server")
+
+func (s *Server) handleGetGroupCredentials(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	query := r.URL.Query()
+	start, err1 := strconv.ParseInt(query.Get("redemptionStartSeconds"), 10, 64)
+	end, err2 := strconv.ParseInt(query.Get("redemptionEndSeconds"), 10, 64)
+	const day = int64(24 * time.Hour / time.Second)
+	if err1 != nil || err2 != nil || start%day != 0 || end%day != 0 || end < start || end-start > 7*day {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	type credential struct {
+		Credential     []byte `json:"credential"`
+		RedemptionTime int64  `json:"redemptionTime"`
+	}
+	credentials := make([]credential, 0, 8)
+	for redemptionTime := start; redemptionTime <= end; redemptionTime += day {
+		resp, err := libsignalgo.ServerSecretParamsIssueAuthCredentialWithPni(s.secretParams, dev.account.aci, dev.account.pni, uint64(redemptionTime))
+		if err != nil {
+			s.log(r).Err(err).Msg("Failed to issue group auth credential")
+			writeError(w, http.StatusInternalServerError)
+			return
+		}
+		credentials = append(credentials, credential{Credential: resp[:], RedemptionTime: redemptionTime})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"credentials": credentials,
+		"pni":         dev.account.pni,
+	})
+}
+
+type groupAuth struct {
+	publicParams libsignalgo.GroupPublicParams
+	groupID      libsignalgo.GroupIdentifier
+	userID       []byte
+}
+
+// authenticateGroup verifies the zero-knowledge group auth credential presentation in the request.
+// The user ID is the encrypted ACI of the user, which can be compared to member user IDs,
+// as UUID encryption is deterministic for a given group.
+func (s *Server) authenticateGroup(w http.ResponseWriter, r *http.Request) *groupAuth {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		writeError(w, http.StatusUnauthorized)
+		return nil
+	}
+	var auth groupAuth
+	presentation, err := hex.DecodeString(password)
+	if n, err2 := hex.Decode(auth.publicParams[:], []byte(username)); err != nil || err2 != nil || n != len(auth.publicParams) {
+		writeError(w, http.StatusUnauthorized)
+		return nil
+	}
+	err = libsignalgo.ServerSecretParamsVerifyAuthCredentialPresentation(s.secretParams, auth.publicParams, presentation, time.Now())
+	if err != nil {
+		s.log(r).Debug().Err(err).Msg("Invalid group auth credential presentation")
+		writeError(w, http.StatusUnauthorized)
+		return nil
+	}
+	userID, err := libsignalgo.AuthCredentialPresentation(presentation).UUIDCiphertext()
+	if err != nil {
+		writeError(w, http.StatusUnauthorized)
+		return nil
+	}
+	auth.userID = userID[:]
+	groupID, err := libsignalgo.GetGroupIdentifier(auth.publicParams)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized)
+		return nil
+	}
+	auth.groupID = *groupID
+	return &auth
+}
+
+func readProto(w http.ResponseWriter, r *http.Request, into proto.Message) bool {
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = proto.Unmarshal(data, into)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeProto(w http.ResponseWriter, data proto.Message) {
+	out, err := proto.Marshal(data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// decryptPresentation verifies a profile key credential presentation and
+// replaces it with the encrypted user ID and profile key that it contains.
+func (s *Server) decryptPresentation(auth *groupAuth, presentation []byte) (userID, profileKey []byte, err error) {
+	err = libsignalgo.ServerSecretParamsVerifyProfileKeyCredentialPresentation(s.secretParams, auth.publicParams, presentation, time.Now())
+	if err != nil {
+		return
+	}
+	uuidCiphertext, err := libsignalgo.ProfileKeyCredentialPresentation(presentation).UUIDCiphertext()
+	if err != nil {
+		return
+	}
+	profileKeyCiphertext, err := libsignalgo.ProfileKeyCredentialPresentation(presentation).ProfileKeyCiphertext()
+	if err != nil {
+		return
+	}
+	return uuidCiphertext[:], profileKeyCiphertext[:], nil
+}
+
+func (s *Server) decryptMember(auth *groupAuth, member *signalpb.Member) error {
+	if member.Presentation == nil {
+		return nil
+	}
+	var err error
+	member.UserId, member.ProfileKey, err = s.decryptPresentation(auth, member.Presentation)
+	member.Presentation = nil
+	return err
+}
+
+func findMember(state *signalpb.Group, userID []byte) int {
+	return slices.IndexFunc(state.Members, func(member *signalpb.Member) bool {
+		return bytes.Equal(member.UserId, userID)
+	})
+}
+
+func findPendingMember(state *signalpb.Group, userID []byte) int {
+	return slices.IndexFunc(state.PendingMembers, func(member *signalpb.PendingMember) bool {
+		return bytes.Equal(member.GetMember().GetUserId(), userID)
+	})
+}
+
+// signChange records a group change in the group's history and returns the signed change.
+// The server lock must be held.
+func (s *Server) signChange(grp *group, actions *signalpb.GroupChange_Actions) (*signalpb.GroupChange, error) {
+	actionsBytes, err := proto.Marshal(actions)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := libsignalgo.ServerSecretParamsSign(s.secretParams, actionsBytes)
+	if err != nil {
+		return nil, err
+	}
+	change := &signalpb.GroupChange{
+		Actions:         actionsBytes,
+		ServerSignature: signature[:],
+		ChangeEpoch:     5,
+	}
+	grp.changes = append(grp.changes, &signalpb.GroupChanges_GroupChangeState{
+		GroupChange: change,
+		GroupState:  proto.Clone(grp.state).(*signalpb.Group),
+	})
+	return change, nil
+}
+
+func (s *Server) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	auth := s.authenticateGroup(w, r)
+	if auth == nil {
+		return
+	}
+	var state signalpb.Group
+	if !readProto(w, r, &state) {
+		return
+	} else if !bytes.Equal(state.PublicKey, auth.publicParams[:]) || state.Revision != 0 {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	for _, member := range state.Members {
+		if err := s.decryptMember(auth, member); err != nil {
+			s.log(r).Debug().Err(err).Msg("Invalid member presentation in new group")
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	if findMember(&state, auth.userID) < 0 {
+		writeError(w, http.StatusForbidden)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exists := s.groups[auth.groupID]; exists {
+		writeError(w, http.StatusConflict)
+		return
+	}
+	grp := &group{state: &state}
+	_, err := s.signChange(grp, &signalpb.GroupChange_Actions{
+		SourceServiceId: auth.userID,
+		GroupId:         auth.groupID[:],
+		Revision:        0,
+	})
+	if err != nil {
+		s.log(r).Err(err).Msg("Failed to sign initial group change")
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	s.groups[auth.groupID] = grp
+	w.WriteHeader(http.StatusOK)
+}
+
+// groupForRequest finds the group the request is authenticated for and checks that the user is in it.
+// The server lock must be held.
+func (s *Server) groupForRequest(w http.ResponseWriter, auth *groupAuth) *group {
+	grp, ok := s.groups[auth.groupID]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return nil
+	} else if findMember(grp.state, auth.userID) < 0 && findPendingMember(grp.state, auth.userID) < 0 {
+		writeError(w, http.StatusForbidden)
+		return nil
+	}
+	return grp
+}
+
+func (s *Server) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	auth := s.authenticateGroup(w, r)
+	if auth == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if grp := s.groupForRequest(w, auth); grp != nil {
+		writeProto(w, grp.state)
+	}
+}
+
+func (s *Server) handleModifyGroup(w http.ResponseWriter, r *http.Request) {
+	auth := s.authenticateGroup(w, r)
+	if auth == nil {
+		return
+	}
+	var actions signalpb.GroupChange_Actions
+	if !readProto(w, r, &actions) {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	grp := s.groupForRequest(w, auth)
+	if grp == nil {
+		return
+	} else if actions.Revision != grp.state.Revision+1 {
+		writeError(w, http.StatusConflict)
+		return
+	}
+	newState := proto.Clone(grp.state).(*signalpb.Group)
+	err := s.applyGroupChange(auth, newState, &actions)
+	if err != nil {
+		s.log(r).Debug().Err(err).Msg("Failed to apply group change")
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	newState.Revision = actions.Revision
+	actions.SourceServiceId = auth.userID
+	actions.GroupId = auth.groupID[:]
+	oldState := grp.state
+	grp.state = newState
+	change, err := s.signChange(grp, &actions)
+	if err != nil {
+		grp.state = oldState
+		s.log(r).Err(err).Msg("Failed to sign group change")
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	writeProto(w, change)
+}
+
+// applyGroupChange applies the actions to the group state. Permissions are not checked.
+// Presentations in the actions are replaced with the encrypted user IDs and profile keys like the real server does.
+func (s *Server) applyGroupChange(auth *groupAuth, state *signalpb.Group, actions *signalpb.GroupChange_Actions) error {
+	if len(actions.AddRequestingMembers) > 0 || len(actions.DeleteRequestingMembers) > 0 ||
+		len(actions.PromoteRequestingMembers) > 0 || len(actions.AddBannedMembers) > 0 ||
+		len(actions.DeleteBannedMembers) > 0 || len(actions.PromotePendingPniAciMembers) > 0 {
+		return errUnsupportedGroupAction
+	}
+	if state.AccessControl == nil {
+		state.AccessControl = &signalpb.AccessControl{}
+	}
+	for _, action := range actions.AddMembers {
+		if err := s.decryptMember(auth, action.GetAdded()); err != nil {
+			return err
+		}
+		action.Added.JoinedAtRevision = actions.Revision
+		state.Members = append(state.Members, action.Added)
+	}
+	for _, action := range actions.DeleteMembers {
+		state.Members = slices.DeleteFunc(state.Members, func(member *signalpb.Member) bool {
+			return bytes.Equal(member.UserId, action.DeletedUserId)
+		})
+	}
+	for _, action := range actions.ModifyMemberRoles {
+		if idx := findMember(state, action.UserId); idx >= 0 {
+			state.Members[idx].Role = action.Role
+		}
+	}
+	for _, action := range actions.ModifyMemberProfileKeys {
+		userID, profileKey, err := s.decryptPresentation(auth, action.Presentation)
+		if err != nil {
+			return err
+		}
+		action.Presentation, action.UserId, action.ProfileKey = nil, userID, profileKey
+		if idx := findMember(state, userID); idx >= 0 {
+			state.Members[idx].ProfileKey = profileKey
+		}
+	}
+	for _, action := range actions.AddPendingMembers {
+		action.Added.AddedByUserId = auth.userID
+		action.Added.Timestamp = uint64(time.Now().UnixMilli())
+		state.PendingMembers = append(state.PendingMembers, action.Added)
+	}
+	for _, action := range actions.DeletePendingMembers {
+		state.PendingMembers = slices.DeleteFunc(state.PendingMembers, func(member *signalpb.PendingMember) bool {
+			return bytes.Equal(member.GetMember().GetUserId(), action.DeletedUserId)
+		})
+	}
+	for _, action := range actions.PromotePendingMembers {
+		userID, profileKey, err := s.decryptPresentation(auth, action.Presentation)
+		if err != nil {
+			return err
+		}
+		action.Presentation, action.UserId, action.ProfileKey = nil, userID, profileKey
+		if idx := findPendingMember(state, userID); idx >= 0 {
+			pending := state.PendingMembers[idx]
+			state.PendingMembers = slices.Delete(state.PendingMembers, idx, idx+1)
+			state.Members = append(state.Members, &signalpb.Member{
+				UserId:           userID,
+				Role:             pending.GetMember().GetRole(),
+				ProfileKey:       profileKey,
+				JoinedAtRevision: actions.Revision,
+			})
+		}
+	}
+	if actions.ModifyTitle != nil {
+		state.Title = actions.ModifyTitle.Title
+	}
+	if actions.ModifyAvatar != nil {
+		state.Avatar = actions.ModifyAvatar.Avatar
+	}
+	if actions.ModifyDisappearingMessagesTimer != nil {
+		state.DisappearingMessagesTimer = actions.ModifyDisappearingMessagesTimer.Timer
+	}
+	if actions.ModifyAttributesAccess != nil {
+		state.AccessControl.Attributes = actions.ModifyAttributesAccess.AttributesAccess
+	}
+	if actions.ModifyMemberAccess != nil {
+		state.AccessControl.Members = actions.ModifyMemberAccess.MembersAccess
+	}
+	if actions.ModifyAddFromInviteLinkAccess != nil {
+		state.AccessControl.AddFromInviteLink = actions.ModifyAddFromInviteLinkAccess.AddFromInviteLinkAccess
+	}
+	if actions.ModifyInviteLinkPassword != nil {
+		state.InviteLinkPassword = actions.ModifyInviteLinkPassword.InviteLinkPassword
+	}
+	if actions.ModifyDescription != nil {
+		state.Description = actions.ModifyDescription.Description
+	}
+	if actions.ModifyAnnouncementsOnly != nil {
+		state.AnnouncementsOnly = actions.ModifyAnnouncementsOnly.AnnouncementsOnly
+	}
+	return nil
+}
+
+func (s *Server) handleGetGroupLogs(w http.ResponseWriter, r *http.Request) {
+	auth := s.authenticateGroup(w, r)
+	if auth == nil {
+		return
+	}
+	fromRevision, err := strconv.Atoi(r.PathValue("fromRevision"))
+	if err != nil || fromRevision < 0 {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	includeFirstState := query.Get("includeFirstState") == "true"
+	includeLastState := query.Get("includeLastState") == "true"
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	grp := s.groupForRequest(w, auth)
+	if grp == nil {
+		return
+	} else if fromRevision >= len(grp.changes) {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	changes := grp.changes[fromRevision:]
+	resp := &signalpb.GroupChanges{
+		GroupChanges: make([]*signalpb.GroupChanges_GroupChangeState, len(changes)),
+	}
+	for i, change := range changes {
+		entry := &signalpb.GroupChanges_GroupChangeState{GroupChange: change.GroupChange}
+		if (i == 0 && includeFirstState) || (i == len(changes)-1 && includeLastState) {
+			entry.GroupState = change.GroupState
+		}
+		resp.GroupChanges[i] = entry
+	}
+	writeProto(w, resp)
+}
+
+func (s *Server) handleGetGroupAvatarForm(w http.ResponseWriter, r *http.Request) {
+	auth := s.authenticateGroup(w, r)
+	if auth == nil {
+		return
+	}
+	key := "groups/" + base64.RawURLEncoding.EncodeToString(auth.groupID[:]) + "/" + base64.RawURLEncoding.EncodeToString(random.Bytes(16))
+	writeProto(w, &signalpb.AvatarUploadAttributes{
+		Key:        key,
+		Credential: "fake",
+		Acl:        "private",
+		Algorithm:  "AWS4-HMAC-SHA256",
+		Date:       time.Now().UTC().Format("20060102T150405Z"),
+		Policy:     "fake",
+		Signature:  "fake",
+	})
+}
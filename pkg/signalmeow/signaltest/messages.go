@@ -0,0 +1,136 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+type outgoingMessage struct {
+	Type                      int    `json:"type"`
+	DestinationDeviceID       int    `json:"destinationDeviceId"`
+	DestinationRegistrationID int    `json:"destinationRegistrationId"`
+	Content                   []byte `json:"content"`
+}
+
+// checkAccessKey checks the unidentified access key header of a sealed sender request.
+// The server lock must be held.
+func checkAccessKey(r *http.Request, acc *account) bool {
+	accessKey, err := base64.StdEncoding.DecodeString(r.Header.Get("Unidentified-Access-Key"))
+	return err == nil && len(acc.unidentifiedAccessKey) > 0 && subtle.ConstantTimeCompare(accessKey, acc.unidentifiedAccessKey) == 1
+}
+
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	sender := s.authenticate(r)
+	destination, err := libsignalgo.ServiceIDFromString(r.PathValue("destination"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Timestamp uint64            `json:"timestamp"`
+		Online    bool              `json:"online"`
+		Urgent    bool              `json:"urgent"`
+		Messages  []outgoingMessage `json:"messages"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recipient, identity := s.accountByServiceID(destination)
+	if recipient == nil {
+		writeError(w, http.StatusNotFound)
+		return
+	} else if sender == nil && (identity != identityACI || !checkAccessKey(r, recipient)) {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	expectedDevices := recipient.sortedDeviceIDs()
+	if sender != nil && sender.account == recipient {
+		expectedDevices = slices.DeleteFunc(expectedDevices, func(id int) bool {
+			return id == sender.id
+		})
+	}
+	var mismatch struct {
+		MissingDevices []int `json:"missingDevices,omitempty"`
+		ExtraDevices   []int `json:"extraDevices,omitempty"`
+	}
+	var staleDevices []int
+	for _, deviceID := range expectedDevices {
+		if !slices.ContainsFunc(req.Messages, func(msg outgoingMessage) bool {
+			return msg.DestinationDeviceID == deviceID
+		}) {
+			mismatch.MissingDevices = append(mismatch.MissingDevices, deviceID)
+		}
+	}
+	for _, msg := range req.Messages {
+		dev, ok := recipient.devices[msg.DestinationDeviceID]
+		if !ok || !slices.Contains(expectedDevices, msg.DestinationDeviceID) {
+			mismatch.ExtraDevices = append(mismatch.ExtraDevices, msg.DestinationDeviceID)
+		} else if dev.keys[identity].registrationID != msg.DestinationRegistrationID {
+			staleDevices = append(staleDevices, msg.DestinationDeviceID)
+		}
+	}
+	if len(mismatch.MissingDevices) > 0 || len(mismatch.ExtraDevices) > 0 {
+		writeJSON(w, http.StatusConflict, &mismatch)
+		return
+	} else if len(staleDevices) > 0 {
+		writeJSON(w, http.StatusGone, map[string]any{
+			"staleDevices": staleDevices,
+		})
+		return
+	}
+
+	serverTimestamp := uint64(time.Now().UnixMilli())
+	for _, msg := range req.Messages {
+		envelope := &signalpb.Envelope{
+			Type:                 signalpb.Envelope_Type(msg.Type).Enum(),
+			DestinationServiceId: proto.String(recipient.serviceID(identity).String()),
+			Timestamp:            proto.Uint64(req.Timestamp),
+			Content:              msg.Content,
+			ServerGuid:           proto.String(uuid.NewString()),
+			ServerTimestamp:      proto.Uint64(serverTimestamp),
+			Urgent:               proto.Bool(req.Urgent),
+		}
+		if sender != nil {
+			envelope.SourceServiceId = proto.String(sender.account.serviceID(identityACI).String())
+			envelope.SourceDevice = proto.Uint32(uint32(sender.id))
+		}
+		dev := recipient.devices[msg.DestinationDeviceID]
+		dev.queue = append(dev.queue, envelope)
+		select {
+		case dev.notify <- struct{}{}:
+		default:
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"needsSync": false,
+	})
+}
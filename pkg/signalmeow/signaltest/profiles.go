@@ -0,0 +1,174 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/util/random"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+)
+
+// profileVersion is a profile that has been encrypted with a specific profile key.
+type profileVersion struct {
+	commitment *libsignalgo.ProfileKeyCommitment
+	name       []byte
+	about      []byte
+}
+
+// SetProfile stores the profile of the given client on the server, encrypted with the client's own profile key.
+//
+// This is used to simulate profile changes made by the official apps, which can also set the about text.
+func (s *Server) SetProfile(ctx context.Context, cli *signalmeow.Client, name, about string) error {
+	aci := cli.Store.ACI
+	profileKey, err := cli.ProfileKeyForSignalID(ctx, aci)
+	if err != nil {
+		return fmt.Errorf("failed to get own profile key: %w", err)
+	} else if profileKey == nil {
+		return fmt.Errorf("own profile key not found")
+	}
+	version, err := profileKey.GetProfileKeyVersion(aci)
+	if err != nil {
+		return fmt.Errorf("failed to get profile key version: %w", err)
+	}
+	commitment, err := profileKey.GetCommitment(aci)
+	if err != nil {
+		return fmt.Errorf("failed to get profile key commitment: %w", err)
+	}
+	profile := &profileVersion{commitment: commitment}
+	profile.name, err = encryptProfileField(profileKey, name, 53, 257)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt name: %w", err)
+	}
+	if about != "" {
+		profile.about, err = encryptProfileField(profileKey, about, 128, 254, 512)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt about: %w", err)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	acc, ok := s.accounts[aci]
+	if !ok {
+		return fmt.Errorf("account %s not found", aci)
+	}
+	acc.profiles[version.String()] = profile
+	return nil
+}
+
+// encryptProfileField encrypts a profile field the same way as the official clients,
+// padding the plaintext to the smallest of the given lengths that fits it.
+func encryptProfileField(profileKey *libsignalgo.ProfileKey, plaintext string, paddedLengths ...int) ([]byte, error) {
+	for _, length := range paddedLengths {
+		if len(plaintext) > length {
+			continue
+		}
+		padded := make([]byte, length)
+		copy(padded, plaintext)
+		nonce := random.Bytes(signalmeow.NONCE_LENGTH)
+		ciphertext, err := signalmeow.AesgcmEncrypt(profileKey[:], nonce, padded)
+		if err != nil {
+			return nil, err
+		}
+		return append(nonce, ciphertext...), nil
+	}
+	return nil, fmt.Errorf("plaintext too long")
+}
+
+func (s *Server) handleSetProfile(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	var req struct {
+		Version    string `json:"version"`
+		Name       []byte `json:"name"`
+		About      []byte `json:"about"`
+		Commitment []byte `json:"commitment"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var commitment libsignalgo.ProfileKeyCommitment
+	if req.Version == "" || len(req.Commitment) != len(commitment) {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	copy(commitment[:], req.Commitment)
+	s.lock.Lock()
+	dev.account.profiles[req.Version] = &profileVersion{
+		commitment: &commitment,
+		name:       req.Name,
+		about:      req.About,
+	}
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *Server) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	dev := s.authenticate(r)
+	aci, err := uuid.Parse(r.PathValue("aci"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	acc, ok := s.accounts[aci]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	} else if dev == nil && !checkAccessKey(r, acc) {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	resp := map[string]any{
+		"uuid":        acc.aci,
+		"identityKey": acc.identities[identityACI].identityKey,
+	}
+	profile, ok := acc.profiles[r.PathValue("version")]
+	if ok {
+		resp["name"] = profile.name
+		if profile.about != nil {
+			resp["about"] = profile.about
+		}
+		if rawRequest := r.PathValue("credentialRequest"); rawRequest != "" {
+			var request libsignalgo.ProfileKeyCredentialRequest
+			if n, err := hex.Decode(request[:], []byte(rawRequest)); err != nil || n != len(request) {
+				writeError(w, http.StatusBadRequest)
+				return
+			}
+			// Expiring profile key credentials must expire at a day boundary at most 7 days in the future
+			expiration := time.Now().UTC().Truncate(24 * time.Hour).Add(7 * 24 * time.Hour)
+			credential, err := libsignalgo.ServerSecretParamsIssueExpiringProfileKeyCredential(s.secretParams, &request, acc.aci, profile.commitment, expiration)
+			if err != nil {
+				s.log(r).Err(err).Msg("Failed to issue profile key credential")
+				writeError(w, http.StatusBadRequest)
+				return
+			}
+			resp["credential"] = credential[:]
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
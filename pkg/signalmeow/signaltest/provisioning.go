@@ -0,0 +1,194 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"go.mau.fi/util/random"
+	"golang.org/x/crypto/hkdf"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// LinkDevice acts as the primary device scanning the QR code of a device being linked.
+//
+// The provisioning URL is the one returned by [signalmeow.PerformProvisioning].
+// The primary client doesn't need to be connected, only its credentials and keys are used.
+func (s *Server) LinkDevice(ctx context.Context, primary *signalmeow.Client, provisioningURL string) error {
+	parsedURL, err := url.Parse(provisioningURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse provisioning URL: %w", err)
+	}
+	address := parsedURL.Query().Get("uuid")
+	rawPublicKey, err := base64.StdEncoding.DecodeString(parsedURL.Query().Get("pub_key"))
+	if err != nil {
+		return fmt.Errorf("failed to decode public key in provisioning URL: %w", err)
+	}
+	publicKey, err := libsignalgo.DeserializePublicKey(rawPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key in provisioning URL: %w", err)
+	}
+
+	username, password := primary.Store.BasicAuthCreds()
+	var codeResp struct {
+		VerificationCode string `json:"verificationCode"`
+	}
+	err = s.sendJSON(ctx, http.MethodGet, "/v1/devices/provisioning/code", username, password, nil, &codeResp)
+	if err != nil {
+		return fmt.Errorf("failed to get provisioning code: %w", err)
+	}
+
+	profileKey, err := primary.ProfileKeyForSignalID(ctx, primary.Store.ACI)
+	if err != nil {
+		return fmt.Errorf("failed to get own profile key: %w", err)
+	} else if profileKey == nil {
+		return fmt.Errorf("own profile key not found")
+	}
+	msg := &signalpb.ProvisionMessage{
+		Aci:                 proto.String(primary.Store.ACI.String()),
+		Pni:                 proto.String(primary.Store.PNI.String()),
+		Number:              proto.String(primary.Store.Number),
+		ProvisioningCode:    proto.String(codeResp.VerificationCode),
+		UserAgent:           proto.String("signaltest"),
+		ProfileKey:          profileKey.Slice(),
+		ReadReceipts:        proto.Bool(true),
+		ProvisioningVersion: proto.Uint32(1),
+		MasterKey:           primary.Store.MasterKey,
+	}
+	if primary.Store.AccountEntropyPool != "" {
+		msg.AccountEntropyPool = proto.String(string(primary.Store.AccountEntropyPool))
+	}
+	msg.AciIdentityKeyPublic, err = primary.Store.ACIIdentityKeyPair.GetPublicKey().Serialize()
+	if err != nil {
+		return err
+	}
+	msg.AciIdentityKeyPrivate, err = primary.Store.ACIIdentityKeyPair.GetPrivateKey().Serialize()
+	if err != nil {
+		return err
+	}
+	msg.PniIdentityKeyPublic, err = primary.Store.PNIIdentityKeyPair.GetPublicKey().Serialize()
+	if err != nil {
+		return err
+	}
+	msg.PniIdentityKeyPrivate, err = primary.Store.PNIIdentityKeyPair.GetPrivateKey().Serialize()
+	if err != nil {
+		return err
+	}
+	envelope, err := encryptProvisionMessage(publicKey, msg)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt provisioning message: %w", err)
+	}
+	envelopeBytes, err := proto.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	err = s.sendJSON(ctx, http.MethodPut, "/v1/provisioning/"+url.PathEscape(address), username, password, map[string]any{
+		"body": envelopeBytes,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to send provisioning message: %w", err)
+	}
+	return nil
+}
+
+// encryptProvisionMessage is the inverse of [signalmeow.ProvisioningCipher.Decrypt].
+func encryptProvisionMessage(theirPublicKey *libsignalgo.PublicKey, msg *signalpb.ProvisionMessage) (*signalpb.ProvisionEnvelope, error) {
+	plaintext, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey, err := libsignalgo.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublicKey, err := ephemeralKey.GetPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	serializedPublicKey, err := ephemeralPublicKey.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	agreement, err := ephemeralKey.Agree(theirPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]byte, 64)
+	_, err = io.ReadFull(hkdf.New(sha256.New, agreement, nil, []byte("TextSecure Provisioning Message")), keys)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	paddingLen := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(plaintext, bytes.Repeat([]byte{byte(paddingLen)}, paddingLen)...)
+	iv := random.Bytes(aes.BlockSize)
+	body := make([]byte, 1+len(iv)+len(padded), 1+len(iv)+len(padded)+sha256.Size)
+	body[0] = signalmeow.SUPPORTED_VERSION
+	copy(body[1:], iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(body[1+len(iv):], padded)
+	mac := hmac.New(sha256.New, keys[32:])
+	mac.Write(body)
+	return &signalpb.ProvisionEnvelope{
+		PublicKey: serializedPublicKey,
+		Body:      mac.Sum(body),
+	}, nil
+}
+
+func (s *Server) sendJSON(ctx context.Context, method, path, username, password string, body, into any) error {
+	opts := &web.HTTPReqOpt{
+		Username:    &username,
+		Password:    &password,
+		ContentType: web.ContentTypeJSON,
+	}
+	if body != nil {
+		var err error
+		opts.Body, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := s.env.SendHTTPRequest(ctx, method, path, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	} else if into != nil {
+		return json.NewDecoder(resp.Body).Decode(into)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package signaltest implements an in-process fake Signal server for testing signalmeow without network access.
+//
+// The server speaks the same HTTP and websocket protocols as the real chat, storage and CDN servers,
+// but only implements the subset of the API that signalmeow uses and performs very little validation.
+// All hosts of the [web.ServerEnvironment] returned by [Server.Env] point at a single httptest server.
+package signaltest
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// VerificationCode is the code that the fake server accepts for all phone number verification sessions.
+const VerificationCode = "123456"
+
+type Server struct {
+	// Log is used for the server and clients created with it. It defaults to a no-op logger.
+	Log zerolog.Logger
+
+	httpServer *httptest.Server
+	mux        *http.ServeMux
+	env        *web.ServerEnvironment
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	secretParams *libsignalgo.ServerSecretParams
+	certKey      *libsignalgo.PrivateKey
+	serverCert   *libsignalgo.ServerCertificate
+
+	lock          sync.Mutex
+	sessions      map[string]*verificationSession
+	accounts      map[uuid.UUID]*account
+	accountsByPNI map[uuid.UUID]*account
+	accountsByNum map[string]*account
+	linkCodes     map[string]*account
+	provisioning  map[string]chan []byte
+	groups        map[libsignalgo.GroupIdentifier]*group
+	cdn           map[string][]byte
+
+	interruptDownloads int
+	interruptAfter     int64
+	corruptRanges      bool
+	downloadRanges     []string
+}
+
+// NewServer starts a fake Signal server that is shut down when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{
+		Log: zerolog.Nop(),
+
+		mux:           http.NewServeMux(),
+		sessions:      make(map[string]*verificationSession),
+		accounts:      make(map[uuid.UUID]*account),
+		accountsByPNI: make(map[uuid.UUID]*account),
+		accountsByNum: make(map[string]*account),
+		linkCodes:     make(map[string]*account),
+		provisioning:  make(map[string]chan []byte),
+		groups:        make(map[libsignalgo.GroupIdentifier]*group),
+		cdn:           make(map[string][]byte),
+	}
+	var err error
+	s.secretParams, err = libsignalgo.GenerateServerSecretParams()
+	if err != nil {
+		t.Fatalf("failed to generate server secret params: %v", err)
+	}
+	publicParams, err := libsignalgo.ServerSecretParamsGetPublicParams(s.secretParams)
+	if err != nil {
+		t.Fatalf("failed to get server public params: %v", err)
+	}
+	serializedPublicParams, err := libsignalgo.SerializeServerPublicParams(publicParams)
+	if err != nil {
+		t.Fatalf("failed to serialize server public params: %v", err)
+	}
+	trustRoot, err := libsignalgo.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate trust root: %v", err)
+	}
+	s.certKey, err = libsignalgo.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate server certificate key: %v", err)
+	}
+	certPublicKey, err := s.certKey.GetPublicKey()
+	if err != nil {
+		t.Fatalf("failed to get server certificate public key: %v", err)
+	}
+	s.serverCert, err = libsignalgo.NewServerCertificate(1, certPublicKey, trustRoot)
+	if err != nil {
+		t.Fatalf("failed to create server certificate: %v", err)
+	}
+
+	s.registerRoutes()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.httpServer = httptest.NewUnstartedServer(s.mux)
+	s.httpServer.Config.BaseContext = func(net.Listener) context.Context {
+		return s.ctx
+	}
+	s.httpServer.StartTLS()
+	t.Cleanup(s.Close)
+
+	host := s.httpServer.Listener.Addr().String()
+	s.env = &web.ServerEnvironment{
+		Name:        "signaltest",
+		ChatHost:    host,
+		StorageHost: host,
+		CDNHosts: map[uint32]string{
+			0: host,
+			2: host,
+			3: host,
+		},
+		ServerPublicParams: serializedPublicParams,
+		TrustRoots:         [][]byte{s.httpServer.Certificate().Raw},
+	}
+	err = s.env.Init()
+	if err != nil {
+		t.Fatalf("failed to initialize server environment: %v", err)
+	}
+	return s
+}
+
+// Env returns the server environment that clients must use to connect to this server.
+func (s *Server) Env() *web.ServerEnvironment {
+	return s.env
+}
+
+// Close disconnects all websockets and stops the server. It's called automatically when the test finishes.
+func (s *Server) Close() {
+	s.cancel()
+	s.httpServer.Close()
+}
+
+func (s *Server) registerRoutes() {
+	s.mux.HandleFunc("GET /v1/websocket/{$}", s.handleWebsocket)
+	s.mux.HandleFunc("GET /v1/websocket/provisioning/{$}", s.handleProvisioningWebsocket)
+	s.mux.HandleFunc("GET /v1/keepalive", s.handleKeepalive)
+
+	s.mux.HandleFunc("POST /v1/verification/session", s.handleCreateVerificationSession)
+	s.mux.HandleFunc("GET /v1/verification/session/{id}", s.handleGetVerificationSession)
+	s.mux.HandleFunc("PATCH /v1/verification/session/{id}", s.handleGetVerificationSession)
+	s.mux.HandleFunc("POST /v1/verification/session/{id}/code", s.handleRequestVerificationCode)
+	s.mux.HandleFunc("PUT /v1/verification/session/{id}/code", s.handleSubmitVerificationCode)
+	s.mux.HandleFunc("POST /v1/registration", s.handleRegistration)
+
+	s.mux.HandleFunc("GET /v1/devices/provisioning/code", s.handleGetLinkCode)
+	s.mux.HandleFunc("PUT /v1/provisioning/{address}", s.handleSendProvisioningMessage)
+	s.mux.HandleFunc("PUT /v1/devices/link", s.handleLinkDevice)
+	s.mux.HandleFunc("PUT /v1/devices/capabilities", s.handleSetCapabilities)
+	s.mux.HandleFunc("DELETE /v1/devices/{id}", s.handleDeleteDevice)
+	s.mux.HandleFunc("GET /v1/devices", s.handleListDevices)
+	s.mux.HandleFunc("PUT /v1/accounts/name", s.handleSetDeviceName)
+
+	s.mux.HandleFunc("PUT /v2/keys", s.handleUploadKeys)
+	s.mux.HandleFunc("GET /v2/keys", s.handleGetKeyCounts)
+	s.mux.HandleFunc("GET /v2/keys/{serviceID}/{deviceID}", s.handleGetPreKeys)
+
+	s.mux.HandleFunc("PUT /v1/messages/{destination}", s.handleSendMessage)
+	s.mux.HandleFunc("GET /v1/certificate/delivery", s.handleGetSenderCertificate)
+
+	s.mux.HandleFunc("PUT /v1/profile", s.handleSetProfile)
+	s.mux.HandleFunc("GET /v1/profile/{aci}", s.handleGetProfile)
+	s.mux.HandleFunc("GET /v1/profile/{aci}/{version}", s.handleGetProfile)
+	s.mux.HandleFunc("GET /v1/profile/{aci}/{version}/{credentialRequest}", s.handleGetProfile)
+
+	s.mux.HandleFunc("GET /v1/certificate/auth/group", s.handleGetGroupCredentials)
+	s.mux.HandleFunc("PUT /v1/groups/{$}", s.handleCreateGroup)
+	s.mux.HandleFunc("GET /v1/groups", s.handleGetGroup)
+	s.mux.HandleFunc("GET /v1/groups/{$}", s.handleGetGroup)
+	s.mux.HandleFunc("PATCH /v1/groups/{$}", s.handleModifyGroup)
+	s.mux.HandleFunc("GET /v1/groups/logs/{fromRevision}", s.handleGetGroupLogs)
+	s.mux.HandleFunc("GET /v1/groups/avatar/form", s.handleGetGroupAvatarForm)
+
+	s.mux.HandleFunc("GET /v4/attachments/form/upload", s.handleGetAttachmentUploadForm)
+	s.mux.HandleFunc("POST /cdn3/upload/{key}", s.handleTUSUpload)
+	s.mux.HandleFunc("POST /{$}", s.handleAvatarUpload)
+	s.mux.HandleFunc("GET /attachments/{key}", s.handleDownload)
+	s.mux.HandleFunc("GET /groups/{path...}", s.handleDownload)
+}
+
+func (s *Server) log(r *http.Request) *zerolog.Logger {
+	log := s.Log.With().
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Logger()
+	return &log
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int) {
+	writeJSON(w, status, map[string]any{
+		"code":    status,
+		"message": http.StatusText(status),
+	})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, into any) bool {
+	err := json.NewDecoder(r.Body).Decode(into)
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// authenticate finds the device whose basic auth credentials are in the request.
+// The username is either the ACI and device ID separated by a dot, or only the ACI for the primary device.
+// The server lock must not be held.
+func (s *Server) authenticate(r *http.Request) *device {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.authenticateLocked(username, password)
+}
+
+func (s *Server) authenticateLocked(username, password string) *device {
+	rawACI, rawDeviceID, hasDeviceID := strings.Cut(username, ".")
+	aci, err := uuid.Parse(rawACI)
+	if err != nil {
+		return nil
+	}
+	deviceID := 1
+	if hasDeviceID {
+		deviceID, err = strconv.Atoi(rawDeviceID)
+		if err != nil {
+			return nil
+		}
+	}
+	acc, ok := s.accounts[aci]
+	if !ok {
+		return nil
+	}
+	dev, ok := acc.devices[deviceID]
+	if !ok || subtle.ConstantTimeCompare([]byte(dev.password), []byte(password)) != 1 {
+		return nil
+	}
+	return dev
+}
+
+// requireAuth is like authenticate, but writes a 401 response if the credentials are invalid.
+func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) *device {
+	dev := s.authenticate(r)
+	if dev == nil {
+		writeError(w, http.StatusUnauthorized)
+	}
+	return dev
+}
+
+func (s *Server) senderCertificate(dev *device, includeE164 bool) ([]byte, error) {
+	identityKey, err := libsignalgo.DeserializePublicKey(dev.account.identities[identityACI].identityKey)
+	if err != nil {
+		return nil, err
+	}
+	var e164 string
+	if includeE164 {
+		e164 = dev.account.number
+	}
+	cert, err := libsignalgo.NewSenderCertificate(
+		libsignalgo.NewSealedSenderAddress(e164, dev.account.aci, uint32(dev.id)),
+		identityKey,
+		time.Now().Add(7*24*time.Hour),
+		s.serverCert,
+		s.certKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return cert.Serialize()
+}
+
+func (s *Server) handleGetSenderCertificate(w http.ResponseWriter, r *http.Request) {
+	dev := s.requireAuth(w, r)
+	if dev == nil {
+		return
+	}
+	s.lock.Lock()
+	cert, err := s.senderCertificate(dev, r.URL.Query().Get("includeE164") != "false")
+	s.lock.Unlock()
+	if err != nil {
+		s.log(r).Err(err).Msg("Failed to create sender certificate")
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"certificate": cert,
+	})
+}
@@ -0,0 +1,359 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/random"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/signaltest"
+)
+
+func textContent(body string) *signalpb.Content {
+	return &signalpb.Content{
+		DataMessage: &signalpb.DataMessage{
+			Body:      proto.String(body),
+			Timestamp: proto.Uint64(uint64(time.Now().UnixMilli())),
+		},
+	}
+}
+
+func waitForText(t *testing.T, cli *signaltest.Client, chatID, body string) *events.ChatEvent {
+	t.Helper()
+	return signaltest.WaitForEvent(t, cli, func(evt *events.ChatEvent) bool {
+		dm, ok := evt.Event.(*signalpb.DataMessage)
+		return ok && evt.Info.ChatID == chatID && dm.GetBody() == body
+	})
+}
+
+func TestDirectMessages(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	bob := srv.RegisterClient(t, "+15550000002")
+	aliceID := libsignalgo.NewACIServiceID(alice.Store.ACI)
+	bobID := libsignalgo.NewACIServiceID(bob.Store.ACI)
+
+	// Bob doesn't know Alice's profile key yet, so this isn't sealed sender
+	res := bob.SendMessage(ctx, aliceID, textContent("hello alice"))
+	require.True(t, res.WasSuccessful, res.Error)
+	assert.False(t, res.Unidentified)
+	evt := waitForText(t, alice, bobID.String(), "hello alice")
+	assert.Equal(t, bob.Store.ACI, evt.Info.Sender)
+
+	// Alice got Bob's profile key from the message, so the reply uses sealed sender
+	res = alice.SendMessage(ctx, bobID, textContent("hello bob"))
+	require.True(t, res.WasSuccessful, res.Error)
+	assert.True(t, res.Unidentified)
+	evt = waitForText(t, bob, aliceID.String(), "hello bob")
+	assert.Equal(t, alice.Store.ACI, evt.Info.Sender)
+
+	// Linking a new device makes the server reject the next send until Bob fetches its prekeys
+	aliceLaptop := srv.LinkClient(t, alice, "Alice's laptop")
+	assert.Equal(t, alice.Store.ACI, aliceLaptop.Store.ACI)
+	assert.NotEqual(t, alice.Store.DeviceID, aliceLaptop.Store.DeviceID)
+	res = bob.SendMessage(ctx, aliceID, textContent("hello again"))
+	require.True(t, res.WasSuccessful, res.Error)
+	waitForText(t, alice, bobID.String(), "hello again")
+	waitForText(t, aliceLaptop, bobID.String(), "hello again")
+}
+
+func TestMessageOrdering(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	senders := []*signaltest.Client{
+		srv.RegisterClient(t, "+15550000002"),
+		srv.RegisterClient(t, "+15550000003"),
+	}
+	aliceID := libsignalgo.NewACIServiceID(alice.Store.ACI)
+
+	// Messages from different senders may be handled in parallel, but each sender's messages must stay in order
+	const count = 10
+	for i := 0; i < count; i++ {
+		for _, sender := range senders {
+			res := sender.SendMessage(ctx, aliceID, textContent(fmt.Sprintf("message %d", i)))
+			require.True(t, res.WasSuccessful, res.Error)
+		}
+	}
+	next := make(map[string]int)
+	for received := 0; received < count*len(senders); received++ {
+		evt := signaltest.WaitForEvent[*events.ChatEvent](t, alice, nil)
+		body := evt.Event.(*signalpb.DataMessage).GetBody()
+		assert.Equal(t, fmt.Sprintf("message %d", next[evt.Info.ChatID]), body)
+		next[evt.Info.ChatID]++
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	const number = "+15550000001"
+
+	session, err := signalmeow.CreateVerificationSession(ctx, number)
+	require.NoError(t, err)
+	assert.False(t, session.Verified)
+	_, err = signalmeow.SubmitVerificationCode(ctx, session.ID, signaltest.VerificationCode)
+	var regErr *signalmeow.RegistrationError
+	require.ErrorAs(t, err, &regErr, "submitting a code before requesting one should fail")
+	assert.Equal(t, http.StatusConflict, regErr.StatusCode)
+
+	session, err = signalmeow.RequestVerificationCode(ctx, session.ID, signalmeow.VerificationTransportSMS, "")
+	require.NoError(t, err)
+	_, err = signalmeow.RegisterPrimaryDevice(ctx, signaltest.NewDeviceStore(t), number, session.ID, nil)
+	require.Error(t, err, "registering with an unverified session should fail")
+
+	session, err = signalmeow.SubmitVerificationCode(ctx, session.ID, "000000")
+	require.ErrorIs(t, err, signalmeow.ErrVerificationCodeIncorrect)
+	assert.False(t, session.Verified)
+	session, err = signalmeow.SubmitVerificationCode(ctx, session.ID, signaltest.VerificationCode)
+	require.NoError(t, err)
+	assert.True(t, session.Verified)
+
+	device, err := signalmeow.RegisterPrimaryDevice(ctx, signaltest.NewDeviceStore(t), number, session.ID, &signalmeow.RegistrationParams{
+		ProfileName: "Alice",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, number, device.Number)
+	assert.Equal(t, 1, device.DeviceID)
+
+	cli := &signalmeow.Client{Store: device, Environment: srv.Env()}
+	profile, err := cli.RetrieveProfileByID(ctx, device.ACI, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", profile.Name)
+}
+
+func TestProfilesAndGroups(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	bob := srv.RegisterClient(t, "+15550000002")
+	aliceID := libsignalgo.NewACIServiceID(alice.Store.ACI)
+	bobID := libsignalgo.NewACIServiceID(bob.Store.ACI)
+	require.NoError(t, srv.SetProfile(ctx, alice.Client, "Alice", "testing"))
+	require.NoError(t, srv.SetProfile(ctx, bob.Client, "Bob", ""))
+
+	// Exchange profile keys
+	require.True(t, bob.SendMessage(ctx, aliceID, textContent("hi")).WasSuccessful)
+	waitForText(t, alice, bobID.String(), "hi")
+	require.True(t, alice.SendMessage(ctx, bobID, textContent("hi")).WasSuccessful)
+	waitForText(t, bob, aliceID.String(), "hi")
+
+	profile, err := bob.RetrieveProfileByID(ctx, alice.Store.ACI, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", profile.Name)
+	assert.Equal(t, "testing", profile.About)
+	assert.NotEmpty(t, profile.Credential)
+
+	group, err := alice.CreateGroup(ctx, &signalmeow.Group{
+		Title: "Test group",
+		Members: []*signalmeow.GroupMember{
+			{ACI: alice.Store.ACI, Role: signalmeow.GroupMember_ADMINISTRATOR},
+			{ACI: bob.Store.ACI, Role: signalmeow.GroupMember_DEFAULT},
+		},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), group.Revision)
+
+	res, err := alice.SendGroupMessage(ctx, group.GroupIdentifier, textContent("hello group"))
+	require.NoError(t, err)
+	require.Len(t, res.SuccessfullySentTo, 1)
+	waitForText(t, bob, string(group.GroupIdentifier), "hello group")
+
+	bobGroup, err := bob.RetrieveGroupByID(ctx, group.GroupIdentifier, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "Test group", bobGroup.Title)
+	assert.Len(t, bobGroup.Members, 2)
+
+	revision, err := bob.UpdateGroup(ctx, &signalmeow.GroupChange{
+		ModifyTitle: proto.String("Renamed group"),
+	}, group.GroupIdentifier)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), revision)
+	history, err := alice.GetGroupHistoryPage(ctx, group.GroupIdentifier, 0, true)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "Test group", history[0].GroupState.Title)
+	assert.Equal(t, "Renamed group", *history[1].GroupChange.ModifyTitle)
+}
+
+func TestUpdateGroupTwice(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	bob := srv.RegisterClient(t, "+15550000002")
+
+	group, err := alice.CreateGroup(ctx, &signalmeow.Group{
+		Title: "Test group",
+		Members: []*signalmeow.GroupMember{
+			{ACI: alice.Store.ACI, Role: signalmeow.GroupMember_ADMINISTRATOR},
+			{ACI: bob.Store.ACI, Role: signalmeow.GroupMember_DEFAULT},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	// Each update invalidates the cached group, so the second one must see the revision of the first
+	for i, title := range []string{"First title", "Second title"} {
+		revision, err := alice.UpdateGroup(ctx, &signalmeow.GroupChange{
+			ModifyTitle: proto.String(title),
+		}, group.GroupIdentifier)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i+1), revision)
+	}
+	updated, err := alice.RetrieveGroupByID(ctx, group.GroupIdentifier, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "Second title", updated.Title)
+	assert.Equal(t, uint32(2), updated.Revision)
+}
+
+func TestDevices(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	laptop := srv.LinkClient(t, alice, "Alice's laptop")
+	laptopID := laptop.Store.DeviceID
+
+	getNames := func(cli *signaltest.Client) map[int]string {
+		devices, err := cli.ListDevices(ctx)
+		require.NoError(t, err)
+		names := make(map[int]string, len(devices))
+		for _, dev := range devices {
+			names[dev.ID] = dev.Name
+		}
+		return names
+	}
+	assert.Equal(t, map[int]string{1: "", laptopID: "Alice's laptop"}, getNames(alice))
+
+	// Linked devices can only rename themselves
+	err := laptop.RenameDevice(ctx, signalmeow.PrimaryDeviceID, "Phone")
+	assert.ErrorIs(t, err, signalmeow.ErrNotPrimaryDevice)
+	require.NoError(t, laptop.RenameDevice(ctx, laptopID, "Laptop"))
+	assert.Equal(t, "Laptop", getNames(laptop)[laptopID])
+
+	require.NoError(t, alice.RenameDevice(ctx, laptopID, "Work laptop"))
+	require.NoError(t, alice.RenameDevice(ctx, signalmeow.PrimaryDeviceID, "Phone"))
+	assert.Equal(t, map[int]string{1: "Phone", laptopID: "Work laptop"}, getNames(laptop))
+	assert.Error(t, alice.RenameDevice(ctx, laptopID+1, "Nonexistent"))
+}
+
+func TestAttachments(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+
+	data := random.Bytes(100_000)
+	pointer, err := alice.UploadAttachment(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), pointer.GetCdnNumber())
+	downloaded, err := signalmeow.DownloadAttachmentWithPointer(ctx, pointer)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+}
+
+func TestAttachmentDownloadResume(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+
+	data := random.Bytes(100_000)
+	pointer, err := alice.UploadAttachment(ctx, data)
+	require.NoError(t, err)
+	srv.InterruptDownloads(2, 30_000)
+	downloaded, err := signalmeow.DownloadAttachmentWithPointer(ctx, pointer)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	// Each retry must continue from where the previous response was cut off instead of starting over
+	assert.Equal(t, []string{"", "bytes=30000-", "bytes=60000-"}, srv.DownloadRanges())
+}
+
+func TestAttachmentDownloadResumeCorrupted(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+
+	pointer, err := alice.UploadAttachment(ctx, random.Bytes(100_000))
+	require.NoError(t, err)
+	// The MAC and digest cover the whole file, so corrupted data in a resumed response must be detected
+	srv.InterruptDownloads(1, 30_000)
+	srv.CorruptRangeRequests()
+	_, err = signalmeow.DownloadAttachmentWithPointer(ctx, pointer)
+	assert.ErrorIs(t, err, signalmeow.ErrInvalidMACForAttachment)
+	assert.Equal(t, []string{"", "bytes=30000-"}, srv.DownloadRanges())
+}
+
+func TestForceReconnect(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	bob := srv.RegisterClient(t, "+15550000002")
+	aliceID := libsignalgo.NewACIServiceID(alice.Store.ACI)
+	bobID := libsignalgo.NewACIServiceID(bob.Store.ACI)
+
+	// Messages sent while reconnecting are delivered once the new connection is up
+	alice.ForceReconnect()
+	res := bob.SendMessage(ctx, aliceID, textContent("after reconnect"))
+	require.True(t, res.WasSuccessful, res.Error)
+	waitForText(t, alice, bobID.String(), "after reconnect")
+}
+
+func TestSubscriptions(t *testing.T) {
+	srv := signaltest.NewServer(t)
+	ctx := srv.Env().WithContext(context.Background())
+	alice := srv.RegisterClient(t, "+15550000001")
+	bob := srv.RegisterClient(t, "+15550000002")
+	carol := srv.RegisterClient(t, "+15550000003")
+	aliceID := libsignalgo.NewACIServiceID(alice.Store.ACI)
+
+	fromBob := make(chan string, 10)
+	unsubscribe := signalmeow.Subscribe(alice.Client, func(evt *events.ChatEvent) bool {
+		if dm, ok := evt.Event.(*signalpb.DataMessage); ok {
+			fromBob <- dm.GetBody()
+		}
+		return true
+	}, &signalmeow.SubscribeOptions{
+		Senders:   []uuid.UUID{bob.Store.ACI},
+		QueueSize: 10,
+	})
+	defer unsubscribe()
+
+	require.True(t, carol.SendMessage(ctx, aliceID, textContent("from carol")).WasSuccessful)
+	require.True(t, bob.SendMessage(ctx, aliceID, textContent("from bob 1")).WasSuccessful)
+	require.True(t, bob.SendMessage(ctx, aliceID, textContent("from bob 2")).WasSuccessful)
+	for _, expected := range []string{"from bob 1", "from bob 2"} {
+		select {
+		case body := <-fromBob:
+			assert.Equal(t, expected, body)
+		case <-time.After(signaltest.DefaultTimeout):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+	// The main event handler still receives everything
+	waitForText(t, alice, libsignalgo.NewACIServiceID(carol.Store.ACI).String(), "from carol")
+}
@@ -0,0 +1,334 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signaltest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.mau.fi/util/random"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/wspb"
+)
+
+// requestTimeout is how long the server waits for the client to respond to a websocket request.
+const requestTimeout = 30 * time.Second
+
+type wsConn struct {
+	conn *websocket.Conn
+	dev  *device
+
+	username string
+	password string
+
+	nextRequestID atomic.Uint64
+	responseLock  sync.Mutex
+	responses     map[uint64]chan *signalpb.WebSocketResponseMessage
+}
+
+func (s *Server) acceptWebsocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(8 << 20)
+	return &wsConn{
+		conn:      conn,
+		responses: make(map[uint64]chan *signalpb.WebSocketResponseMessage),
+	}, nil
+}
+
+func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	username, password, authed := r.BasicAuth()
+	var dev *device
+	if authed {
+		dev = s.authenticate(r)
+		if dev == nil {
+			// The client treats a 403 when connecting as being logged out
+			writeError(w, http.StatusForbidden)
+			return
+		}
+	}
+	wc, err := s.acceptWebsocket(w, r)
+	if err != nil {
+		s.log(r).Err(err).Msg("Failed to accept websocket")
+		return
+	}
+	wc.dev = dev
+	wc.username = username
+	wc.password = password
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if dev != nil {
+		go s.deliveryLoop(ctx, wc)
+	}
+	err = s.readLoop(ctx, wc)
+	if err != nil && ctx.Err() == nil && websocket.CloseStatus(err) == -1 {
+		s.log(r).Debug().Err(err).Msg("Websocket read loop exited")
+	}
+	_ = wc.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (s *Server) readLoop(ctx context.Context, wc *wsConn) error {
+	for {
+		var msg signalpb.WebSocketMessage
+		err := wspb.Read(ctx, wc.conn, &msg)
+		if err != nil {
+			return err
+		}
+		switch msg.GetType() {
+		case signalpb.WebSocketMessage_REQUEST:
+			go s.handleWebsocketRequest(ctx, wc, msg.GetRequest())
+		case signalpb.WebSocketMessage_RESPONSE:
+			wc.responseLock.Lock()
+			ch, ok := wc.responses[msg.GetResponse().GetId()]
+			delete(wc.responses, msg.GetResponse().GetId())
+			wc.responseLock.Unlock()
+			if ok {
+				ch <- msg.GetResponse()
+			}
+		default:
+			return fmt.Errorf("unexpected websocket message type %s", msg.GetType())
+		}
+	}
+}
+
+// handleWebsocketRequest dispatches a request sent over the websocket to the same handlers that serve plain HTTP.
+func (s *Server) handleWebsocketRequest(ctx context.Context, wc *wsConn, wsReq *signalpb.WebSocketRequestMessage) {
+	status, headers, body := s.serveWebsocketRequest(ctx, wc, wsReq)
+	msgType := signalpb.WebSocketMessage_RESPONSE
+	err := wspb.Write(ctx, wc.conn, &signalpb.WebSocketMessage{
+		Type: &msgType,
+		Response: &signalpb.WebSocketResponseMessage{
+			Id:      proto.Uint64(wsReq.GetId()),
+			Status:  proto.Uint32(uint32(status)),
+			Message: proto.String(http.StatusText(status)),
+			Headers: headers,
+			Body:    body,
+		},
+	})
+	if err != nil && ctx.Err() == nil {
+		s.Log.Debug().Err(err).Str("path", wsReq.GetPath()).Msg("Failed to write websocket response")
+	}
+}
+
+func (s *Server) serveWebsocketRequest(ctx context.Context, wc *wsConn, wsReq *signalpb.WebSocketRequestMessage) (int, []string, []byte) {
+	req, err := http.NewRequestWithContext(ctx, wsReq.GetVerb(), "https://"+s.env.ChatHost+wsReq.GetPath(), bytes.NewReader(wsReq.GetBody()))
+	if err != nil {
+		return http.StatusBadRequest, nil, nil
+	}
+	for _, header := range wsReq.GetHeaders() {
+		name, value, ok := strings.Cut(header, ":")
+		if ok {
+			req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	if wc.dev != nil {
+		// Requests on the authenticated websocket always use the credentials of the connection.
+		req.SetBasicAuth(wc.username, wc.password)
+	}
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	var headers []string
+	if contentType := rec.Header().Get("Content-Type"); contentType != "" {
+		headers = append(headers, "content-type:"+contentType)
+	}
+	return rec.Code, headers, rec.Body.Bytes()
+}
+
+func (s *Server) handleKeepalive(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendRequest sends a request to the client and waits for the response.
+func (wc *wsConn) sendRequest(ctx context.Context, verb, path string, body []byte) (*signalpb.WebSocketResponseMessage, error) {
+	id := wc.nextRequestID.Add(1)
+	ch := make(chan *signalpb.WebSocketResponseMessage, 1)
+	wc.responseLock.Lock()
+	wc.responses[id] = ch
+	wc.responseLock.Unlock()
+	defer func() {
+		wc.responseLock.Lock()
+		delete(wc.responses, id)
+		wc.responseLock.Unlock()
+	}()
+
+	msgType := signalpb.WebSocketMessage_REQUEST
+	err := wspb.Write(ctx, wc.conn, &signalpb.WebSocketMessage{
+		Type: &msgType,
+		Request: &signalpb.WebSocketRequestMessage{
+			Id:      &id,
+			Verb:    &verb,
+			Path:    &path,
+			Body:    body,
+			Headers: []string{},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(requestTimeout):
+		return nil, fmt.Errorf("timed out waiting for response to %s %s", verb, path)
+	}
+}
+
+// deliveryLoop sends queued envelopes to an authenticated device one by one.
+// An envelope is only removed from the queue after the client acknowledges it.
+func (s *Server) deliveryLoop(ctx context.Context, wc *wsConn) {
+	log := s.Log.With().
+		Stringer("aci", wc.dev.account.aci).
+		Int("device_id", wc.dev.id).
+		Logger()
+	sentQueueEmpty := false
+	for {
+		s.lock.Lock()
+		var envelope *signalpb.Envelope
+		if len(wc.dev.queue) > 0 {
+			envelope = wc.dev.queue[0]
+		}
+		notify := wc.dev.notify
+		s.lock.Unlock()
+
+		if envelope == nil {
+			if !sentQueueEmpty {
+				_, err := wc.sendRequest(ctx, http.MethodPut, "/api/v1/queue/empty", nil)
+				if err != nil {
+					log.Debug().Err(err).Msg("Failed to send queue empty notice")
+					_ = wc.conn.Close(websocket.StatusGoingAway, "")
+					return
+				}
+				sentQueueEmpty = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-notify:
+				if !ok {
+					// The device was unlinked or the account was re-registered
+					_ = wc.conn.Close(websocket.StatusPolicyViolation, "device removed")
+					return
+				}
+			}
+			continue
+		}
+
+		data, err := proto.Marshal(envelope)
+		if err != nil {
+			log.Err(err).Msg("Failed to marshal envelope")
+			return
+		}
+		resp, err := wc.sendRequest(ctx, http.MethodPut, "/api/v1/message", data)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Debug().Err(err).Msg("Failed to deliver envelope")
+			}
+			_ = wc.conn.Close(websocket.StatusGoingAway, "")
+			return
+		} else if resp.GetStatus() != http.StatusOK {
+			// The real server would retry later, but dropping is more useful for making tests fail fast.
+			log.Warn().Uint32("status", resp.GetStatus()).Msg("Client rejected envelope, dropping it")
+		}
+		s.lock.Lock()
+		if len(wc.dev.queue) > 0 && wc.dev.queue[0] == envelope {
+			wc.dev.queue = wc.dev.queue[1:]
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *Server) handleProvisioningWebsocket(w http.ResponseWriter, r *http.Request) {
+	wc, err := s.acceptWebsocket(w, r)
+	if err != nil {
+		s.log(r).Err(err).Msg("Failed to accept provisioning websocket")
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		_ = s.readLoop(ctx, wc)
+		cancel()
+	}()
+
+	address := base64.RawURLEncoding.EncodeToString(random.Bytes(16))
+	messageChan := make(chan []byte, 1)
+	s.lock.Lock()
+	s.provisioning[address] = messageChan
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.provisioning, address)
+		s.lock.Unlock()
+	}()
+
+	addressBody, _ := proto.Marshal(&signalpb.ProvisioningAddress{Address: &address})
+	_, err = wc.sendRequest(ctx, http.MethodPut, "/v1/address", addressBody)
+	if err != nil {
+		_ = wc.conn.Close(websocket.StatusGoingAway, "")
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case message := <-messageChan:
+		_, err = wc.sendRequest(ctx, http.MethodPut, "/v1/message", message)
+		if err != nil {
+			s.log(r).Debug().Err(err).Msg("Failed to send provisioning message")
+		}
+	}
+	_ = wc.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (s *Server) handleSendProvisioningMessage(w http.ResponseWriter, r *http.Request) {
+	if s.requireAuth(w, r) == nil {
+		return
+	}
+	var req struct {
+		Body []byte `json:"body"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.lock.Lock()
+	messageChan, ok := s.provisioning[r.PathValue("address")]
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	select {
+	case messageChan <- req.Body:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusConflict)
+	}
+}
@@ -0,0 +1,250 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/hkdf"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+const (
+	stickerManifestPath = "/stickers/%s/manifest.proto"
+	stickerDataPath     = "/stickers/%s/full/%d"
+
+	// maxStickerDownloadSize is the maximum size of an encrypted sticker or manifest.
+	maxStickerDownloadSize = 1024 * 1024
+	// stickerCacheMaxBytes is the total size of decrypted sticker images and manifests kept in memory.
+	stickerCacheMaxBytes = 32 * 1024 * 1024
+)
+
+// StickerPackURL returns the signal.art link that can be used to install the given sticker pack.
+func StickerPackURL(packID, packKey []byte) string {
+	return fmt.Sprintf("https://signal.art/addstickers/#pack_id=%x&pack_key=%x", packID, packKey)
+}
+
+func deriveStickerPackKeys(packKey []byte) ([]byte, error) {
+	keys := make([]byte, 64)
+	_, err := io.ReadFull(hkdf.New(sha256.New, packKey, nil, []byte("Sticker Pack")), keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func downloadStickerData(ctx context.Context, path string, packKey []byte) ([]byte, error) {
+	if cached := stickerCache.get(path); cached != nil {
+		return cached, nil
+	}
+	keys, err := deriveStickerPackKeys(packKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive sticker pack keys: %w", err)
+	}
+	resp, err := web.GetAttachment(ctx, path, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return nil, ErrAttachmentNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	encrypted, err := io.ReadAll(io.LimitReader(resp.Body, maxStickerDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	} else if len(encrypted) > maxStickerDownloadSize {
+		return nil, fmt.Errorf("sticker data too large")
+	}
+	decrypter := aesDecryptStream([32]byte(keys[:32]), [32]byte(keys[32:]), bytes.NewReader(encrypted), int64(len(encrypted)))
+	data, err := io.ReadAll(decrypter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	stickerCache.put(path, data)
+	return data, nil
+}
+
+// DownloadStickerPackManifest downloads and decrypts the manifest of a sticker pack.
+func DownloadStickerPackManifest(ctx context.Context, packID, packKey []byte) (*signalpb.Pack, error) {
+	data, err := downloadStickerData(ctx, fmt.Sprintf(stickerManifestPath, hex.EncodeToString(packID)), packKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest: %w", err)
+	}
+	var pack signalpb.Pack
+	err = proto.Unmarshal(data, &pack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	return &pack, nil
+}
+
+// DownloadSticker downloads and decrypts a single sticker image from a pack.
+// Recently used stickers are cached in memory.
+func DownloadSticker(ctx context.Context, packID, packKey []byte, stickerID uint32) ([]byte, error) {
+	data, err := downloadStickerData(ctx, fmt.Sprintf(stickerDataPath, hex.EncodeToString(packID), stickerID), packKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download sticker: %w", err)
+	}
+	return data, nil
+}
+
+// InstallStickerPack saves the sticker pack as installed and tells other devices to install it too.
+func (cli *Client) InstallStickerPack(ctx context.Context, packID, packKey []byte) (*store.StickerPack, error) {
+	manifest, err := DownloadStickerPackManifest(ctx, packID, packKey)
+	if err != nil {
+		return nil, err
+	}
+	pack := &store.StickerPack{ID: packID, Key: packKey, Manifest: manifest}
+	err = cli.Store.StickerStore.PutStickerPack(ctx, pack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save sticker pack: %w", err)
+	}
+	err = cli.sendStickerPackOperation(ctx, packID, packKey, signalpb.SyncMessage_StickerPackOperation_INSTALL)
+	if err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// RemoveStickerPack removes the sticker pack from the account and tells other devices to remove it too.
+func (cli *Client) RemoveStickerPack(ctx context.Context, packID []byte) error {
+	pack, err := cli.Store.StickerStore.GetStickerPack(ctx, packID)
+	if err != nil {
+		return fmt.Errorf("failed to get sticker pack: %w", err)
+	} else if pack == nil {
+		return nil
+	}
+	err = cli.Store.StickerStore.DeleteStickerPack(ctx, packID)
+	if err != nil {
+		return fmt.Errorf("failed to delete sticker pack: %w", err)
+	}
+	return cli.sendStickerPackOperation(ctx, pack.ID, pack.Key, signalpb.SyncMessage_StickerPackOperation_REMOVE)
+}
+
+func (cli *Client) sendStickerPackOperation(ctx context.Context, packID, packKey []byte, opType signalpb.SyncMessage_StickerPackOperation_Type) error {
+	_, err := cli.sendContent(ctx, cli.Store.ACIServiceID(), uint64(time.Now().UnixMilli()), &signalpb.Content{
+		SyncMessage: &signalpb.SyncMessage{
+			StickerPackOperation: []*signalpb.SyncMessage_StickerPackOperation{{
+				PackId:  packID,
+				PackKey: packKey,
+				Type:    opType.Enum(),
+			}},
+		},
+	}, 0, false, false)
+	if err != nil {
+		return fmt.Errorf("failed to send sticker pack sync message: %w", err)
+	}
+	return nil
+}
+
+func (cli *Client) handleStickerPackOperations(ctx context.Context, ops []*signalpb.SyncMessage_StickerPackOperation) bool {
+	log := zerolog.Ctx(ctx)
+	handlerSuccess := true
+	for _, op := range ops {
+		evt := &events.StickerPackUpdate{
+			PackID:    op.GetPackId(),
+			PackKey:   op.GetPackKey(),
+			Installed: op.GetType() == signalpb.SyncMessage_StickerPackOperation_INSTALL,
+		}
+		var err error
+		if evt.Installed {
+			evt.Manifest, err = DownloadStickerPackManifest(ctx, evt.PackID, evt.PackKey)
+			if err != nil {
+				log.Err(err).Hex("pack_id", evt.PackID).Msg("Failed to download manifest of installed sticker pack")
+			}
+			err = cli.Store.StickerStore.PutStickerPack(ctx, &store.StickerPack{
+				ID:       evt.PackID,
+				Key:      evt.PackKey,
+				Manifest: evt.Manifest,
+			})
+		} else {
+			err = cli.Store.StickerStore.DeleteStickerPack(ctx, evt.PackID)
+		}
+		if err != nil {
+			log.Err(err).Hex("pack_id", evt.PackID).Msg("Failed to update sticker pack in store")
+			continue
+		}
+		handlerSuccess = cli.handleEvent(evt) && handlerSuccess
+	}
+	return handlerSuccess
+}
+
+type stickerCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// lruByteCache is a size-limited least-recently-used cache for downloaded data.
+type lruByteCache struct {
+	lock     sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List
+	size     int
+	maxBytes int
+}
+
+var stickerCache = &lruByteCache{
+	items:    make(map[string]*list.Element),
+	order:    list.New(),
+	maxBytes: stickerCacheMaxBytes,
+}
+
+func (c *lruByteCache) get(key string) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*stickerCacheEntry).data
+}
+
+func (c *lruByteCache) put(key string, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.size -= len(elem.Value.(*stickerCacheEntry).data)
+		c.order.Remove(elem)
+	}
+	c.items[key] = c.order.PushFront(&stickerCacheEntry{key: key, data: data})
+	c.size += len(data)
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*stickerCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.items, entry.key)
+		c.size -= len(entry.data)
+	}
+}
@@ -0,0 +1,195 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+const (
+	// MaxStickersPerPack is the maximum number of stickers the server allows in a single pack.
+	MaxStickersPerPack = 200
+	// MaxStickerSize is the maximum size of a single unencrypted sticker image.
+	MaxStickerSize = 300 * 1024
+	// StickerDimension is the width and height that sticker images should have.
+	StickerDimension = 512
+)
+
+type StickerUpload struct {
+	Data        []byte
+	ContentType string
+	Emoji       string
+}
+
+type stickerUploadAttributes struct {
+	ID         int    `json:"id"`
+	Key        string `json:"key"`
+	Credential string `json:"credential"`
+	ACL        string `json:"acl"`
+	Algorithm  string `json:"algorithm"`
+	Date       string `json:"date"`
+	Policy     string `json:"policy"`
+	Signature  string `json:"signature"`
+}
+
+type stickerPackUploadForm struct {
+	PackID   string                     `json:"packId"`
+	Manifest stickerUploadAttributes    `json:"manifest"`
+	Stickers []*stickerUploadAttributes `json:"stickers"`
+}
+
+// UploadStickerPack encrypts and uploads a new sticker pack, then installs it on the account.
+// The first sticker is used as the cover of the pack.
+//
+// The sticker images must already be in a format Signal supports (WebP, PNG or APNG)
+// and no larger than MaxStickerSize.
+func (cli *Client) UploadStickerPack(ctx context.Context, title, author string, stickers []*StickerUpload) (*store.StickerPack, error) {
+	log := zerolog.Ctx(ctx).With().Str("func", "upload sticker pack").Logger()
+	if len(stickers) == 0 {
+		return nil, fmt.Errorf("no stickers to upload")
+	} else if len(stickers) > MaxStickersPerPack {
+		return nil, fmt.Errorf("too many stickers (%d, max %d)", len(stickers), MaxStickersPerPack)
+	}
+	for i, sticker := range stickers {
+		if len(sticker.Data) > MaxStickerSize {
+			return nil, fmt.Errorf("sticker #%d is too large (%d bytes, max %d)", i+1, len(sticker.Data), MaxStickerSize)
+		}
+	}
+
+	username, password := cli.Store.BasicAuthCreds()
+	resp, err := cli.sendRequest(ctx, http.MethodGet, fmt.Sprintf("/v1/sticker/pack/form/%d", len(stickers)), &web.HTTPReqOpt{
+		Username: &username,
+		Password: &password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request upload form: %w", err)
+	}
+	var form stickerPackUploadForm
+	err = web.DecodeHTTPResponseBody(ctx, &form, resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode upload form: %w", err)
+	} else if len(form.Stickers) != len(stickers) {
+		return nil, fmt.Errorf("server returned %d sticker upload forms, expected %d", len(form.Stickers), len(stickers))
+	}
+	packID, err := hex.DecodeString(form.PackID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pack ID: %w", err)
+	}
+	packKey := random.Bytes(32)
+	keys, err := deriveStickerPackKeys(packKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive sticker pack keys: %w", err)
+	}
+
+	manifest := &signalpb.Pack{
+		Title:    proto.String(title),
+		Author:   proto.String(author),
+		Stickers: make([]*signalpb.Pack_Sticker, len(stickers)),
+	}
+	for i, sticker := range stickers {
+		attrs := form.Stickers[i]
+		manifest.Stickers[i] = &signalpb.Pack_Sticker{
+			Id:          proto.Uint32(uint32(attrs.ID)),
+			Emoji:       proto.String(sticker.Emoji),
+			ContentType: proto.String(sticker.ContentType),
+		}
+		err = cli.uploadStickerFile(ctx, attrs, keys, sticker.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload sticker #%d: %w", i+1, err)
+		}
+		log.Debug().Int("sticker_id", attrs.ID).Msg("Uploaded sticker")
+	}
+	manifest.Cover = manifest.Stickers[0]
+	manifestBytes, err := proto.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	err = cli.uploadStickerFile(ctx, &form.Manifest, keys, manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	log.Info().Hex("pack_id", packID).Int("sticker_count", len(stickers)).Msg("Uploaded sticker pack")
+
+	pack := &store.StickerPack{ID: packID, Key: packKey, Manifest: manifest}
+	err = cli.Store.StickerStore.PutStickerPack(ctx, pack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save sticker pack: %w", err)
+	}
+	err = cli.sendStickerPackOperation(ctx, packID, packKey, signalpb.SyncMessage_StickerPackOperation_INSTALL)
+	if err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// uploadStickerFile encrypts a sticker or manifest with the pack keys and uploads it to the CDN using the S3 form.
+// Unlike normal attachments, sticker data is not padded.
+func (cli *Client) uploadStickerFile(ctx context.Context, attrs *stickerUploadAttributes, keys, data []byte) error {
+	var encrypted bytes.Buffer
+	_, err := encryptAttachmentStream(keys, bytes.NewReader(data), int64(len(data)), int64(len(data)), &encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	requestBody := &bytes.Buffer{}
+	w := multipart.NewWriter(requestBody)
+	_ = w.WriteField("key", attrs.Key)
+	_ = w.WriteField("x-amz-credential", attrs.Credential)
+	_ = w.WriteField("acl", attrs.ACL)
+	_ = w.WriteField("x-amz-algorithm", attrs.Algorithm)
+	_ = w.WriteField("x-amz-date", attrs.Date)
+	_ = w.WriteField("policy", attrs.Policy)
+	_ = w.WriteField("x-amz-signature", attrs.Signature)
+	_ = w.WriteField("Content-Type", "application/octet-stream")
+	fileWriter, err := w.CreateFormFile("file", "file")
+	if err != nil {
+		return err
+	}
+	_, err = fileWriter.Write(encrypted.Bytes())
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodPost, "", &web.HTTPReqOpt{
+		Body:        requestBody.Bytes(),
+		ContentType: web.ContentType(w.FormDataContentType()),
+		Host:        cli.env().CDNHost(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send upload request: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upload request returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
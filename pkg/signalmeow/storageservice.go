@@ -35,7 +35,9 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
 	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/store"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/types"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
 )
@@ -43,22 +45,91 @@ import (
 func (cli *Client) SyncStorage(ctx context.Context) {
 	log := cli.Log.With().Str("action", "sync storage").Logger()
 	ctx = log.WithContext(ctx)
-	// TODO only fetch changed entries
-	update, err := cli.FetchStorage(ctx, cli.Store.MasterKey, 0, nil)
+	cli.storageSyncLock.Lock()
+	defer cli.storageSyncLock.Unlock()
+	currentVersion, err := cli.Store.StorageStore.GetStorageVersion(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get current storage version")
+		return
+	}
+	existingKeys, err := cli.Store.StorageStore.GetStorageRecordIDs(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get existing storage record IDs")
+		return
+	}
+	if len(existingKeys) == 0 {
+		// Always fetch the full manifest if we don't have any records stored
+		currentVersion = 0
+	}
+	update, err := cli.FetchStorage(ctx, cli.Store.MasterKey, currentVersion, existingKeys)
 	if err != nil {
 		log.Err(err).Msg("Failed to fetch storage")
 		return
+	} else if update == nil {
+		log.Debug().Uint64("version", currentVersion).Msg("Storage is already up to date")
+		return
 	}
+	var evts []events.SignalEvent
 	err = cli.Store.DoContactTxn(ctx, func(ctx context.Context) error {
-		return cli.processStorageInTxn(ctx, update)
+		evts, err = cli.processStorageInTxn(ctx, update)
+		return err
 	})
 	if err != nil {
 		log.Err(err).Msg("Failed to process storage update")
+		return
+	}
+	log.Debug().
+		Uint64("previous_version", currentVersion).
+		Uint64("new_version", update.Version).
+		Int("new_records", len(update.NewRecords)).
+		Int("removed_records", len(update.RemovedRecords)).
+		Int("missing_records", len(update.MissingRecords)).
+		Msg("Processed storage update")
+	for _, evt := range evts {
+		cli.handleEvent(evt)
 	}
 }
 
-func (cli *Client) processStorageInTxn(ctx context.Context, update *StorageUpdate) error {
+func (cli *Client) processStorageInTxn(ctx context.Context, update *StorageUpdate) ([]events.SignalEvent, error) {
 	log := zerolog.Ctx(ctx)
+	// Records are replaced with a new storage ID whenever they change,
+	// so removed records are only treated as deletions if there's no new record for the same contact or group.
+	removedContacts := make(map[uuid.UUID]*signalpb.ContactRecord)
+	removedGroups := make(map[types.GroupIdentifier]struct{})
+	for _, storageID := range update.RemovedRecords {
+		record, err := cli.Store.StorageStore.GetStorageRecord(ctx, storageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get removed storage record %s: %w", storageID, err)
+		} else if record == nil {
+			continue
+		}
+		err = cli.Store.StorageStore.DeleteStorageRecord(ctx, storageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete removed storage record %s: %w", storageID, err)
+		}
+		switch data := record.Record.GetRecord().(type) {
+		case *signalpb.StorageRecord_Contact:
+			aci, _ := uuid.Parse(data.Contact.Aci)
+			pni, _ := uuid.Parse(data.Contact.Pni)
+			if aci != uuid.Nil {
+				removedContacts[aci] = data.Contact
+			}
+			if pni != uuid.Nil {
+				removedContacts[pni] = data.Contact
+			}
+		case *signalpb.StorageRecord_GroupV2:
+			if len(data.GroupV2.MasterKey) != libsignalgo.GroupMasterKeyLength {
+				continue
+			}
+			groupID, err := groupIdentifierFromMasterKey(masterKeyFromBytes(libsignalgo.GroupMasterKey(data.GroupV2.MasterKey)))
+			if err != nil {
+				log.Warn().Err(err).Str("item_id", storageID).Msg("Failed to get group ID of removed storage record")
+				continue
+			}
+			removedGroups[groupID] = struct{}{}
+		}
+	}
+	var hiddenContacts []*signalpb.ContactRecord
 	for _, record := range update.NewRecords {
 		switch data := record.StorageRecord.GetRecord().(type) {
 		case *signalpb.StorageRecord_Contact:
@@ -71,7 +142,16 @@ func (cli *Client) processStorageInTxn(ctx context.Context, update *StorageUpdat
 					Str("raw_pni", data.Contact.Pni).
 					Str("raw_e164", data.Contact.E164).
 					Msg("Storage service has contact record with no ACI or PNI")
-				continue
+				break
+			}
+			previous := removedContacts[aci]
+			if previous == nil {
+				previous = removedContacts[pni]
+			}
+			delete(removedContacts, aci)
+			delete(removedContacts, pni)
+			if data.Contact.Hidden && previous != nil && !previous.Hidden {
+				hiddenContacts = append(hiddenContacts, data.Contact)
 			}
 			contact := data.Contact
 			_, err := cli.Store.RecipientStore.LoadAndUpdateRecipient(ctx, aci, pni, func(recipient *types.Recipient) (changed bool, err error) {
@@ -95,34 +175,92 @@ func (cli *Client) processStorageInTxn(ctx context.Context, update *StorageUpdat
 				return
 			})
 			if err != nil {
-				return fmt.Errorf("failed to update contact %s/%s: %w", aci, pni, err)
+				return nil, fmt.Errorf("failed to update contact %s/%s: %w", aci, pni, err)
 			}
 		case *signalpb.StorageRecord_GroupV2:
 			if len(data.GroupV2.MasterKey) != libsignalgo.GroupMasterKeyLength {
 				log.Warn().Msg("Invalid group master key length")
-				continue
+				break
 			}
 			masterKey := libsignalgo.GroupMasterKey(data.GroupV2.MasterKey)
 			groupID, err := cli.StoreMasterKey(ctx, masterKeyFromBytes(masterKey))
 			if err != nil {
-				return fmt.Errorf("failed to store group master key for %s: %w", groupID, err)
+				return nil, fmt.Errorf("failed to store group master key for %s: %w", groupID, err)
 			}
+			delete(removedGroups, groupID)
 			log.Debug().Stringer("group_id", groupID).Msg("Stored group master key from storage service")
 		case *signalpb.StorageRecord_Account:
 			log.Trace().Any("account_record", data.Account).Msg("Found account record")
 			cli.Store.AccountRecord = data.Account
 			err := cli.Store.DeviceStore.PutDevice(ctx, &cli.Store.DeviceData)
 			if err != nil {
-				return fmt.Errorf("failed to save device after receiving account record: %w", err)
+				return nil, fmt.Errorf("failed to save device after receiving account record: %w", err)
 			}
 			log.Debug().Msg("Saved device after receiving account record")
 		case *signalpb.StorageRecord_GroupV1, *signalpb.StorageRecord_StoryDistributionList:
 			// irrelevant data
 		default:
-			log.Warn().Type("type", data).Str("item_id", record.StorageID).Msg("Unknown storage record type")
+			log.Debug().
+				Type("type", data).
+				Stringer("item_type", record.ItemType).
+				Str("item_id", record.StorageID).
+				Msg("Storing unknown storage record type as-is")
+		}
+		err := cli.Store.StorageStore.PutStorageRecord(ctx, &store.StorageRecord{
+			StorageID: record.StorageID,
+			ItemType:  record.ItemType,
+			Record:    record.StorageRecord,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to save storage record %s: %w", record.StorageID, err)
 		}
 	}
-	return nil
+
+	var evts []events.SignalEvent
+	handledContacts := make(map[*signalpb.ContactRecord]struct{}, len(removedContacts))
+	for _, contact := range removedContacts {
+		if _, alreadyHandled := handledContacts[contact]; alreadyHandled {
+			continue
+		}
+		handledContacts[contact] = struct{}{}
+		hiddenContacts = append(hiddenContacts, contact)
+	}
+	for _, contact := range hiddenContacts {
+		aci, _ := uuid.Parse(contact.Aci)
+		pni, _ := uuid.Parse(contact.Pni)
+		recipient, err := cli.Store.RecipientStore.LoadAndUpdateRecipient(ctx, aci, pni, func(recipient *types.Recipient) (changed bool, err error) {
+			changed = recipient.ContactName != ""
+			recipient.ContactName = ""
+			return
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update removed contact %s/%s: %w", aci, pni, err)
+		}
+		log.Debug().Stringer("aci", aci).Stringer("pni", pni).Msg("Contact was removed from storage service")
+		evts = append(evts, &events.ContactRemoved{Contact: recipient})
+	}
+	for groupID := range removedGroups {
+		err := cli.Store.GroupStore.DeleteMasterKey(ctx, groupID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete group master key for %s: %w", groupID, err)
+		}
+		log.Debug().Stringer("group_id", groupID).Msg("Group was removed from storage service")
+		evts = append(evts, &events.GroupRemoved{GroupID: groupID})
+	}
+	if len(update.MissingRecords) > 0 {
+		// The manifest is only fetched if the version changed, so keep the old version to make the next sync
+		// diff the manifest again. The missing records aren't in the store, so they'll be fetched again then.
+		log.Warn().
+			Strs("item_ids", update.MissingRecords).
+			Uint64("new_version", update.Version).
+			Msg("Some storage records couldn't be fetched, not updating stored storage version")
+		return evts, nil
+	}
+	err := cli.Store.StorageStore.PutStorageVersion(ctx, update.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save storage version: %w", err)
+	}
+	return evts, nil
 }
 
 type StorageUpdate struct {
@@ -140,19 +278,7 @@ func (cli *Client) FetchStorage(ctx context.Context, masterKey []byte, currentVe
 	} else if manifest == nil {
 		return nil, nil
 	}
-	removedKeys := make([]string, 0)
-	newKeys := manifestRecordToMap(manifest.GetIdentifiers())
-	slices.Sort(existingKeys)
-	existingKeys = slices.Compact(existingKeys)
-	for _, key := range existingKeys {
-		_, isStillThere := newKeys[key]
-		if isStillThere {
-			delete(newKeys, key)
-		} else {
-			removedKeys = append(removedKeys, key)
-		}
-		delete(newKeys, key)
-	}
+	newKeys, removedKeys := diffStorageManifest(manifest.GetIdentifiers(), existingKeys)
 	newRecords, missingKeys, err := cli.fetchStorageRecords(ctx, storageKey, manifest.GetRecordIkm(), newKeys)
 	if err != nil {
 		return nil, err
@@ -165,6 +291,27 @@ func (cli *Client) FetchStorage(ctx context.Context, masterKey []byte, currentVe
 	}, nil
 }
 
+// diffStorageManifest compares the records in a manifest with the storage IDs of locally stored records.
+// It returns the records that need to be fetched, and the sorted IDs of local records that aren't in the manifest anymore.
+func diffStorageManifest(
+	manifest []*signalpb.ManifestRecord_Identifier, existingKeys []string,
+) (newKeys map[string]signalpb.ManifestRecord_Identifier_Type, removedKeys []string) {
+	removedKeys = make([]string, 0)
+	newKeys = manifestRecordToMap(manifest)
+	existingKeys = slices.Clone(existingKeys)
+	slices.Sort(existingKeys)
+	existingKeys = slices.Compact(existingKeys)
+	for _, key := range existingKeys {
+		_, isStillThere := newKeys[key]
+		if isStillThere {
+			delete(newKeys, key)
+		} else {
+			removedKeys = append(removedKeys, key)
+		}
+	}
+	return
+}
+
 func manifestRecordToMap(manifest []*signalpb.ManifestRecord_Identifier) map[string]signalpb.ManifestRecord_Identifier_Type {
 	manifestMap := make(map[string]signalpb.ManifestRecord_Identifier_Type, len(manifest))
 	for _, item := range manifest {
@@ -222,11 +369,11 @@ func (cli *Client) fetchStorageManifest(ctx context.Context, storageKey []byte,
 	}
 	var encryptedManifest signalpb.StorageManifest
 	var manifestRecord signalpb.ManifestRecord
-	resp, err := web.SendHTTPRequest(ctx, http.MethodGet, path, &web.HTTPReqOpt{
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodGet, path, &web.HTTPReqOpt{
 		Username:    &storageCreds.Username,
 		Password:    &storageCreds.Password,
 		ContentType: web.ContentTypeProtobuf,
-		Host:        web.StorageHostname,
+		Host:        cli.env().StorageHost,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch storage manifest: %w", err)
@@ -328,12 +475,12 @@ func (cli *Client) fetchStorageItemsChunk(ctx context.Context, recordKeys [][]by
 		return nil, fmt.Errorf("failed to marshal read operation: %w", err)
 	}
 	var storageItems signalpb.StorageItems
-	resp, err := web.SendHTTPRequest(ctx, http.MethodPut, "/v1/storage/read", &web.HTTPReqOpt{
+	resp, err := cli.env().SendHTTPRequest(ctx, http.MethodPut, "/v1/storage/read", &web.HTTPReqOpt{
 		Username:    &storageCreds.Username,
 		Password:    &storageCreds.Password,
 		Body:        body,
 		ContentType: web.ContentTypeProtobuf,
-		Host:        web.StorageHostname,
+		Host:        cli.env().StorageHost,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch storage records: %w", err)
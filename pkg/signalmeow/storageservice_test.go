@@ -0,0 +1,105 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+func makeManifestIdentifier(raw string, itemType signalpb.ManifestRecord_Identifier_Type) *signalpb.ManifestRecord_Identifier {
+	return &signalpb.ManifestRecord_Identifier{Raw: []byte(raw), Type: itemType}
+}
+
+func storageID(raw string) string {
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+func TestDiffStorageManifest(t *testing.T) {
+	const (
+		contact = signalpb.ManifestRecord_Identifier_CONTACT
+		group   = signalpb.ManifestRecord_Identifier_GROUPV2
+		account = signalpb.ManifestRecord_Identifier_ACCOUNT
+	)
+	type itemTypes = map[string]signalpb.ManifestRecord_Identifier_Type
+	tests := []struct {
+		name            string
+		manifest        []*signalpb.ManifestRecord_Identifier
+		existing        []string
+		expectedNew     itemTypes
+		expectedRemoved []string
+	}{{
+		name:            "Initial",
+		manifest:        []*signalpb.ManifestRecord_Identifier{makeManifestIdentifier("a", contact), makeManifestIdentifier("b", group)},
+		expectedNew:     itemTypes{storageID("a"): contact, storageID("b"): group},
+		expectedRemoved: []string{},
+	}, {
+		name:            "Unchanged",
+		manifest:        []*signalpb.ManifestRecord_Identifier{makeManifestIdentifier("a", contact), makeManifestIdentifier("b", group)},
+		existing:        []string{storageID("b"), storageID("a")},
+		expectedNew:     itemTypes{},
+		expectedRemoved: []string{},
+	}, {
+		name:            "Added",
+		manifest:        []*signalpb.ManifestRecord_Identifier{makeManifestIdentifier("a", contact), makeManifestIdentifier("c", account)},
+		existing:        []string{storageID("a")},
+		expectedNew:     itemTypes{storageID("c"): account},
+		expectedRemoved: []string{},
+	}, {
+		name:            "Removed",
+		manifest:        []*signalpb.ManifestRecord_Identifier{makeManifestIdentifier("a", contact)},
+		existing:        []string{storageID("a"), storageID("b"), storageID("c")},
+		expectedNew:     itemTypes{},
+		expectedRemoved: []string{storageID("b"), storageID("c")},
+	}, {
+		// Records are replaced with a new ID when they change
+		name:            "Replaced",
+		manifest:        []*signalpb.ManifestRecord_Identifier{makeManifestIdentifier("a", contact), makeManifestIdentifier("b2", group)},
+		existing:        []string{storageID("a"), storageID("b")},
+		expectedNew:     itemTypes{storageID("b2"): group},
+		expectedRemoved: []string{storageID("b")},
+	}, {
+		name:            "DuplicateExisting",
+		manifest:        []*signalpb.ManifestRecord_Identifier{makeManifestIdentifier("a", contact)},
+		existing:        []string{storageID("b"), storageID("a"), storageID("b")},
+		expectedNew:     itemTypes{},
+		expectedRemoved: []string{storageID("b")},
+	}, {
+		name:            "EmptyManifest",
+		manifest:        nil,
+		existing:        []string{storageID("a"), storageID("b")},
+		expectedNew:     itemTypes{},
+		expectedRemoved: []string{storageID("a"), storageID("b")},
+	}, {
+		name:            "EmptyManifestAndStore",
+		expectedNew:     itemTypes{},
+		expectedRemoved: []string{},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existingCopy := append([]string(nil), test.existing...)
+			newKeys, removedKeys := diffStorageManifest(test.manifest, test.existing)
+			assert.Equal(t, test.expectedNew, newKeys)
+			assert.Equal(t, test.expectedRemoved, removedKeys)
+			assert.Equal(t, existingCopy, test.existing, "input slice must not be modified")
+		})
+	}
+}
@@ -22,6 +22,7 @@ type DeviceStore interface {
 	PutDevice(ctx context.Context, dd *DeviceData) error
 	DeviceByACI(ctx context.Context, aci uuid.UUID) (*Device, error)
 	DeviceByPNI(ctx context.Context, pni uuid.UUID) (*Device, error)
+	DeleteDevice(ctx context.Context, dd *DeviceData) error
 }
 
 // Container is a wrapper for a SQL database that can contain multiple signalmeow sessions.
@@ -109,6 +110,8 @@ func (c *Container) scanDevice(row dbutil.Scannable) (*Device, error) {
 	device.DeviceStore = baseStore
 	device.BackupStore = baseStore
 	device.EventBuffer = baseStore
+	device.StorageStore = baseStore
+	device.StickerStore = baseStore
 	device.sqlStore = baseStore
 	device.db = c.db
 	return &device, nil
@@ -80,6 +80,8 @@ type Device struct {
 	DeviceStore    DeviceStore
 	BackupStore    BackupStore
 	EventBuffer    EventBuffer
+	StorageStore   StorageStore
+	StickerStore   StickerPackStore
 
 	sqlStore *sqlStore
 	db       *dbutil.Database
@@ -37,6 +37,8 @@ type dbGroup struct {
 type GroupStore interface {
 	MasterKeyFromGroupIdentifier(ctx context.Context, groupID types.GroupIdentifier) (types.SerializedGroupMasterKey, error)
 	StoreMasterKey(ctx context.Context, groupID types.GroupIdentifier, key types.SerializedGroupMasterKey) error
+	DeleteMasterKey(ctx context.Context, groupID types.GroupIdentifier) error
+	AllGroupIdentifiers(ctx context.Context) ([]types.GroupIdentifier, error)
 }
 
 const (
@@ -47,6 +49,8 @@ const (
 		ON CONFLICT (account_id, group_identifier) DO UPDATE
 			SET master_key = excluded.master_key;
 	`
+	deleteGroupMasterKeyQuery = `DELETE FROM signalmeow_groups WHERE account_id=$1 AND group_identifier=$2`
+	getAllGroupIDsQuery       = `SELECT group_identifier FROM signalmeow_groups WHERE account_id=$1`
 )
 
 func scanGroup(row dbutil.Scannable) (*dbGroup, error) {
@@ -73,3 +77,13 @@ func (s *sqlStore) StoreMasterKey(ctx context.Context, groupID types.GroupIdenti
 	_, err := s.db.Exec(ctx, upsertGroupMasterKeyQuery, s.AccountID, groupID, key)
 	return err
 }
+
+func (s *sqlStore) DeleteMasterKey(ctx context.Context, groupID types.GroupIdentifier) error {
+	_, err := s.db.Exec(ctx, deleteGroupMasterKeyQuery, s.AccountID, groupID)
+	return err
+}
+
+func (s *sqlStore) AllGroupIdentifiers(ctx context.Context) ([]types.GroupIdentifier, error) {
+	rows, err := s.db.Query(ctx, getAllGroupIDsQuery, s.AccountID)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[types.GroupIdentifier], err).AsList()
+}
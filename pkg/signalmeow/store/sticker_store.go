@@ -0,0 +1,105 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/util/dbutil"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+// StickerPack is a sticker pack installed on the account.
+//
+// The manifest is nil if it hasn't been downloaded successfully yet.
+type StickerPack struct {
+	ID       []byte
+	Key      []byte
+	Manifest *signalpb.Pack
+}
+
+type StickerPackStore interface {
+	GetStickerPack(ctx context.Context, packID []byte) (*StickerPack, error)
+	GetAllStickerPacks(ctx context.Context) ([]*StickerPack, error)
+	PutStickerPack(ctx context.Context, pack *StickerPack) error
+	DeleteStickerPack(ctx context.Context, packID []byte) error
+}
+
+var _ StickerPackStore = (*sqlStore)(nil)
+
+const (
+	getAllStickerPacksQuery = `SELECT pack_id, pack_key, manifest FROM signalmeow_sticker_pack WHERE account_id=$1`
+	getStickerPackQuery     = getAllStickerPacksQuery + ` AND pack_id=$2`
+	putStickerPackQuery     = `
+		INSERT INTO signalmeow_sticker_pack (account_id, pack_id, pack_key, manifest)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, pack_id) DO UPDATE
+			SET pack_key=excluded.pack_key, manifest=excluded.manifest
+	`
+	deleteStickerPackQuery = `DELETE FROM signalmeow_sticker_pack WHERE account_id=$1 AND pack_id=$2`
+)
+
+func scanStickerPack(row dbutil.Scannable) (*StickerPack, error) {
+	var pack StickerPack
+	var manifest []byte
+	err := row.Scan(&pack.ID, &pack.Key, &manifest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(manifest) > 0 {
+		pack.Manifest = &signalpb.Pack{}
+		err = proto.Unmarshal(manifest, pack.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal sticker pack manifest: %w", err)
+		}
+	}
+	return &pack, nil
+}
+
+func (s *sqlStore) GetStickerPack(ctx context.Context, packID []byte) (*StickerPack, error) {
+	return scanStickerPack(s.db.QueryRow(ctx, getStickerPackQuery, s.AccountID, packID))
+}
+
+func (s *sqlStore) GetAllStickerPacks(ctx context.Context) ([]*StickerPack, error) {
+	rows, err := s.db.Query(ctx, getAllStickerPacksQuery, s.AccountID)
+	return dbutil.NewRowIterWithError(rows, scanStickerPack, err).AsList()
+}
+
+func (s *sqlStore) PutStickerPack(ctx context.Context, pack *StickerPack) error {
+	var manifest []byte
+	if pack.Manifest != nil {
+		var err error
+		manifest, err = proto.Marshal(pack.Manifest)
+		if err != nil {
+			return fmt.Errorf("failed to marshal sticker pack manifest: %w", err)
+		}
+	}
+	_, err := s.db.Exec(ctx, putStickerPackQuery, s.AccountID, pack.ID, pack.Key, manifest)
+	return err
+}
+
+func (s *sqlStore) DeleteStickerPack(ctx context.Context, packID []byte) error {
+	_, err := s.db.Exec(ctx, deleteStickerPackQuery, s.AccountID, packID)
+	return err
+}
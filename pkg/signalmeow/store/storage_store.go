@@ -0,0 +1,129 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/util/dbutil"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+// StorageRecord is a decrypted storage service record that has been seen by the client.
+//
+// Records are stored as serialized protobufs, which means fields and record types
+// that this version doesn't understand are retained as-is.
+type StorageRecord struct {
+	StorageID string
+	ItemType  signalpb.ManifestRecord_Identifier_Type
+	Record    *signalpb.StorageRecord
+}
+
+type StorageStore interface {
+	GetStorageVersion(ctx context.Context) (uint64, error)
+	PutStorageVersion(ctx context.Context, version uint64) error
+
+	GetStorageRecord(ctx context.Context, storageID string) (*StorageRecord, error)
+	GetStorageRecordIDs(ctx context.Context) ([]string, error)
+	GetStorageRecordsByType(ctx context.Context, itemType signalpb.ManifestRecord_Identifier_Type) ([]*StorageRecord, error)
+	PutStorageRecord(ctx context.Context, record *StorageRecord) error
+	DeleteStorageRecord(ctx context.Context, storageID string) error
+}
+
+var _ StorageStore = (*sqlStore)(nil)
+
+const (
+	getStorageVersionQuery = `SELECT storage_version FROM signalmeow_device WHERE aci_uuid=$1`
+	putStorageVersionQuery = `UPDATE signalmeow_device SET storage_version=$2 WHERE aci_uuid=$1`
+
+	getStorageRecordBaseQuery = `
+		SELECT storage_id, item_type, data FROM signalmeow_storage_record WHERE account_id=$1
+	`
+	getStorageRecordQuery        = getStorageRecordBaseQuery + `AND storage_id=$2`
+	getStorageRecordsByTypeQuery = getStorageRecordBaseQuery + `AND item_type=$2`
+	getStorageRecordIDsQuery     = `SELECT storage_id FROM signalmeow_storage_record WHERE account_id=$1`
+	putStorageRecordQuery        = `
+		INSERT INTO signalmeow_storage_record (account_id, storage_id, item_type, data)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, storage_id) DO UPDATE
+			SET item_type=excluded.item_type, data=excluded.data
+	`
+	deleteStorageRecordQuery = `DELETE FROM signalmeow_storage_record WHERE account_id=$1 AND storage_id=$2`
+)
+
+func (s *sqlStore) GetStorageVersion(ctx context.Context) (version uint64, err error) {
+	err = s.db.QueryRow(ctx, getStorageVersionQuery, s.AccountID).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return
+}
+
+func (s *sqlStore) PutStorageVersion(ctx context.Context, version uint64) error {
+	_, err := s.db.Exec(ctx, putStorageVersionQuery, s.AccountID, int64(version))
+	return err
+}
+
+func scanStorageRecord(row dbutil.Scannable) (*StorageRecord, error) {
+	var record StorageRecord
+	var data []byte
+	err := row.Scan(&record.StorageID, &record.ItemType, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	record.Record = &signalpb.StorageRecord{}
+	err = proto.Unmarshal(data, record.Record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal storage record %s: %w", record.StorageID, err)
+	}
+	return &record, nil
+}
+
+func (s *sqlStore) GetStorageRecord(ctx context.Context, storageID string) (*StorageRecord, error) {
+	return scanStorageRecord(s.db.QueryRow(ctx, getStorageRecordQuery, s.AccountID, storageID))
+}
+
+func (s *sqlStore) GetStorageRecordIDs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.Query(ctx, getStorageRecordIDsQuery, s.AccountID)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[string], err).AsList()
+}
+
+func (s *sqlStore) GetStorageRecordsByType(ctx context.Context, itemType signalpb.ManifestRecord_Identifier_Type) ([]*StorageRecord, error) {
+	rows, err := s.db.Query(ctx, getStorageRecordsByTypeQuery, s.AccountID, itemType)
+	return dbutil.NewRowIterWithError(rows, scanStorageRecord, err).AsList()
+}
+
+func (s *sqlStore) PutStorageRecord(ctx context.Context, record *StorageRecord) error {
+	data, err := proto.Marshal(record.Record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage record: %w", err)
+	}
+	_, err = s.db.Exec(ctx, putStorageRecordQuery, s.AccountID, record.StorageID, record.ItemType, data)
+	return err
+}
+
+func (s *sqlStore) DeleteStorageRecord(ctx context.Context, storageID string) error {
+	_, err := s.db.Exec(ctx, deleteStorageRecordQuery, s.AccountID, storageID)
+	return err
+}
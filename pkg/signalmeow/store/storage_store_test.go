@@ -0,0 +1,152 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/litestream"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+func newTestStorageStore(t *testing.T) *sqlStore {
+	ctx := context.Background()
+	db, err := dbutil.NewWithDialect("file::memory:?_txlock=immediate", "sqlite3-fk-wal")
+	require.NoError(t, err)
+	db.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	container := NewStore(db, dbutil.NoopLogger)
+	require.NoError(t, container.Upgrade(ctx))
+	aci := uuid.New()
+	// The identity keys aren't used by the storage store, so a device row with dummy keys is enough
+	_, err = db.Exec(ctx, `
+		INSERT INTO signalmeow_device (aci_uuid, aci_identity_key_pair, registration_id, pni_uuid, pni_identity_key_pair, pni_registration_id, device_id)
+		VALUES ($1, '', 1, $2, '', 2, 2)
+	`, aci, uuid.New())
+	require.NoError(t, err)
+	return &sqlStore{Container: container, AccountID: aci}
+}
+
+func makeContactStorageRecord(storageID, aci string) *StorageRecord {
+	return &StorageRecord{
+		StorageID: storageID,
+		ItemType:  signalpb.ManifestRecord_Identifier_CONTACT,
+		Record: &signalpb.StorageRecord{
+			Record: &signalpb.StorageRecord_Contact{Contact: &signalpb.ContactRecord{Aci: aci}},
+		},
+	}
+}
+
+func TestStorageStore_Version(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorageStore(t)
+	version, err := s.GetStorageVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), version)
+	require.NoError(t, s.PutStorageVersion(ctx, 42))
+	version, err = s.GetStorageVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), version)
+}
+
+func TestStorageStore_Records(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorageStore(t)
+
+	record, err := s.GetStorageRecord(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, record)
+	ids, err := s.GetStorageRecordIDs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	contact := makeContactStorageRecord("contact", uuid.NewString())
+	group := &StorageRecord{
+		StorageID: "group",
+		ItemType:  signalpb.ManifestRecord_Identifier_GROUPV2,
+		Record: &signalpb.StorageRecord{
+			Record: &signalpb.StorageRecord_GroupV2{GroupV2: &signalpb.GroupV2Record{MasterKey: make([]byte, 32)}},
+		},
+	}
+	require.NoError(t, s.PutStorageRecord(ctx, contact))
+	require.NoError(t, s.PutStorageRecord(ctx, group))
+
+	record, err = s.GetStorageRecord(ctx, "contact")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, contact.ItemType, record.ItemType)
+	assert.True(t, proto.Equal(contact.Record, record.Record))
+	ids, err = s.GetStorageRecordIDs(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"contact", "group"}, ids)
+	groups, err := s.GetStorageRecordsByType(ctx, signalpb.ManifestRecord_Identifier_GROUPV2)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "group", groups[0].StorageID)
+
+	// Putting a record with an existing ID replaces it
+	updated := makeContactStorageRecord("contact", uuid.NewString())
+	require.NoError(t, s.PutStorageRecord(ctx, updated))
+	record, err = s.GetStorageRecord(ctx, "contact")
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(updated.Record, record.Record))
+	contacts, err := s.GetStorageRecordsByType(ctx, signalpb.ManifestRecord_Identifier_CONTACT)
+	require.NoError(t, err)
+	assert.Len(t, contacts, 1)
+
+	require.NoError(t, s.DeleteStorageRecord(ctx, "contact"))
+	record, err = s.GetStorageRecord(ctx, "contact")
+	require.NoError(t, err)
+	assert.Nil(t, record)
+	ids, err = s.GetStorageRecordIDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group"}, ids)
+	// Deleting a nonexistent record isn't an error
+	require.NoError(t, s.DeleteStorageRecord(ctx, "contact"))
+}
+
+func TestStorageStore_UnknownFieldsRetained(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorageStore(t)
+	record := makeContactStorageRecord("contact", uuid.NewString())
+	// Field 1000 with varint value 1, which doesn't exist in the contact record
+	record.Record.GetContact().ProtoReflect().SetUnknown([]byte{0xc0, 0x3e, 0x01})
+	require.NoError(t, s.PutStorageRecord(ctx, record))
+	loaded, err := s.GetStorageRecord(ctx, "contact")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xc0, 0x3e, 0x01}, []byte(loaded.Record.GetContact().ProtoReflect().GetUnknown()))
+}
+
+func TestStorageStore_ScopedToAccount(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorageStore(t)
+	other := &sqlStore{Container: s.Container, AccountID: uuid.New()}
+	require.NoError(t, s.PutStorageRecord(ctx, makeContactStorageRecord("contact", uuid.NewString())))
+	record, err := other.GetStorageRecord(ctx, "contact")
+	require.NoError(t, err)
+	assert.Nil(t, record)
+	ids, err := other.GetStorageRecordIDs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
@@ -1,4 +1,4 @@
--- v0 -> v21 (compatible with v13+): Latest revision
+-- v0 -> v23 (compatible with v13+): Latest revision
 CREATE TABLE signalmeow_device (
     aci_uuid              TEXT PRIMARY KEY,
 
@@ -17,7 +17,8 @@ CREATE TABLE signalmeow_device (
     account_record        bytea,
     account_entropy_pool  TEXT,
     ephemeral_backup_key  bytea,
-    media_root_backup_key bytea
+    media_root_backup_key bytea,
+    storage_version       BIGINT  NOT NULL DEFAULT 0
 );
 
 CREATE TABLE signalmeow_pre_keys (
@@ -74,6 +75,26 @@ CREATE TABLE signalmeow_event_buffer (
     FOREIGN KEY (account_id) REFERENCES signalmeow_device (aci_uuid) ON DELETE CASCADE ON UPDATE CASCADE
 );
 
+CREATE TABLE signalmeow_storage_record (
+    account_id TEXT    NOT NULL,
+    storage_id TEXT    NOT NULL,
+    item_type  INTEGER NOT NULL,
+    data       bytea   NOT NULL,
+
+    PRIMARY KEY (account_id, storage_id),
+    FOREIGN KEY (account_id) REFERENCES signalmeow_device (aci_uuid) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE signalmeow_sticker_pack (
+    account_id TEXT  NOT NULL,
+    pack_id    bytea NOT NULL,
+    pack_key   bytea NOT NULL,
+    manifest   bytea,
+
+    PRIMARY KEY (account_id, pack_id),
+    FOREIGN KEY (account_id) REFERENCES signalmeow_device (aci_uuid) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
 CREATE TABLE signalmeow_profile_keys (
     account_id     TEXT  NOT NULL,
     their_aci_uuid TEXT  NOT NULL,
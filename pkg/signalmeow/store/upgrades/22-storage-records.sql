@@ -0,0 +1,12 @@
+-- v22 (compatible with v13+): Store storage service records
+ALTER TABLE signalmeow_device ADD COLUMN storage_version BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE signalmeow_storage_record (
+    account_id TEXT    NOT NULL,
+    storage_id TEXT    NOT NULL,
+    item_type  INTEGER NOT NULL,
+    data       bytea   NOT NULL,
+
+    PRIMARY KEY (account_id, storage_id),
+    FOREIGN KEY (account_id) REFERENCES signalmeow_device (aci_uuid) ON DELETE CASCADE ON UPDATE CASCADE
+);
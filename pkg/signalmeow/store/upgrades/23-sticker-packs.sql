@@ -0,0 +1,10 @@
+-- v23 (compatible with v13+): Store installed sticker packs
+CREATE TABLE signalmeow_sticker_pack (
+    account_id TEXT  NOT NULL,
+    pack_id    bytea NOT NULL,
+    pack_key   bytea NOT NULL,
+    manifest   bytea,
+
+    PRIMARY KEY (account_id, pack_id),
+    FOREIGN KEY (account_id) REFERENCES signalmeow_device (aci_uuid) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,133 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/types"
+)
+
+func (cli *Client) incomingStoryMessage(ctx context.Context, story *signalpb.StoryMessage, senderACI uuid.UUID, timestamp, serverTimestamp uint64) bool {
+	log := zerolog.Ctx(ctx)
+	if story.ProfileKey != nil {
+		err := cli.Store.RecipientStore.StoreProfileKey(ctx, senderACI, libsignalgo.ProfileKey(story.ProfileKey))
+		if err != nil {
+			log.Err(err).Msg("StoreProfileKey error")
+			return false
+		}
+	}
+	var groupID types.GroupIdentifier
+	if story.GetGroup() != nil {
+		masterKey := masterKeyFromBytes(libsignalgo.GroupMasterKey(story.GetGroup().GetMasterKey()))
+		var err error
+		groupID, err = cli.StoreMasterKey(ctx, masterKey)
+		if err != nil {
+			log.Err(err).Msg("StoreMasterKey error")
+			return false
+		}
+	}
+	evt := DecodeStory(story)
+	if evt == nil {
+		log.Debug().Uint64("story_ts", timestamp).Msg("Ignoring story with no content")
+		return true
+	}
+	evt.Info = events.MessageInfo{
+		Sender:          senderACI,
+		ChatID:          groupOrUserID(groupID, libsignalgo.NewACIServiceID(senderACI)),
+		GroupRevision:   story.GetGroup().GetRevision(),
+		ServerTimestamp: serverTimestamp,
+	}
+	evt.Timestamp = timestamp
+	return cli.handleEvent(evt)
+}
+
+// DecodeStory converts the content of a story message into a Story event.
+// The message info and timestamp are not filled. If the story has no content, nil is returned.
+func DecodeStory(story *signalpb.StoryMessage) *events.Story {
+	evt := &events.Story{
+		AllowsReplies: story.GetAllowsReplies(),
+		BodyRanges:    story.GetBodyRanges(),
+	}
+	switch att := story.Attachment.(type) {
+	case *signalpb.StoryMessage_FileAttachment:
+		if att.FileAttachment == nil {
+			return nil
+		}
+		evt.File = att.FileAttachment
+	case *signalpb.StoryMessage_TextAttachment:
+		if att.TextAttachment == nil {
+			return nil
+		}
+		evt.Text = decodeTextStory(att.TextAttachment)
+	default:
+		return nil
+	}
+	return evt
+}
+
+func decodeTextStory(ta *signalpb.TextAttachment) *events.TextStory {
+	ts := &events.TextStory{
+		Text:            ta.GetText(),
+		Style:           ta.GetTextStyle(),
+		ForegroundColor: ta.TextForegroundColor,
+		BackgroundColor: ta.TextBackgroundColor,
+		Preview:         ta.GetPreview(),
+	}
+	switch bg := ta.Background.(type) {
+	case *signalpb.TextAttachment_Color:
+		ts.BackgroundSolid = &bg.Color
+	case *signalpb.TextAttachment_Gradient_:
+		ts.BackgroundGradient = decodeStoryGradient(bg.Gradient)
+	}
+	return ts
+}
+
+func decodeStoryGradient(gradient *signalpb.TextAttachment_Gradient) *events.StoryGradient {
+	if gradient == nil {
+		return nil
+	}
+	sg := &events.StoryGradient{
+		Angle:     gradient.GetAngle(),
+		Colors:    gradient.GetColors(),
+		Positions: gradient.GetPositions(),
+	}
+	if len(sg.Colors) == 0 && gradient.StartColor != nil && gradient.EndColor != nil {
+		// Old clients only send the deprecated start and end colors
+		sg.Colors = []uint32{gradient.GetStartColor(), gradient.GetEndColor()}
+		sg.Positions = []float32{0, 1}
+	}
+	if len(sg.Positions) != len(sg.Colors) {
+		// Spread the colors evenly if the positions are missing or don't match
+		sg.Positions = make([]float32, len(sg.Colors))
+		for i := range sg.Positions {
+			if len(sg.Colors) > 1 {
+				sg.Positions[i] = float32(i) / float32(len(sg.Colors)-1)
+			}
+		}
+	}
+	if len(sg.Colors) == 0 {
+		return nil
+	}
+	return sg
+}
@@ -0,0 +1,211 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/events"
+)
+
+// SubscribeOptions contains the filters and delivery mode of an event subscription.
+type SubscribeOptions struct {
+	// ChatIDs limits the subscription to events in the given chats. Events that don't belong to a chat,
+	// like receipts and contact list updates, are never delivered if this is set.
+	ChatIDs []string
+	// Senders limits the subscription to events sent by the given users. Events without a sender
+	// are never delivered if this is set.
+	Senders []uuid.UUID
+
+	// QueueSize is the number of events buffered for the subscriber. If zero, the handler is called
+	// synchronously while handling the envelope, and returning false from it prevents the envelope
+	// from being acknowledged, so the server will redeliver it later.
+	//
+	// If non-zero, the handler is called from a separate goroutine and its return value is ignored:
+	// the envelope is acknowledged as soon as the event has been queued.
+	QueueSize int
+	// DropWhenFull makes queued subscriptions drop events when the queue is full. By default, handling
+	// incoming messages waits until the subscriber has room in its queue.
+	DropWhenFull bool
+}
+
+type subscription struct {
+	match  func(events.SignalEvent) bool
+	handle func(events.SignalEvent) bool
+
+	queue   chan events.SignalEvent
+	stop    chan struct{}
+	drop    bool
+	dropped atomic.Uint64
+}
+
+type subscriptionRegistry struct {
+	lock sync.Mutex
+	subs atomic.Pointer[[]*subscription]
+}
+
+// Subscribe registers a handler for events of type T. Use events.SignalEvent as the type to receive all events.
+// Any number of subscriptions can be registered in addition to [Client.EventHandler].
+//
+// Each subscriber receives events in the order they were handled. Envelopes from different senders are
+// handled in parallel, so only the order of events from the same sender is deterministic. Synchronous
+// subscribers are called in the order they were registered, after EventHandler.
+//
+// An envelope is only acknowledged if EventHandler and all synchronous subscribers return true,
+// otherwise the server will send it again, possibly to subscribers that already received it.
+//
+// The returned function removes the subscription. Events still in the queue of the subscription are discarded.
+func Subscribe[T events.SignalEvent](cli *Client, handler func(T) bool, opts *SubscribeOptions) (unsubscribe func()) {
+	if opts == nil {
+		opts = &SubscribeOptions{}
+	}
+	chatIDs := slices.Clone(opts.ChatIDs)
+	senders := slices.Clone(opts.Senders)
+	sub := &subscription{
+		match: func(evt events.SignalEvent) bool {
+			if _, ok := evt.(T); !ok {
+				return false
+			}
+			if len(chatIDs) == 0 && len(senders) == 0 {
+				return true
+			}
+			chatID, sender := eventChatAndSender(evt)
+			return (len(chatIDs) == 0 || (chatID != "" && slices.Contains(chatIDs, chatID))) &&
+				(len(senders) == 0 || (sender != uuid.Nil && slices.Contains(senders, sender)))
+		},
+		handle: func(evt events.SignalEvent) bool {
+			return handler(evt.(T))
+		},
+		drop: opts.DropWhenFull,
+	}
+	if opts.QueueSize > 0 {
+		sub.queue = make(chan events.SignalEvent, opts.QueueSize)
+		sub.stop = make(chan struct{})
+		go sub.loop()
+	}
+	cli.subscriptions.add(sub)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cli.subscriptions.remove(sub)
+			if sub.stop != nil {
+				close(sub.stop)
+			}
+		})
+	}
+}
+
+// eventChatAndSender returns the chat and sender of an event, or empty values if the event doesn't have them.
+func eventChatAndSender(evt events.SignalEvent) (chatID string, sender uuid.UUID) {
+	switch typedEvt := evt.(type) {
+	case *events.ChatEvent:
+		return typedEvt.Info.ChatID, typedEvt.Info.Sender
+	case *events.Call:
+		return typedEvt.Info.ChatID, typedEvt.Info.Sender
+	case *events.Story:
+		return typedEvt.Info.ChatID, typedEvt.Info.Sender
+	case *events.DecryptionError:
+		return "", typedEvt.Sender
+	case *events.Receipt:
+		return "", typedEvt.Sender
+	default:
+		return "", uuid.Nil
+	}
+}
+
+func (sr *subscriptionRegistry) add(sub *subscription) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	var subs []*subscription
+	if existing := sr.subs.Load(); existing != nil {
+		subs = slices.Clone(*existing)
+	}
+	subs = append(subs, sub)
+	sr.subs.Store(&subs)
+}
+
+func (sr *subscriptionRegistry) remove(sub *subscription) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	existing := sr.subs.Load()
+	if existing == nil {
+		return
+	}
+	subs := slices.DeleteFunc(slices.Clone(*existing), func(s *subscription) bool {
+		return s == sub
+	})
+	sr.subs.Store(&subs)
+}
+
+// dispatch delivers the event to all matching subscriptions and returns false if any synchronous subscriber did.
+func (sr *subscriptionRegistry) dispatch(cli *Client, evt events.SignalEvent) bool {
+	subs := sr.subs.Load()
+	if subs == nil {
+		return true
+	}
+	ok := true
+	for _, sub := range *subs {
+		if !sub.match(evt) {
+			continue
+		}
+		if sub.queue == nil {
+			if !sub.handle(evt) {
+				ok = false
+			}
+		} else if !sub.enqueue(evt) {
+			cli.Log.Warn().
+				Type("event_type", evt).
+				Uint64("dropped_count", sub.dropped.Load()).
+				Msg("Subscriber queue is full, dropped event")
+		}
+	}
+	return ok
+}
+
+func (sub *subscription) enqueue(evt events.SignalEvent) bool {
+	if sub.drop {
+		select {
+		case sub.queue <- evt:
+			return true
+		case <-sub.stop:
+			return true
+		default:
+			sub.dropped.Add(1)
+			return false
+		}
+	}
+	select {
+	case sub.queue <- evt:
+	case <-sub.stop:
+	}
+	return true
+}
+
+func (sub *subscription) loop() {
+	for {
+		select {
+		case evt := <-sub.queue:
+			sub.handle(evt)
+		case <-sub.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/coder/websocket"
+	"github.com/rs/zerolog"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/protobuf/proto"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/web"
+)
+
+// SVR2MaxTries is the number of incorrect PIN guesses after which the enclave deletes the backed up data.
+const SVR2MaxTries = 10
+
+var (
+	ErrSVRDataMissing     = errors.New("no master key backed up in SVR2")
+	ErrSVRRequestInvalid  = errors.New("SVR2 rejected request as invalid")
+	ErrSVRDecryptionError = errors.New("failed to decrypt master key restored from SVR2")
+)
+
+// SVRPINMismatchError is returned when restoring from SVR2 fails due to an incorrect PIN.
+type SVRPINMismatchError struct {
+	TriesRemaining uint32
+}
+
+func (e *SVRPINMismatchError) Error() string {
+	return fmt.Sprintf("incorrect PIN (%d tries remaining)", e.TriesRemaining)
+}
+
+// NormalizePIN normalizes a PIN the same way as the official clients before it's hashed:
+// surrounding whitespace is removed, non-ASCII digits are converted to ASCII and the result is NFKD-normalized.
+func NormalizePIN(pin string) []byte {
+	pin = strings.TrimSpace(pin)
+	allDigits := true
+	for _, r := range pin {
+		if !unicode.IsDigit(r) {
+			allDigits = false
+			break
+		}
+	}
+	if allDigits {
+		pin = strings.Map(func(r rune) rune {
+			// Unicode decimal digits are always in contiguous runs starting from zero
+			start := r
+			for unicode.IsDigit(start - 1) {
+				start--
+			}
+			return '0' + (r-start)%10
+		}, pin)
+	}
+	return norm.NFKD.Bytes([]byte(pin))
+}
+
+// RegistrationLockToken derives the registration lock token from the master key.
+func RegistrationLockToken(masterKey []byte) string {
+	return hex.EncodeToString(hmacSHA256(masterKey, []byte("Registration Lock")))
+}
+
+// encryptSVRData encrypts the master key with the HMAC-SIV construction used by the official clients.
+func encryptSVRData(key, plaintext []byte) ([]byte, error) {
+	if len(plaintext) != 32 {
+		return nil, fmt.Errorf("invalid plaintext length %d", len(plaintext))
+	}
+	authKey := hmacSHA256(key, []byte("auth"))
+	encKey := hmacSHA256(key, []byte("enc"))
+	iv := hmacSHA256(authKey, plaintext)[:16]
+	keystream := hmacSHA256(encKey, iv)
+	output := make([]byte, 16+32)
+	copy(output, iv)
+	for i := range plaintext {
+		output[16+i] = plaintext[i] ^ keystream[i]
+	}
+	return output, nil
+}
+
+func decryptSVRData(key, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) != 16+32 {
+		return nil, fmt.Errorf("%w: invalid ciphertext length %d", ErrSVRDecryptionError, len(ciphertext))
+	}
+	authKey := hmacSHA256(key, []byte("auth"))
+	encKey := hmacSHA256(key, []byte("enc"))
+	iv := ciphertext[:16]
+	keystream := hmacSHA256(encKey, iv)
+	plaintext := make([]byte, 32)
+	for i := range plaintext {
+		plaintext[i] = ciphertext[16+i] ^ keystream[i]
+	}
+	if !hmac.Equal(hmacSHA256(authKey, plaintext)[:16], iv) {
+		return nil, ErrSVRDecryptionError
+	}
+	return plaintext, nil
+}
+
+func (cli *Client) getSVR2Credentials(ctx context.Context) (*basicExpiringCredentials, error) {
+	return cli.getCredentialsFromServer(ctx, "/v2/backup/auth")
+}
+
+func svr2PinKeys(env *web.ServerEnvironment, pin, username string) (accessKey, encryptionKey []byte, err error) {
+	pinHash, err := libsignalgo.NewPinHashForSVR2(NormalizePIN(pin), username, env.SVR2MrenclaveBytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to hash PIN: %w", err)
+	}
+	accessKey, err = pinHash.AccessKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get PIN access key: %w", err)
+	}
+	encryptionKey, err = pinHash.EncryptionKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get PIN encryption key: %w", err)
+	}
+	return
+}
+
+// svr2Session is an attested connection to the SVR2 enclave.
+type svr2Session struct {
+	WS  *websocket.Conn
+	SVR *libsignalgo.SGXClientState
+	Env *web.ServerEnvironment
+}
+
+func openSVR2Session(ctx context.Context, env *web.ServerEnvironment, username, password string) (*svr2Session, error) {
+	addr := (&url.URL{
+		Scheme: "wss",
+		Host:   env.SVR2Host,
+		User:   url.UserPassword(username, password),
+		Path:   path.Join("v1", env.SVR2Mrenclave),
+	}).String()
+	ws, _, err := env.OpenWebsocket(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SVR2 websocket: %w", err)
+	}
+	sess := &svr2Session{WS: ws, Env: env}
+	err = sess.handshake(ctx)
+	if err != nil {
+		_ = ws.CloseNow()
+		return nil, fmt.Errorf("failed to handshake with SVR2 enclave: %w", err)
+	}
+	return sess, nil
+}
+
+func (sess *svr2Session) readBinary(ctx context.Context) ([]byte, error) {
+	msgType, msg, err := sess.WS.Read(ctx)
+	if err != nil {
+		return nil, err
+	} else if msgType != websocket.MessageBinary {
+		return nil, fmt.Errorf("expected binary message, got %s", msgType.String())
+	}
+	return msg, nil
+}
+
+func (sess *svr2Session) handshake(ctx context.Context) error {
+	attestationMsg, err := sess.readBinary(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read attestation message: %w", err)
+	}
+	svrClient, err := libsignalgo.NewSVR2ClientState(sess.Env.SVR2MrenclaveBytes(), attestationMsg, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to initialize SVR2 client state: %w", err)
+	}
+	initReq, err := svrClient.InitialRequest()
+	if err != nil {
+		return fmt.Errorf("failed to generate initial request: %w", err)
+	}
+	err = sess.WS.Write(ctx, websocket.MessageBinary, initReq)
+	if err != nil {
+		return fmt.Errorf("failed to write initial request: %w", err)
+	}
+	handshakeFinishMsg, err := sess.readBinary(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read handshake finish message: %w", err)
+	}
+	err = svrClient.CompleteHandshake(handshakeFinishMsg)
+	if err != nil {
+		return fmt.Errorf("failed to complete handshake: %w", err)
+	}
+	sess.SVR = svrClient
+	return nil
+}
+
+func (sess *svr2Session) Do(ctx context.Context, req *signalpb.SVR2Request) (*signalpb.SVR2Response, error) {
+	plaintext, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	ciphertext, err := sess.SVR.EstablishedSend(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt request: %w", err)
+	}
+	err = sess.WS.Write(ctx, websocket.MessageBinary, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	ciphertext, err = sess.readBinary(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	plaintext, err = sess.SVR.EstablishedReceive(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt response: %w", err)
+	}
+	var resp signalpb.SVR2Response
+	err = proto.Unmarshal(plaintext, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &resp, nil
+}
+
+func (sess *svr2Session) Close() {
+	_ = sess.WS.Close(websocket.StatusNormalClosure, "")
+}
+
+// BackupMasterKeyWithPIN stores the account's master key in SVR2, encrypted with the given PIN.
+//
+// This doesn't enable registration lock, use SetRegistrationLock for that after the backup succeeds.
+func (cli *Client) BackupMasterKeyWithPIN(ctx context.Context, pin string) error {
+	if len(cli.Store.MasterKey) == 0 {
+		return fmt.Errorf("no master key")
+	}
+	creds, err := cli.getSVR2Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch SVR2 auth: %w", err)
+	}
+	accessKey, encryptionKey, err := svr2PinKeys(cli.env(), pin, creds.Username)
+	if err != nil {
+		return err
+	}
+	encryptedMasterKey, err := encryptSVRData(encryptionKey, cli.Store.MasterKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt master key: %w", err)
+	}
+	sess, err := openSVR2Session(ctx, cli.env(), creds.Username, creds.Password)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	resp, err := sess.Do(ctx, &signalpb.SVR2Request{Inner: &signalpb.SVR2Request_Backup{Backup: &signalpb.SVR2BackupRequest{
+		Data:     encryptedMasterKey,
+		Pin:      accessKey,
+		MaxTries: proto.Uint32(SVR2MaxTries),
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to send backup request: %w", err)
+	} else if status := resp.GetBackup().GetStatus(); status != signalpb.SVR2BackupResponse_OK {
+		return fmt.Errorf("unexpected backup response status %s", status.String())
+	}
+	// The backup isn't restorable until it's exposed
+	resp, err = sess.Do(ctx, &signalpb.SVR2Request{Inner: &signalpb.SVR2Request_Expose{Expose: &signalpb.SVR2ExposeRequest{
+		Data: encryptedMasterKey,
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to send expose request: %w", err)
+	} else if status := resp.GetExpose().GetStatus(); status != signalpb.SVR2ExposeResponse_OK {
+		return fmt.Errorf("unexpected expose response status %s", status.String())
+	}
+	zerolog.Ctx(ctx).Info().Msg("Backed up master key to SVR2")
+	return nil
+}
+
+// RestoreMasterKeyFromSVR2 fetches the master key from SVR2 using the given credentials and PIN.
+//
+// This is used during registration, where the credentials come from the [RegistrationLockError].
+// Logged in clients should use [Client.RestoreMasterKeyWithPIN] instead.
+func RestoreMasterKeyFromSVR2(ctx context.Context, username, password, pin string) ([]byte, error) {
+	env := web.Env(ctx)
+	accessKey, encryptionKey, err := svr2PinKeys(env, pin, username)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := openSVR2Session(ctx, env, username, password)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	resp, err := sess.Do(ctx, &signalpb.SVR2Request{Inner: &signalpb.SVR2Request_Restore{Restore: &signalpb.SVR2RestoreRequest{
+		Pin: accessKey,
+	}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send restore request: %w", err)
+	}
+	return parseSVR2RestoreResponse(encryptionKey, resp.GetRestore())
+}
+
+func parseSVR2RestoreResponse(encryptionKey []byte, resp *signalpb.SVR2RestoreResponse) ([]byte, error) {
+	switch resp.GetStatus() {
+	case signalpb.SVR2RestoreResponse_OK:
+		return decryptSVRData(encryptionKey, resp.GetData())
+	case signalpb.SVR2RestoreResponse_MISSING:
+		return nil, ErrSVRDataMissing
+	case signalpb.SVR2RestoreResponse_PIN_MISMATCH:
+		return nil, &SVRPINMismatchError{TriesRemaining: resp.GetTries()}
+	case signalpb.SVR2RestoreResponse_REQUEST_INVALID:
+		return nil, ErrSVRRequestInvalid
+	default:
+		return nil, fmt.Errorf("unexpected restore response status %s", resp.GetStatus().String())
+	}
+}
+
+// RestoreMasterKeyWithPIN fetches the master key from SVR2 and saves it as the account's master key.
+//
+// If the restored key doesn't match the current account entropy pool, the pool is discarded.
+func (cli *Client) RestoreMasterKeyWithPIN(ctx context.Context, pin string) error {
+	creds, err := cli.getSVR2Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch SVR2 auth: %w", err)
+	}
+	masterKey, err := RestoreMasterKeyFromSVR2(cli.env().WithContext(ctx), creds.Username, creds.Password, pin)
+	if err != nil {
+		return err
+	}
+	if cli.Store.AccountEntropyPool != "" {
+		aepMasterKey, err := cli.Store.AccountEntropyPool.DeriveSVRKey()
+		if err != nil || !hmac.Equal(aepMasterKey, masterKey) {
+			cli.Store.AccountEntropyPool = ""
+		}
+	}
+	cli.Store.MasterKey = masterKey
+	err = cli.Store.DeviceStore.PutDevice(ctx, &cli.Store.DeviceData)
+	if err != nil {
+		return fmt.Errorf("failed to save restored master key: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Msg("Restored master key from SVR2")
+	return nil
+}
+
+type reqSetRegistrationLock struct {
+	RegistrationLock string `json:"registrationLock"`
+}
+
+// SetRegistrationLock enables or disables registration lock for the account.
+//
+// The master key should be backed up with [Client.BackupMasterKeyWithPIN] before enabling registration lock,
+// as re-registering will require restoring it.
+func (cli *Client) SetRegistrationLock(ctx context.Context, enabled bool) error {
+	username, password := cli.Store.BasicAuthCreds()
+	req := &web.HTTPReqOpt{
+		Username: &username,
+		Password: &password,
+	}
+	method := http.MethodDelete
+	if enabled {
+		if len(cli.Store.MasterKey) == 0 {
+			return fmt.Errorf("no master key")
+		}
+		method = http.MethodPut
+		req.ContentType = web.ContentTypeJSON
+		var err error
+		req.Body, err = json.Marshal(&reqSetRegistrationLock{RegistrationLock: RegistrationLockToken(cli.Store.MasterKey)})
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := cli.sendRequest(ctx, method, "/v1/accounts/registration_lock", req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
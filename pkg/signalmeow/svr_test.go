@@ -0,0 +1,137 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signalmeow
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
+)
+
+func makeTestBytes(start byte) []byte {
+	data := make([]byte, 32)
+	for i := range data {
+		data[i] = start + byte(i)
+	}
+	return data
+}
+
+func TestEncryptSVRData(t *testing.T) {
+	key := makeTestBytes(0)
+	masterKey := makeTestBytes(32)
+	// Calculated with an independent implementation of the HMAC-SIV construction
+	expected, _ := hex.DecodeString("f27036915a60d704b04d452ef0d55a5d1668e7d91339daba9c950d985b7556471d13cc609e59eec62fb1ce27f5c5a342")
+
+	encrypted, err := encryptSVRData(key, masterKey)
+	require.NoError(t, err)
+	assert.Equal(t, expected, encrypted)
+	// The IV is derived from the plaintext, so encryption is deterministic
+	encryptedAgain, err := encryptSVRData(key, masterKey)
+	require.NoError(t, err)
+	assert.Equal(t, encrypted, encryptedAgain)
+
+	decrypted, err := decryptSVRData(key, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, masterKey, decrypted)
+
+	_, err = encryptSVRData(key, masterKey[:16])
+	assert.Error(t, err)
+}
+
+func TestDecryptSVRData_Invalid(t *testing.T) {
+	key := makeTestBytes(0)
+	encrypted, err := encryptSVRData(key, makeTestBytes(32))
+	require.NoError(t, err)
+
+	_, err = decryptSVRData(makeTestBytes(1), encrypted)
+	assert.ErrorIs(t, err, ErrSVRDecryptionError, "wrong key")
+	for _, i := range []int{0, 15, 16, 47} {
+		tampered := bytes.Clone(encrypted)
+		tampered[i] ^= 1
+		_, err = decryptSVRData(key, tampered)
+		assert.ErrorIs(t, err, ErrSVRDecryptionError, "flipped bit in byte %d", i)
+	}
+	_, err = decryptSVRData(key, encrypted[:47])
+	assert.ErrorIs(t, err, ErrSVRDecryptionError, "truncated")
+	_, err = decryptSVRData(key, append(bytes.Clone(encrypted), 0))
+	assert.ErrorIs(t, err, ErrSVRDecryptionError, "extended")
+}
+
+func TestRegistrationLockToken(t *testing.T) {
+	assert.Equal(t, "1d2ebfb68c3b6321d21bf5b4410146fa978399bbd6de677f9cf4f52df58e1a8f", RegistrationLockToken(make([]byte, 32)))
+}
+
+func TestNormalizePIN(t *testing.T) {
+	assert.Equal(t, []byte("1234"), NormalizePIN(" 1234\n"))
+	// Arabic-Indic and fullwidth digits are converted to ASCII
+	assert.Equal(t, []byte("1234"), NormalizePIN("١٢٣٤"))
+	assert.Equal(t, []byte("1234"), NormalizePIN("１２３４"))
+	// Alphanumeric PINs are only NFKD-normalized
+	assert.Equal(t, []byte("pássword"), NormalizePIN("pássword"))
+}
+
+func TestParseSVR2RestoreResponse(t *testing.T) {
+	key := makeTestBytes(0)
+	masterKey := makeTestBytes(32)
+	encrypted, err := encryptSVRData(key, masterKey)
+	require.NoError(t, err)
+
+	restored, err := parseSVR2RestoreResponse(key, &signalpb.SVR2RestoreResponse{
+		Status: signalpb.SVR2RestoreResponse_OK.Enum(),
+		Data:   encrypted,
+		Tries:  proto.Uint32(SVR2MaxTries),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, masterKey, restored)
+
+	_, err = parseSVR2RestoreResponse(makeTestBytes(1), &signalpb.SVR2RestoreResponse{
+		Status: signalpb.SVR2RestoreResponse_OK.Enum(),
+		Data:   encrypted,
+	})
+	assert.ErrorIs(t, err, ErrSVRDecryptionError)
+
+	_, err = parseSVR2RestoreResponse(key, &signalpb.SVR2RestoreResponse{
+		Status: signalpb.SVR2RestoreResponse_MISSING.Enum(),
+	})
+	assert.ErrorIs(t, err, ErrSVRDataMissing)
+
+	_, err = parseSVR2RestoreResponse(key, &signalpb.SVR2RestoreResponse{
+		Status: signalpb.SVR2RestoreResponse_PIN_MISMATCH.Enum(),
+		Tries:  proto.Uint32(3),
+	})
+	var mismatchErr *SVRPINMismatchError
+	if assert.ErrorAs(t, err, &mismatchErr) {
+		assert.Equal(t, uint32(3), mismatchErr.TriesRemaining)
+	}
+
+	_, err = parseSVR2RestoreResponse(key, &signalpb.SVR2RestoreResponse{
+		Status: signalpb.SVR2RestoreResponse_REQUEST_INVALID.Enum(),
+	})
+	assert.ErrorIs(t, err, ErrSVRRequestInvalid)
+
+	_, err = parseSVR2RestoreResponse(key, &signalpb.SVR2RestoreResponse{})
+	assert.Error(t, err)
+	// A missing response must not be treated as success
+	_, err = parseSVR2RestoreResponse(key, nil)
+	assert.Error(t, err)
+}
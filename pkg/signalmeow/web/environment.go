@@ -0,0 +1,317 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"go.mau.fi/util/exerrors"
+
+	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
+)
+
+//go:embed signal-root.crt.der
+var signalRootCertBytes []byte
+
+//go:embed prod-server-public-params.dat
+var prodServerPublicParams []byte
+
+// ServerEnvironment describes a Signal server deployment: the hosts of the individual services,
+// the enclaves that are trusted for contact discovery and PIN backups,
+// the zkgroup server public params and the certificates that are trusted for TLS.
+type ServerEnvironment struct {
+	Name string
+
+	ChatHost    string
+	StorageHost string
+	// CDNHosts maps CDN numbers found in attachment pointers to hostnames. CDN 0 is used as the fallback.
+	CDNHosts map[uint32]string
+
+	ContactDiscoveryHost      string
+	ContactDiscoveryMrenclave string
+	SVR2Host                  string
+	SVR2Mrenclave             string
+
+	// ServerPublicParams is the serialized zkgroup server public params used for group and profile credentials.
+	ServerPublicParams []byte
+
+	// TrustRoots are DER-encoded certificates that are trusted for TLS connections to the servers.
+	TrustRoots [][]byte
+	// TrustSystemRoots makes the system certificate pool trusted in addition to TrustRoots.
+	TrustSystemRoots bool
+
+	// Proxy is the URL of a proxy that all connections are made through (see ParseProxyURL).
+	Proxy string
+
+	// FrontingPaths maps the hosts above to the path prefixes the fronting reflectors use for them.
+	FrontingPaths map[string]string
+	// Fronting enables censorship circumvention by sending requests through a CDN with a different SNI and Host.
+	Fronting *FrontingConfig
+
+	initOnce sync.Once
+	initErr  error
+
+	httpClient                     *http.Client
+	serverPublicParams             *libsignalgo.ServerPublicParams
+	contactDiscoveryMrenclaveBytes []byte
+	svr2MrenclaveBytes             []byte
+}
+
+var ProductionEnvironment = &ServerEnvironment{
+	Name: "production",
+
+	ChatHost:    "chat.signal.org",
+	StorageHost: "storage.signal.org",
+	CDNHosts: map[uint32]string{
+		0: "cdn.signal.org",
+		1: "cdn.signal.org",
+		2: "cdn2.signal.org",
+		3: "cdn3.signal.org",
+	},
+
+	ContactDiscoveryHost:      "cdsi.signal.org",
+	ContactDiscoveryMrenclave: "c6ff0682219217f7045624be472a077c0d4b06193fe71632eb0adb50051d5da1",
+	SVR2Host:                  "svr2.signal.org",
+	SVR2Mrenclave:             "29cd63c87bea751e3bfd0fbd401279192e2e5c99948b4ee9437eafc4968355fb",
+
+	ServerPublicParams: prodServerPublicParams,
+	TrustRoots:         [][]byte{signalRootCertBytes},
+
+	FrontingPaths: map[string]string{
+		"chat.signal.org":    "/service",
+		"storage.signal.org": "/storage",
+		"cdn.signal.org":     "/cdn",
+		"cdn2.signal.org":    "/cdn2",
+		"cdn3.signal.org":    "/cdn3",
+		"cdsi.signal.org":    "/cdsi",
+		"svr2.signal.org":    "/svr2",
+	},
+}
+
+// StagingEnvironment contains the hosts of Signal's staging servers.
+// The staging enclaves and zkgroup params are rotated independently of production,
+// so ContactDiscoveryMrenclave, SVR2Mrenclave and ServerPublicParams must be filled in before use.
+var StagingEnvironment = &ServerEnvironment{
+	Name: "staging",
+
+	ChatHost:    "chat.staging.signal.org",
+	StorageHost: "storage-staging.signal.org",
+	CDNHosts: map[uint32]string{
+		0: "cdn-staging.signal.org",
+		1: "cdn-staging.signal.org",
+		2: "cdn2-staging.signal.org",
+		3: "cdn3-staging.signal.org",
+	},
+
+	ContactDiscoveryHost: "cdsi.staging.signal.org",
+	SVR2Host:             "svr2.staging.signal.org",
+
+	TrustRoots: [][]byte{signalRootCertBytes},
+
+	FrontingPaths: map[string]string{
+		"chat.staging.signal.org":    "/service-staging",
+		"storage-staging.signal.org": "/storage-staging",
+		"cdn-staging.signal.org":     "/cdn-staging",
+		"cdn2-staging.signal.org":    "/cdn2-staging",
+		"cdn3-staging.signal.org":    "/cdn3-staging",
+		"cdsi.staging.signal.org":    "/cdsi-staging",
+		"svr2.staging.signal.org":    "/svr2-staging",
+	},
+}
+
+// DefaultEnvironment is used when no environment is set in the context or on the client.
+var DefaultEnvironment = ProductionEnvironment
+
+func init() {
+	exerrors.PanicIfNotNil(ProductionEnvironment.Init())
+}
+
+type contextKey int
+
+const contextKeyEnvironment contextKey = 1
+
+// WithContext returns a copy of the context that makes Env return this environment.
+func (env *ServerEnvironment) WithContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyEnvironment, env)
+}
+
+// Env returns the environment stored in the context, or DefaultEnvironment if there isn't one.
+func Env(ctx context.Context) *ServerEnvironment {
+	env, ok := ctx.Value(contextKeyEnvironment).(*ServerEnvironment)
+	if !ok || env == nil {
+		return DefaultEnvironment
+	}
+	return env
+}
+
+// Clone returns an uninitialized copy of the environment that can be modified before calling Init.
+func (env *ServerEnvironment) Clone() *ServerEnvironment {
+	return &ServerEnvironment{
+		Name:                      env.Name,
+		ChatHost:                  env.ChatHost,
+		StorageHost:               env.StorageHost,
+		CDNHosts:                  maps.Clone(env.CDNHosts),
+		ContactDiscoveryHost:      env.ContactDiscoveryHost,
+		ContactDiscoveryMrenclave: env.ContactDiscoveryMrenclave,
+		SVR2Host:                  env.SVR2Host,
+		SVR2Mrenclave:             env.SVR2Mrenclave,
+		ServerPublicParams:        env.ServerPublicParams,
+		TrustRoots:                slices.Clone(env.TrustRoots),
+		TrustSystemRoots:          env.TrustSystemRoots,
+		Proxy:                     env.Proxy,
+		FrontingPaths:             maps.Clone(env.FrontingPaths),
+		Fronting:                  env.Fronting,
+	}
+}
+
+// Init validates the environment and parses the certificates, enclave IDs and public params.
+// It is safe to call multiple times; the environment must not be modified after the first call.
+func (env *ServerEnvironment) Init() error {
+	env.initOnce.Do(func() {
+		env.initErr = env.init()
+	})
+	return env.initErr
+}
+
+func (env *ServerEnvironment) init() error {
+	if env.ChatHost == "" {
+		return errors.New("chat host not set")
+	} else if env.StorageHost == "" {
+		return errors.New("storage host not set")
+	} else if env.CDNHosts[0] == "" {
+		return errors.New("fallback CDN host (0) not set")
+	} else if len(env.ServerPublicParams) == 0 {
+		return errors.New("server public params not set")
+	}
+	var err error
+	env.serverPublicParams, err = libsignalgo.DeserializeServerPublicParams(env.ServerPublicParams)
+	if err != nil {
+		return fmt.Errorf("failed to parse server public params: %w", err)
+	}
+	env.contactDiscoveryMrenclaveBytes, err = hex.DecodeString(env.ContactDiscoveryMrenclave)
+	if err != nil {
+		return fmt.Errorf("failed to parse contact discovery mrenclave: %w", err)
+	}
+	env.svr2MrenclaveBytes, err = hex.DecodeString(env.SVR2Mrenclave)
+	if err != nil {
+		return fmt.Errorf("failed to parse SVR2 mrenclave: %w", err)
+	}
+
+	var rootCAs *x509.CertPool
+	if env.TrustSystemRoots {
+		rootCAs, err = x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("failed to load system certificates: %w", err)
+		}
+	} else {
+		rootCAs = x509.NewCertPool()
+	}
+	for i, der := range env.TrustRoots {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse trust root #%d: %w", i+1, err)
+		}
+		rootCAs.AddCert(cert)
+	}
+	transport, err := env.newTransport(rootCAs)
+	if err != nil {
+		return err
+	}
+	env.httpClient = &http.Client{Transport: transport}
+	if env.Fronting != nil {
+		if len(env.FrontingPaths) == 0 {
+			return errors.New("fronting paths not set")
+		} else if len(env.Fronting.SNIs) == 0 {
+			return fmt.Errorf("no SNI domains in %s fronting config", env.Fronting.Name)
+		}
+		// The fronting CDNs use normal publicly trusted certificates for the SNI domains
+		frontedTransport, err := env.newTransport(nil)
+		if err != nil {
+			return err
+		}
+		env.httpClient.Transport = &frontingTransport{
+			direct:  transport,
+			fronted: frontedTransport,
+			config:  env.Fronting,
+			paths:   env.FrontingPaths,
+		}
+	}
+	return nil
+}
+
+func (env *ServerEnvironment) newTransport(rootCAs *x509.CertPool) (*http.Transport, error) {
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			RootCAs: rootCAs,
+		},
+	}
+	if env.Proxy != "" {
+		err := configureProxy(transport, env.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+	}
+	return transport, nil
+}
+
+func (env *ServerEnvironment) mustInit() {
+	if err := env.Init(); err != nil {
+		panic(fmt.Errorf("invalid server environment %q: %w", env.Name, err))
+	}
+}
+
+// HTTPClient returns the HTTP client that trusts the environment's certificates.
+func (env *ServerEnvironment) HTTPClient() *http.Client {
+	env.mustInit()
+	return env.httpClient
+}
+
+// PublicParams returns the parsed zkgroup server public params.
+func (env *ServerEnvironment) PublicParams() *libsignalgo.ServerPublicParams {
+	env.mustInit()
+	return env.serverPublicParams
+}
+
+func (env *ServerEnvironment) ContactDiscoveryMrenclaveBytes() []byte {
+	env.mustInit()
+	return env.contactDiscoveryMrenclaveBytes
+}
+
+func (env *ServerEnvironment) SVR2MrenclaveBytes() []byte {
+	env.mustInit()
+	return env.svr2MrenclaveBytes
+}
+
+// CDNHost returns the hostname for the given CDN number, falling back to CDN 0 for unknown numbers.
+func (env *ServerEnvironment) CDNHost(cdnNumber uint32) string {
+	host, ok := env.CDNHosts[cdnNumber]
+	if !ok || host == "" {
+		return env.CDNHosts[0]
+	}
+	return host
+}
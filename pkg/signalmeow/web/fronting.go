@@ -0,0 +1,129 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strings"
+)
+
+// FrontingConfig describes a CDN that can be used to reach Signal when its own domains are blocked.
+//
+// Connections are made to one of the innocuous SNI domains, which are served by the same CDN as Host.
+// The CDN routes the request based on the Host header to a reflector that forwards it to the real
+// Signal server selected by the path prefix (see ServerEnvironment.FrontingPaths).
+type FrontingConfig struct {
+	Name string
+	// Host is the reflector hostname sent in the Host header.
+	Host string
+	// SNIs are the domains used for DNS and the TLS handshake. One is picked at random for each request.
+	SNIs []string
+}
+
+var FrontingGoogle = &FrontingConfig{
+	Name: "google",
+	Host: "reflector-nrgwuv7kwq-uc.a.run.app",
+	SNIs: []string{
+		"www.google.com",
+		"android.clients.google.com",
+		"clients3.google.com",
+		"clients4.google.com",
+		"inbox.google.com",
+	},
+}
+
+var FrontingFastly = &FrontingConfig{
+	Name: "fastly",
+	Host: "chat-signal.global.ssl.fastly.net",
+	SNIs: []string{
+		"github.githubassets.com",
+		"pinterest.com",
+		"www.redditstatic.com",
+	},
+}
+
+// FrontingConfigs contains the available fronting configs by name.
+var FrontingConfigs = map[string]*FrontingConfig{
+	FrontingGoogle.Name: FrontingGoogle,
+	FrontingFastly.Name: FrontingFastly,
+}
+
+// CensoredCountries maps the calling codes of countries where Signal is known to be blocked
+// to the fronting config that official clients use there.
+var CensoredCountries = map[string]*FrontingConfig{
+	"20":  FrontingGoogle, // Egypt
+	"971": FrontingGoogle, // United Arab Emirates
+	"968": FrontingGoogle, // Oman
+	"974": FrontingGoogle, // Qatar
+	"998": FrontingGoogle, // Uzbekistan
+	"98":  FrontingFastly, // Iran
+	"53":  FrontingFastly, // Cuba
+	"7":   FrontingFastly, // Russia
+	"58":  FrontingFastly, // Venezuela
+}
+
+// FrontingForNumber returns the fronting config to use for the given E.164 phone number,
+// or nil if the number's country doesn't need censorship circumvention.
+func FrontingForNumber(e164 string) *FrontingConfig {
+	digits := strings.TrimPrefix(e164, "+")
+	// Calling codes are prefix-free and at most 3 digits long
+	for i := 1; i <= 3 && i <= len(digits); i++ {
+		if cfg, ok := CensoredCountries[digits[:i]]; ok {
+			return cfg
+		}
+	}
+	return nil
+}
+
+func (fc *FrontingConfig) randomSNI() string {
+	return fc.SNIs[rand.IntN(len(fc.SNIs))]
+}
+
+// frontingTransport sends requests to hosts in paths through the fronting CDN and everything else directly.
+type frontingTransport struct {
+	direct  http.RoundTripper
+	fronted http.RoundTripper
+	config  *FrontingConfig
+	paths   map[string]string
+}
+
+func (ft *frontingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	prefix, ok := ft.paths[req.URL.Host]
+	if !ok {
+		return ft.direct.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Host = ft.config.Host
+	req.URL.Host = ft.config.randomSNI()
+	req.URL.Path = prefix + req.URL.Path
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = prefix + req.URL.RawPath
+	}
+	return ft.fronted.RoundTrip(req)
+}
+
+// WithFronting returns a copy of the environment that reaches the servers through the given fronting CDN.
+// A nil config returns the environment itself.
+func (env *ServerEnvironment) WithFronting(fronting *FrontingConfig) (*ServerEnvironment, error) {
+	if fronting == nil || fronting == env.Fronting {
+		return env, nil
+	}
+	clone := env.Clone()
+	clone.Fronting = fronting
+	return clone, clone.Init()
+}
@@ -0,0 +1,103 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// SignalProxyLinkHost is the host used in Signal's proxy share links (https://signal.tube/#proxy.example.com).
+const SignalProxyLinkHost = "signal.tube"
+
+// ParseProxyURL parses a proxy URL. The supported schemes are http, https, socks5, socks5h and signal.
+// Signal TLS proxies can also be specified as signal.tube share links.
+func ParseProxyURL(proxy string) (*url.URL, error) {
+	parsed, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "https" && parsed.Host == SignalProxyLinkHost {
+		if parsed.Fragment == "" {
+			return nil, errors.New("signal proxy link doesn't contain a proxy host")
+		}
+		parsed = &url.URL{Scheme: "signal", Host: parsed.Fragment}
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	case "signal":
+		if parsed.Port() == "" {
+			parsed.Host = net.JoinHostPort(parsed.Host, "443")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return nil, errors.New("proxy URL doesn't contain a host")
+	}
+	return parsed, nil
+}
+
+func configureProxy(transport *http.Transport, proxy string) error {
+	proxyURL, err := ParseProxyURL(proxy)
+	if err != nil {
+		return err
+	}
+	if proxyURL.Scheme == "signal" {
+		transport.DialContext = signalTLSProxyDialer(proxyURL.Host)
+	} else {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return nil
+}
+
+// signalTLSProxyDialer returns a dialer for Signal's TLS proxy protocol.
+//
+// The proxy is a TLS server that forwards the decrypted stream to the Signal server chosen by the SNI of the
+// inner TLS handshake. The returned connections are already wrapped in the outer TLS layer,
+// so the transport's own TLS handshake with the real server happens inside it.
+func signalTLSProxyDialer(proxyAddr string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyHost, _, _ := net.SplitHostPort(proxyAddr)
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: proxyHost}}
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, proxyAddr)
+	}
+}
+
+// ProxyDirect is a special proxy value for WithProxy that removes the proxy from an environment.
+const ProxyDirect = "direct"
+
+// WithProxy returns a copy of the environment that sends all requests through the given proxy.
+// An empty proxy URL returns the environment itself, while ProxyDirect returns a copy without a proxy.
+func (env *ServerEnvironment) WithProxy(proxy string) (*ServerEnvironment, error) {
+	if proxy == ProxyDirect {
+		proxy = ""
+	} else if proxy == "" {
+		return env, nil
+	}
+	if proxy == env.Proxy {
+		return env, nil
+	}
+	clone := env.Clone()
+	clone.Proxy = proxy
+	return clone, clone.Init()
+}
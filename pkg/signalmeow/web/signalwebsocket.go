@@ -17,10 +17,13 @@
 package web
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"strings"
@@ -32,6 +35,7 @@ import (
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exsync"
 
+	"go.mau.fi/mautrix-signal/pkg/signalmeow/metrics"
 	signalpb "go.mau.fi/mautrix-signal/pkg/signalmeow/protobuf"
 	"go.mau.fi/mautrix-signal/pkg/signalmeow/wspb"
 )
@@ -39,14 +43,40 @@ import (
 const WebsocketProvisioningPath = "/v1/websocket/provisioning/"
 const WebsocketPath = "/v1/websocket/"
 
+const (
+	// minBackoff is the base delay before reconnecting. It's doubled after every failed attempt up to maxBackoff.
+	minBackoff = 2 * time.Second
+	maxBackoff = 60 * time.Second
+
+	// keepaliveInterval is how often a keepalive request is sent to the server, and keepaliveTimeout is how long
+	// to wait for a response before assuming the connection is dead. Like the official clients, this is done
+	// with requests rather than websocket pings, because the server answers requests from the application layer,
+	// which detects half-open connections that the TCP stack or a proxy might still answer pings for.
+	keepaliveInterval = 30 * time.Second
+	keepaliveTimeout  = 20 * time.Second
+)
+
+// ErrForcedReconnect is the cause of the connection being closed by [SignalWebsocket.ForceReconnect].
+var ErrForcedReconnect = errors.New("forced reconnect")
+
+// ErrRequestNotSent is returned by SendRequest when the request couldn't be queued on the websocket,
+// which means it's safe to retry it over another connection. Any other error may happen after the server
+// has already received the request.
+var ErrRequestNotSent = errors.New("request was not sent")
+
 type SimpleResponse struct {
 	Status        int
 	WriteCallback func(time.Time)
+	// Done can be set to delay the response until the handler has finished processing the request in the background.
+	// Responses are always sent in the order the requests were received, so a pending response also holds back the
+	// responses to all requests after it. If a non-nil error is received from the channel, no response is sent.
+	Done <-chan error
 }
 type RequestHandlerFunc func(context.Context, *signalpb.WebSocketRequestMessage) (*SimpleResponse, error)
 
 type SignalWebsocket struct {
 	ws            atomic.Pointer[websocket.Conn]
+	env           *ServerEnvironment
 	basicAuth     *url.Userinfo
 	sendChannel   chan SignalWebsocketSendMessage
 	statusChannel chan SignalWebsocketConnectionStatus
@@ -54,14 +84,21 @@ type SignalWebsocket struct {
 	closeEvt      *exsync.Event
 	closeCalled   atomic.Bool
 	cancel        atomic.Pointer[context.CancelFunc]
+	connCancel    atomic.Pointer[context.CancelCauseFunc]
+	reconnectNow  chan struct{}
+
+	// Metrics is optional and must be set before calling Connect.
+	Metrics *metrics.Metrics
 }
 
-func NewSignalWebsocket(basicAuth *url.Userinfo) *SignalWebsocket {
+func NewSignalWebsocket(env *ServerEnvironment, basicAuth *url.Userinfo) *SignalWebsocket {
 	return &SignalWebsocket{
+		env:           env,
 		basicAuth:     basicAuth,
 		sendChannel:   make(chan SignalWebsocketSendMessage),
 		statusChannel: make(chan SignalWebsocketConnectionStatus),
 		closeEvt:      exsync.NewEvent(),
+		reconnectNow:  make(chan struct{}, 1),
 	}
 }
 
@@ -116,6 +153,45 @@ func (s *SignalWebsocket) Close() (err error) {
 	return err
 }
 
+// ForceReconnect closes the current connection and reconnects immediately, skipping any reconnection backoff.
+// It should be called when the network changes, as the old connection is unlikely to work after that, but it
+// may take a while until the keepalive or a failed write notices.
+func (s *SignalWebsocket) ForceReconnect() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.reconnectNow <- struct{}{}:
+	default:
+	}
+	if connCancel := s.connCancel.Load(); connCancel != nil {
+		(*connCancel)(ErrForcedReconnect)
+	}
+}
+
+// jitterBackoff returns a random duration between half of the given backoff and the full backoff,
+// so that clients which were disconnected at the same time don't all reconnect at the same time.
+func jitterBackoff(backoff time.Duration) time.Duration {
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// waitBackoff waits for the given duration, or until the context is canceled or ForceReconnect is called.
+// It returns true if the wait was cut short by ForceReconnect.
+func (s *SignalWebsocket) waitBackoff(ctx context.Context, metricsLabel string, backoff time.Duration) (forced bool) {
+	backoffDone := s.Metrics.WebsocketBackoff(metricsLabel, backoff)
+	defer backoffDone()
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-s.reconnectNow:
+		zerolog.Ctx(ctx).Debug().Msg("Reconnect backoff was short-circuited")
+		return true
+	}
+	return false
+}
+
 func (s *SignalWebsocket) Connect(ctx context.Context, requestHandler RequestHandlerFunc) chan SignalWebsocketConnectionStatus {
 	go s.connectLoop(ctx, requestHandler)
 	return s.statusChannel
@@ -138,7 +214,7 @@ func (s *SignalWebsocket) pushOutgoing(ctx context.Context, send SignalWebsocket
 	s.closeLock.RLock()
 	defer s.closeLock.RUnlock()
 	if s.sendChannel == nil {
-		return errors.New("connection is not open")
+		return fmt.Errorf("%w: connection is not open", ErrRequestNotSent)
 	}
 	select {
 	case s.sendChannel <- send:
@@ -146,7 +222,7 @@ func (s *SignalWebsocket) pushOutgoing(ctx context.Context, send SignalWebsocket
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-s.closeEvt.GetChan():
-		return errors.New("connection closed before send could be queued")
+		return fmt.Errorf("%w: connection closed before send could be queued", ErrRequestNotSent)
 	}
 }
 
@@ -175,16 +251,15 @@ func (s *SignalWebsocket) connectLoop(
 		s.sendChannel = nil
 	}()
 
-	const initialBackoff = 10 * time.Second
-	const backoffIncrement = 5 * time.Second
-	const maxBackoff = 60 * time.Second
-
 	if s.ws.Load() != nil {
 		panic("Already connected")
 	}
 
 	// First set up request handler loop. This exists outside of the
-	// connection loops because we want to maintain it across reconnections
+	// connection loops because we want to maintain it across reconnections.
+	// Responses are sent from a separate loop, so that handlers can process
+	// requests in the background while still acking them in order.
+	pendingResponses := make(chan SignalWebsocketSendMessage, 256)
 	go func() {
 		for {
 			select {
@@ -210,12 +285,13 @@ func (s *SignalWebsocket) connectLoop(
 				if err != nil {
 					log.Err(err).Uint64("request_id", request.GetId()).Msg("Error handling request")
 				} else if response != nil {
-					err = s.pushOutgoing(ctx, SignalWebsocketSendMessage{
+					select {
+					case pendingResponses <- SignalWebsocketSendMessage{
 						RequestMessage:  request,
 						ResponseMessage: response,
-					})
-					if err != nil {
-						log.Err(err).Uint64("request_id", request.GetId()).Msg("Error queuing response message")
+					}:
+					case <-ctx.Done():
+						return
 					}
 				} else {
 					log.Warn().Uint64("request_id", request.GetId()).Msg("Request handler didn't return a response nor an error")
@@ -223,35 +299,67 @@ func (s *SignalWebsocket) connectLoop(
 			}
 		}
 	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case send := <-pendingResponses:
+				if send.ResponseMessage.Done != nil {
+					select {
+					case err := <-send.ResponseMessage.Done:
+						if err != nil {
+							log.Err(err).Uint64("request_id", send.RequestMessage.GetId()).Msg("Error handling request")
+							continue
+						}
+					case <-ctx.Done():
+						return
+					}
+				}
+				err := s.pushOutgoing(ctx, send)
+				if err != nil {
+					log.Err(err).Uint64("request_id", send.RequestMessage.GetId()).Msg("Error queuing response message")
+				}
+			}
+		}
+	}()
 
 	// Main connection loop - if there's a problem with anything just
 	// kill everything (including the websocket) and build it all up again
-	backoff := initialBackoff
+	backoff := minBackoff
 	retrying := false
+	forced := false
 	errorCount := 0
 	isFirstConnect := true
+	hasConnected := false
+	metricsLabel := "unauthed"
+	if s.basicAuth != nil {
+		metricsLabel = "authed"
+	}
 	wsURL := (&url.URL{
 		Scheme: "wss",
-		Host:   APIHostname,
+		Host:   s.env.ChatHost,
 		Path:   WebsocketPath,
 		User:   s.basicAuth,
 	}).String()
 	for {
-		if retrying {
-			if backoff > maxBackoff {
-				backoff = maxBackoff
-			}
-			log.Warn().Dur("backoff", backoff).Msg("Failed to connect, waiting to retry...")
+		if forced {
+			// Drop the signal from ForceReconnect, the connection was already closed because of it
 			select {
-			case <-time.After(backoff):
-			case <-ctx.Done():
+			case <-s.reconnectNow:
+			default:
 			}
-			backoff += backoffIncrement
-		} else if !isFirstConnect && s.basicAuth != nil {
-			select {
-			case <-time.After(initialBackoff):
-			case <-ctx.Done():
+			forced = false
+		} else if retrying {
+			wait := jitterBackoff(backoff)
+			log.Warn().Dur("backoff", wait).Msg("Failed to connect, waiting to retry...")
+			if s.waitBackoff(ctx, metricsLabel, wait) {
+				backoff = minBackoff
+			} else {
+				backoff = min(backoff*2, maxBackoff)
 			}
+		} else if !isFirstConnect && s.basicAuth != nil {
+			s.waitBackoff(ctx, metricsLabel, jitterBackoff(minBackoff))
 		}
 		if ctx.Err() != nil {
 			log.Info().Msg("ctx done, stopping connection loop")
@@ -259,7 +367,7 @@ func (s *SignalWebsocket) connectLoop(
 		}
 		isFirstConnect = false
 
-		ws, resp, err := OpenWebsocket(ctx, wsURL)
+		ws, resp, err := s.env.OpenWebsocket(ctx, wsURL)
 		if resp != nil {
 			if resp.StatusCode != 101 {
 				// Server didn't want to open websocket
@@ -296,12 +404,20 @@ func (s *SignalWebsocket) connectLoop(
 
 		// Succssfully connected
 		s.pushStatus(ctx, SignalWebsocketConnectionEventConnected, nil)
+		s.Metrics.WebsocketConnected(metricsLabel, hasConnected)
+		hasConnected = true
 		s.ws.Store(ws)
 		retrying = false
-		backoff = initialBackoff
+		backoff = minBackoff
+		// The new connection is fresh, so a reconnect requested while dialing isn't necessary anymore
+		select {
+		case <-s.reconnectNow:
+		default:
+		}
 
 		responseChannels := make(map[uint64]chan *signalpb.WebSocketResponseMessage)
 		loopCtx, loopCancel := context.WithCancelCause(ctx)
+		s.connCancel.Store(&loopCancel)
 		var wg sync.WaitGroup
 		wg.Add(3)
 
@@ -333,49 +449,28 @@ func (s *SignalWebsocket) connectLoop(
 			log.Info().Msg("writeLoop exited")
 		}()
 
-		// Ping loop (send a keepalive Ping every 30s)
+		// Keepalive loop (send a keepalive request every 30s)
 		go func() {
 			defer wg.Done()
-			ticker := time.NewTicker(30 * time.Second)
-			defer ticker.Stop()
-
-			pingTimeoutCount := 0
-			for {
-				select {
-				case <-ticker.C:
-					pingCtx, cancel := context.WithTimeout(loopCtx, 20*time.Second)
-					err := ws.Ping(pingCtx)
-					cancel()
-					if err != nil {
-						pingTimeoutCount++
-						log.Err(err).Msg("Failed to send ping")
-						if pingTimeoutCount >= 5 {
-							log.Warn().Msg("Ping timeout count exceeded, closing websocket")
-							err = ws.Close(websocket.StatusNormalClosure, "Ping timeout")
-							if err != nil {
-								log.Err(err).Msg("Error closing websocket after ping timeout")
-							}
-							return
-						}
-					} else if pingTimeoutCount > 0 {
-						pingTimeoutCount = 0
-						log.Debug().Msg("Recovered from ping error")
-					} else {
-						log.Trace().Msg("Sent keepalive")
-					}
-				case <-loopCtx.Done():
-					return
-				}
+			err := s.keepaliveLoop(loopCtx)
+			if err != nil {
+				loopCancel(fmt.Errorf("error in keepaliveLoop: %w", err))
 			}
 		}()
 
-		// Wait for read or write or ping loop to exit (which means there was an error)
+		// Wait for read or write or keepalive loop to exit (which means there was an error)
 		log.Debug().Msg("Finished preparing connection, waiting for loop context to finish")
 		<-loopCtx.Done()
+		s.connCancel.CompareAndSwap(&loopCancel, nil)
+		s.Metrics.WebsocketDisconnected(metricsLabel)
 		ctxCauseErr := context.Cause(loopCtx)
 		log.Debug().AnErr("ctx_cause_err", ctxCauseErr).Msg("Read or write loop exited")
 		if ctxCauseErr == nil || errors.Is(ctxCauseErr, context.Canceled) {
 			s.pushStatus(ctx, SignalWebsocketConnectionEventCleanShutdown, nil)
+		} else if errors.Is(ctxCauseErr, ErrForcedReconnect) {
+			log.Info().Msg("Reconnecting websocket as requested")
+			s.pushStatus(ctx, SignalWebsocketConnectionEventDisconnected, ctxCauseErr)
+			forced = true
 		} else {
 			errorCount++
 			s.pushStatus(ctx, SignalWebsocketConnectionEventDisconnected, ctxCauseErr)
@@ -398,6 +493,62 @@ func (s *SignalWebsocket) connectLoop(
 	}
 }
 
+// keepaliveLoop periodically sends keepalive requests to the server. It returns an error if the server
+// doesn't respond in time, which means the connection is dead and should be reopened.
+func (s *SignalWebsocket) keepaliveLoop(ctx context.Context) error {
+	log := zerolog.Ctx(ctx).With().
+		Str("loop", "signal_websocket_keepalive_loop").
+		Logger()
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			err := s.sendKeepalive(ctx)
+			if ctx.Err() != nil {
+				return nil
+			} else if err != nil {
+				log.Warn().Err(err).Msg("Keepalive failed, closing websocket")
+				return err
+			}
+			log.Trace().Dur("duration", time.Since(start)).Msg("Sent keepalive")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *SignalWebsocket) sendKeepalive(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, keepaliveTimeout)
+	defer cancel()
+	method := http.MethodGet
+	path := "/v1/keepalive"
+	responseChannel := make(chan *signalpb.WebSocketResponseMessage, 1)
+	err := s.pushOutgoing(ctx, SignalWebsocketSendMessage{
+		RequestMessage: &signalpb.WebSocketRequestMessage{
+			Verb: &method,
+			Path: &path,
+		},
+		ResponseChannel: responseChannel,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue keepalive request: %w", err)
+	}
+	select {
+	case resp := <-responseChannel:
+		if resp == nil {
+			return errors.New("connection closed before keepalive response")
+		} else if resp.GetStatus() != 200 {
+			// The connection works even if the server didn't like the request
+			zerolog.Ctx(ctx).Warn().Uint32("status", resp.GetStatus()).Msg("Unexpected keepalive response status")
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("keepalive timed out: %w", ctx.Err())
+	}
+}
+
 func readLoop(
 	ctx context.Context,
 	ws *websocket.Conn,
@@ -565,6 +716,68 @@ func (s *SignalWebsocket) SendRequest(
 	return s.sendRequestInternal(ctx, request, startTime, 0)
 }
 
+// SendHTTPRequest sends a REST request over the websocket and converts the response into a [http.Response],
+// so that it can be used in place of [ServerEnvironment.SendHTTPRequest]. The Host, OverrideURL, BodyStream
+// and credential fields of the options are ignored: requests always go to the chat server the websocket is
+// connected to, and are authenticated by the websocket itself.
+func (s *SignalWebsocket) SendHTTPRequest(ctx context.Context, method, path string, opt *HTTPReqOpt) (*http.Response, error) {
+	if opt == nil {
+		opt = &HTTPReqOpt{}
+	}
+	if len(path) > 0 && path[0] != '/' {
+		path = "/" + path
+	}
+	contentType := opt.ContentType
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	request := &signalpb.WebSocketRequestMessage{
+		Verb:    &method,
+		Path:    &path,
+		Body:    opt.Body,
+		Headers: []string{"content-type:" + string(contentType)},
+	}
+	for key, value := range opt.Headers {
+		request.Headers = append(request.Headers, strings.ToLower(key)+":"+value)
+	}
+
+	type result struct {
+		resp *signalpb.WebSocketResponseMessage
+		err  error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		resp, err := s.SendRequest(ctx, request)
+		resultChan <- result{resp, err}
+	}()
+	var res result
+	select {
+	case res = <-resultChan:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	header := make(http.Header, len(res.resp.Headers))
+	for _, line := range res.resp.Headers {
+		key, value, ok := strings.Cut(line, ":")
+		if ok {
+			header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", res.resp.GetStatus(), res.resp.GetMessage()),
+		StatusCode:    int(res.resp.GetStatus()),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(res.resp.Body)),
+		ContentLength: int64(len(res.resp.Body)),
+	}, nil
+}
+
 func (s *SignalWebsocket) sendRequestInternal(
 	ctx context.Context,
 	request *signalpb.WebSocketRequestMessage,
@@ -580,7 +793,10 @@ func (s *SignalWebsocket) sendRequestInternal(
 		ResponseChannel: responseChannel,
 		RequestTime:     startTime,
 	})
-	if err != nil {
+	if err != nil && retryCount > 0 && errors.Is(err, ErrRequestNotSent) {
+		// An earlier attempt may have reached the server, so don't let the caller resend the request
+		return nil, fmt.Errorf("failed to retry request: %s", err.Error())
+	} else if err != nil {
 		return nil, err
 	}
 	response := <-responseChannel
@@ -607,9 +823,14 @@ func (s *SignalWebsocket) sendRequestInternal(
 	return response, nil
 }
 
+// OpenWebsocket opens a websocket using the environment stored in the context (see Env).
 func OpenWebsocket(ctx context.Context, url string) (*websocket.Conn, *http.Response, error) {
+	return Env(ctx).OpenWebsocket(ctx, url)
+}
+
+func (env *ServerEnvironment) OpenWebsocket(ctx context.Context, url string) (*websocket.Conn, *http.Response, error) {
 	opt := &websocket.DialOptions{
-		HTTPClient: SignalHTTPClient,
+		HTTPClient: env.HTTPClient(),
 		HTTPHeader: make(http.Header, 2),
 	}
 	opt.HTTPHeader.Set("User-Agent", UserAgent)
@@ -19,16 +19,12 @@ package web
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	_ "embed"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog"
@@ -36,62 +32,9 @@ import (
 	"go.mau.fi/mautrix-signal/pkg/libsignalgo"
 )
 
-const proxyUrlStr = "" // Set this to proxy requests
-const caCertPath = ""  // Set this to trust a self-signed cert (ie. for mitmproxy)
-
 var UserAgent = "signalmeow/0.1.0 libsignal/" + libsignalgo.Version + " go/" + strings.TrimPrefix(runtime.Version(), "go")
 var SignalAgent = "MAU"
 
-const (
-	APIHostname     = "chat.signal.org"
-	StorageHostname = "storage.signal.org"
-	CDN1Hostname    = "cdn.signal.org"
-	CDN2Hostname    = "cdn2.signal.org"
-	CDN3Hostname    = "cdn3.signal.org"
-)
-
-var CDNHosts = []string{
-	CDN1Hostname,
-	CDN1Hostname,
-	CDN2Hostname,
-	CDN3Hostname,
-}
-
-//go:embed signal-root.crt.der
-var signalRootCertBytes []byte
-var signalTransport = &http.Transport{
-	ForceAttemptHTTP2: true,
-	TLSClientConfig: &tls.Config{
-		RootCAs: x509.NewCertPool(),
-	},
-}
-var SignalHTTPClient = &http.Client{
-	Transport: signalTransport,
-}
-
-func init() {
-	cert, err := x509.ParseCertificate(signalRootCertBytes)
-	if err != nil {
-		panic(err)
-	}
-	signalTransport.TLSClientConfig.RootCAs.AddCert(cert)
-
-	if proxyUrlStr != "" {
-		proxyURL, err := url.Parse(proxyUrlStr)
-		if err != nil {
-			panic(err)
-		}
-		signalTransport.Proxy = http.ProxyURL(proxyURL)
-	}
-	if caCertPath != "" {
-		caCert, err := os.ReadFile(caCertPath)
-		if err != nil {
-			panic(err)
-		}
-		signalTransport.TLSClientConfig.RootCAs.AppendCertsFromPEM(caCert)
-	}
-}
-
 type ContentType string
 
 const (
@@ -102,24 +45,33 @@ const (
 )
 
 type HTTPReqOpt struct {
-	Body        []byte
-	Username    *string
-	Password    *string
-	ContentType ContentType
-	Host        string
-	Headers     map[string]string
-	OverrideURL string // Override the full URL, if set ignores path and Host
+	Body []byte
+	// BodyStream is used instead of Body if set. The length of the stream must be set in ContentLength.
+	// If the stream is also an io.Closer, it will be closed after the request.
+	BodyStream    io.Reader
+	ContentLength int64
+	Username      *string
+	Password      *string
+	ContentType   ContentType
+	Host          string
+	Headers       map[string]string
+	OverrideURL   string // Override the full URL, if set ignores path and Host
 }
 
 var httpReqCounter = 0
 
+// SendHTTPRequest sends a request to the environment stored in the context (see Env).
 func SendHTTPRequest(ctx context.Context, method string, path string, opt *HTTPReqOpt) (*http.Response, error) {
+	return Env(ctx).SendHTTPRequest(ctx, method, path, opt)
+}
+
+func (env *ServerEnvironment) SendHTTPRequest(ctx context.Context, method string, path string, opt *HTTPReqOpt) (*http.Response, error) {
 	// Set defaults
 	if opt == nil {
 		opt = &HTTPReqOpt{}
 	}
 	if opt.Host == "" {
-		opt.Host = APIHostname
+		opt.Host = env.ChatHost
 	}
 	if len(path) > 0 && path[0] != '/' {
 		path = "/" + path
@@ -135,11 +87,20 @@ func SendHTTPRequest(ctx context.Context, method string, path string, opt *HTTPR
 		Logger()
 	ctx = log.WithContext(ctx)
 
-	req, err := http.NewRequestWithContext(ctx, method, urlStr, bytes.NewReader(opt.Body))
+	var body io.Reader
+	contentLength := int64(len(opt.Body))
+	if opt.BodyStream != nil {
+		body = opt.BodyStream
+		contentLength = opt.ContentLength
+	} else {
+		body = bytes.NewReader(opt.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
 	if err != nil {
 		log.Err(err).Msg("Error creating request")
 		return nil, err
 	}
+	req.ContentLength = contentLength
 	if opt.Headers != nil {
 		for k, v := range opt.Headers {
 			req.Header.Add(k, v)
@@ -150,7 +111,7 @@ func SendHTTPRequest(ctx context.Context, method string, path string, opt *HTTPR
 	} else {
 		req.Header.Set("Content-Type", string(ContentTypeJSON))
 	}
-	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(opt.Body)))
+	req.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
 	req.Header.Set("User-Agent", UserAgent)
 	req.Header.Set("X-Signal-Agent", SignalAgent)
 	if opt.Username != nil && opt.Password != nil {
@@ -160,7 +121,7 @@ func SendHTTPRequest(ctx context.Context, method string, path string, opt *HTTPR
 	httpReqCounter++
 	log = log.With().Int("request_number", httpReqCounter).Logger()
 	log.Trace().Msg("Sending HTTP request")
-	resp, err := SignalHTTPClient.Do(req)
+	resp, err := env.HTTPClient().Do(req)
 	if err != nil {
 		log.Err(err).Msg("Error sending request")
 		return nil, err
@@ -192,7 +153,12 @@ func DecodeHTTPResponseBody(ctx context.Context, out any, resp *http.Response) e
 	return nil
 }
 
+// GetAttachment downloads a file from a CDN of the environment stored in the context (see Env).
 func GetAttachment(ctx context.Context, path string, cdnNumber uint32, opt *HTTPReqOpt) (*http.Response, error) {
+	return Env(ctx).GetAttachment(ctx, path, cdnNumber, opt)
+}
+
+func (env *ServerEnvironment) GetAttachment(ctx context.Context, path string, cdnNumber uint32, opt *HTTPReqOpt) (*http.Response, error) {
 	log := zerolog.Ctx(ctx).With().
 		Str("action", "get_attachment").
 		Str("path", path).
@@ -202,31 +168,20 @@ func GetAttachment(ctx context.Context, path string, cdnNumber uint32, opt *HTTP
 		opt = &HTTPReqOpt{}
 	}
 	if opt.Host == "" {
-		if int(cdnNumber) > len(CDNHosts) {
-			log.Warn().Msg("Invalid CDN index")
-			opt.Host = CDN1Hostname
-		} else {
-			opt.Host = CDNHosts[cdnNumber]
-		}
-		if cdnNumber == 0 {
-			// This is basically a fallback if cdnNumber is not set
-			// but it also seems to be the right host if cdnNumber == 0
-			opt.Host = CDNHosts[0]
-		} else if cdnNumber > 0 && int(cdnNumber) <= len(CDNHosts) {
-			// Pull CDN hosts from array (cdnNumber is 1-indexed, but we have a placeholder host at index 0)
-			// (the 1-indexed is just an assumption, other clients seem to only explicitly handle cdnNumber == 0 and 2)
-			opt.Host = CDNHosts[cdnNumber]
-		} else {
-			opt.Host = CDNHosts[0]
+		if _, ok := env.CDNHosts[cdnNumber]; !ok {
 			log.Warn().Msg("Invalid CDN index")
 		}
+		opt.Host = env.CDNHost(cdnNumber)
 	}
 	log.Debug().Str("host", opt.Host).Msg("getting attachment")
 	urlStr := "https://" + opt.Host + path
-	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range opt.Headers {
+		req.Header.Set(k, v)
+	}
 
 	//const SERVICE_REFLECTOR_HOST = "europe-west1-signal-cdn-reflector.cloudfunctions.net"
 	//req.Header.Add("Host", SERVICE_REFLECTOR_HOST)
@@ -240,7 +195,7 @@ func GetAttachment(ctx context.Context, path string, cdnNumber uint32, opt *HTTP
 		Logger()
 
 	log.Debug().Msg("Sending Attachment HTTP request")
-	resp, err := SignalHTTPClient.Do(req)
+	resp, err := env.HTTPClient().Do(req)
 	if err != nil {
 		return nil, err
 	}